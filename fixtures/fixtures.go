@@ -0,0 +1,78 @@
+// Package fixtures loads data files into a database from go tests, the tables of the files emptied
+// first, with the Uploader of the loader package in the test process.
+//
+//	func TestOrders(t *testing.T) {
+//		db := openTestDB(t)
+//		fixtures.Load(t, db, "testdata/seed")
+//		...
+//	}
+package fixtures
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"uptomssql/loader"
+)
+
+// DefaultLockTimeout is how long a load waits for the load of another test into the same database
+// to finish, when the options set no LockTimeout. Loads take the run lock of the database, so
+// parallel tests sharing it load one at a time.
+const DefaultLockTimeout = time.Minute
+
+// Load loads the data files of dir into db, truncating their tables first, and returns the outcome
+// of every file. A failing load fails the test, with the error of the row stopping it and the
+// files loaded so far.
+func Load(t testing.TB, db *sql.DB, dir string) *loader.RunResult {
+	t.Helper()
+	return LoadWith(t, db, loader.Options{Dirs: []string{dir}, Truncate: true})
+}
+
+// LoadWith loads the data files of the options into db, failing the test as Load does. Mode
+// refresh instead of Truncate empties tables referenced by foreign keys, which cannot be truncated.
+func LoadWith(t testing.TB, db *sql.DB, opts loader.Options) *loader.RunResult {
+	t.Helper()
+	var out bytes.Buffer
+	opts.Out = &out
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = DefaultLockTimeout
+	}
+	result, err := loader.NewUploader(db, opts).Upload(t.Context())
+	if err != nil {
+		t.Fatal(describe(opts, result, err, out.String()))
+	}
+	return result
+}
+
+// describe tells what failed in a load, the run error with its return code and the files of the
+// run with their status.
+func describe(opts loader.Options, result *loader.RunResult, err error, out string) string {
+	source := strings.Join(opts.Dirs, ", ")
+	if opts.File != "" {
+		source = opts.File
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "load fixtures %s: %v", source, err)
+	var runErr *loader.RunError
+	if errors.As(err, &runErr) {
+		fmt.Fprintf(&b, " (exit code %d)", runErr.Code)
+	}
+	for _, f := range result.Files {
+		fmt.Fprintf(&b, "\n  %s => %s: %s, %d rows", f.File, f.Table, f.Status, f.Rows)
+		if f.RejectedRows > 0 {
+			fmt.Fprintf(&b, ", %d rejected", f.RejectedRows)
+		}
+		if f.Error != "" {
+			fmt.Fprintf(&b, ": %s", f.Error)
+		}
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		b.WriteString("\n" + out)
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package fixtures
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"uptomssql/loader"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   loader.Options
+		result *loader.RunResult
+		err    error
+		out    string
+		want   string
+	}{
+		{
+			name:   "run error",
+			opts:   loader.Options{Dirs: []string{"testdata/seed"}},
+			result: &loader.RunResult{},
+			err:    &loader.RunError{Code: loader.ConnectErrorCode, Err: errors.New("login failed")},
+			want:   "load fixtures testdata/seed: error on connect to db: login failed (exit code 1)",
+		},
+		{
+			name: "files",
+			opts: loader.Options{Dirs: []string{"a", "b"}},
+			result: &loader.RunResult{Files: []*loader.FileResult{
+				{File: "01_Users.json", Table: "dbo.Users", Status: "loaded", Rows: 3},
+				{File: "02_Orders.csv", Table: "dbo.Orders", Status: "failed", Rows: 1, RejectedRows: 2, Error: "duplicate key"},
+			}},
+			err: errors.New("stopped"),
+			out: "summary\n",
+			want: "load fixtures a, b: stopped" +
+				"\n  01_Users.json => dbo.Users: loaded, 3 rows" +
+				"\n  02_Orders.csv => dbo.Orders: failed, 1 rows, 2 rejected: duplicate key" +
+				"\nsummary",
+		},
+		{
+			name:   "file",
+			opts:   loader.Options{Dirs: []string{"a"}, File: "seed/01_Users.json"},
+			result: &loader.RunResult{},
+			err:    errors.New("stopped"),
+			want:   "load fixtures seed/01_Users.json: stopped",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describe(tt.opts, tt.result, tt.err, tt.out)
+			if got != tt.want {
+				t.Errorf("describe =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingTB records the failure of a load instead of failing the test.
+type failingTB struct {
+	testing.TB
+	failure string
+}
+
+func (f *failingTB) Helper() {}
+
+func (f *failingTB) Fatal(args ...any) {
+	f.failure = fmt.Sprint(args...)
+}
+
+// unreachable is a database the connections to fail.
+type unreachable struct{}
+
+func (unreachable) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("server unreachable")
+}
+func (unreachable) Driver() driver.Driver { return nil }
+
+func TestLoadFails(t *testing.T) {
+	db := sql.OpenDB(unreachable{})
+	defer db.Close()
+	tb := &failingTB{TB: t}
+	Load(tb, db, t.TempDir())
+	if !strings.HasPrefix(tb.failure, "load fixtures ") || !strings.Contains(tb.failure, "(exit code ") {
+		t.Errorf("load failure = %q, want the run error and its exit code", tb.failure)
+	}
+}
@@ -0,0 +1,143 @@
+package loader
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v3"
+)
+
+// assertionSampleRows are the rows returned by a failing assertion printed.
+const assertionSampleRows = 5
+
+// tableAssertion is a query run after the load that must return no row, like the rows of a
+// table whose foreign key has no parent or the business keys loaded twice.
+type tableAssertion struct {
+	table tableRef
+	name  string
+	query string
+}
+
+// assertionFile is the assertions file, the queries by name by table.
+type assertionFile struct {
+	Tables map[string]map[string]string `yaml:"tables"`
+}
+
+// readAssertions loads the assertions file, it returns nil if path is empty.
+func readAssertions(path string) ([]tableAssertion, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file assertionFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var assertions []tableAssertion
+	for table, queries := range file.Tables {
+		for name, query := range queries {
+			if strings.TrimSpace(query) == "" {
+				return nil, fmt.Errorf("%s: %s: assertion %s has no query", path, table, name)
+			}
+			assertions = append(assertions, tableAssertion{table: parseTableRef(table), name: name, query: query})
+		}
+	}
+	return assertions, nil
+}
+
+// planAssertions adds the assert queries of the files of the run to the assertions of the file,
+// which take precedence for the same table and name. They are sorted by table and name.
+func planAssertions(plans []*filePlan, assertions []tableAssertion) []tableAssertion {
+	byKey := make(map[string]tableAssertion)
+	key := func(a tableAssertion) string { return a.table.String() + "\x00" + a.name }
+	for _, plan := range plans {
+		for name, query := range plan.opts.Assert {
+			a := tableAssertion{table: plan.table, name: name, query: query}
+			byKey[key(a)] = a
+		}
+	}
+	for _, a := range assertions {
+		byKey[key(a)] = a
+	}
+	res := make([]tableAssertion, 0, len(byKey))
+	for _, a := range byKey {
+		res = append(res, a)
+	}
+	slices.SortFunc(res, func(a, b tableAssertion) int {
+		return cmp.Or(strings.Compare(a.table.String(), b.table.String()), strings.Compare(a.name, b.name))
+	})
+	return res
+}
+
+// checkAssertions runs the assertions after the load, the run fails if any returns rows. The
+// first rows of a failing assertion are printed.
+func checkAssertions(db *sqlx.DB, assertions []tableAssertion, out io.Writer, log *slog.Logger) error {
+	color := colorFor(out)
+	failed := 0
+	for _, a := range assertions {
+		count, sample, err := runAssertion(db, a.query)
+		if err != nil {
+			return runError(err, dbErrorCode(err, ExportErrorCode))
+		}
+		log.Info("assertion checked", "table", a.table.String(), "assertion", a.name, "rows", count)
+		if count == 0 {
+			fmt.Fprintf(out, "%s: %s, ok\n", a.table, a.name)
+			continue
+		}
+		failed++
+		fmt.Fprintln(out, paint(color, colorRed, fmt.Sprintf("%s: %s, %d rows", a.table, a.name, count)))
+		for _, row := range sample {
+			fmt.Fprintf(out, "  %s\n", row)
+		}
+	}
+	if failed > 0 {
+		return runError(fmt.Errorf("%d of %d assertions returned rows", failed, len(assertions)), AssertionFailedCode)
+	}
+	return nil
+}
+
+// runAssertion returns the rows of the query counted, and the first ones as column=value lists.
+func runAssertion(db *sqlx.DB, query string) (int, []string, error) {
+	rows, err := db.Queryx(query)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, nil, err
+	}
+	count := 0
+	var sample []string
+	for rows.Next() {
+		count++
+		if count > assertionSampleRows {
+			continue
+		}
+		values, err := rows.SliceScan()
+		if err != nil {
+			return 0, nil, err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			switch b := v.(type) {
+			case nil:
+				v = "NULL"
+			case []byte:
+				v = string(b)
+			}
+			fields[i] = fmt.Sprintf("%s=%v", columns[i], v)
+		}
+		sample = append(sample, strings.Join(fields, ", "))
+	}
+	return count, sample, rows.Err()
+}
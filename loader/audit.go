@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditSources are the values audit columns are filled with: the start of the run, the uuid of
+// the run, the -audit-user and the name of the data file.
+var auditSources = []string{"run_time", "run_id", "user", "file"}
+
+func checkAudit(audit map[string]string) error {
+	for col, source := range audit {
+		if !slices.Contains(auditSources, source) {
+			return fmt.Errorf("audit column %s: unknown value %q, want %s", col, source, strings.Join(auditSources, ", "))
+		}
+	}
+	return nil
+}
+
+// auditUser returns the user audit columns are filled with, the name of the OS user if none
+// is given.
+func auditUser(name string) string {
+	if name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// auditValues returns the values of the audit columns of a data file.
+func auditValues(audit map[string]string, userName, fileName string) map[string]any {
+	if len(audit) == 0 {
+		return nil
+	}
+	values := make(map[string]any, len(audit))
+	for col, source := range audit {
+		switch source {
+		case "run_time":
+			values[col] = runStarted.Format(time.RFC3339Nano)
+		case "run_id":
+			values[col] = runID
+		case "user":
+			values[col] = auditUser(userName)
+		case "file":
+			values[col] = fileName
+		}
+	}
+	return values
+}
+
+// provenanceColumns are the columns recording where a row comes from, filled in the tables having
+// them: the name of the data file, the line the row starts on and the uuid of the run.
+var provenanceColumns = []string{"_SourceFile", "_SourceLine", "_LoadRunId"}
+
+// fillProvenance sets the provenance columns the table has in the record, over the values of the
+// file, so a row traces back to the file loading it.
+func fillProvenance(record dataRecord, fileName string, table *tableInfo) {
+	values := map[string]any{
+		provenanceColumns[0]: fileName,
+		provenanceColumns[1]: json.Number(strconv.Itoa(record.line)),
+		provenanceColumns[2]: runID,
+	}
+	setTableColumns([]dataRecord{record}, values, table, true)
+}
+
+// fillAudit sets the audit columns the table has in the records, the values of the file taking
+// precedence.
+func fillAudit(records []dataRecord, values map[string]any, table *tableInfo) {
+	setTableColumns(records, values, table, false)
+}
+
+// injectColumns sets the -inject columns the table has in every record, over the values of the file.
+func injectColumns(records []dataRecord, values map[string]any, table *tableInfo) {
+	setTableColumns(records, values, table, true)
+}
+
+// setTableColumns sets the columns of values the table has in the records, matched in any case.
+// The values of the records are kept unless override.
+func setTableColumns(records []dataRecord, values map[string]any, table *tableInfo, override bool) {
+	for col, val := range values {
+		i := slices.IndexFunc(table.columns, func(name string) bool { return strings.EqualFold(name, col) })
+		if i < 0 {
+			continue
+		}
+		col = table.columns[i]
+		for _, record := range records {
+			if override {
+				// the value of the file may be under another case
+				for name := range record.values {
+					if strings.EqualFold(name, col) {
+						delete(record.values, name)
+					}
+				}
+			}
+			if _, ok := record.values[col]; !ok {
+				record.values[col] = val
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditValues(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"01_Orders.json": "[]"})
+	_, source := sourceFor(t, "upload", "-d", dir, "-audit", "CreatedAt=run_time", "-audit", " LoadId = run_id ",
+		"-audit", "CreatedBy=user", "-audit", "SourceFile=file", "-audit-user", "etl")
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, _, err := source.planFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"CreatedAt":  runStarted.Format(time.RFC3339Nano),
+		"LoadId":     runID,
+		"CreatedBy":  "etl",
+		"SourceFile": "01_Orders.json",
+	}
+	audit := plans[0].audit
+	if len(audit) != len(want) {
+		t.Fatalf("audit values %v, want %v", audit, want)
+	}
+	for col, val := range want {
+		if audit[col] != val {
+			t.Errorf("%s = %v, want %v", col, audit[col], val)
+		}
+	}
+	if auditValues(nil, "etl", "01_Orders.json") != nil {
+		t.Error("audit values without audit columns")
+	}
+	if err := checkAudit(map[string]string{"CreatedAt": "now"}); err == nil || !strings.Contains(err.Error(), "audit column CreatedAt") {
+		t.Errorf("error %v, want the unknown value of the column", err)
+	}
+}
+
+func TestFillAudit(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "CreatedBy", DataType: "nvarchar", MaxLength: 50},
+		{Name: "SourceFile", DataType: "nvarchar", MaxLength: 200},
+	}}).tableInfo()
+	values := map[string]any{"createdby": "etl", "SourceFile": "01_Orders.json", "CreatedAt": "2024-05-01T00:00:00Z"}
+	records := []dataRecord{
+		{values: map[string]any{"Id": 1}},
+		{values: map[string]any{"Id": 2, "CreatedBy": "ann"}},
+	}
+	fillAudit(records, values, table)
+	// the columns are matched in any case, the ones the table has not are left out and the
+	// values of the file are kept
+	want := []map[string]any{
+		{"Id": 1, "CreatedBy": "etl", "SourceFile": "01_Orders.json"},
+		{"Id": 2, "CreatedBy": "ann", "SourceFile": "01_Orders.json"},
+	}
+	for i := range want {
+		if len(records[i].values) != len(want[i]) {
+			t.Errorf("record %d: %v, want %v", i+1, records[i].values, want[i])
+			continue
+		}
+		for col, val := range want[i] {
+			if records[i].values[col] != val {
+				t.Errorf("record %d: %s = %v, want %v", i+1, col, records[i].values[col], val)
+			}
+		}
+	}
+}
+
+func TestInjectColumns(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "TenantId", DataType: "int"},
+	}}).tableInfo()
+	// the value of the file is replaced, under any case, the columns the table has not are left out
+	records := planRecords(t, table, "01_Orders.json", `[{"Id": 1}, {"Id": 2, "tenantid": 7}]`,
+		"-inject", "TenantId=42", "-inject", "Region=eu")
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	for i, record := range records {
+		if len(record.values) != 2 || record.values["TenantId"] != "42" {
+			t.Errorf("record %d: %v, want TenantId 42", i+1, record.values)
+		}
+	}
+	if err := sourceError("-inject", "=42"); err == nil {
+		t.Error("no error for an inject without column")
+	}
+}
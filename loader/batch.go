@@ -0,0 +1,275 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// batch runs the statements of a file in transactions of up to size rows, committed as they fill.
+// With no size every statement commits on its own.
+type batch struct {
+	// ctx stops the statements, the transaction open when it is done is rolled back
+	ctx  context.Context
+	db   *sqlx.DB
+	size int
+	tx   *sqlx.Tx
+	log  *slog.Logger
+	// rows counts the rows of the open transaction
+	rows int
+	// next is the file offset past the last row handled, offset past the last row committed
+	next, offset int
+	// nextAt is the position in the data past the last row handled, at past the last row
+	// committed
+	nextAt, at filePosition
+	// onCommit is called with the offset and position after every commit
+	onCommit func(offset int, at filePosition) error
+	// observe is called after every commit with the time of the first statement of the
+	// transaction and its rows
+	observe func(started time.Time, rows int)
+	// started is when the first statement of the open transaction ran
+	started time.Time
+	// statements are the ones run in the open transaction, run again in a new one when the server
+	// throttles it
+	statements []batchStatement
+	// wait is the backoff of the next throttling, lost set when the statements could not be run
+	// again
+	wait time.Duration
+	lost bool
+	// fileTx keeps the transaction open for the whole file, committed at its end, size rows
+	// between savepoints with savepoints
+	fileTx, savepoints bool
+	// savedRows counts the rows kept in the transaction before the last savepoint
+	savedRows int
+	// saved are the statements run before the last savepoint, run again in a new transaction
+	// when the server rolls the open one back past it
+	saved []batchStatement
+}
+
+// savepointName names the savepoint of the batch rows in a file transaction.
+const savepointName = "uptomssql_batch"
+
+// batchStatement is a statement run in the open transaction.
+type batchStatement struct {
+	query string
+	args  []any
+	// keys receives the values the statement returns, read again when it runs again
+	keys []any
+}
+
+// run runs the statement on e, reading the row it returns into keys if it has keys.
+func (s batchStatement) run(ctx context.Context, e sqlx.ExtContext) (sql.Result, error) {
+	if s.keys == nil {
+		return e.ExecContext(ctx, s.query, s.args...)
+	}
+	dest := make([]any, len(s.keys))
+	for i := range s.keys {
+		dest[i] = &s.keys[i]
+	}
+	return nil, e.QueryRowxContext(ctx, s.query, s.args...).Scan(dest...)
+}
+
+// newBatch starts a batch for a file, at offset rows from the start when resuming.
+func newBatch(ctx context.Context, db *sqlx.DB, size int, offset int, log *slog.Logger) *batch {
+	return &batch{ctx: ctx, db: db, size: size, next: offset, offset: offset, log: log}
+}
+
+// exec runs the statement in the open transaction, trying it again while the server throttles.
+func (b *batch) exec(query string, args ...any) (sql.Result, error) {
+	return b.run(batchStatement{query: query, args: args})
+}
+
+// execReturning runs the statement like exec, reading the row it returns into keys, again when
+// the statement runs again in a new transaction.
+func (b *batch) execReturning(keys []any, query string, args ...any) error {
+	_, err := b.run(batchStatement{query: query, args: args, keys: keys})
+	return err
+}
+
+func (b *batch) run(stmt batchStatement) (sql.Result, error) {
+	if b.started.IsZero() {
+		b.started = time.Now()
+	}
+	res, err := b.execOnce(stmt)
+	for attempt := 1; attempt <= throttleRetries && isSqlError(err, throttleErrors); attempt++ {
+		if err = b.retryThrottled(attempt, err); err == nil {
+			res, err = b.execOnce(stmt)
+		}
+	}
+	if err == nil && b.tx != nil {
+		b.statements = append(b.statements, stmt)
+	}
+	return res, err
+}
+
+func (b *batch) execOnce(stmt batchStatement) (sql.Result, error) {
+	if b.size <= 0 && !b.fileTx {
+		return stmt.run(b.ctx, b.db)
+	}
+	if b.tx == nil {
+		tx, err := b.db.BeginTxx(b.ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		b.tx = tx
+		if err := b.save(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt.run(b.ctx, b.tx)
+}
+
+// rowDone counts a row inserted, next is the file offset past it. The transaction
+// is committed when it is full, in a file transaction a savepoint set.
+func (b *batch) rowDone(next int) error {
+	b.rows++
+	b.next = next
+	if b.size > 0 && b.rows-b.savedRows < b.size || b.fileTx && b.size <= 0 {
+		return nil
+	}
+	if b.fileTx {
+		b.savedRows = b.rows
+		return b.save()
+	}
+	return b.commit()
+}
+
+// rowSkipped moves past a row not inserted, it counts as handled with the next commit.
+func (b *batch) rowSkipped(next int) error {
+	b.next = next
+	if b.size > 0 || b.fileTx {
+		return nil
+	}
+	return b.commit()
+}
+
+// save sets the savepoint of the rows to come with savepoints, the statements run before are
+// kept whatever fails after.
+func (b *batch) save() error {
+	if !b.savepoints || b.tx == nil {
+		return nil
+	}
+	set, _ := dialectOf(b.db).savepoint(savepointName)
+	if _, err := b.tx.ExecContext(b.ctx, set); err != nil {
+		return err
+	}
+	b.saved = append(b.saved, b.statements...)
+	b.statements = nil
+	return nil
+}
+
+// rollbackBatch rolls the transaction back to the savepoint of the batch after a statement left
+// it unable to go on, and runs the statements of the batch done before it again, so the rows
+// before the batch are kept. A transaction the server rolled back past the savepoint, like SQL
+// Server does on errors dooming it, is run again in a new one instead. False when there is no
+// savepoint or the statements fail when run again.
+func (b *batch) rollbackBatch() bool {
+	if !b.savepoints || b.tx == nil || b.lost {
+		return false
+	}
+	_, rollback := dialectOf(b.db).savepoint(savepointName)
+	if _, err := b.tx.ExecContext(b.ctx, rollback); err != nil {
+		b.log.Warn("transaction rolled back past its savepoint, running it again", "err", err, "statements", len(b.saved)+len(b.statements))
+		// the server may have rolled it back already
+		_ = b.tx.Rollback()
+		b.tx = nil
+		if err := b.replay(); err != nil {
+			b.log.Warn("transaction run again", "err", err)
+			return false
+		}
+		return true
+	}
+	for _, stmt := range b.statements {
+		if _, err := stmt.run(b.ctx, b.tx); err != nil {
+			b.log.Warn("batch run again after the rollback to its savepoint", "err", err)
+			return false
+		}
+	}
+	return true
+}
+
+// replay runs the statements of the transaction rolled back again in a new one, with the
+// savepoint set between the ones before it and the others. lost is left set when they fail.
+func (b *batch) replay() error {
+	b.lost = len(b.saved) > 0 || len(b.statements) > 0
+	if !b.lost {
+		return nil
+	}
+	tx, err := b.db.BeginTxx(b.ctx, nil)
+	if err != nil {
+		return err
+	}
+	b.tx = tx
+	for _, stmt := range b.saved {
+		if _, err := stmt.run(b.ctx, tx); err != nil {
+			return err
+		}
+	}
+	if b.savepoints {
+		set, _ := dialectOf(b.db).savepoint(savepointName)
+		if _, err := tx.ExecContext(b.ctx, set); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range b.statements {
+		if _, err := stmt.run(b.ctx, tx); err != nil {
+			return err
+		}
+	}
+	b.lost = false
+	return nil
+}
+
+func (b *batch) commit() error {
+	if b.tx != nil {
+		if err := b.tx.Commit(); err != nil {
+			return err
+		}
+		b.tx = nil
+	}
+	if b.observe != nil && !b.started.IsZero() {
+		b.observe(b.started, b.rows)
+	}
+	b.started = time.Time{}
+	b.rows, b.savedRows = 0, 0
+	b.statements, b.saved = nil, nil
+	b.wait /= 2
+	b.offset, b.at = b.next, b.nextAt
+	if b.onCommit != nil {
+		return b.onCommit(b.offset, b.at)
+	}
+	return nil
+}
+
+// rollback drops the rows of the open transaction, one rolled back already when ctx was done
+// is no error.
+func (b *batch) rollback() error {
+	if b.tx == nil {
+		return nil
+	}
+	err := b.tx.Rollback()
+	b.tx = nil
+	b.started = time.Time{}
+	b.rows, b.savedRows = 0, 0
+	b.statements, b.saved = nil, nil
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// alive tells whether the open transaction can go on after a statement failed with err,
+// errors like conversions on the server roll it back entirely.
+func (b *batch) alive(err error) bool {
+	if b.lost {
+		return false
+	}
+	if b.tx == nil {
+		return true
+	}
+	return dialectOf(b.db).txAlive(b.tx, err)
+}
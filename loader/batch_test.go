@@ -0,0 +1,244 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeServer is a database/sql driver keeping the statements of the transactions committed. The
+// statements of fail fail, dooming the transaction like SQL Server on conversion errors with doom.
+type fakeServer struct {
+	fail map[string]bool
+	doom bool
+	// state is the XACT_STATE of the open transaction
+	state int
+	// tx holds the statements of the open transaction, mark the count before its savepoint
+	tx        []string
+	mark      int
+	committed []string
+	// identity is the last key generated for the inserts returning their key
+	identity int64
+	// results are the rows the queries of the keys return
+	results map[string]*fakeResult
+}
+
+// fakeResult is the columns and rows of a query.
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (s *fakeServer) Connect(context.Context) (driver.Conn, error) { return &fakeConn{s}, nil }
+func (s *fakeServer) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	s *fakeServer
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.s.tx, c.s.mark, c.s.state = nil, 0, 1
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	if c.s.state != 1 {
+		return errors.New("transaction doomed")
+	}
+	c.s.committed = append(c.s.committed, c.s.tx...)
+	c.s.tx, c.s.state = nil, 0
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.s.tx, c.s.state = nil, 0
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	s := c.s
+	switch {
+	case strings.Contains(query, "SAVE TRANSACTION"), strings.HasPrefix(query, "SAVEPOINT"):
+		s.mark = len(s.tx)
+	case strings.HasPrefix(query, "ROLLBACK"):
+		if s.state != 1 {
+			return nil, errors.New("3931: the current transaction cannot be committed")
+		}
+		s.tx = s.tx[:s.mark]
+	case s.fail[query]:
+		if s.doom {
+			s.state = -1
+		}
+		return nil, errors.New("conversion failed")
+	case s.state == 1:
+		s.tx = append(s.tx, query)
+	default:
+		s.committed = append(s.committed, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.HasPrefix(query, "DECLARE @keys") {
+		if _, err := c.ExecContext(ctx, query, args); err != nil {
+			return nil, err
+		}
+		c.s.identity++
+		return &fakeRows{value: c.s.identity}, nil
+	}
+	if strings.Contains(query, "sp_getapplock") {
+		return &fakeRows{}, nil
+	}
+	if result, ok := c.s.results[query]; ok {
+		return &fakeResultRows{result: result}, nil
+	}
+	if query != "SELECT XACT_STATE()" {
+		return nil, errors.New("query not supported")
+	}
+	return &fakeRows{value: int64(c.s.state)}, nil
+}
+
+// fakeRows is the single value row of a query.
+type fakeRows struct {
+	value int64
+	done  bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+// fakeResultRows are the rows of a fakeResult.
+type fakeResultRows struct {
+	result *fakeResult
+	next   int
+}
+
+func (r *fakeResultRows) Columns() []string { return r.result.columns }
+func (r *fakeResultRows) Close() error      { return nil }
+
+func (r *fakeResultRows) Next(dest []driver.Value) error {
+	if r.next == len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+// loadRows inserts the rows in the batch like uploadFile, failing rows set aside.
+func loadRows(b *batch, rows []string) error {
+	for i, query := range rows {
+		_, err := b.exec(query)
+		if err == nil {
+			if err := b.rowDone(i + 1); err != nil {
+				return err
+			}
+			continue
+		}
+		if !b.alive(err) && !b.rollbackBatch() {
+			return err
+		}
+		if err := b.rowSkipped(i + 1); err != nil {
+			return err
+		}
+	}
+	return b.commit()
+}
+
+func TestBatchSavepoints(t *testing.T) {
+	rows := []string{"INSERT 1", "INSERT 2", "INSERT 3", "INSERT bad", "INSERT 4"}
+	good := []string{"INSERT 1", "INSERT 2", "INSERT 3", "INSERT 4"}
+	tests := []struct {
+		name       string
+		driver     string
+		doom       bool
+		savepoints bool
+		want       []string
+		wantErr    bool
+	}{
+		{name: "sql server statement error", driver: "sqlserver", savepoints: true, want: good},
+		{name: "sql server doomed", driver: "sqlserver", doom: true, savepoints: true, want: good},
+		{name: "sql server doomed without savepoints", driver: "sqlserver", doom: true, wantErr: true},
+		{name: "postgres", driver: "pgx", savepoints: true, want: good},
+		{name: "postgres without savepoints", driver: "pgx", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeServer{fail: map[string]bool{"INSERT bad": true}, doom: tt.doom}
+			db := sql.OpenDB(server)
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			b := newBatch(context.Background(), sqlx.NewDb(db, tt.driver), 2, 0, logger())
+			b.fileTx, b.savepoints = true, tt.savepoints
+			err := loadRows(b, rows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if b.rollback() != nil || len(server.committed) > 0 {
+					t.Errorf("rows committed after the file failed: %v", server.committed)
+				}
+				return
+			}
+			if !slices.Equal(server.committed, tt.want) {
+				t.Errorf("committed %v, want %v", server.committed, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchReturnedKeys(t *testing.T) {
+	server := &fakeServer{fail: map[string]bool{"DECLARE @keys bad": true}, doom: true}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	b := newBatch(context.Background(), sqlx.NewDb(db, "sqlserver"), 10, 0, logger())
+	b.fileTx, b.savepoints = true, true
+	var keys [][]any
+	for i, query := range []string{"DECLARE @keys 1", "DECLARE @keys 2", "DECLARE @keys bad"} {
+		key := make([]any, 1)
+		err := b.execReturning(key, query)
+		if err == nil {
+			keys = append(keys, key)
+			if err := b.rowDone(i + 1); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if b.alive(err) || !b.rollbackBatch() {
+			t.Fatalf("batch not run again after %v", err)
+		}
+	}
+	if err := b.commit(); err != nil {
+		t.Fatal(err)
+	}
+	// the doomed transaction ran the inserts again, generating new keys
+	want := [][]any{{int64(3)}, {int64(4)}}
+	if !slices.EqualFunc(keys, want, slices.Equal) {
+		t.Errorf("keys %v, want the ones of the inserts run again %v", keys, want)
+	}
+}
@@ -0,0 +1,368 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Load strategies bench compares.
+const (
+	// benchRow runs a parameterized INSERT per row, as upload does
+	benchRow = "row"
+	// benchBatch runs INSERT statements of many rows of literal values
+	benchBatch = "batch"
+	// benchTVP runs an INSERT ... SELECT of the rows passed as a table-valued parameter
+	benchTVP = "tvp"
+	// benchBulk bulk copies the rows, as generate, kafka and copy do
+	benchBulk = "bulk"
+)
+
+var benchStrategies = []string{benchRow, benchBatch, benchTVP, benchBulk}
+
+// benchValuesRows is the most rows the server takes in the VALUES of an INSERT.
+const benchValuesRows = 1000
+
+// benchTypeName is the table type the tvp strategy creates for the run and drops after it.
+const benchTypeName = "dbo.__uptomssql_bench_rows"
+
+// benchOptions are the flags of the bench command.
+type benchOptions struct {
+	conn        connOptions
+	log         logOptions
+	table       string
+	rows        int
+	strategies  string
+	batchSizes  string
+	seed        uint64
+	nullRate    float64
+	lockTimeout time.Duration
+	conv        conversionOptions
+}
+
+func (o *benchOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.table, "t", "", "table (or schema.table) to load, it must be empty and is emptied after every run")
+	fs.IntVar(&o.rows, "n", 10000, "rows to generate and load in every run")
+	fs.StringVar(&o.strategies, "strategies", strings.Join(benchStrategies, ","), "comma separated load strategies to compare: row, batch, tvp or bulk")
+	fs.StringVar(&o.batchSizes, "batch-sizes", "100,1000,10000", "comma separated rows per transaction to run every strategy with")
+	fs.Uint64Var(&o.seed, "seed", 0, "seed of the random values, the same seed makes the same data; random if 0")
+	fs.Float64Var(&o.nullRate, "null-rate", 0.1, "share of null values in nullable columns, 0 to 1")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 0, "how long to wait for another run loading the same database to finish, 0 fails right away")
+	fs.IntVar(&o.conv.SRID, "srid", 4326, "spatial reference id for geography and geometry values")
+}
+
+func runBench(cmd *command, args []string) {
+	var opts benchOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	var strategies []string
+	var sizes []int
+	err := opts.log.check()
+	for _, s := range strings.Split(opts.strategies, ",") {
+		if s = strings.TrimSpace(s); !slices.Contains(benchStrategies, s) {
+			err = errors.Join(err, fmt.Errorf("unknown strategy %q, want one of %s", s, strings.Join(benchStrategies, ", ")))
+		}
+		strategies = append(strategies, s)
+	}
+	for _, s := range strings.Split(opts.batchSizes, ",") {
+		n, convErr := strconv.Atoi(strings.TrimSpace(s))
+		if convErr != nil || n <= 0 {
+			err = errors.Join(err, fmt.Errorf("-batch-sizes: invalid batch size %q", s))
+		}
+		sizes = append(sizes, n)
+	}
+	switch {
+	case err != nil:
+	case opts.table == "":
+		err = errors.New("no table to load, give it with -t")
+	case opts.rows <= 0:
+		err = fmt.Errorf("invalid -n %d", opts.rows)
+	case opts.nullRate < 0 || opts.nullRate > 1:
+		err = fmt.Errorf("invalid -null-rate %g, want 0 to 1", opts.nullRate)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	bench(&opts, slices.Compact(strategies), slices.Compact(sizes))
+}
+
+// benchResult is the time a strategy took to load the rows with a batch size.
+type benchResult struct {
+	strategy  string
+	batchSize int
+	duration  time.Duration
+	err       error
+}
+
+// bencher loads the same generated rows into the table with every strategy.
+type bencher struct {
+	db    *sqlx.DB
+	table *tableInfo
+	defs  []columnDefinition
+	cols  []ColumnSchema
+	rows  [][]any
+	conv  conversionOptions
+}
+
+// bench generates the rows once and loads them with every strategy and batch size in turn,
+// emptying the table after every run, and prints the rows per second of each.
+func bench(opts *benchOptions, strategies []string, sizes []int) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	lock, err := acquireRunLock(ctx, db, opts.lockTimeout)
+	handleError(err, dbErrorCode(err, LockedCode))
+	defer lock.release()
+
+	seed := opts.seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	g, err := newTableGenerator(db, parseTableRef(opts.table), rand.New(rand.NewPCG(seed, seed)), opts.nullRate, nil)
+	handleError(err, dbErrorCode(err, TableInfoErrorCode))
+	var count int64
+	err = db.Get(&count, fmt.Sprintf("SELECT COUNT_BIG(*) FROM %s", g.table.ref.quoted()))
+	handleError(err, dbErrorCode(err, TableInfoErrorCode))
+	if count > 0 {
+		handleError(fmt.Errorf("table %s has %d rows, bench empties the table after every run and takes only an empty one", g.table.ref, count), TableInfoErrorCode)
+	}
+
+	b := &bencher{db: db, table: g.table, defs: g.cols, cols: g.columnSchemas(), conv: opts.conv}
+	for range opts.rows {
+		row, err := g.row()
+		handleError(err, ConversionErrorCode)
+		for i, v := range row {
+			if row[i], err = convertValue(b.cols[i], v, opts.conv); err != nil {
+				handleError(fmt.Errorf("column %s value %v: %w", b.cols[i].ColumnName, v, err), ConversionErrorCode)
+			}
+		}
+		b.rows = append(b.rows, row)
+	}
+	logger().Info("rows generated", "table", g.table.ref.String(), "rows", len(b.rows), "seed", seed)
+	if slices.Contains(strategies, benchTVP) {
+		err = b.createType()
+		handleError(err, dbErrorCode(err, InsertDataErrorCode))
+		defer b.dropType()
+	}
+
+	var results []benchResult
+	for _, strategy := range strategies {
+		for _, size := range sizes {
+			duration, err := b.run(ctx, strategy, size)
+			if errors.Is(err, errInterrupted) {
+				handleError(errors.Join(b.empty(), errors.New("bench interrupted")), InterruptedCode)
+			}
+			if err != nil {
+				logger().Error("bench run failed", "strategy", strategy, "batch_size", size, "err", err)
+			} else {
+				logger().Info("bench run done", "strategy", strategy, "batch_size", size, "duration", duration.Round(time.Millisecond).String())
+			}
+			results = append(results, benchResult{strategy: strategy, batchSize: size, duration: duration, err: err})
+			err = b.empty()
+			handleError(err, dbErrorCode(err, InsertDataErrorCode))
+		}
+	}
+	printBench(os.Stdout, g.table.ref, len(b.rows), results)
+}
+
+// run loads the rows with the strategy in transactions of size rows and returns the time it took.
+func (b *bencher) run(ctx context.Context, strategy string, size int) (time.Duration, error) {
+	start := time.Now()
+	for rows := b.rows; len(rows) > 0; rows = rows[min(size, len(rows)):] {
+		if ctx.Err() != nil {
+			return 0, errInterrupted
+		}
+		tx, err := b.db.Beginx()
+		if err != nil {
+			return 0, err
+		}
+		if err := b.load(tx, strategy, rows[:min(size, len(rows))]); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+// load inserts the rows in tx with the strategy.
+func (b *bencher) load(tx *sqlx.Tx, strategy string, rows [][]any) error {
+	names := strings.Join(quoteNames(b.columnNames()), ", ")
+	switch strategy {
+	case benchRow:
+		placeholders := make([]string, len(b.cols))
+		for i, col := range b.cols {
+			placeholders[i] = placeholder(col, fmt.Sprintf("@p%d", i+1), b.conv)
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", b.table.ref.quoted(), names, strings.Join(placeholders, ", "))
+		for _, row := range rows {
+			if _, err := tx.Exec(query, row...); err != nil {
+				return err
+			}
+		}
+	case benchBatch:
+		for chunk := range slices.Chunk(rows, benchValuesRows) {
+			values := make([]string, len(chunk))
+			for r, row := range chunk {
+				literals := make([]string, len(row))
+				for i, v := range row {
+					literal, err := sqlLiteral(v)
+					if err != nil {
+						return fmt.Errorf("column %s: %w", b.cols[i].ColumnName, err)
+					}
+					literals[i] = literal
+					if v != nil {
+						literals[i] = placeholder(b.cols[i], literal, b.conv)
+					}
+				}
+				values[r] = "(" + strings.Join(literals, ", ") + ")"
+			}
+			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;", b.table.ref.quoted(), names, strings.Join(values, ", "))
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+	case benchTVP:
+		value, err := b.tvpRows(rows)
+		if err != nil {
+			return err
+		}
+		exprs := make([]string, len(b.defs))
+		for i, def := range b.defs {
+			name := "r." + quoteName(def.Name)
+			exprs[i] = placeholder(b.cols[i], name, b.conv)
+			if exprs[i] == name {
+				exprs[i] = fmt.Sprintf("CONVERT(%s, %s)", def.typeName(), name)
+			}
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM @p1 AS r;", b.table.ref.quoted(), names, strings.Join(exprs, ", "))
+		_, err = tx.Exec(query, mssql.TVP{TypeName: benchTypeName, Value: value})
+		return err
+	case benchBulk:
+		return bulkInsert(tx, b.table, b.cols, rows, b.conv)
+	}
+	return nil
+}
+
+func (b *bencher) columnNames() []string {
+	names := make([]string, len(b.cols))
+	for i, col := range b.cols {
+		names[i] = col.ColumnName
+	}
+	return names
+}
+
+// isBinary tells whether the values of the column are bytes.
+func isBinary(col ColumnSchema) bool {
+	return col.DataType == "binary" || col.DataType == "varbinary" || col.DataType == "image"
+}
+
+// createType creates the table type of the tvp strategy, text columns the INSERT converts to
+// the column types and binary ones.
+func (b *bencher) createType() error {
+	cols := make([]string, len(b.cols))
+	for i, col := range b.cols {
+		cols[i] = quoteName(col.ColumnName) + " nvarchar(max) NULL"
+		if isBinary(col) {
+			cols[i] = quoteName(col.ColumnName) + " varbinary(max) NULL"
+		}
+	}
+	b.dropType()
+	query := fmt.Sprintf("CREATE TYPE %s AS TABLE (%s);", benchTypeName, strings.Join(cols, ", "))
+	logger().Debug("query", "sql", query)
+	_, err := b.db.Exec(query)
+	return err
+}
+
+func (b *bencher) dropType() {
+	query := fmt.Sprintf("IF TYPE_ID(N'%s') IS NOT NULL DROP TYPE %s;", benchTypeName, benchTypeName)
+	if _, err := b.db.Exec(query); err != nil {
+		logger().Warn("drop table type failed", "type", benchTypeName, "err", err)
+	}
+}
+
+// tvpRows makes the value of the table-valued parameter, a slice of structs with a field for
+// each column, of the text of the values or their bytes.
+func (b *bencher) tvpRows(rows [][]any) (any, error) {
+	fields := make([]reflect.StructField, len(b.cols))
+	for i, col := range b.cols {
+		fields[i] = reflect.StructField{Name: fmt.Sprintf("C%d", i), Type: reflect.TypeFor[sql.NullString]()}
+		if isBinary(col) {
+			fields[i].Type = reflect.TypeFor[[]byte]()
+		}
+	}
+	value := reflect.MakeSlice(reflect.SliceOf(reflect.StructOf(fields)), len(rows), len(rows))
+	for r, row := range rows {
+		for i, v := range row {
+			field := value.Index(r).Field(i)
+			switch v := v.(type) {
+			case nil:
+				// the zero values are nulls
+			case []byte:
+				field.SetBytes(v)
+			case string:
+				field.Set(reflect.ValueOf(sql.NullString{String: v, Valid: true}))
+			default:
+				literal, err := sqlLiteral(v)
+				if err != nil {
+					return nil, fmt.Errorf("column %s: %w", b.cols[i].ColumnName, err)
+				}
+				// the literals of the other values are quoted text or numbers
+				text := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(literal, "N"), "'"), "'")
+				field.Set(reflect.ValueOf(sql.NullString{String: strings.ReplaceAll(text, "''", "'"), Valid: true}))
+			}
+		}
+	}
+	return value.Interface(), nil
+}
+
+// empty deletes the rows loaded by a run.
+func (b *bencher) empty() error {
+	query := fmt.Sprintf("DELETE FROM %s;", b.table.ref.quoted())
+	logger().Debug("query", "table", b.table.ref.String(), "sql", query)
+	_, err := b.db.Exec(query)
+	return err
+}
+
+// printBench writes the results as a table and the fastest run.
+func printBench(w io.Writer, table tableRef, rows int, results []benchResult) {
+	fmt.Fprintf(w, "%s, %d rows:\n", table, rows)
+	fmt.Fprintf(w, "  %-8s %10s %12s %12s\n", "strategy", "batch size", "duration", "rows/s")
+	var fastest *benchResult
+	for i, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "  %-8s %10d failed: %v\n", r.strategy, r.batchSize, r.err)
+			continue
+		}
+		fmt.Fprintf(w, "  %-8s %10d %12s %12.0f\n", r.strategy, r.batchSize, r.duration.Round(time.Millisecond), float64(rows)/r.duration.Seconds())
+		if fastest == nil || r.duration < fastest.duration {
+			fastest = &results[i]
+		}
+	}
+	if fastest != nil {
+		fmt.Fprintf(w, "fastest: %s with batch size %d\n", fastest.strategy, fastest.batchSize)
+	}
+}
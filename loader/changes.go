@@ -0,0 +1,320 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Operations of a change event, as in the change events of Debezium.
+const (
+	changeCreate = "c"
+	changeUpdate = "u"
+	changeDelete = "d"
+	// changeRead is a row of a snapshot, upserted so a snapshot replays over the rows there
+	changeRead = "r"
+)
+
+// changeOps maps the operations taken to the ones above.
+var changeOps = map[string]string{
+	"c": changeCreate, "i": changeCreate, "insert": changeCreate,
+	"u": changeUpdate, "update": changeUpdate,
+	"d": changeDelete, "delete": changeDelete,
+	"r": changeRead, "upsert": changeRead,
+}
+
+// changeEvent is a line of a change stream. The key defaults to the primary key values of data.
+type changeEvent struct {
+	Op    string         `json:"op"`
+	Table string         `json:"table"`
+	Key   map[string]any `json:"key"`
+	Data  map[string]any `json:"data"`
+}
+
+// applyOptions are the flags of the apply command.
+type applyOptions struct {
+	conn        connOptions
+	log         logOptions
+	batchSize   int
+	dryRun      bool
+	strict      bool
+	lockTimeout time.Duration
+	conv        conversionOptions
+}
+
+func (o *applyOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.IntVar(&o.batchSize, "batch-size", 500, "events per transaction, 0 commits every event on its own")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "read and convert the events and count them without writing")
+	fs.BoolVar(&o.strict, "strict", false, "fail on updates and deletes matching no row, by default they are logged and skipped")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 0, "how long to wait for another run loading the same database to finish, 0 fails right away")
+	fs.IntVar(&o.conv.SRID, "srid", 4326, "spatial reference id for geography and geometry values")
+}
+
+func runApply(cmd *command, args []string) {
+	var opts applyOptions
+	fs := newFlagSet(cmd)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: uptomssql %s [flags] <changes.ndjson>... (- for stdin)\n\n%s\n\n", cmd.name, cmd.summary)
+		fs.PrintDefaults()
+		printReturnCodes()
+	}
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.log.check()
+	if err == nil && fs.NArg() == 0 {
+		err = errors.New("give the change files to apply")
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	applyChanges(&opts, fs.Args())
+}
+
+// changeApplier applies change events in order, in transactions of the batch size.
+type changeApplier struct {
+	opts   *applyOptions
+	db     *sqlx.DB
+	u      *uploader
+	batch  *batch
+	tables map[string]*tableInfo
+	// counts are the events applied by operation, missed the updates and deletes matching no row
+	counts map[string]int
+	missed int
+}
+
+// applyChanges applies the change events of the files, NDJSON lines of operation, table, key and
+// data, as INSERT, UPDATE, DELETE or upsert statements.
+func applyChanges(opts *applyOptions, files []string) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if !opts.dryRun {
+		lock, err := acquireRunLock(ctx, db, opts.lockTimeout)
+		handleError(err, dbErrorCode(err, LockedCode))
+		defer lock.release()
+	}
+	a := &changeApplier{
+		opts:   opts,
+		db:     db,
+		u:      &uploader{ctx: ctx, db: db, opts: &uploadOptions{sourceOptions: sourceOptions{conv: opts.conv}}, summary: newRunSummary(), log: logger()},
+		batch:  newBatch(ctx, db, opts.batchSize, 0, logger()),
+		tables: make(map[string]*tableInfo),
+		counts: make(map[string]int),
+	}
+	for _, file := range files {
+		if err := a.applyFile(ctx, file); err != nil {
+			handleError(a.batch.rollback(), InsertDataErrorCode)
+			if errors.Is(err, errInterrupted) {
+				handleError(fmt.Errorf("%s: interrupted, the events up to line %d are applied", file, a.batch.offset), InterruptedCode)
+			}
+			handleError(err, dbErrorCode(err, InsertDataErrorCode))
+		}
+	}
+	err = a.batch.commit()
+	handleError(err, dbErrorCode(err, InsertDataErrorCode))
+	logger().Info("apply done", "inserted", a.counts[changeCreate], "updated", a.counts[changeUpdate], "deleted", a.counts[changeDelete],
+		"upserted", a.counts[changeRead], "missed", a.missed, "dry_run", opts.dryRun)
+}
+
+// applyFile applies the events of the file, stdin for -, committing the open transaction at its end.
+func (a *changeApplier) applyFile(ctx context.Context, file string) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			handleError(err, OpenFileErrorCode)
+		}
+		defer f.Close()
+		r = f
+	}
+	a.batch.next, a.batch.offset = 0, 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var event changeEvent
+		d := json.NewDecoder(bytes.NewReader(text))
+		d.UseNumber()
+		if err := d.Decode(&event); err != nil {
+			handleError(fmt.Errorf("%s line %d: invalid change event: %w", file, line, err), UnmarshalErrorCode)
+		}
+		at := fmt.Sprintf("%s line %d", file, line)
+		if err := a.apply(&event, at); err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			return fmt.Errorf("%s: %w", at, err)
+		}
+		if a.opts.dryRun {
+			continue
+		}
+		if err := a.batch.rowDone(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		handleError(fmt.Errorf("%s: %w", file, err), ReadFileErrorCode)
+	}
+	return a.batch.commit()
+}
+
+// apply runs the statement of the event read at the place given, it returns the database errors.
+func (a *changeApplier) apply(event *changeEvent, at string) error {
+	op, ok := changeOps[strings.ToLower(event.Op)]
+	if !ok {
+		handleError(fmt.Errorf("%s: unknown operation %q", at, event.Op), UnmarshalErrorCode)
+	}
+	if event.Table == "" {
+		handleError(fmt.Errorf("%s: event without table", at), UnmarshalErrorCode)
+	}
+	table := a.table(event.Table)
+	var stmt *insertStatement
+	var err error
+	switch op {
+	case changeCreate, changeRead:
+		mode := InsertMode
+		if op == changeRead {
+			mode = UpsertMode
+		}
+		row, err := a.u.buildRow(table, event.Data, Json, a.opts.conv)
+		if err == nil {
+			stmt, err = a.u.buildStatement(table, row, mode)
+		}
+		if err != nil {
+			handleError(fmt.Errorf("%s: %w", at, err), ConversionErrorCode)
+		}
+	case changeUpdate, changeDelete:
+		stmt, err = a.keyedStatement(table, op, event)
+		if err != nil {
+			handleError(fmt.Errorf("%s: %w", at, err), ConversionErrorCode)
+		}
+	}
+	a.counts[op]++
+	if a.opts.dryRun {
+		return nil
+	}
+	query := stmt.sql()
+	logger().Debug("query", "table", table.ref.String(), "op", op, "sql", query)
+	res, err := a.batch.exec(query, stmt.values...)
+	if err != nil {
+		return err
+	}
+	if op == changeUpdate || op == changeDelete {
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			a.counts[op]--
+			a.missed++
+			if a.opts.strict {
+				return fmt.Errorf("%s of %s matched no row with key %v", event.Op, table.ref, event.Key)
+			}
+			logger().Warn("change matched no row", "table", table.ref.String(), "op", op, "key", fmt.Sprint(event.Key))
+		}
+	}
+	return nil
+}
+
+// table returns the table info of the name, read once.
+func (a *changeApplier) table(name string) *tableInfo {
+	key := strings.ToLower(name)
+	if table, ok := a.tables[key]; ok {
+		return table
+	}
+	table, err := getTableInfo(a.db, parseTableRef(name))
+	handleError(err, dbErrorCode(err, TableInfoErrorCode))
+	if len(table.columns) == 0 {
+		handleError(fmt.Errorf("table %s not found", name), TableInfoErrorCode)
+	}
+	a.tables[key] = table
+	return table
+}
+
+// keyedStatement makes the UPDATE of the data columns or the DELETE of the row with the key.
+func (a *changeApplier) keyedStatement(table *tableInfo, op string, event *changeEvent) (*insertStatement, error) {
+	key := event.Key
+	if len(key) == 0 {
+		if len(table.primaryKey) == 0 {
+			return nil, fmt.Errorf("event without key for table %s, which has no primary key", table.ref)
+		}
+		key = make(map[string]any, len(table.primaryKey))
+		for _, name := range table.primaryKey {
+			val, ok := event.Data[name]
+			if !ok {
+				return nil, fmt.Errorf("event without key, %w: %s", errRequiredMissing, name)
+			}
+			key[name] = val
+		}
+		event.Key = key
+	}
+	var values []any
+	var sets []string
+	if op == changeUpdate {
+		for _, name := range table.columns {
+			val, ok := event.Data[name]
+			_, inKey := key[name]
+			if !ok || inKey || slices.Contains(table.computeColumns, name) || slices.Contains(table.identityColumns, name) {
+				continue
+			}
+			col := table.schema[name]
+			if _, skip := skipReason(col); skip {
+				continue
+			}
+			v, err := convertValue(col, val, a.opts.conv)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", name, err)
+			}
+			values = append(values, v)
+			sets = append(sets, fmt.Sprintf("%s = %s", quoteName(name), placeholder(col, fmt.Sprintf("@p%d", len(values)), a.opts.conv)))
+		}
+		if len(sets) == 0 {
+			return nil, fmt.Errorf("no column of %s to update", table.ref)
+		}
+	}
+	var conditions []string
+	for _, name := range slices.Sorted(maps.Keys(key)) {
+		col, ok := table.schema[name]
+		if !ok {
+			return nil, fmt.Errorf("key column %s not in table %s", name, table.ref)
+		}
+		if key[name] == nil {
+			conditions = append(conditions, quoteName(name)+" IS NULL")
+			continue
+		}
+		v, err := convertValue(col, key[name], a.opts.conv)
+		if err != nil {
+			return nil, fmt.Errorf("key column %s: %w", name, err)
+		}
+		values = append(values, v)
+		conditions = append(conditions, fmt.Sprintf("%s = %s", quoteName(name), placeholder(col, fmt.Sprintf("@p%d", len(values)), a.opts.conv)))
+	}
+	where := strings.Join(conditions, " AND ")
+	if op == changeDelete {
+		return &insertStatement{query: fmt.Sprintf("DELETE FROM %s WHERE %s;", table.ref.quoted(), where), values: values}, nil
+	}
+	return &insertStatement{query: fmt.Sprintf("UPDATE %s SET %s WHERE %s;", table.ref.quoted(), strings.Join(sets, ", "), where), values: values}, nil
+}
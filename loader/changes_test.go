@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// changeTable is the table the change events of the tests go to.
+func changeTable() *tableInfo {
+	return (&tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50, Nullable: true},
+		{Name: "Total", DataType: "int", Nullable: true},
+	}}).tableInfo()
+}
+
+func TestApplyFile(t *testing.T) {
+	events := `{"op": "c", "table": "Orders", "data": {"Id": 1, "Customer": "Ann"}}
+
+{"op": "u", "table": "orders", "data": {"Id": 1, "Total": 12}}
+{"op": "delete", "table": "Orders", "key": {"Id": 2}}
+{"op": "r", "table": "Orders", "data": {"Id": 3, "Customer": "Cid"}}
+`
+	path := filepath.Join(t.TempDir(), "changes.ndjson")
+	if err := os.WriteFile(path, []byte(events), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	sdb := sqlx.NewDb(db, "sqlserver")
+	opts := &applyOptions{batchSize: 10}
+	a := &changeApplier{
+		opts:   opts,
+		db:     sdb,
+		u:      &uploader{ctx: context.Background(), db: sdb, opts: &uploadOptions{}, summary: newRunSummary(), log: logger()},
+		batch:  newBatch(context.Background(), sdb, opts.batchSize, 0, logger()),
+		tables: map[string]*tableInfo{"orders": changeTable()},
+		counts: make(map[string]int),
+	}
+	if err := a.applyFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"INSERT INTO [dbo].[Orders] ([Id], [Customer]) VALUES (@p1, @p2);",
+		"UPDATE [dbo].[Orders] SET [Total] = @p1 WHERE [Id] = @p2;",
+		"DELETE FROM [dbo].[Orders] WHERE [Id] = @p1;",
+		"MERGE INTO [dbo].[Orders] AS target USING (VALUES (@p1, @p2)) AS source ([Id], [Customer]) ON target.[Id] = source.[Id]" +
+			" WHEN MATCHED THEN UPDATE SET target.[Customer] = source.[Customer]" +
+			" WHEN NOT MATCHED THEN INSERT ([Id], [Customer]) VALUES (source.[Id], source.[Customer]);",
+	}
+	if !slices.Equal(server.committed, want) {
+		t.Errorf("statements:\n%s\nwant:\n%s", strings.Join(server.committed, "\n"), strings.Join(want, "\n"))
+	}
+	for op, n := range map[string]int{changeCreate: 1, changeUpdate: 1, changeDelete: 1, changeRead: 1} {
+		if a.counts[op] != n {
+			t.Errorf("%s events %d, want %d", op, a.counts[op], n)
+		}
+	}
+}
+
+func TestKeyedStatement(t *testing.T) {
+	a := &changeApplier{opts: &applyOptions{}}
+	table := changeTable()
+	tests := []struct {
+		name       string
+		op         string
+		event      changeEvent
+		want       string
+		wantValues []any
+		wantErr    string
+	}{
+		{name: "update by key", op: changeUpdate, event: changeEvent{Key: map[string]any{"Id": "1"}, Data: map[string]any{"Id": "1", "Customer": "Ann", "Total": nil}},
+			want: "UPDATE [dbo].[Orders] SET [Customer] = @p1, [Total] = @p2 WHERE [Id] = @p3;", wantValues: []any{"Ann", nil, int64(1)}},
+		{name: "delete by null key", op: changeDelete, event: changeEvent{Key: map[string]any{"Customer": nil}},
+			want: "DELETE FROM [dbo].[Orders] WHERE [Customer] IS NULL;"},
+		{name: "key missing", op: changeDelete, event: changeEvent{Data: map[string]any{"Customer": "Ann"}}, wantErr: "event without key"},
+		{name: "nothing to update", op: changeUpdate, event: changeEvent{Data: map[string]any{"Id": "1"}}, wantErr: "no column"},
+		{name: "unknown key", op: changeDelete, event: changeEvent{Key: map[string]any{"Code": "a"}}, wantErr: "key column Code not in table"},
+	}
+	for _, tt := range tests {
+		stmt, err := a.keyedStatement(table, tt.op, &tt.event)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || stmt.query != tt.want || !slices.Equal(stmt.values, tt.wantValues) {
+			t.Errorf("%s: got %v, %v, want %s %v", tt.name, stmt, err, tt.want, tt.wantValues)
+		}
+	}
+}
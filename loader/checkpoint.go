@@ -0,0 +1,106 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint is the progress of a run saved to a local file as the rows are committed,
+// for -resume to go on from where an interrupted or failed run stopped.
+type checkpoint struct {
+	path string
+	// interval is the least time between two saves of the progress, saved the time of the last
+	interval time.Duration
+	saved    time.Time
+	Server   string `json:"server"`
+	Database string `json:"database"`
+	// Files holds the progress by data file path
+	Files map[string]*fileCheckpoint `json:"files"`
+}
+
+type fileCheckpoint struct {
+	// Rows counts the rows from the start of the file committed or rejected
+	Rows int `json:"rows"`
+	// Offset is the byte offset in the data past the rows, and Line the line there, where
+	// reading goes on
+	Offset int64 `json:"offset,omitempty"`
+	Line   int   `json:"line,omitempty"`
+	Done   bool  `json:"done"`
+}
+
+func newCheckpoint(path string, conn *connOptions) *checkpoint {
+	return &checkpoint{path: path, Server: conn.dataSource, Database: conn.initialCatalog, Files: make(map[string]*fileCheckpoint)}
+}
+
+// loadCheckpoint reads the checkpoint of an earlier run against the same database.
+func loadCheckpoint(path string, conn *connOptions) (*checkpoint, error) {
+	c := newCheckpoint(path, conn)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	if c.Server != conn.dataSource || c.Database != conn.initialCatalog {
+		return nil, fmt.Errorf("checkpoint %s is of a run on %s, database %s", path, c.Server, c.Database)
+	}
+	return c, nil
+}
+
+// file returns the progress of the data file, nil for no checkpoint.
+func (c *checkpoint) file(filePath string) *fileCheckpoint {
+	if c == nil {
+		return nil
+	}
+	key := filepath.Clean(filePath)
+	if c.Files[key] == nil {
+		c.Files[key] = &fileCheckpoint{}
+	}
+	return c.Files[key]
+}
+
+// progress saves the checkpoint if the interval went by since it was last saved.
+func (c *checkpoint) progress() error {
+	if c == nil || time.Since(c.saved) < c.interval {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file first, a crash leaves the previous one.
+func (c *checkpoint) save() error {
+	if c == nil {
+		return nil
+	}
+	c.saved = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// remove drops the checkpoint once the run is done.
+func (c *checkpoint) remove() error {
+	if c == nil {
+		return nil
+	}
+	err := os.Remove(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
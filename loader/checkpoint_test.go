@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointProgress(t *testing.T) {
+	conn := &connOptions{dataSource: "db1", initialCatalog: "app"}
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// wantRows are the rows of the file saved after each commit
+		wantRows []int
+	}{
+		{name: "every commit", interval: 0, wantRows: []int{10, 20, 30}},
+		{name: "interval", interval: time.Hour, wantRows: []int{10, 10, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "load.state.json")
+			c := newCheckpoint(path, conn)
+			c.interval = tt.interval
+			for i, want := range tt.wantRows {
+				c.file("Orders.json").Rows = (i + 1) * 10
+				if err := c.progress(); err != nil {
+					t.Fatal(err)
+				}
+				saved, err := loadCheckpoint(path, conn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := saved.file("Orders.json").Rows; got != want {
+					t.Errorf("commit %d: rows saved %d, want %d", i+1, got, want)
+				}
+			}
+			// the end of the file or a failure saves what the interval held back
+			if err := c.save(); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := loadCheckpoint(path, conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := saved.file("Orders.json").Rows, len(tt.wantRows)*10; got != want {
+				t.Errorf("rows saved %d, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package loader
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// ANSI colors of the terminal output.
+const (
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// noColor turns colors off, by -no-color or the NO_COLOR environment variable.
+var noColor = os.Getenv("NO_COLOR") != ""
+
+// colorFor tells whether output to w is colored, only terminals get colors.
+func colorFor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && !noColor && isTerminal(f)
+}
+
+// paint wraps s in the color when on is set.
+func paint(on bool, color, s string) string {
+	if !on {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// levelColorWriter colors the text log records of warnings and errors, written one per call.
+type levelColorWriter struct {
+	w io.Writer
+}
+
+func (c levelColorWriter) Write(p []byte) (int, error) {
+	color := ""
+	switch {
+	case bytes.Contains(p, []byte(" level=ERROR ")):
+		color = colorRed
+	case bytes.Contains(p, []byte(" level=WARN ")):
+		color = colorYellow
+	}
+	if color == "" {
+		return c.w.Write(p)
+	}
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	if _, err := io.WriteString(c.w, color+string(line)+colorReset+"\n"); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// localServers are the data source host names taken as the local machine.
+var localServers = []string{"localhost", "127.0.0.1", "::1", ".", "(local)"}
+
+// isLocalServer tells whether the data source, like tcp:host\instance,port, is the local machine.
+func isLocalServer(dataSource string) bool {
+	host := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(dataSource)), "tcp:")
+	if i := strings.IndexAny(host, ",\\"); i >= 0 {
+		host = host[:i]
+	}
+	return slices.Contains(localServers, host)
+}
+
+// destructiveTables lists the tables whose rows the plans delete before loading,
+// with the rows deleted.
+func destructiveTables(plans []*filePlan, noDelete bool) []string {
+	var tables []string
+	for _, plan := range plans {
+		switch {
+		case plan.opts.Truncate || plan.opts.Mode == RefreshMode:
+			tables = append(tables, plan.table.String()+": all rows")
+		case plan.opts.Mode == SyncMode && !noDelete:
+			tables = append(tables, plan.table.String()+": rows missing from the data files")
+		}
+	}
+	return slices.Compact(tables)
+}
+
+// confirmDestructive asks on the terminal before deleting rows on a non local server,
+// the run stops unless the answer is yes.
+func confirmDestructive(conn *connOptions, plans []*filePlan, noDelete bool) error {
+	return confirmDeletes(conn.dataSource, conn.initialCatalog, destructiveTables(plans, noDelete))
+}
+
+// confirmDeletes asks before deleting rows of the tables on a non local server.
+func confirmDeletes(server, database string, tables []string) error {
+	if len(tables) == 0 || isLocalServer(server) {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Rows of these tables will be deleted on server %s, database %s:\n", server, database)
+	for _, table := range tables {
+		fmt.Fprintf(os.Stderr, "  %s\n", table)
+	}
+	fmt.Fprint(os.Stderr, "Continue? [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		if err == nil {
+			err = errors.New("answered no")
+		}
+		return runError(fmt.Errorf("%w, run with -yes to skip the question", err), NotConfirmedCode)
+	}
+	return nil
+}
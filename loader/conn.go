@@ -0,0 +1,237 @@
+package loader
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+)
+
+// constraintErrors are the server error numbers of rows breaking a constraint:
+// NULL into a NOT NULL column, foreign key or check, unique index, primary key or unique constraint.
+var constraintErrors = []int32{515, 547, 2601, 2627}
+
+// mySqlConstraintErrors are the MySQL error numbers of rows breaking a constraint: NULL into a
+// NOT NULL column, no default, duplicate key, foreign key or check.
+var mySqlConstraintErrors = []uint16{1048, 1062, 1364, 1451, 1452, 3819}
+
+// the servers the upload loads, as the -driver flag names them
+const (
+	sqlServerDriver = "sqlserver"
+	postgresDriver  = "postgres"
+	mySqlDriver     = "mysql"
+)
+
+// driverDefaults are the port and initial catalog of the servers other than SQL Server.
+var driverDefaults = map[string]struct{ port, catalog string }{
+	postgresDriver: {"5432", "postgres"},
+	mySqlDriver:    {"3306", "mysql"},
+}
+
+// passwordEnv is the environment variable the password is taken from when no -p is given.
+const passwordEnv = "UPTOMSSQL_PASSWORD"
+
+// connOptions holds the flags every command connecting to the database takes.
+type connOptions struct {
+	dataSource     string
+	initialCatalog string
+	userId         string
+	password       string
+	// driver is the server to connect to, SQL Server if empty
+	driver string
+	// dsn is the connection string of a -target, connected to instead of the fields above
+	dsn string
+	// columnEncryption connects with Always Encrypted on, for the tables with encrypted columns
+	columnEncryption bool
+}
+
+func (o *connOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.dataSource, "s", "localhost,1433", "db data source")
+	fs.StringVar(&o.initialCatalog, "c", "master", "initial catalog")
+	fs.StringVar(&o.userId, "u", "test", "user id")
+	fs.StringVar(&o.password, "p", "test", "user password, "+passwordEnv+" of the environment if set")
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		// a -p given still goes first
+		o.password = password
+	}
+}
+
+// addDriverFlag adds the -driver flag, for the commands loading other servers than SQL Server.
+func (o *connOptions) addDriverFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.driver, "driver", sqlServerDriver, "database server: sqlserver, postgres or mysql")
+}
+
+// addEncryptionFlag adds the -column-encryption flag, for the commands inserting rows.
+func (o *connOptions) addEncryptionFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.columnEncryption, "column-encryption", false, "connect with Always Encrypted on, values of encrypted columns encrypted with keys of the Windows certificate store or Azure Key Vault")
+}
+
+// checkDriver checks the -driver flag, the data source and catalog not given default to the
+// ones of the server.
+func (o *connOptions) checkDriver(fs *flag.FlagSet) error {
+	switch o.driver {
+	case "", sqlServerDriver:
+		return nil
+	case postgresDriver, mySqlDriver:
+	default:
+		return fmt.Errorf("unknown driver %q", o.driver)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	defaults := driverDefaults[o.driver]
+	if !set["s"] {
+		o.dataSource = "localhost," + defaults.port
+	}
+	if !set["c"] {
+		o.initialCatalog = defaults.catalog
+	}
+	return nil
+}
+
+func (o *connOptions) connectionString() string {
+	if o.dsn != "" {
+		return withColumnEncryption(o.dsn, o.columnEncryption)
+	}
+	if o.driver == "" || o.driver == sqlServerDriver {
+		return withColumnEncryption(fmt.Sprintf("Data Source=%s; Initial Catalog=%s;User ID=%s;Password=%s;", o.dataSource, o.initialCatalog, o.userId, o.password), o.columnEncryption)
+	}
+	// the data source is host,port as for SQL Server
+	host, port, _ := strings.Cut(o.dataSource, ",")
+	if port == "" {
+		port = driverDefaults[o.driver].port
+	}
+	addr := net.JoinHostPort(strings.TrimPrefix(host, "tcp:"), port)
+	if o.driver == postgresDriver {
+		u := url.URL{Scheme: "postgres", User: url.UserPassword(o.userId, o.password), Host: addr, Path: "/" + o.initialCatalog}
+		return u.String()
+	}
+	cfg := mysql.NewConfig()
+	cfg.User = o.userId
+	cfg.Passwd = o.password
+	cfg.Net = "tcp"
+	cfg.Addr = addr
+	cfg.DBName = o.initialCatalog
+	return cfg.FormatDSN()
+}
+
+// withColumnEncryption returns the SQL Server connection string with Always Encrypted on if on,
+// as a URL parameter or a key=value pair.
+func withColumnEncryption(dsn string, on bool) string {
+	if !on {
+		return dsn
+	}
+	if u, err := url.Parse(dsn); err == nil && u.Scheme == "sqlserver" {
+		q := u.Query()
+		q.Set("columnencryption", "true")
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	if !strings.HasSuffix(strings.TrimSpace(dsn), ";") {
+		dsn += ";"
+	}
+	return dsn + columnEncryptionSetting
+}
+
+// parseTarget reads the connection string of a SQL Server to load, its server, database and user
+// kept for the questions, logs and checkpoint.
+func parseTarget(dsn string) (connOptions, error) {
+	cfg, err := msdsn.Parse(dsn)
+	if err != nil {
+		return connOptions{}, err
+	}
+	dataSource := cfg.Host
+	if cfg.Port != 0 {
+		dataSource = fmt.Sprintf("%s,%d", cfg.Host, cfg.Port)
+	}
+	return connOptions{dataSource: dataSource, initialCatalog: cfg.Database, userId: cfg.User, driver: sqlServerDriver, dsn: dsn}, nil
+}
+
+// open connects to the database, checking the connection works.
+func (o *connOptions) open() (*sqlx.DB, error) {
+	return openDriver(sqlDriverName(o.driver), o.connectionString())
+}
+
+// sqlDriverName returns the database/sql driver of a -driver flag value.
+func sqlDriverName(driver string) string {
+	switch driver {
+	case postgresDriver:
+		return "pgx"
+	case mySqlDriver:
+		return "mysql"
+	}
+	return "sqlserver"
+}
+
+// openDSN connects to the database of a connection string, as a URL or a list of key=value pairs.
+func openDSN(dsn string) (*sqlx.DB, error) {
+	return openDriver("sqlserver", dsn)
+}
+
+// openDriver connects to the database of a connection string of the database/sql driver, waiting
+// for a database not available yet.
+func openDriver(driverName, dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := ping(db, logger()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// reconnect checks the connections of the pool still work, connecting again when the server
+// closed them, like after an idle timeout, so a long run does not fail on them. database/sql drops
+// a connection the driver finds broken and tries the ping on another, a new one after two, so the
+// settings of a pool given by an embedding program are left as they are. Statements set no session
+// state a new connection misses, IDENTITY_INSERT is set around each insert and the run lock is
+// checked apart.
+func reconnect(ctx context.Context, db *sqlx.DB, log *slog.Logger) error {
+	if db == nil {
+		return nil
+	}
+	err := db.PingContext(ctx)
+	if err == nil {
+		return nil
+	}
+	log.Warn("connection lost, connecting again", "err", err)
+	return ping(db, log)
+}
+
+// dbErrorCode classifies a database error, code is returned for errors of no particular class.
+func dbErrorCode(err error, code AppExitCode) AppExitCode {
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) && slices.Contains(constraintErrors, sqlErr.SQLErrorNumber()) {
+		return ConstraintErrorCode
+	}
+	// class 23 are the integrity constraint violations of PostgreSQL
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23") {
+		return ConstraintErrorCode
+	}
+	var mySqlErr *mysql.MySQLError
+	if errors.As(err, &mySqlErr) && slices.Contains(mySqlConstraintErrors, mySqlErr.Number) {
+		return ConstraintErrorCode
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.As(err, &netErr) {
+		return ConnectionLostErrorCode
+	}
+	return code
+}
@@ -0,0 +1,78 @@
+package loader
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCopyColumns(t *testing.T) {
+	source := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int", Identity: &identityDefinition{Seed: 1, Increment: 1}},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50},
+		{Name: "Total", DataType: "money"},
+		{Name: "Legacy", DataType: "nvarchar", MaxLength: 10},
+		{Name: "Version", DataType: "timestamp"},
+	}}).tableInfo()
+	target := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int", Identity: &identityDefinition{Seed: 1, Increment: 1}},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50},
+		{Name: "Total", DataType: "money"},
+		{Name: "Net", DataType: "money", Computed: "([Total]/(1.19))"},
+		{Name: "Region", DataType: "nvarchar", MaxLength: 10, Nullable: true},
+		{Name: "Version", DataType: "timestamp"},
+	}}).tableInfo()
+
+	cols, err := copyColumns(source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, col := range cols {
+		names = append(names, col.ColumnName)
+	}
+	// computed, rowversion and columns of one table only are not copied
+	if want := []string{"Id", "Customer", "Total"}; !slices.Equal(names, want) {
+		t.Errorf("columns %v, want %v", names, want)
+	}
+	// the identity values are kept and money goes through the stage table
+	if !needsStage(target, cols) || !copiesIdentity(target, cols) {
+		t.Error("want the rows staged, identity copied")
+	}
+	want := []string{"[Id] * 1 AS [Id]", "[Customer]", "CONVERT(decimal(19, 4), [Total]) AS [Total]"}
+	if got := stageColumns(target, cols); !slices.Equal(got, want) {
+		t.Errorf("stage columns %v, want %v", got, want)
+	}
+	if needsStage(target, cols[1:2]) {
+		t.Error("want nvarchar columns bulk copied to the table")
+	}
+
+	target.columns = append(target.columns, "Tag")
+	target.schema["Tag"] = ColumnSchema{ColumnName: "Tag", DataType: "sql_variant"}
+	source.columns = append(source.columns, "Tag")
+	source.schema["Tag"] = ColumnSchema{ColumnName: "Tag", DataType: "sql_variant"}
+	if _, err := copyColumns(source, target); err == nil || !strings.Contains(err.Error(), "sql_variant") {
+		t.Errorf("error %v, want sql_variant not copied", err)
+	}
+}
+
+func TestBulkValue(t *testing.T) {
+	tests := []struct {
+		dataType string
+		v        any
+		want     any
+	}{
+		{dataType: "decimal", v: []byte("12.50"), want: "12.50"},
+		{dataType: "money", v: []byte("1.0000"), want: "1.0000"},
+		{dataType: "real", v: float32(1.5), want: 1.5},
+		{dataType: "int", v: int64(7), want: int64(7)},
+	}
+	for _, tt := range tests {
+		if got := bulkValue(ColumnSchema{DataType: tt.dataType}, tt.v); got != tt.want {
+			t.Errorf("%s %v: got %#v, want %#v", tt.dataType, tt.v, got, tt.want)
+		}
+	}
+	if got, ok := bulkValue(ColumnSchema{DataType: "varbinary"}, []byte{1}).([]byte); !ok || len(got) != 1 {
+		t.Errorf("varbinary %v, want the bytes", got)
+	}
+}
@@ -0,0 +1,76 @@
+package loader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Monday
+	from := time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 6-18 * * 1-5", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"@every 10m", time.Date(2024, 1, 1, 10, 17, 30, 0, time.UTC)},
+		{"0 9 * * 0", time.Date(2024, 1, 7, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 1, 7, 9, 0, 0, 0, time.UTC)},
+		{"0 8 * * 6-7", time.Date(2024, 1, 6, 8, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * 1", time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"30 2 29 2 *", time.Date(2024, 2, 29, 2, 30, 0, 0, time.UTC)},
+		{"5,35 */6 * 3 *", time.Date(2024, 3, 1, 0, 5, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := c.next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: next = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	c, err := parseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 02:00 is in the time of the location, not UTC
+	got := c.next(time.Date(2024, 6, 1, 12, 0, 0, 0, berlin))
+	if want := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next = %s, want %s", got, want)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"0 0 30 2 *",
+		"@every 0s",
+		"@every soon",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) = nil error", expr)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// daemonOptions are the flags of the daemon command, the arguments after them are of the command run.
+type daemonOptions struct {
+	log      logOptions
+	schedule string
+	command  string
+	// runAtStart runs the command once when the daemon starts, before the first scheduled time
+	runAtStart bool
+	healthAddr string
+}
+
+func (o *daemonOptions) addFlags(fs *flag.FlagSet) {
+	o.log.addFlags(fs)
+	fs.StringVar(&o.schedule, "schedule", "", "cron expression of the run times, minute hour day-of-month month day-of-week in local time, e.g. '*/15 * * * *', @hourly or '@every 10m'")
+	fs.StringVar(&o.command, "command", "upload", "command to run: upload, watch (with -once in the arguments), migrate, copy or verify")
+	fs.BoolVar(&o.runAtStart, "run-at-start", false, "run the command when the daemon starts too")
+	fs.StringVar(&o.healthAddr, "health-addr", "", "answer GET /healthz, /readyz and /metrics on this address, host:port, none if empty")
+}
+
+// daemonCommands are the commands the daemon runs.
+var daemonCommands = []string{"upload", "watch", "migrate", "copy", "verify"}
+
+func runDaemon(cmd *command, args []string) {
+	var opts daemonOptions
+	fs := newFlagSet(cmd)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: uptomssql %s [flags] -- [command flags]\n\n%s\n\n", cmd.name, cmd.summary)
+		fs.PrintDefaults()
+		printReturnCodes()
+	}
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	var schedule *cronSchedule
+	err := opts.log.check()
+	if err == nil && opts.schedule == "" {
+		err = errors.New("give the run times with -schedule")
+	}
+	if err == nil {
+		schedule, err = parseCron(opts.schedule)
+	}
+	if err == nil && !slices.Contains(daemonCommands, opts.command) {
+		err = fmt.Errorf("unknown command %q", opts.command)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	daemon(&opts, schedule, fs.Args())
+}
+
+// daemon runs the command in a process of its own at the times of the schedule until interrupted,
+// a failing run is logged and the next one runs on time.
+func daemon(opts *daemonOptions, schedule *cronSchedule, args []string) {
+	exe, err := os.Executable()
+	handleError(err, InternalErrorCode)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var health *healthState
+	if opts.healthAddr != "" {
+		health = newHealthState(nil)
+		handleError(health.serve(opts.healthAddr), InternalErrorCode)
+	}
+	logger().Info("daemon started", "command", opts.command, "schedule", schedule.String(), "pid", os.Getpid())
+	runs, failures := 0, 0
+	next := time.Now()
+	if !opts.runAtStart {
+		next = schedule.next(next)
+	}
+	for {
+		logger().Info("next run", "at", next.Format(time.RFC3339), "runs", runs, "consecutive_failures", failures)
+		select {
+		case <-ctx.Done():
+			logger().Info("daemon stopped", "runs", runs)
+			return
+		case <-time.After(time.Until(next)):
+		}
+		runs++
+		start := time.Now()
+		health.setQueued(1)
+		code := runChild(ctx, exe, append([]string{opts.command}, args...), nil)
+		health.setQueued(0)
+		health.runDone(start, code)
+		duration := time.Since(start).Round(time.Millisecond).String()
+		if code == SuccessCode {
+			failures = 0
+			logger().Info("run done", "run", runs, "duration", duration)
+		} else {
+			failures++
+			logger().Error("run failed", "run", runs, "code", code, "err", exitCodeDescription[code], "duration", duration, "consecutive_failures", failures)
+		}
+		// a run longer than the interval skips the times it overran
+		next = schedule.next(time.Now())
+	}
+}
+
+// runChild runs the tool with the arguments, its output going to the daemon's, and returns its
+// exit code. The run is interrupted when ctx is done and gets a minute to stop.
+func runChild(ctx context.Context, exe string, args, env []string) AppExitCode {
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, os.Stdout, os.Stderr
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = time.Minute
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return SuccessCode
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return exitErr.ExitCode()
+	}
+	logger().Error("run not started", "err", err)
+	return InternalErrorCode
+}
@@ -0,0 +1,184 @@
+package loader
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// rejectedInfix marks the files of rejected rows, e.g. 01_Users.rejected.json.
+const rejectedInfix = ".rejected."
+
+// rejectReasonColumn holds why a row was rejected, the loader ignores columns the table has not.
+const rejectReasonColumn = "_error"
+
+func isRejectedFile(fileName string) bool {
+	return strings.Contains(fileName, rejectedInfix)
+}
+
+// rejectedPath returns the dead letter file of the data file, next to it.
+func rejectedPath(filePath string) string {
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + rejectedInfix + strings.TrimPrefix(ext, ".")
+}
+
+// rejectWriter writes the rows rejected from a data file to its dead letter file, in the format,
+// encoding and column names of the data file so it can be fixed and loaded again.
+// The file is created with the first row.
+type rejectWriter struct {
+	path string
+	ext  Format
+	opts fileOptions
+	// fileColumns maps the table columns back to the renamed file columns
+	fileColumns map[string]string
+
+	// appending adds to the file of an earlier run instead of replacing it, when resuming
+	appending bool
+
+	f     *os.File
+	w     io.Writer
+	csv   *csv.Writer
+	count int
+}
+
+func newRejectWriter(filePath string, ext Format, opts fileOptions, appending bool) *rejectWriter {
+	fileColumns := make(map[string]string, len(opts.Columns))
+	for from, to := range opts.Columns {
+		fileColumns[to] = from
+	}
+	path := rejectedPath(filePath)
+	if ext != Json && ext != Csv {
+		// the rows of registered formats are written as json, e.g. 01_Users.rejected.xlsx.json
+		path, ext = path+".json", Json
+	}
+	return &rejectWriter{path: path, ext: ext, opts: opts, fileColumns: fileColumns, appending: appending}
+}
+
+// jsonArrayEnd closes the array of rejected rows in json files.
+const jsonArrayEnd = "\n]\n"
+
+func (w *rejectWriter) open(record dataRecord) error {
+	f, appended, err := w.create()
+	if err != nil {
+		return err
+	}
+	w.f, w.w = f, f
+	if w.opts.Encoding != "" {
+		enc, err := htmlindex.Get(w.opts.Encoding)
+		if err != nil {
+			return fmt.Errorf("encoding %q: %w", w.opts.Encoding, err)
+		}
+		w.w = transform.NewWriter(f, enc.NewEncoder())
+	}
+	if w.ext == Json {
+		if appended {
+			// the next row follows the ones there
+			w.count++
+			return nil
+		}
+		_, err = io.WriteString(w.w, "[\n")
+		return err
+	}
+	w.csv = csv.NewWriter(w.w)
+	w.csv.Comma = ';'
+	if w.opts.Delimiter != "" {
+		w.csv.Comma = []rune(w.opts.Delimiter)[0]
+	}
+	if appended {
+		return nil
+	}
+	return w.csv.Write(append(record.fields, rejectReasonColumn))
+}
+
+// create opens the file for the rows, positioned after the rows of an earlier run if appending to it.
+func (w *rejectWriter) create() (*os.File, bool, error) {
+	info, err := os.Stat(w.path)
+	if !w.appending || err != nil || info.Size() == 0 {
+		f, err := os.Create(w.path)
+		return f, false, err
+	}
+	f, err := os.OpenFile(w.path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	end := info.Size()
+	if w.ext == Json {
+		// reopen the array to add to it
+		tail := make([]byte, len(jsonArrayEnd))
+		_, err := f.ReadAt(tail, end-int64(len(jsonArrayEnd)))
+		if err != nil || string(tail) != jsonArrayEnd {
+			f.Close()
+			return nil, false, fmt.Errorf("%s does not end a json array, cannot add to it", w.path)
+		}
+		end -= int64(len(jsonArrayEnd))
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return nil, false, err
+		}
+	}
+	_, err = f.Seek(end, io.SeekStart)
+	return f, true, err
+}
+
+// add writes the row with the reason it was rejected.
+func (w *rejectWriter) add(record dataRecord, reason error) error {
+	if w.f == nil {
+		if err := w.open(record); err != nil {
+			return err
+		}
+	}
+	w.count++
+	values := make(map[string]any, len(record.values)+1)
+	for col, val := range record.values {
+		if fileCol, ok := w.fileColumns[col]; ok {
+			col = fileCol
+		}
+		values[col] = val
+	}
+	if w.ext == Csv {
+		row := make([]string, 0, len(record.fields)+1)
+		for _, field := range record.fields {
+			s, _ := values[field].(string)
+			row = append(row, s)
+		}
+		return w.csv.Write(append(row, reason.Error()))
+	}
+	values[rejectReasonColumn] = reason.Error()
+	b, err := json.MarshalIndent(values, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := "  "
+	if w.count > 1 {
+		sep = ",\n  "
+	}
+	_, err = fmt.Fprintf(w.w, "%s%s", sep, b)
+	return err
+}
+
+func (w *rejectWriter) close() error {
+	if w.f == nil {
+		return nil
+	}
+	var err error
+	if w.csv != nil {
+		w.csv.Flush()
+		err = w.csv.Error()
+	} else {
+		_, err = io.WriteString(w.w, jsonArrayEnd)
+	}
+	if c, ok := w.w.(io.Closer); ok && w.w != io.Writer(w.f) && err == nil {
+		err = c.Close()
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRejectWriterJson(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01_Users.json")
+	// the column Mail of the file is renamed Email for the table
+	opts := fileOptions{Columns: map[string]string{"Mail": "Email"}}
+	w := newRejectWriter(path, Json, opts, false)
+	if err := w.add(dataRecord{values: map[string]any{"Id": "1", "Email": "a@example.com"}}, errors.New("duplicate key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	// resuming adds to the rows of the earlier run
+	w = newRejectWriter(path, Json, opts, true)
+	if err := w.add(dataRecord{values: map[string]any{"Id": "2", "Email": "b"}}, errors.New("invalid email")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.path != filepath.Join(filepath.Dir(path), "01_Users.rejected.json") {
+		t.Errorf("path %s", w.path)
+	}
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]string
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatalf("%v:\n%s", err, data)
+	}
+	want := []map[string]string{
+		{"Id": "1", "Mail": "a@example.com", rejectReasonColumn: "duplicate key"},
+		{"Id": "2", "Mail": "b", rejectReasonColumn: "invalid email"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows %v, want %v", rows, want)
+	}
+	for i := range want {
+		for col, val := range want[i] {
+			if rows[i][col] != val {
+				t.Errorf("row %d: %s = %q, want %q", i, col, rows[i][col], val)
+			}
+		}
+	}
+}
+
+func TestRejectWriterCsv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01_Users.csv")
+	w := newRejectWriter(path, Csv, fileOptions{Delimiter: ","}, false)
+	fields := []string{"Id", "Name"}
+	for _, record := range []dataRecord{
+		{values: map[string]any{"Id": "1", "Name": "Ann"}, fields: fields},
+		{values: map[string]any{"Id": "x", "Name": "Bob, Jr."}, fields: fields},
+	} {
+		if err := w.add(record, errors.New("invalid Id")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "01_Users.rejected.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Id,Name,_error\n1,Ann,invalid Id\nx,\"Bob, Jr.\",invalid Id\n"
+	if string(data) != want {
+		t.Errorf("file:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestRejectWriterNoRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01_Users.json")
+	w := newRejectWriter(path, Json, fileOptions{}, false)
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(w.path); !os.IsNotExist(err) {
+		t.Errorf("dead letter file written without rejected rows: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package loader
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// diagOptions turns on the runtime diagnostics of a run.
+type diagOptions struct {
+	pprofAddr  string
+	cpuProfile string
+	memProfile string
+}
+
+func (o *diagOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.pprofAddr, "pprof-addr", "", "serve net/http/pprof on this address during the run, e.g. localhost:6060")
+	fs.StringVar(&o.cpuProfile, "cpuprofile", "", "write a cpu profile of the run to this file")
+	fs.StringVar(&o.memProfile, "memprofile", "", "write a heap profile at the end of the run to this file")
+}
+
+// start starts the diagnostics, the returned stop writes the profiles. It runs as well
+// when the run exits on an error.
+func (o *diagOptions) start() (stop func(), err error) {
+	if o.pprofAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(o.pprofAddr, nil); err != nil {
+				logger().Error("pprof server", "err", err)
+			}
+		}()
+	}
+	var cpu *os.File
+	if o.cpuProfile != "" {
+		cpu, err = os.Create(o.cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	stopped := false
+	stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if o.memProfile != "" {
+			if err := writeHeapProfile(o.memProfile); err != nil {
+				logger().Error("write heap profile", "err", err)
+			}
+		}
+	}
+	return stop, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	return errors.Join(pprof.WriteHeapProfile(f), f.Close())
+}
@@ -0,0 +1,177 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-sql/civil"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// errLockNotGranted fails a run finding the run lock held past its -lock-timeout.
+var errLockNotGranted = errors.New("another run is loading the database, lock not granted")
+
+// dialect is the SQL of a database server, so data files load into PostgreSQL and MySQL tables
+// as into SQL Server ones. The column types of the other servers are read as their SQL Server
+// counterparts, so the values convert the same whatever the server.
+type dialect interface {
+	// quote returns the identifier to use in statements
+	quote(name string) string
+	// param returns the placeholder of the nth parameter of a statement, from 1
+	param(n int) string
+	// tableInfo reads the metadata of a table, its columns typed with SQL Server names
+	tableInfo(db *sqlx.DB, table tableRef) (*tableInfo, error)
+	// insertQuery inserts a row of the columns
+	insertQuery(table *tableInfo, columns, placeholders []string) string
+	// upsertQuery inserts a row or updates the update columns of the row of the same primary key
+	upsertQuery(table *tableInfo, columns, placeholders, update []string) string
+	// identityInsert tells whether inserts of identity values go between SET IDENTITY_INSERT ON and OFF
+	identityInsert() bool
+	// syncIdentity returns the statements moving the identity sequences of the table past the
+	// values inserted, none if the server does it itself
+	syncIdentity(table *tableInfo) []*insertStatement
+	// bind returns the parameter of a converted value
+	bind(v any) any
+	// lock takes the run lock on the connection, waiting up to timeout for another run holding it
+	lock(ctx context.Context, conn *sqlx.Conn, timeout time.Duration) error
+	unlock(conn *sqlx.Conn) error
+	// txAlive tells whether the transaction can go on after a statement failed with err
+	txAlive(tx *sqlx.Tx, err error) bool
+	// savepoint returns the statements setting a savepoint of the name and rolling back to it
+	savepoint(name string) (set, rollback string)
+}
+
+// dialectOf returns the dialect of the server db connects to.
+func dialectOf(db *sqlx.DB) dialect {
+	switch db.DriverName() {
+	case "pgx":
+		return postgres{}
+	case "mysql":
+		return mySql{}
+	}
+	return sqlServer{}
+}
+
+// unsupportedType names a column type with no SQL Server counterpart, with its server so it is
+// not taken for a SQL Server type of the same name.
+func unsupportedType(server, dataType string) string {
+	return server + " " + dataType
+}
+
+// portableValue returns a converted value as a parameter the drivers of the other servers take,
+// the values typed for go-mssqldb as strings and times.
+func portableValue(v any) any {
+	switch v := v.(type) {
+	case mssql.VarChar:
+		return string(v)
+	case mssql.VarCharMax:
+		return string(v)
+	case mssql.DateTime1:
+		return time.Time(v)
+	case mssql.UniqueIdentifier:
+		return uuid.UUID(v).String()
+	case civil.Date, civil.DateTime, civil.Time:
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// primaryKeyOf reads the primary key columns of a table from INFORMATION_SCHEMA, for the servers
+// taking the schema of the table as the second parameter.
+func primaryKeyOf(db *sqlx.DB, d dialect, table tableRef) ([]string, error) {
+	query := fmt.Sprintf(`
+SELECT kcu.COLUMN_NAME
+FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc
+JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE kcu
+  ON kcu.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND kcu.CONSTRAINT_NAME = tc.CONSTRAINT_NAME AND kcu.TABLE_NAME = tc.TABLE_NAME
+WHERE tc.CONSTRAINT_TYPE = 'PRIMARY KEY' AND tc.TABLE_NAME = %s AND tc.TABLE_SCHEMA = %s
+ORDER BY kcu.ORDINAL_POSITION`, d.param(1), d.param(2))
+	var res []string
+	if err := db.Select(&res, query, table.name, table.schema); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// sqlServer is the dialect of SQL Server.
+type sqlServer struct{}
+
+func (sqlServer) quote(name string) string {
+	return quoteName(name)
+}
+
+func (sqlServer) param(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+func (sqlServer) insertQuery(table *tableInfo, columns, placeholders []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", table.quotedName(), strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+}
+
+// upsertQuery makes a MERGE matching the row to the table rows by primary key.
+func (sqlServer) upsertQuery(table *tableInfo, columns, placeholders, update []string) string {
+	var match, set, sourceColumns []string
+	for _, key := range table.primaryKey {
+		match = append(match, fmt.Sprintf("target.%s = source.%s", quoteName(key), quoteName(key)))
+	}
+	for _, col := range columns {
+		sourceColumns = append(sourceColumns, "source."+col)
+	}
+	for _, col := range update {
+		set = append(set, fmt.Sprintf("target.%s = source.%s", col, col))
+	}
+	query := fmt.Sprintf("MERGE INTO %s AS target USING (VALUES (%s)) AS source (%s) ON %s",
+		table.quotedName(), strings.Join(placeholders, ", "), strings.Join(columns, ", "), strings.Join(match, " AND "))
+	if len(set) > 0 {
+		query += " WHEN MATCHED THEN UPDATE SET " + strings.Join(set, ", ")
+	}
+	query += fmt.Sprintf(" WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);", strings.Join(columns, ", "), strings.Join(sourceColumns, ", "))
+	return query
+}
+
+func (sqlServer) identityInsert() bool {
+	return true
+}
+
+// syncIdentity returns none, identity inserts move the seed of the table past the values.
+func (sqlServer) syncIdentity(*tableInfo) []*insertStatement {
+	return nil
+}
+
+func (sqlServer) bind(v any) any {
+	return v
+}
+
+// lock takes an exclusive session application lock.
+func (sqlServer) lock(ctx context.Context, conn *sqlx.Conn, timeout time.Duration) error {
+	var status int
+	err := conn.GetContext(ctx, &status, `DECLARE @status int;
+EXEC @status = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2;
+SELECT @status;`, runLockResource, timeout.Milliseconds())
+	if err == nil && status < 0 {
+		err = fmt.Errorf("%w (status %d)", errLockNotGranted, status)
+	}
+	return err
+}
+
+func (sqlServer) unlock(conn *sqlx.Conn) error {
+	_, err := conn.ExecContext(context.Background(), "EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session';", runLockResource)
+	return err
+}
+
+// savepoint turns XACT_ABORT off, so a failing statement is rolled back alone and the
+// transaction goes on, the ones dooming it cannot be rolled back to the savepoint.
+func (sqlServer) savepoint(name string) (string, string) {
+	return "SET XACT_ABORT OFF; SAVE TRANSACTION " + name, "ROLLBACK TRANSACTION " + name
+}
+
+// txAlive asks the server, errors like conversions on the server roll the transaction back entirely.
+func (sqlServer) txAlive(tx *sqlx.Tx, err error) bool {
+	var state int
+	return tx.Get(&state, "SELECT XACT_STATE()") == nil && state == 1
+}
@@ -0,0 +1,137 @@
+package loader
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-sql/civil"
+	"github.com/google/uuid"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// dialectTable is the Orders table of the servers of the dialect tests, keyed by an identity Id.
+func dialectTable(d dialect, schema string) *tableInfo {
+	table := (&tableDefinition{Schema: schema, Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int", Identity: &identityDefinition{Seed: 1, Increment: 1}},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50, Nullable: true},
+		{Name: `Odd"Name`, DataType: "int", Nullable: true},
+	}}).tableInfo()
+	table.dialect = d
+	return table
+}
+
+func TestDialectStatements(t *testing.T) {
+	tests := []struct {
+		name           string
+		dialect        dialect
+		schema         string
+		mode           string
+		keyOnly        bool
+		want           string
+		identityInsert bool
+	}{
+		{name: "postgres insert", dialect: postgres{}, schema: "public", mode: InsertMode,
+			want: `INSERT INTO "public"."Orders" ("Id", "Customer", "Odd""Name") OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3);`},
+		{name: "postgres upsert", dialect: postgres{}, schema: "public", mode: UpsertMode,
+			want: `INSERT INTO "public"."Orders" ("Id", "Customer", "Odd""Name") OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3)` +
+				` ON CONFLICT ("Id") DO UPDATE SET "Customer" = EXCLUDED."Customer", "Odd""Name" = EXCLUDED."Odd""Name";`},
+		{name: "postgres upsert of the key", dialect: postgres{}, schema: "public", mode: UpsertMode, keyOnly: true,
+			want: `INSERT INTO "public"."Orders" ("Id") OVERRIDING SYSTEM VALUE VALUES ($1) ON CONFLICT ("Id") DO NOTHING;`},
+		{name: "mysql insert", dialect: mySql{}, schema: "shop", mode: InsertMode,
+			want: "INSERT INTO `shop`.`Orders` (`Id`, `Customer`, `Odd\"Name`) VALUES (?, ?, ?);"},
+		{name: "mysql upsert", dialect: mySql{}, schema: "shop", mode: UpsertMode,
+			want: "INSERT INTO `shop`.`Orders` (`Id`, `Customer`, `Odd\"Name`) VALUES (?, ?, ?)" +
+				" ON DUPLICATE KEY UPDATE `Customer` = VALUES(`Customer`), `Odd\"Name` = VALUES(`Odd\"Name`);"},
+		{name: "mysql upsert of the key", dialect: mySql{}, schema: "shop", mode: UpsertMode, keyOnly: true,
+			want: "INSERT INTO `shop`.`Orders` (`Id`) VALUES (?) ON DUPLICATE KEY UPDATE `Id` = `Id`;"},
+		{name: "sql server insert", dialect: sqlServer{}, schema: "dbo", mode: InsertMode,
+			want: "INSERT INTO [dbo].[Orders] ([Id], [Customer], [Odd\"Name]) VALUES (@p1, @p2, @p3);", identityInsert: true},
+	}
+	u := &uploader{opts: &uploadOptions{}}
+	for _, tt := range tests {
+		table := dialectTable(tt.dialect, tt.schema)
+		row := &rowValues{
+			columns: []ColumnSchema{table.schema["Id"], table.schema["Customer"], table.schema[`Odd"Name`]},
+			values:  []any{int64(1), mssql.VarChar("Ann"), nil},
+		}
+		if tt.keyOnly {
+			row = &rowValues{columns: row.columns[:1], values: row.values[:1]}
+		}
+		stmt, err := u.buildStatement(table, row, tt.mode)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if stmt.query != tt.want {
+			t.Errorf("%s: query\n%s\nwant\n%s", tt.name, stmt.query, tt.want)
+		}
+		if got := stmt.identityTable != ""; got != tt.identityInsert {
+			t.Errorf("%s: identity insert %v, want %v", tt.name, got, tt.identityInsert)
+		}
+	}
+}
+
+func TestSyncIdentity(t *testing.T) {
+	stmts := postgres{}.syncIdentity(dialectTable(postgres{}, "public"))
+	if len(stmts) != 1 {
+		t.Fatalf("%d statements, want 1", len(stmts))
+	}
+	want := `SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX("Id"), 0) + 1, false) FROM "public"."Orders";`
+	if stmts[0].query != want || !slices.Equal(stmts[0].values, []any{`"public"."Orders"`, "Id"}) {
+		t.Errorf("statement %s %v, want %s", stmts[0].query, stmts[0].values, want)
+	}
+	if stmts := (mySql{}).syncIdentity(dialectTable(mySql{}, "shop")); stmts != nil {
+		t.Errorf("mysql statements %v, want none", stmts)
+	}
+}
+
+func TestPortableValue(t *testing.T) {
+	id := uuid.MustParse("6f9619ff-8b86-d011-b42d-00c04fc964ff")
+	at := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		v    any
+		want any
+	}{
+		{v: mssql.VarChar("Ann"), want: "Ann"},
+		{v: mssql.VarCharMax("Ann"), want: "Ann"},
+		{v: mssql.DateTime1(at), want: at},
+		{v: mssql.UniqueIdentifier(id), want: id.String()},
+		{v: civil.Date{Year: 2024, Month: 5, Day: 1}, want: "2024-05-01"},
+		{v: civil.DateTime{Date: civil.Date{Year: 2024, Month: 5, Day: 1}, Time: civil.Time{Hour: 8, Minute: 30}}, want: "2024-05-01T08:30:00"},
+		{v: int64(3), want: int64(3)},
+	}
+	for _, tt := range tests {
+		for _, d := range []dialect{postgres{}, mySql{}} {
+			if got := d.bind(tt.v); got != tt.want {
+				t.Errorf("%T bind(%#v) = %#v, want %#v", d, tt.v, got, tt.want)
+			}
+		}
+	}
+	if v := mssql.VarChar("Ann"); (sqlServer{}).bind(v) != v {
+		t.Error("sql server values changed")
+	}
+}
+
+func TestMySqlType(t *testing.T) {
+	tests := []struct {
+		dataType, columnType string
+		want                 string
+	}{
+		{dataType: "tinyint", columnType: "tinyint(1)", want: "bit"},
+		{dataType: "tinyint", columnType: "tinyint(3) unsigned", want: "tinyint"},
+		{dataType: "tinyint", columnType: "tinyint(4)", want: "smallint"},
+		{dataType: "smallint", columnType: "smallint(5) unsigned", want: "int"},
+		{dataType: "int", columnType: "int(10) unsigned", want: "bigint"},
+		{dataType: "int", columnType: "int(11)", want: "int"},
+		{dataType: "varchar", columnType: "varchar(20)", want: "nvarchar"},
+		{dataType: "double", columnType: "double", want: "float"},
+		{dataType: "geometry", columnType: "geometry", want: "mysql geometry"},
+	}
+	for _, tt := range tests {
+		col := mySqlColumn{ColumnSchema: ColumnSchema{DataType: tt.dataType}, ColumnType: tt.columnType}
+		if got := mySqlType(col); got != tt.want {
+			t.Errorf("%s: type %s, want %s", tt.columnType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package loader
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// diffOptions are the flags of the diff command.
+type diffOptions struct {
+	sourceOptions
+	conn connOptions
+	log  logOptions
+}
+
+func (o *diffOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	o.sourceOptions.addFlags(fs)
+}
+
+func runDiff(cmd *command, args []string) {
+	var opts diffOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.sourceOptions.check()
+	if err == nil {
+		err = opts.log.check()
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	diff(&opts)
+}
+
+// diff compares the rows of the data files to the rows of their tables by primary key, reading only.
+func diff(opts *diffOptions) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+
+	source, err := newFileSource(&opts.sourceOptions, logger())
+	handleError(err, ReadDirErrorCode)
+	files, err := source.files()
+	handleError(err, ReadDirErrorCode)
+	plans, _, err := source.planFiles(files)
+	handleError(err, ReadFileErrorCode)
+
+	// the files of a table are compared together to the table rows
+	tables, tablePlans := groupByTable(plans)
+	differing := 0
+	for _, ref := range tables {
+		table, err := getTableInfo(db, ref)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+		if len(table.columns) == 0 {
+			handleError(fmt.Errorf("table %s not found", ref), TableInfoErrorCode)
+		}
+		if len(table.primaryKey) == 0 {
+			logger().Warn("skip table, no primary key to match the rows by", "table", ref.String())
+			continue
+		}
+		d := &tableDiff{table: ref}
+		rows := make(map[string]*diffRow)
+		var keys []string
+		for _, plan := range tablePlans[ref] {
+			d.files = append(d.files, plan.name)
+			for _, row := range readDiffRows(plan, table) {
+				if _, ok := rows[row.key]; !ok {
+					keys = append(keys, row.key)
+				} else {
+					logger().Warn("primary key twice in the data, the last row is compared", "file", row.file, "line", row.line, "key", row.key)
+				}
+				rows[row.key] = row
+			}
+		}
+		count, err := d.compare(db, table, rows)
+		handleError(err, dbErrorCode(err, ExportErrorCode))
+		for _, key := range keys {
+			if !rows[key].seen {
+				d.missing = append(d.missing, fmt.Sprintf("%s (%s line %d)", key, rows[key].file, rows[key].line))
+			}
+		}
+		logger().Info("table compared", "table", ref.String(), "file_rows", len(rows), "table_rows", count,
+			"missing", len(d.missing), "differing", len(d.differing), "extra", len(d.extra))
+		if d.differs() {
+			differing++
+			d.print(os.Stdout)
+		}
+	}
+	if differing > 0 {
+		handleError(fmt.Errorf("%d of %d tables differ from their data files", differing, len(tables)), DiffFoundCode)
+	}
+	logger().Info("diff done, the tables match the data files")
+}
+
+// diffRow is a data file row in the form compared to the table rows.
+type diffRow struct {
+	key  string
+	file string
+	line int
+	// values holds the compared form of the values by column, for the columns of the row only
+	values map[string]string
+	// seen is set once the table row with the key is found
+	seen bool
+}
+
+// readDiffRows reads and converts the rows of the data file as they would be loaded.
+func readDiffRows(plan *filePlan, table *tableInfo) []*diffRow {
+	records, err := plan.records(table)
+	defer records.close()
+	handleError(err, ReadFileErrorCode)
+	rows := make([]*diffRow, 0, records.len())
+	for i, record := range records.all() {
+		if record.err != nil {
+			handleError(fmt.Errorf("%s row %d (line %d): %w", plan.name, i+1, record.line, record.err), ConversionErrorCode)
+		}
+		row := &diffRow{file: plan.name, line: record.line, values: make(map[string]string)}
+		for _, col := range table.columns {
+			val, ok := record.values[col]
+			if !ok {
+				continue
+			}
+			colSchema := table.schema[col]
+			if _, skip := skipReason(colSchema); skip || slices.Contains(table.computeColumns, col) {
+				continue
+			}
+			if plan.ext == Csv && val == "NULL" {
+				val = nil
+			}
+			v, err := convertValue(colSchema, val, plan.conv)
+			if err == nil {
+				row.values[col], err = diffValue(colSchema, v)
+			}
+			if err != nil {
+				handleError(fmt.Errorf("%s row %d (line %d): column %s: %w", plan.name, i+1, record.line, col, err), ConversionErrorCode)
+			}
+		}
+		key, err := row.keyOf(table)
+		if err != nil {
+			handleError(fmt.Errorf("%s row %d (line %d): %w", plan.name, i+1, record.line, err), ValidationErrorCode)
+		}
+		row.key = key
+		rows = append(rows, row)
+	}
+	handleError(records.err(), ReadFileErrorCode)
+	return rows
+}
+
+// keyOf writes the primary key of the row like Id=1, Code='a'.
+func (r *diffRow) keyOf(table *tableInfo) (string, error) {
+	parts := make([]string, len(table.primaryKey))
+	for i, col := range table.primaryKey {
+		v, ok := r.values[col]
+		if !ok {
+			return "", fmt.Errorf("no value for primary key column %s", col)
+		}
+		parts[i] = col + "=" + v
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// recordKey writes the primary key of a data file record as keyOf does, converting the key values only.
+func recordKey(table *tableInfo, record map[string]any, ext Format, conv conversionOptions) (string, error) {
+	row := &diffRow{values: make(map[string]string, len(table.primaryKey))}
+	for _, col := range table.primaryKey {
+		val, ok := record[col]
+		if !ok {
+			continue
+		}
+		if ext == Csv && val == "NULL" {
+			val = nil
+		}
+		v, err := convertValue(table.schema[col], val, conv)
+		if err == nil {
+			row.values[col], err = diffValue(table.schema[col], v)
+		}
+		if err != nil {
+			return "", fmt.Errorf("column %s: %w", col, err)
+		}
+	}
+	return row.keyOf(table)
+}
+
+// tableKey is the primary key of a table row, in the compared form and as values to bind.
+type tableKey struct {
+	key    string
+	values []any
+}
+
+// tableKeys reads the primary keys of the table rows.
+func tableKeys(db *sqlx.DB, table *tableInfo) ([]tableKey, error) {
+	cols, exprs, err := exportColumns(table, tableExport{columns: table.primaryKey})
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) != len(table.primaryKey) {
+		return nil, fmt.Errorf("primary key of %s cannot be compared", table.ref)
+	}
+	result, err := db.Query(selectQuery(table, exprs, ""))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var keys []tableKey
+	for result.Next() {
+		if err := result.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := &diffRow{values: make(map[string]string, len(cols))}
+		key := tableKey{values: make([]any, len(cols))}
+		for i, col := range cols {
+			v, err := exportValue(col, values[i])
+			if err == nil {
+				v, err = convertValue(col, v, conversionOptions{})
+			}
+			if err == nil {
+				// bound in primary key order
+				key.values[slices.Index(table.primaryKey, col.ColumnName)] = v
+				row.values[col.ColumnName], err = diffValue(col, v)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %s: %w", len(keys)+1, col.ColumnName, err)
+			}
+		}
+		if key.key, err = row.keyOf(table); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, result.Err()
+}
+
+// tableDiff holds the differences between a table and its data files.
+type tableDiff struct {
+	table tableRef
+	files []string
+	// missing, differing and extra are the keys of the rows in the files only, in both with other values
+	// and in the table only, with the values differing
+	missing   []string
+	differing []string
+	extra     []string
+}
+
+// compare reads the table rows and matches them to the file rows by key, returning the table row count.
+func (d *tableDiff) compare(db *sqlx.DB, table *tableInfo, rows map[string]*diffRow) (int, error) {
+	cols, exprs, err := exportColumns(table, tableExport{})
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range table.primaryKey {
+		if !slices.ContainsFunc(cols, func(c ColumnSchema) bool { return c.ColumnName == key }) {
+			return 0, fmt.Errorf("primary key column %s of %s cannot be compared", key, table.ref)
+		}
+	}
+	result, err := db.Query(selectQuery(table, exprs, ""))
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	count := 0
+	for result.Next() {
+		if err := result.Scan(dest...); err != nil {
+			return count, err
+		}
+		count++
+		tableRow := &diffRow{values: make(map[string]string, len(cols))}
+		for i, col := range cols {
+			v, err := exportValue(col, values[i])
+			if err == nil {
+				v, err = convertValue(col, v, conversionOptions{})
+			}
+			if err == nil {
+				tableRow.values[col.ColumnName], err = diffValue(col, v)
+			}
+			if err != nil {
+				return count, fmt.Errorf("row %d column %s: %w", count, col.ColumnName, err)
+			}
+		}
+		key, err := tableRow.keyOf(table)
+		if err != nil {
+			return count, err
+		}
+		fileRow, ok := rows[key]
+		if !ok {
+			d.extra = append(d.extra, key)
+			continue
+		}
+		fileRow.seen = true
+		var changes []string
+		for _, col := range cols {
+			fileValue, ok := fileRow.values[col.ColumnName]
+			if ok && fileValue != tableRow.values[col.ColumnName] {
+				changes = append(changes, fmt.Sprintf("%s: file %s, table %s", col.ColumnName, fileValue, tableRow.values[col.ColumnName]))
+			}
+		}
+		if len(changes) > 0 {
+			d.differing = append(d.differing, key+": "+strings.Join(changes, "; "))
+		}
+	}
+	return count, result.Err()
+}
+
+func (d *tableDiff) differs() bool {
+	return len(d.missing) > 0 || len(d.differing) > 0 || len(d.extra) > 0
+}
+
+func (d *tableDiff) print(w io.Writer) {
+	header := fmt.Sprintf("%s (%s): %d missing, %d differing, %d extra",
+		d.table, strings.Join(d.files, ", "), len(d.missing), len(d.differing), len(d.extra))
+	fmt.Fprintln(w, paint(colorFor(w), colorYellow, header))
+	for _, key := range d.missing {
+		fmt.Fprintf(w, "  missing   %s\n", key)
+	}
+	for _, key := range d.differing {
+		fmt.Fprintf(w, "  differing %s\n", key)
+	}
+	for _, key := range d.extra {
+		fmt.Fprintf(w, "  extra     %s\n", key)
+	}
+}
+
+// diffValue writes a value converted for the column in the form compared, the same
+// for a value read from a data file and the value the server stored for it.
+func diffValue(col ColumnSchema, v any) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return diffText(col, val), nil
+	case mssql.VarChar:
+		return diffText(col, string(val)), nil
+	case mssql.VarCharMax:
+		return diffText(col, string(val)), nil
+	case float64:
+		if col.DataType == "real" {
+			return strconv.FormatFloat(val, 'g', -1, 32), nil
+		}
+	case mssql.DateTime1:
+		v = mssql.DateTime1(roundDateTime(col.DataType, time.Time(val)))
+	case time.Time:
+		v = val.UTC()
+	}
+	return sqlLiteral(v)
+}
+
+// diffText writes a text value, numbers without trailing zeros, fixed length text without padding
+// and spatial text without optional spaces.
+func diffText(col ColumnSchema, s string) string {
+	switch col.DataType {
+	case "decimal", "numeric", "money", "smallmoney":
+		s = trimZeros(s)
+	case "char", "nchar":
+		s = strings.TrimRight(s, " ")
+	case "geography", "geometry":
+		s = strings.ToUpper(strings.Join(strings.Fields(s), " "))
+		for _, sep := range []string{"(", ")", ","} {
+			s = strings.ReplaceAll(s, " "+sep, sep)
+			s = strings.ReplaceAll(s, sep+" ", sep)
+		}
+	}
+	return quoteString(s)
+}
+
+// trimZeros writes a number without trailing decimal zeros.
+func trimZeros(s string) string {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return s
+	}
+	return strings.TrimSuffix(strings.TrimRight(r.FloatString(38), "0"), ".")
+}
+
+// roundDateTime rounds the time as the server stores it, datetime to 1/300 of a second
+// and smalldatetime to the minute.
+func roundDateTime(dataType string, t time.Time) time.Time {
+	if dataType == "smalldatetime" {
+		return t.Round(time.Minute)
+	}
+	ticks := (int64(t.Nanosecond())*300 + int64(time.Second)/2) / int64(time.Second)
+	return t.Truncate(time.Second).Add(time.Duration(ticks) * time.Second / 300)
+}
@@ -0,0 +1,97 @@
+package loader
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestTableDiff(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50},
+		{Name: "Total", DataType: "decimal", Precision: 10, Scale: 2, Nullable: true},
+	}}).tableInfo()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"01_Orders.json": `[
+		{"Id": 1, "Customer": "Ann", "Total": 12.5},
+		{"Id": 2, "Customer": "Bob", "Total": 3},
+		{"Id": 3, "Customer": "Cid", "Total": null}
+	]`})
+	_, source := sourceFor(t, "diff", "-d", dir)
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, _, err := source.planFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]*diffRow)
+	for _, row := range readDiffRows(plans[0], table) {
+		rows[row.key] = row
+	}
+
+	server := &fakeServer{results: map[string]*fakeResult{
+		"SELECT [Id], [Customer], [Total] FROM [dbo].[Orders] ORDER BY [Id]": {
+			columns: []string{"Id", "Customer", "Total"},
+			rows: [][]driver.Value{
+				// the decimal is stored with its scale, the same value
+				{int64(1), "Ann", []byte("12.50")},
+				{int64(2), "Bobby", []byte("3.00")},
+				{int64(4), "Dan", nil},
+			},
+		},
+	}}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	d := &tableDiff{table: table.ref}
+	count, err := d.compare(sqlx.NewDb(db, "sqlserver"), table, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("table rows %d, want 3", count)
+	}
+	if want := []string{"Id=2: Customer: file 'Bob', table 'Bobby'"}; !slices.Equal(d.differing, want) {
+		t.Errorf("differing %q, want %q", d.differing, want)
+	}
+	if want := []string{"Id=4"}; !slices.Equal(d.extra, want) {
+		t.Errorf("extra %q, want %q", d.extra, want)
+	}
+	if !rows["Id=1"].seen || !rows["Id=2"].seen || rows["Id=3"].seen {
+		t.Error("want the rows of Id 1 and 2 found and Id 3 missing from the table")
+	}
+}
+
+func TestDiffValue(t *testing.T) {
+	at := time.Date(2024, 5, 2, 10, 15, 30, 4_000_000, time.UTC)
+	tests := []struct {
+		dataType string
+		v        any
+		want     string
+	}{
+		{dataType: "decimal", v: "12.500", want: "'12.5'"},
+		{dataType: "decimal", v: "3.00", want: "'3'"},
+		{dataType: "nchar", v: "ab   ", want: "'ab'"},
+		{dataType: "geography", v: "point ( 1 2 )", want: "'POINT(1 2)'"},
+		{dataType: "real", v: float64(float32(0.1)), want: "0.1"},
+	}
+	for _, tt := range tests {
+		got, err := diffValue(ColumnSchema{DataType: tt.dataType}, tt.v)
+		if err != nil || got != tt.want {
+			t.Errorf("%s %v: got %s, %v, want %s", tt.dataType, tt.v, got, err, tt.want)
+		}
+	}
+	// datetime is stored to 1/300 of a second
+	if got, want := roundDateTime("datetime", at), at.Truncate(time.Second).Add(time.Second/300); !got.Equal(want) {
+		t.Errorf("datetime %v, want %v", got, want)
+	}
+	if got, want := roundDateTime("smalldatetime", at), at.Truncate(time.Minute).Add(time.Minute); !got.Equal(want) {
+		t.Errorf("smalldatetime %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// duplicateSampleRows are the rows of a duplicate key printed.
+const duplicateSampleRows = 5
+
+// keyRow is a row of a data file by its key.
+type keyRow struct {
+	file string
+	line int
+}
+
+func (r keyRow) String() string {
+	return fmt.Sprintf("%s line %d", r.file, r.line)
+}
+
+// duplicateKey is a key more than one row of the data files of a table has.
+type duplicateKey struct {
+	table tableRef
+	key   string
+	rows  []keyRow
+}
+
+// keyColumns returns the columns rows of the table are told apart by: the business key given for
+// the table, or else its primary key. Names are matched to the table columns in any case.
+func keyColumns(table *tableInfo, businessKeys map[string][]string) ([]string, error) {
+	names, ok := tableEntry(businessKeys, table.ref)
+	if !ok {
+		return table.primaryKey, nil
+	}
+	columns := make([]string, len(names))
+	for i, name := range names {
+		j := slices.IndexFunc(table.columns, func(col string) bool { return strings.EqualFold(col, name) })
+		if j < 0 {
+			return nil, fmt.Errorf("key column %s not in table %s", name, table.ref)
+		}
+		columns[i] = table.columns[j]
+	}
+	return columns, nil
+}
+
+// rowKeyOf writes the key columns of a data file record as recordKey does, ok false if the record
+// has none of them, like rows whose identity the server generates.
+func rowKeyOf(table *tableInfo, columns []string, record map[string]any, ext Format, conv conversionOptions) (string, bool, error) {
+	parts := make([]string, 0, len(columns))
+	for _, col := range columns {
+		val, ok := record[col]
+		if !ok {
+			continue
+		}
+		if ext == Csv && val == "NULL" {
+			val = nil
+		}
+		v, err := convertValue(table.schema[col], val, conv)
+		if err == nil {
+			v, err = diffValue(table.schema[col], v)
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("column %s: %w", col, err)
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", col, v))
+	}
+	return strings.Join(parts, ", "), len(parts) > 0, nil
+}
+
+// findDuplicateKeys reads the data files of every table and returns the keys more than one of their
+// rows has, in the same file or in several. Rows failing to read or convert are left to the load.
+func findDuplicateKeys(tables *tableCache, plans []*filePlan, businessKeys map[string][]string) ([]duplicateKey, error) {
+	var duplicates []duplicateKey
+	refs, tablePlans := groupByTable(plans)
+	for _, ref := range refs {
+		table, err := tables.get(ref)
+		if err != nil {
+			return nil, err
+		}
+		columns, err := keyColumns(table, businessKeys)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 {
+			tables.log.Info("no primary or business key, duplicates not checked", "table", ref.String())
+			continue
+		}
+		var keys []string
+		rows := make(map[string][]keyRow)
+		for _, plan := range tablePlans[ref] {
+			records, err := plan.records(table)
+			if err != nil {
+				records.close()
+				return nil, err
+			}
+			for _, record := range records.all() {
+				if record.err != nil {
+					continue
+				}
+				key, ok, err := rowKeyOf(table, columns, record.values, plan.ext, plan.conv)
+				if err != nil || !ok {
+					continue
+				}
+				if rows[key] == nil {
+					keys = append(keys, key)
+				}
+				rows[key] = append(rows[key], keyRow{file: plan.name, line: record.line})
+			}
+			records.close()
+			if err := records.err(); err != nil {
+				return nil, err
+			}
+		}
+		for _, key := range keys {
+			if len(rows[key]) > 1 {
+				duplicates = append(duplicates, duplicateKey{table: ref, key: key, rows: rows[key]})
+			}
+		}
+		tables.log.Info("duplicate keys checked", "table", ref.String(), "keys", len(keys))
+	}
+	return duplicates, nil
+}
+
+// checkDuplicateKeys fails the run before anything is written if rows of the data files of a
+// table share a key, printing the rows of each.
+func checkDuplicateKeys(tables *tableCache, plans []*filePlan, businessKeys map[string][]string, out io.Writer) error {
+	duplicates, err := findDuplicateKeys(tables, plans, businessKeys)
+	if err != nil {
+		return runError(err, dbErrorCode(err, TableInfoErrorCode))
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	color := colorFor(out)
+	for _, d := range duplicates {
+		fmt.Fprintln(out, paint(color, colorRed, fmt.Sprintf("%s: %s in %d rows", d.table, d.key, len(d.rows))))
+		for _, row := range d.rows[:min(len(d.rows), duplicateSampleRows)] {
+			fmt.Fprintf(out, "  %s\n", row)
+		}
+	}
+	return runError(fmt.Errorf("%d keys in more than one row of the data files", len(duplicates)), DuplicateKeysCode)
+}
@@ -0,0 +1,79 @@
+package loader
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckDuplicateKeys(t *testing.T) {
+	defs := []*tableDefinition{
+		{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+			{Name: "Id", DataType: "int"},
+			{Name: "Total", DataType: "int", Nullable: true},
+		}},
+		{Schema: "dbo", Name: "Customers", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+			{Name: "Id", DataType: "int"},
+			{Name: "Email", DataType: "nvarchar", MaxLength: 100},
+		}},
+		{Schema: "dbo", Name: "Notes", Columns: []columnDefinition{
+			{Name: "Text", DataType: "nvarchar", MaxLength: 100},
+		}},
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		// the same key in a file and across files of a table, written as number and text
+		"01_Orders.json": "[\n{\"Id\": 1},\n{\"Id\": 2},\n{\"Id\": \"1\", \"Total\": 5},\n{\"Total\": 7}\n]",
+		"02_Orders.csv":  "Id;Total\n3;1\n2;4\n",
+		// told apart by the business key, not the primary key
+		"03_Customers.json": "[\n{\"Id\": 1, \"Email\": \"a@example.com\"},\n{\"Id\": 2, \"Email\": \"a@example.com\"},\n{\"Id\": 1, \"Email\": \"b@example.com\"}\n]",
+		// no key, not checked
+		"04_Notes.json": `[{"Text": "x"}, {"Text": "x"}]`,
+	})
+	_, source := sourceFor(t, "upload", "-d", dir)
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, _, err := source.planFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := newSnapshotCache(defs, logger())
+	businessKeys := map[string][]string{"Customers": {"email"}}
+	duplicates, err := findDuplicateKeys(tables, plans, businessKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Orders: Id=1 in 01_Orders.json line 2, 01_Orders.json line 4",
+		"Orders: Id=2 in 01_Orders.json line 3, 02_Orders.csv line 3",
+		"Customers: Email='a@example.com' in 03_Customers.json line 2, 03_Customers.json line 3",
+	}
+	var got []string
+	for _, d := range duplicates {
+		var rows []string
+		for _, row := range d.rows {
+			rows = append(rows, row.String())
+		}
+		got = append(got, d.table.String()+": "+d.key+" in "+strings.Join(rows, ", "))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("duplicates:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var out bytes.Buffer
+	err = checkDuplicateKeys(tables, plans, businessKeys, &out)
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Code != DuplicateKeysCode || !strings.Contains(err.Error(), "3 keys") {
+		t.Errorf("error %v, want the 3 keys shared", err)
+	}
+	if !strings.Contains(out.String(), "Orders: Id=2 in 2 rows\n  01_Orders.json line 3\n  02_Orders.csv line 3\n") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	if _, err := findDuplicateKeys(tables, plans, map[string][]string{"Customers": {"Phone"}}); err == nil || !strings.Contains(err.Error(), "key column Phone") {
+		t.Errorf("error %v, want the business key column missing", err)
+	}
+}
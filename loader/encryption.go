@@ -0,0 +1,72 @@
+package loader
+
+import (
+	"fmt"
+	"slices"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	// the column master key stores the driver decrypts column encryption keys with: the Windows
+	// certificate store or pfx files, and Azure Key Vault
+	_ "github.com/microsoft/go-mssqldb/aecmk/akv"
+	_ "github.com/microsoft/go-mssqldb/aecmk/localcert"
+)
+
+// columnEncryptionSetting turns Always Encrypted on in a SQL Server connection string.
+const columnEncryptionSetting = "Column Encryption Setting=Enabled;"
+
+// encryptedTypes are the types of the Always Encrypted columns values are loaded into. The server
+// converts no parameter to an encrypted column, the driver has no parameter of the other types,
+// like decimal or char, and binds time types at precision 7 only.
+var encryptedTypes = []string{
+	"bit", "tinyint", "smallint", "int", "bigint", "float", "real",
+	"date", "datetime", "datetime2", "datetimeoffset", "time",
+	"varchar", "nchar", "nvarchar", "varbinary", "uniqueidentifier",
+}
+
+// checkEncryptedColumns tells why the rows of the file cannot be loaded into the Always
+// Encrypted columns of the table, if so.
+func (u *uploader) checkEncryptedColumns(table *tableInfo) error {
+	for _, name := range table.encryptedColumns {
+		col := table.schema[name]
+		switch {
+		case u.script != nil:
+			return fmt.Errorf("column %s of %s is Always Encrypted, the script cannot write its values", name, table.ref)
+		case !u.opts.conn.columnEncryption && !u.opts.validate && !u.opts.dryRun:
+			return fmt.Errorf("column %s of %s is Always Encrypted, load it with -column-encryption", name, table.ref)
+		case !slices.Contains(encryptedTypes, col.DataType):
+			return fmt.Errorf("column %s of %s: Always Encrypted %s columns are not loaded", name, table.ref, col.DataType)
+		case (col.DataType == "datetime2" || col.DataType == "datetimeoffset" || col.DataType == "time") && col.DatetimePrecision.Int64 != 7:
+			return fmt.Errorf("column %s of %s: Always Encrypted %s(%d) columns are not loaded, only precision 7", name, table.ref, col.DataType, col.DatetimePrecision.Int64)
+		}
+	}
+	return nil
+}
+
+// encryptedValue returns a converted value of an Always Encrypted column typed as the column, the
+// integers and reals convertValue binds wider. Text of a varchar column has to be ascii, the
+// server cannot convert an nvarchar value to its code page.
+func encryptedValue(col ColumnSchema, val any) (any, error) {
+	switch v := val.(type) {
+	case int64:
+		switch col.DataType {
+		case "tinyint":
+			return uint8(v), nil
+		case "smallint":
+			return int16(v), nil
+		case "int":
+			return int32(v), nil
+		}
+	case float64:
+		if col.DataType == "real" {
+			return float32(v), nil
+		}
+	case string:
+		switch col.DataType {
+		case "nchar":
+			return mssql.NChar(v), nil
+		case "varchar":
+			return nil, fmt.Errorf("non-ascii text %q for an Always Encrypted varchar column", v)
+		}
+	}
+	return val, nil
+}
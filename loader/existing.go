@@ -0,0 +1,217 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// existingPolicies are what -check-existing does with the rows of the files whose key a table row
+// has: fail the run, leave them out or upsert them.
+var existingPolicies = []string{"fail", "skip", "upsert"}
+
+// uniqueIndex is a unique index or primary key of a table, by its key columns.
+type uniqueIndex struct {
+	name       string
+	primaryKey bool
+	columns    []string
+}
+
+// getUniqueIndexes returns the unique indexes of the table, filtered ones left out as they do
+// not hold for every row.
+func getUniqueIndexes(db *sqlx.DB, table *tableInfo) ([]uniqueIndex, error) {
+	query := `
+SELECT i.name AS index_name, i.is_primary_key, c.name AS column_name
+FROM ` + table.ref.catalog() + `sys.indexes i
+JOIN ` + table.ref.catalog() + `sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 0
+JOIN ` + table.ref.catalog() + `sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE i.object_id = OBJECT_ID(@p1) AND i.is_unique = 1 AND i.has_filter = 0
+ORDER BY i.index_id, ic.key_ordinal`
+	var rows []struct {
+		Index      string `db:"index_name"`
+		PrimaryKey bool   `db:"is_primary_key"`
+		Column     string `db:"column_name"`
+	}
+	if err := db.Select(&rows, query, table.quotedName()); err != nil {
+		return nil, err
+	}
+	var indexes []uniqueIndex
+	for _, row := range rows {
+		if len(indexes) == 0 || indexes[len(indexes)-1].name != row.Index {
+			indexes = append(indexes, uniqueIndex{name: row.Index, primaryKey: row.PrimaryKey})
+		}
+		last := &indexes[len(indexes)-1]
+		last.columns = append(last.columns, row.Column)
+	}
+	return indexes, nil
+}
+
+// existingKeys returns the keys of the columns the table rows have, written as rowKeyOf does.
+// Rows with a null key column are left out, nulls do not conflict.
+func existingKeys(db *sqlx.DB, table *tableInfo, columns []string) (map[string]bool, error) {
+	cols, exprs, err := exportColumns(table, tableExport{columns: columns})
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) != len(columns) {
+		return nil, fmt.Errorf("key %v of %s cannot be compared", columns, table.ref)
+	}
+	result, err := db.Query(selectQuery(table, exprs, ""))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	keys := make(map[string]bool)
+	for result.Next() {
+		if err := result.Scan(dest...); err != nil {
+			return nil, err
+		}
+		record := make(map[string]any, len(cols))
+		for i, col := range cols {
+			v, err := exportValue(col, values[i])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.ColumnName, err)
+			}
+			if v != nil {
+				record[col.ColumnName] = v
+			}
+		}
+		if len(record) != len(columns) {
+			continue
+		}
+		key, _, err := rowKeyOf(table, columns, record, Json, conversionOptions{})
+		if err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, result.Err()
+}
+
+// isNullValue tells whether a value of a data file of the format is null or missing.
+func isNullValue(val any, ext Format) bool {
+	return val == nil || ext == Csv && val == "NULL"
+}
+
+// existingConflict is a row of a data file whose key a table row has.
+type existingConflict struct {
+	plan  *filePlan
+	index uniqueIndex
+	key   string
+	row   keyRow
+	// record is the index of the row in the records of the file
+	record int
+}
+
+// findExistingConflicts returns the rows of the data files of the table whose key of a unique
+// index a table row has. In upsert and sync mode the primary key is expected to match, tables
+// emptied first have no rows to conflict with.
+func findExistingConflicts(tables *tableCache, ref tableRef, plans []*filePlan) ([]existingConflict, error) {
+	db := tables.db
+	table, err := tables.get(ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.columns) == 0 {
+		return nil, nil
+	}
+	indexes, err := getUniqueIndexes(db, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, plan := range plans {
+		if plan.opts.Truncate || plan.opts.Mode == RefreshMode {
+			return nil, nil
+		}
+	}
+	records := make([]*recordSet, len(plans))
+	for i, plan := range plans {
+		records[i], err = plan.records(table)
+		defer records[i].close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var conflicts []existingConflict
+	for _, index := range indexes {
+		keys, err := existingKeys(db, table, index.columns)
+		if err != nil {
+			return nil, err
+		}
+		for i, plan := range plans {
+			if index.primaryKey && plan.opts.Mode != InsertMode {
+				continue
+			}
+			for j, record := range records[i].all() {
+				if record.err != nil || slices.ContainsFunc(index.columns, func(col string) bool { return isNullValue(record.values[col], plan.ext) }) {
+					continue
+				}
+				key, _, err := rowKeyOf(table, index.columns, record.values, plan.ext, plan.conv)
+				if err != nil || !keys[key] {
+					continue
+				}
+				conflicts = append(conflicts, existingConflict{plan: plan, index: index, key: key, row: keyRow{file: plan.name, line: record.line}, record: j})
+			}
+			if err := records[i].err(); err != nil {
+				return nil, err
+			}
+		}
+		tables.log.Info("existing keys checked", "table", ref.String(), "index", index.name, "keys", len(keys))
+	}
+	return conflicts, nil
+}
+
+// checkExistingKeys looks up the keys of the unique indexes of the tables for the rows of the
+// data files before anything is written, and fails the run on the rows whose key a table row has,
+// leaves them out or upserts them as the policy says.
+func checkExistingKeys(tables *tableCache, plans []*filePlan, policy string, out io.Writer) error {
+	color := colorFor(out)
+	var failed []existingConflict
+	refs, tablePlans := groupByTable(plans)
+	for _, ref := range refs {
+		conflicts, err := findExistingConflicts(tables, ref, tablePlans[ref])
+		if err != nil {
+			return runError(err, dbErrorCode(err, ExportErrorCode))
+		}
+		if len(conflicts) == 0 {
+			continue
+		}
+		for _, c := range conflicts[:min(len(conflicts), duplicateSampleRows)] {
+			fmt.Fprintf(out, "%s: %s of %s in the table, %s\n", ref, c.key, c.index.name, c.row)
+		}
+		switch {
+		case policy == "skip":
+			rows := 0
+			for _, c := range conflicts {
+				if c.plan.skipRecords == nil {
+					c.plan.skipRecords = make(map[int]bool)
+				}
+				if !c.plan.skipRecords[c.record] {
+					rows++
+				}
+				c.plan.skipRecords[c.record] = true
+			}
+			fmt.Fprintln(out, paint(color, colorYellow, fmt.Sprintf("%s: %d rows left out, their keys are in the table", ref, rows)))
+		case policy == "upsert" && !slices.ContainsFunc(conflicts, func(c existingConflict) bool { return !c.index.primaryKey }):
+			for _, plan := range tablePlans[ref] {
+				plan.opts.Mode = UpsertMode
+			}
+			fmt.Fprintln(out, paint(color, colorYellow, fmt.Sprintf("%s: %d rows with their primary key in the table, loaded in upsert mode", ref, len(conflicts))))
+		default:
+			failed = append(failed, conflicts...)
+		}
+	}
+	if len(failed) > 0 {
+		// upsert matches rows by primary key only
+		return runError(fmt.Errorf("%d rows of the data files have the key of a unique index a table row has", len(failed)), ConstraintErrorCode)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// uniqueIndexQuery is the query of the unique indexes of a table of the current database.
+const uniqueIndexQuery = `
+SELECT i.name AS index_name, i.is_primary_key, c.name AS column_name
+FROM sys.indexes i
+JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 0
+JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE i.object_id = OBJECT_ID(@p1) AND i.is_unique = 1 AND i.has_filter = 0
+ORDER BY i.index_id, ic.key_ordinal`
+
+func TestCheckExistingKeys(t *testing.T) {
+	// the table has the rows of Id 1 and 2, of Code A and B
+	server := &fakeServer{results: map[string]*fakeResult{
+		uniqueIndexQuery: {columns: []string{"index_name", "is_primary_key", "column_name"}, rows: [][]driver.Value{
+			{"PK_Orders", true, "Id"},
+			{"UQ_Orders_Code", false, "Code"},
+		}},
+		"SELECT [Id] FROM [dbo].[Orders] ORDER BY [Id]":   {columns: []string{"Id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}}},
+		"SELECT [Code] FROM [dbo].[Orders] ORDER BY [Id]": {columns: []string{"Code"}, rows: [][]driver.Value{{"A"}, {"B"}}},
+	}}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	sdb := sqlx.NewDb(db, "sqlserver")
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Code", DataType: "varchar", MaxLength: 10, Nullable: true},
+	}}).tableInfo()
+
+	tests := []struct {
+		name     string
+		data     string
+		args     []string
+		policy   string
+		wantErr  bool
+		wantSkip []int
+		wantMode string
+		wantOut  string
+	}{
+		{name: "fail", data: `[{"Id": 1, "Code": "X"}, {"Id": 3, "Code": "B"}, {"Id": 4, "Code": "C"}, {"Id": 5, "Code": null}]`, policy: "fail", wantErr: true,
+			wantOut: "Orders: Id=1 of PK_Orders in the table, 01_Orders.json line 1\nOrders: Code='B' of UQ_Orders_Code in the table, 01_Orders.json line 1\n"},
+		{name: "skip", data: `[{"Id": 1, "Code": "X"}, {"Id": 3, "Code": "B"}, {"Id": 4, "Code": "C"}]`, policy: "skip", wantSkip: []int{0, 1}, wantMode: InsertMode,
+			wantOut: "Orders: 2 rows left out"},
+		{name: "upsert by primary key", data: `[{"Id": 1, "Code": "X"}, {"Id": 4, "Code": "C"}]`, policy: "upsert", wantMode: UpsertMode,
+			wantOut: "Orders: 1 rows with their primary key in the table, loaded in upsert mode"},
+		// upsert cannot match rows by another unique index
+		{name: "upsert by unique index", data: `[{"Id": 3, "Code": "B"}]`, policy: "upsert", wantErr: true, wantMode: InsertMode},
+		// upsert mode expects the primary keys in the table
+		{name: "upsert mode", data: `[{"Id": 1, "Code": "X"}]`, args: []string{"-mode", "upsert"}, policy: "fail", wantMode: UpsertMode},
+		{name: "truncated first", data: `[{"Id": 1, "Code": "B"}]`, args: []string{"-truncate"}, policy: "fail", wantMode: InsertMode},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"01_Orders.json": tt.data})
+		_, source := sourceFor(t, "upload", append([]string{"-d", dir}, tt.args...)...)
+		files, err := source.files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plans, _, err := source.planFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables := newTableCache(sdb, logger())
+		tables.tables[plans[0].table] = table
+		var out bytes.Buffer
+		err = checkExistingKeys(tables, plans, tt.policy, &out)
+		var runErr *RunError
+		if tt.wantErr != (err != nil) || err != nil && (!errors.As(err, &runErr) || runErr.Code != ConstraintErrorCode) {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := slices.Sorted(maps.Keys(plans[0].skipRecords)); !slices.Equal(got, tt.wantSkip) {
+			t.Errorf("%s: rows left out %v, want %v", tt.name, got, tt.wantSkip)
+		}
+		if tt.wantMode != "" && plans[0].opts.Mode != tt.wantMode {
+			t.Errorf("%s: mode %s, want %s", tt.name, plans[0].opts.Mode, tt.wantMode)
+		}
+		if !strings.Contains(out.String(), tt.wantOut) {
+			t.Errorf("%s: output\n%s\nwant %q", tt.name, out.String(), tt.wantOut)
+		}
+	}
+}
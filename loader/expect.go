@@ -0,0 +1,127 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rowExpectation is the row count a table must have after the load, exactly or at least.
+type rowExpectation struct {
+	rows int64
+	// atLeast makes rows a minimum
+	atLeast bool
+}
+
+// parseRowExpectation reads an expectation like 120, or >= 100 for a minimum.
+func parseRowExpectation(s string) (rowExpectation, error) {
+	var e rowExpectation
+	count, atLeast := strings.CutPrefix(strings.TrimSpace(s), ">=")
+	rows, err := strconv.ParseInt(strings.TrimSpace(count), 10, 64)
+	if err != nil || rows < 0 {
+		return e, fmt.Errorf("row count %q is not a number or >= a number", s)
+	}
+	return rowExpectation{rows: rows, atLeast: atLeast}, nil
+}
+
+func (e rowExpectation) String() string {
+	if e.atLeast {
+		return fmt.Sprintf(">= %d", e.rows)
+	}
+	return strconv.FormatInt(e.rows, 10)
+}
+
+func (e rowExpectation) met(rows int64) bool {
+	return rows == e.rows || e.atLeast && rows > e.rows
+}
+
+// expectationFile is the expectations file, the row counts by table.
+type expectationFile struct {
+	Tables map[string]string `yaml:"tables"`
+}
+
+// readRowExpectations loads the expectations file, it returns nil if path is empty.
+func readRowExpectations(path string) (map[tableRef]rowExpectation, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file expectationFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	expectations := make(map[tableRef]rowExpectation)
+	for table, count := range file.Tables {
+		e, err := parseRowExpectation(count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, table, err)
+		}
+		expectations[parseTableRef(table)] = e
+	}
+	return expectations, nil
+}
+
+// planExpectations adds the expect_rows of the files of the run to the expectations of the
+// file, which take precedence.
+func planExpectations(plans []*filePlan, expectations map[tableRef]rowExpectation) map[tableRef]rowExpectation {
+	res := make(map[tableRef]rowExpectation)
+	for _, plan := range plans {
+		if plan.opts.ExpectRows != "" {
+			// checked with the options of the file
+			res[plan.table], _ = parseRowExpectation(plan.opts.ExpectRows)
+		}
+	}
+	for table, e := range expectations {
+		res[table] = e
+	}
+	return res
+}
+
+// checkRowCounts counts the rows of the tables with an expectation after the load, the run fails
+// if any count does not match.
+func checkRowCounts(tables *tableCache, expectations map[tableRef]rowExpectation, out io.Writer) error {
+	if len(expectations) == 0 {
+		return nil
+	}
+	refs := slices.SortedFunc(maps.Keys(expectations), func(a, b tableRef) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	color := colorFor(out)
+	failed := 0
+	for _, ref := range refs {
+		e := expectations[ref]
+		table, err := tables.get(ref)
+		if err != nil {
+			return runError(err, dbErrorCode(err, TableInfoErrorCode))
+		}
+		if len(table.columns) == 0 {
+			failed++
+			fmt.Fprintln(out, paint(color, colorRed, fmt.Sprintf("%s: table not found, expected %s rows", ref, e)))
+			continue
+		}
+		var rows int64
+		if err := tables.db.Get(&rows, "SELECT COUNT(*) FROM "+table.quotedName()); err != nil {
+			return runError(err, dbErrorCode(err, ExportErrorCode))
+		}
+		tables.log.Info("row count checked", "table", ref.String(), "rows", rows, "expected", e.String())
+		if !e.met(rows) {
+			failed++
+			fmt.Fprintln(out, paint(color, colorRed, fmt.Sprintf("%s: %d rows, expected %s", ref, rows, e)))
+			continue
+		}
+		fmt.Fprintf(out, "%s: %d rows, ok\n", ref, rows)
+	}
+	if failed > 0 {
+		return runError(fmt.Errorf("%d of %d tables do not have the rows expected", failed, len(refs)), ExpectationFailedCode)
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package loader
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// exportOptions are the flags of the export command.
+type exportOptions struct {
+	conn      connOptions
+	log       logOptions
+	tables    string
+	outDir    string
+	format    string
+	delimiter string
+	// where, columns and excludeColumns hold table=value arguments
+	where          stringList
+	columns        stringList
+	excludeColumns stringList
+}
+
+func (o *exportOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.tables, "t", "", "comma separated tables (or schema.tables) to export, in load order")
+	fs.StringVar(&o.outDir, "o", "export", "dir to write the data files to")
+	fs.StringVar(&o.format, "format", "json", "data file format: json or csv")
+	fs.StringVar(&o.delimiter, "delimiter", ";", "csv delimiter")
+	fs.Var(&o.where, "where", "table=condition, export the rows of the table matching the sql condition, repeatable")
+	fs.Var(&o.columns, "columns", "table=col1,col2, export only these columns of the table, repeatable")
+	fs.Var(&o.excludeColumns, "exclude-columns", "table=col1,col2, leave these columns of the table out, repeatable")
+}
+
+// tableArgs maps lower cased table names to the values of table=value arguments.
+type tableArgs map[string]string
+
+func parseTableArgs(flagName string, args []string) (tableArgs, error) {
+	m := make(tableArgs, len(args))
+	for _, arg := range args {
+		table, value, ok := strings.Cut(arg, "=")
+		if !ok || strings.TrimSpace(table) == "" {
+			return nil, fmt.Errorf("-%s %q is not table=value", flagName, arg)
+		}
+		m[strings.ToLower(strings.TrimSpace(table))] = strings.TrimSpace(value)
+	}
+	return m, nil
+}
+
+// get returns the value given for the table, by schema.table or table name.
+func (m tableArgs) get(table tableRef) (string, bool) {
+	if v, ok := m[strings.ToLower(table.String())]; ok {
+		return v, true
+	}
+	v, ok := m[strings.ToLower(table.name)]
+	return v, ok
+}
+
+// columnList splits a comma separated column list.
+func columnList(s string) []string {
+	var cols []string
+	for _, col := range strings.Split(s, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// tableExport is what to export of a table.
+type tableExport struct {
+	where   string
+	columns []string
+	exclude []string
+}
+
+func runExport(cmd *command, args []string) {
+	var opts exportOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	var where, columns, exclude tableArgs
+	err := opts.log.check()
+	if err == nil {
+		where, err = parseTableArgs("where", opts.where)
+	}
+	if err == nil {
+		columns, err = parseTableArgs("columns", opts.columns)
+	}
+	if err == nil {
+		exclude, err = parseTableArgs("exclude-columns", opts.excludeColumns)
+	}
+	if err == nil && opts.tables == "" {
+		err = errors.New("no tables to export, give them with -t")
+	}
+	if err == nil && opts.format != "json" && opts.format != "csv" {
+		err = fmt.Errorf("unknown format %q", opts.format)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	export(&opts, where, columns, exclude)
+}
+
+func export(opts *exportOptions, where, columns, exclude tableArgs) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+
+	var tables []tableRef
+	for _, name := range strings.Split(opts.tables, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tables = append(tables, parseTableRef(name))
+		}
+	}
+	// order numbers keep the load order of the tables when the files are sorted by name
+	width := max(2, len(strconv.Itoa(len(tables))))
+	for i, ref := range tables {
+		table, err := getTableInfo(db, ref)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+		if len(table.columns) == 0 {
+			handleError(fmt.Errorf("table %s not found", ref), TableInfoErrorCode)
+		}
+		// the loader takes the schema from the subdir with -r
+		dir := filepath.Join(opts.outDir, ref.schema)
+		handleError(os.MkdirAll(dir, 0o755), WriteScriptErrorCode)
+		filePath := filepath.Join(dir, fmt.Sprintf("%0*d_%s.%s", width, i+1, ref.name, opts.format))
+		te := tableExport{}
+		te.where, _ = where.get(ref)
+		if cols, ok := columns.get(ref); ok {
+			te.columns = columnList(cols)
+		}
+		if cols, ok := exclude.get(ref); ok {
+			te.exclude = columnList(cols)
+		}
+		rows, err := exportTable(db, table, te, filePath, opts)
+		handleError(err, dbErrorCode(err, ExportErrorCode))
+		logger().Info("table exported", "table", ref.String(), "file", filePath, "rows", rows)
+	}
+	logger().Info("export done", "dir", opts.outDir)
+}
+
+// exportColumns returns the columns to export and their select expressions, the ones asked for
+// less the excluded and the ones the loader skips.
+func exportColumns(table *tableInfo, te tableExport) ([]ColumnSchema, []string, error) {
+	hasColumn := func(cols []string, name string) bool {
+		return slices.ContainsFunc(cols, func(col string) bool { return strings.EqualFold(col, name) })
+	}
+	for _, name := range slices.Concat(te.columns, te.exclude) {
+		if !hasColumn(table.columns, name) {
+			return nil, nil, fmt.Errorf("table %s has no column %s", table.ref, name)
+		}
+	}
+	var cols []ColumnSchema
+	var exprs []string
+	for _, name := range table.columns {
+		col := table.schema[name]
+		if _, skip := skipReason(col); skip || slices.Contains(table.computeColumns, name) {
+			continue
+		}
+		if (te.columns != nil && !hasColumn(te.columns, name)) || hasColumn(te.exclude, name) {
+			continue
+		}
+		expr := quoteName(name)
+		switch col.DataType {
+		case "geography", "geometry":
+			expr = fmt.Sprintf("%s.STAsText() AS %s", expr, expr)
+		case "hierarchyid":
+			expr = fmt.Sprintf("%s.ToString() AS %s", expr, expr)
+		}
+		cols = append(cols, col)
+		exprs = append(exprs, expr)
+	}
+	if len(cols) == 0 {
+		return nil, nil, fmt.Errorf("no columns of table %s to export", table.ref)
+	}
+	return cols, exprs, nil
+}
+
+// exportTable writes the rows of the table to the data file, ordered by primary key if any.
+func exportTable(db *sqlx.DB, table *tableInfo, te tableExport, filePath string, opts *exportOptions) (int, error) {
+	cols, exprs, err := exportColumns(table, te)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.Query(selectQuery(table, exprs, te.where))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var w rowWriter
+	if opts.format == "csv" {
+		w = newCsvRowWriter(f, cols, opts.delimiter)
+	} else {
+		w = &jsonRowWriter{w: f, cols: cols}
+	}
+
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, err
+		}
+		for i, col := range cols {
+			v, err := exportValue(col, values[i])
+			if err != nil {
+				return count, fmt.Errorf("row %d column %s: %w", count+1, col.ColumnName, err)
+			}
+			values[i] = v
+		}
+		if err := w.write(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, errors.Join(w.close(), f.Close())
+}
+
+// selectQuery selects the columns of the table rows matching the condition if any, ordered by primary key if any.
+func selectQuery(table *tableInfo, exprs []string, where string) string {
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), table.ref.quoted())
+	if where != "" {
+		query += " WHERE (" + where + ")"
+	}
+	if len(table.primaryKey) > 0 {
+		keys := make([]string, len(table.primaryKey))
+		for i, key := range table.primaryKey {
+			keys[i] = quoteName(key)
+		}
+		query += " ORDER BY " + strings.Join(keys, ", ")
+	}
+	return query
+}
+
+// exportValue returns the value as the loader reads it back into the column:
+// nil, bool, int64, float64, json.Number for exact numbers or string.
+func exportValue(col ColumnSchema, v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case time.Time:
+		switch col.DataType {
+		case "date":
+			return v.Format("2006-01-02"), nil
+		case "time":
+			return v.Format("15:04:05.9999999"), nil
+		case "datetimeoffset":
+			return v.Format("2006-01-02T15:04:05.9999999Z07:00"), nil
+		}
+		return v.Format("2006-01-02T15:04:05.9999999"), nil
+	case []byte:
+		switch col.DataType {
+		case "decimal", "numeric", "money", "smallmoney":
+			return json.Number(v), nil
+		case "uniqueidentifier":
+			var id mssql.UniqueIdentifier
+			if err := id.Scan(v); err != nil {
+				return nil, err
+			}
+			return id.String(), nil
+		case "char", "varchar", "text", "nchar", "nvarchar", "ntext", "xml":
+			return string(v), nil
+		}
+		return "0x" + hex.EncodeToString(v), nil
+	}
+	return nil, fmt.Errorf("unsupported value %T", v)
+}
+
+// rowWriter writes exported rows in a data file format.
+type rowWriter interface {
+	write(values []any) error
+	close() error
+}
+
+// jsonRowWriter writes the rows as an array of objects, one per line with the columns in table order.
+type jsonRowWriter struct {
+	w     io.Writer
+	cols  []ColumnSchema
+	count int
+}
+
+func (j *jsonRowWriter) write(values []any) error {
+	var sb strings.Builder
+	sb.WriteString(",\n  {")
+	if j.count == 0 {
+		sb.Reset()
+		sb.WriteString("[\n  {")
+	}
+	for i, col := range j.cols {
+		key, err := json.Marshal(col.ColumnName)
+		if err != nil {
+			return err
+		}
+		val, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.Write(key)
+		sb.WriteString(": ")
+		sb.Write(val)
+	}
+	sb.WriteString("}")
+	j.count++
+	_, err := io.WriteString(j.w, sb.String())
+	return err
+}
+
+func (j *jsonRowWriter) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// csvRowWriter writes a header of the columns and the rows, NULL for null values as the loader reads them.
+type csvRowWriter struct {
+	w      *csv.Writer
+	header []string
+}
+
+func newCsvRowWriter(w io.Writer, cols []ColumnSchema, delimiter string) *csvRowWriter {
+	cw := csv.NewWriter(w)
+	if delimiter != "" {
+		cw.Comma = []rune(delimiter)[0]
+	}
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.ColumnName
+	}
+	return &csvRowWriter{w: cw, header: header}
+}
+
+func (c *csvRowWriter) write(values []any) error {
+	if c.header != nil {
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+		c.header = nil
+	}
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			record[i] = "NULL"
+			continue
+		}
+		s, err := stringOf(v)
+		if err != nil {
+			return err
+		}
+		record[i] = s
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) close() error {
+	if c.header != nil {
+		// no rows, the header alone
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,144 @@
+package loader
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportValue(t *testing.T) {
+	at := time.Date(2024, 5, 2, 10, 15, 30, 120000000, time.FixedZone("", 2*60*60))
+	tests := []struct {
+		dataType string
+		v        any
+		want     any
+	}{
+		{dataType: "int", v: int64(42), want: int64(42)},
+		{dataType: "real", v: float32(1.5), want: 1.5},
+		{dataType: "nvarchar", v: nil, want: nil},
+		{dataType: "date", v: at, want: "2024-05-02"},
+		{dataType: "time", v: at, want: "10:15:30.12"},
+		{dataType: "datetime2", v: at, want: "2024-05-02T10:15:30.12"},
+		{dataType: "datetimeoffset", v: at, want: "2024-05-02T10:15:30.12+02:00"},
+		{dataType: "decimal", v: []byte("12.50"), want: json.Number("12.50")},
+		{dataType: "varchar", v: []byte("text"), want: "text"},
+		{dataType: "varbinary", v: []byte{0xca, 0xfe}, want: "0xcafe"},
+	}
+	for _, tt := range tests {
+		got, err := exportValue(ColumnSchema{ColumnName: "Value", DataType: tt.dataType}, tt.v)
+		if err != nil || got != tt.want {
+			t.Errorf("%s %v: got %#v, %v, want %#v", tt.dataType, tt.v, got, err, tt.want)
+		}
+	}
+	if _, err := exportValue(ColumnSchema{DataType: "int"}, struct{}{}); err == nil {
+		t.Error("no error for an unsupported value")
+	}
+}
+
+func TestExportRowWriters(t *testing.T) {
+	cols := []ColumnSchema{{ColumnName: "Id", DataType: "int"}, {ColumnName: "Name", DataType: "nvarchar"}}
+	rows := [][]any{{int64(1), "Ann"}, {int64(2), nil}}
+	tests := []struct {
+		name string
+		new  func(*strings.Builder) rowWriter
+		rows [][]any
+		want string
+	}{
+		{name: "json", new: func(sb *strings.Builder) rowWriter { return &jsonRowWriter{w: sb, cols: cols} }, rows: rows,
+			want: "[\n  {\"Id\": 1, \"Name\": \"Ann\"},\n  {\"Id\": 2, \"Name\": null}\n]\n"},
+		{name: "json no rows", new: func(sb *strings.Builder) rowWriter { return &jsonRowWriter{w: sb, cols: cols} },
+			want: "[]\n"},
+		{name: "csv", new: func(sb *strings.Builder) rowWriter { return newCsvRowWriter(sb, cols, ";") }, rows: rows,
+			want: "Id;Name\n1;Ann\n2;NULL\n"},
+		{name: "csv no rows", new: func(sb *strings.Builder) rowWriter { return newCsvRowWriter(sb, cols, ",") },
+			want: "Id,Name\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			w := tt.new(&sb)
+			for _, row := range tt.rows {
+				if err := w.write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.close(); err != nil {
+				t.Fatal(err)
+			}
+			if sb.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExportColumns(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Customers", PrimaryKey: []string{"TenantId", "Id"}, Columns: []columnDefinition{
+		{Name: "TenantId", DataType: "int"},
+		{Name: "Id", DataType: "int"},
+		{Name: "Email", DataType: "nvarchar", MaxLength: 100},
+		{Name: "Location", DataType: "geography", Nullable: true},
+		{Name: "Label", DataType: "nvarchar", MaxLength: 120, Computed: "(concat([Id],[Email]))"},
+	}}).tableInfo()
+	tests := []struct {
+		name    string
+		te      tableExport
+		want    []string
+		wantErr string
+	}{
+		{name: "all", want: []string{"[TenantId]", "[Id]", "[Email]", "[Location].STAsText() AS [Location]"}},
+		{name: "columns", te: tableExport{columns: []string{"id", "Email"}}, want: []string{"[Id]", "[Email]"}},
+		{name: "exclude", te: tableExport{exclude: []string{"email", "Location"}}, want: []string{"[TenantId]", "[Id]"}},
+		{name: "unknown column", te: tableExport{exclude: []string{"Phone"}}, wantErr: "has no column Phone"},
+		{name: "none left", te: tableExport{columns: []string{"Id"}, exclude: []string{"Id"}}, wantErr: "no columns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, exprs, err := exportColumns(table, tt.te)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(exprs, tt.want) || len(cols) != len(exprs) {
+				t.Errorf("columns %v, want %v", exprs, tt.want)
+			}
+		})
+	}
+
+	query := selectQuery(table, []string{"[Id]", "[Email]"}, "TenantId = 7")
+	want := "SELECT [Id], [Email] FROM [dbo].[Customers] WHERE (TenantId = 7) ORDER BY [TenantId], [Id]"
+	if query != want {
+		t.Errorf("query %q, want %q", query, want)
+	}
+}
+
+func TestParseTableArgs(t *testing.T) {
+	args, err := parseTableArgs("where", []string{"sales.Orders = Total > 0", "Customers=TenantId = 7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		table tableRef
+		want  string
+		ok    bool
+	}{
+		{table: tableRef{schema: "sales", name: "Orders"}, want: "Total > 0", ok: true},
+		{table: tableRef{schema: "dbo", name: "Orders"}},
+		{table: tableRef{schema: "dbo", name: "customers"}, want: "TenantId = 7", ok: true},
+	}
+	for _, tt := range tests {
+		if got, ok := args.get(tt.table); got != tt.want || ok != tt.ok {
+			t.Errorf("get(%v) = %q, %v, want %q, %v", tt.table, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, err := parseTableArgs("where", []string{"Total > 0"}); err == nil {
+		t.Error("no error for an argument without a table")
+	}
+}
@@ -0,0 +1,362 @@
+package loader
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// dataFile is a file to load and the table it goes to, the table name
+// is taken from the file name when empty.
+type dataFile struct {
+	path  string
+	table tableRef
+	opts  fileOptions
+}
+
+// walkOptions sets how the -d dirs are listed.
+type walkOptions struct {
+	recursive bool
+	// followLinks loads symlinked files and lists symlinked dirs, else they are skipped
+	followLinks bool
+}
+
+// collectFiles resolves the -d arguments, dirs or globs of dirs and files, into the
+// data files to load. Files from all arguments are ordered together by file name
+// in natural order (2_b before 10_a),
+// files with the same name keep the order of the arguments. Dirs with a manifest
+// keep its order instead and are loaded in the place of their argument.
+func collectFiles(patterns []string, walk walkOptions, log *slog.Logger) ([]dataFile, error) {
+	var ordered, files []dataFile
+	sortedAt := -1
+	for _, pattern := range patterns {
+		paths := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", pattern)
+			}
+			paths = matches
+		}
+		for _, path := range paths {
+			path = longPath(path)
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			var m *manifest
+			if info.IsDir() {
+				if m, err = readManifest(path); err != nil {
+					return nil, err
+				}
+			}
+			if m != nil {
+				ordered = append(ordered, m.files(path)...)
+				continue
+			}
+			found, err := dirFiles(path, walk, log)
+			if err != nil {
+				return nil, err
+			}
+			if sortedAt < 0 {
+				sortedAt = len(ordered)
+			}
+			files = append(files, found...)
+		}
+	}
+	slices.SortStableFunc(files, func(a, b dataFile) int {
+		return naturalCompare(filepath.Base(a.path), filepath.Base(b.path))
+	})
+	files = slices.CompactFunc(files, func(a, b dataFile) bool {
+		return a.path == b.path
+	})
+	if sortedAt < 0 {
+		return ordered, nil
+	}
+	return slices.Insert(ordered, sortedAt, files...), nil
+}
+
+// naturalCompare compares strings with runs of digits compared by their numeric value.
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		ra, sa := utf8.DecodeRuneInString(a)
+		rb, sb := utf8.DecodeRuneInString(b)
+		if c := cmp.Compare(ra, rb); c != 0 {
+			return c
+		}
+		a, b = a[sa:], b[sb:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// longPath makes the path absolute on Windows, where the os package then handles paths
+// longer than MAX_PATH.
+func longPath(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// dirFiles lists the files of the dir at path, or path itself if it is a file.
+// Recursive listing maps the subdir a file is in to the schema of its table,
+// so seeds/sales/01_Orders.csv goes to sales.Orders.
+func dirFiles(path string, walk walkOptions, log *slog.Logger) ([]dataFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		// a glob matches the files written next to the data files too
+		if notDataFile(filepath.Base(path)) {
+			log.Debug("skip file, not a data file", "path", path)
+			return nil, nil
+		}
+		return []dataFile{{path: path}}, nil
+	}
+	w := &dirWalker{root: filepath.Clean(path), walkOptions: walk, visited: make(map[string]bool), log: log}
+	err = w.walk(path)
+	return w.files, err
+}
+
+// notDataFile tells whether the file is one of those next to the data files: a manifest, a
+// sidecar, the rejected rows of a file or a done marker.
+func notDataFile(fileName string) bool {
+	return slices.Contains(manifestNames, fileName) || isSidecar(fileName) || isRejectedFile(fileName) || isDoneMarker(fileName)
+}
+
+// dirWalker lists the data files under a -d dir.
+type dirWalker struct {
+	root string
+	walkOptions
+	// visited holds the real paths of the dirs listed, so symlink loops are listed once
+	visited map[string]bool
+	files   []dataFile
+	log     *slog.Logger
+}
+
+// walk lists the dir at path, a symlinked dir is listed under its link path.
+func (w *dirWalker) walk(path string) error {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	if w.visited[real] {
+		return nil
+	}
+	w.visited[real] = true
+	return filepath.WalkDir(real, func(realPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(real, realPath)
+		if err != nil {
+			return err
+		}
+		filePath := filepath.Join(path, rel)
+		if entry.IsDir() {
+			if realPath != real && !w.recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if !w.followLinks {
+				w.log.Debug("skip symlink", "path", filePath)
+				return nil
+			}
+			info, err := os.Stat(realPath)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if !w.recursive {
+					return nil
+				}
+				return w.walk(filePath)
+			}
+		}
+		if notDataFile(entry.Name()) {
+			return nil
+		}
+		w.files = append(w.files, dataFile{path: filePath, table: tableRef{schema: w.schema(filePath)}})
+		return nil
+	})
+}
+
+// schema returns the schema of the tables of the files under a subdir, the name of the subdir of
+// the root the file is in, also when it is nested deeper. It is empty for the files of the root.
+func (w *dirWalker) schema(filePath string) string {
+	rel, err := filepath.Rel(w.root, filepath.Dir(filePath))
+	if err != nil || rel == "." {
+		return ""
+	}
+	first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return first
+}
+
+// defaultNameTemplate is the data file naming convention, e.g. 01_Orders.json
+const defaultNameTemplate = "{order}_{table}.{ext}"
+
+// nameTemplatePatterns are the patterns of the fields a name template can hold,
+// fields not listed here match anything and are ignored.
+var nameTemplatePatterns = map[string]string{
+	"order":    `.*?`,
+	"database": `[^.]+?`,
+	"schema":   `[^.]+?`,
+	"table":    `.+?`,
+	"ext":      `[^.]+`,
+}
+
+var nameTemplateField = regexp.MustCompile(`\{(\w+)\}`)
+
+// nameTemplate parses data file names by a template of {field} placeholders and literal text.
+type nameTemplate struct {
+	template string
+	re       *regexp.Regexp
+}
+
+// fileNameParts are the fields read from a data file name.
+type fileNameParts struct {
+	order    string
+	database string
+	schema   string
+	table    string
+	ext      string
+}
+
+func parseNameTemplate(template string) (*nameTemplate, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	fields := make(map[string]bool)
+	last := 0
+	for _, m := range nameTemplateField.FindAllStringSubmatchIndex(template, -1) {
+		expr.WriteString(regexp.QuoteMeta(template[last:m[0]]))
+		name := template[m[2]:m[3]]
+		pattern, known := nameTemplatePatterns[name]
+		switch {
+		case !known:
+			expr.WriteString(`.+?`)
+		case fields[name]:
+			return nil, fmt.Errorf("name template %q has {%s} twice", template, name)
+		default:
+			fields[name] = true
+			expr.WriteString("(?P<" + name + ">" + pattern + ")")
+		}
+		last = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString("$")
+	if !fields["table"] || !fields["ext"] {
+		return nil, fmt.Errorf("name template %q needs {table} and {ext}", template)
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	return &nameTemplate{template: template, re: re}, nil
+}
+
+func (t *nameTemplate) parse(fileName string) (fileNameParts, error) {
+	m := t.re.FindStringSubmatch(fileName)
+	if m == nil {
+		return fileNameParts{}, fmt.Errorf("file name %s does not match %s", fileName, t.template)
+	}
+	field := func(name string) string {
+		if i := t.re.SubexpIndex(name); i > 0 {
+			return m[i]
+		}
+		return ""
+	}
+	return fileNameParts{
+		order:    field("order"),
+		database: field("database"),
+		schema:   field("schema"),
+		table:    field("table"),
+		ext:      field("ext"),
+	}, nil
+}
+
+// tableFilter selects the tables to load by the -only and -exclude patterns.
+type tableFilter struct {
+	only    []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// parseTableFilter reads comma separated lists of table names or regexps,
+// matched case insensitive against the whole table name or schema.table.
+func parseTableFilter(only, exclude string) (*tableFilter, error) {
+	var f tableFilter
+	var err error
+	if f.only, err = parseTablePatterns(only); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseTablePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseTablePatterns(list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)^(?:" + item + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("table pattern %q: %w", item, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+func matchTable(patterns []*regexp.Regexp, table tableRef) bool {
+	return slices.ContainsFunc(patterns, func(re *regexp.Regexp) bool {
+		return re.MatchString(table.name) || re.MatchString(table.String())
+	})
+}
+
+// match tells whether the table is to be loaded.
+func (f *tableFilter) match(table tableRef) bool {
+	if len(f.only) > 0 && !matchTable(f.only, table) {
+		return false
+	}
+	return !matchTable(f.exclude, table)
+}
@@ -0,0 +1,150 @@
+package loader
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2_b", "10_a", -1},
+		{"10_a", "2_b", 1},
+		{"01_Orders", "1_Orders", 0},
+		{"01_Orders", "01_Products", -1},
+		{"a", "ab", -1},
+		{"file9.json", "file10.json", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := naturalCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseNameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+		want     fileNameParts
+		wantErr  bool
+	}{
+		{template: defaultNameTemplate, name: "01_Orders.json", want: fileNameParts{order: "01", table: "Orders", ext: "json"}},
+		{template: defaultNameTemplate, name: "01_Order_Lines.csv", want: fileNameParts{order: "01", table: "Order_Lines", ext: "csv"}},
+		{template: "{schema}.{table}.{ext}", name: "sales.Orders.json", want: fileNameParts{schema: "sales", table: "Orders", ext: "json"}},
+		{template: "{database}.{schema}.{table}.{ext}", name: "shop.sales.Orders.csv", want: fileNameParts{database: "shop", schema: "sales", table: "Orders", ext: "csv"}},
+		{template: "{table}-{date}.{ext}", name: "Orders-2024-01-02.json", want: fileNameParts{table: "Orders", ext: "json"}},
+		{template: defaultNameTemplate, name: "Orders.json", wantErr: true},
+		{template: "{order}_{table}", wantErr: true},
+		{template: "{table}_{table}.{ext}", wantErr: true},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseNameTemplate(tt.template)
+		if err == nil {
+			var parts fileNameParts
+			parts, err = tmpl.parse(tt.name)
+			if err == nil && parts != tt.want {
+				t.Errorf("%s: parse(%q) = %+v, want %+v", tt.template, tt.name, parts, tt.want)
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %q: error %v, want error %v", tt.template, tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCollectFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("common/10_Lines.json", "[]")
+	write("common/2_Orders.json", "[]")
+	write("common/1_Customers.csv", "Id\n")
+	write("ordered/manifest.yaml", "files:\n  - file: b.json\n  - file: a.json\n")
+	write("ordered/a.json", "[]")
+	write("ordered/b.json", "[]")
+	write("extras/Orders.json", "[]")
+	write("extras/Orders.json.done", "")
+	write("extras/Orders.meta.yaml", "table: Orders\n")
+	write("extras/Orders.rejected.json", "[]")
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "dir", patterns: []string{"common"}, want: []string{"common/1_Customers.csv", "common/2_Orders.json", "common/10_Lines.json"}},
+		{name: "file glob", patterns: []string{"common/*.json"}, want: []string{"common/2_Orders.json", "common/10_Lines.json"}},
+		{name: "single file", patterns: []string{"common/2_Orders.json"}, want: []string{"common/2_Orders.json"}},
+		{name: "manifest", patterns: []string{"ordered"}, want: []string{"ordered/b.json", "ordered/a.json"}},
+		{name: "manifest before sorted files", patterns: []string{"ordered", "common/*.json"}, want: []string{"ordered/b.json", "ordered/a.json", "common/2_Orders.json", "common/10_Lines.json"}},
+		{name: "glob skips files next to data files", patterns: []string{"extras/*"}, want: []string{"extras/Orders.json"}},
+		{name: "single sidecar", patterns: []string{"extras/Orders.meta.yaml"}},
+		{name: "single rejected file", patterns: []string{"extras/Orders.rejected.json", "extras/Orders.json"}, want: []string{"extras/Orders.json"}},
+		{name: "missing", patterns: []string{"missing"}, wantErr: true},
+		{name: "no match", patterns: []string{"common/*.xml"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := make([]string, len(tt.patterns))
+			for i, p := range tt.patterns {
+				patterns[i] = filepath.Join(dir, p)
+			}
+			files, err := collectFiles(patterns, walkOptions{}, logger())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, f := range files {
+				rel, err := filepath.Rel(dir, f.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectFilesSchema(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"01_Settings.json", "sales/01_Orders.json", "sales/2024/02_Lines.json", "sales/2024/q1/03_Returns.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("[]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := collectFiles([]string{dir}, walkOptions{recursive: true}, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range files {
+		got[filepath.Base(f.path)] = f.table.schema
+	}
+	want := map[string]string{"01_Settings.json": "", "01_Orders.json": "sales", "02_Lines.json": "sales", "03_Returns.json": "sales"}
+	if !maps.Equal(got, want) {
+		t.Errorf("schemas = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,742 @@
+package loader
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// keySample is how many keys of a referenced table the foreign keys pick from.
+const keySample = 10000
+
+// generateOptions are the flags of the generate command.
+type generateOptions struct {
+	conn   connOptions
+	log    logOptions
+	tables string
+	rows   int
+	// tableRows holds table=rows arguments
+	tableRows stringList
+	// outDir is the dir to write data files to, the rows are inserted if empty
+	outDir      string
+	format      string
+	delimiter   string
+	seed        uint64
+	nullRate    float64
+	batchSize   int
+	lockTimeout time.Duration
+	conv        conversionOptions
+}
+
+func (o *generateOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.tables, "t", "", "comma separated tables (or schema.tables) to generate rows for, in load order")
+	fs.IntVar(&o.rows, "n", 1000, "rows to generate per table")
+	fs.Var(&o.tableRows, "rows", "table=rows, generate this many rows for the table instead of -n, repeatable")
+	fs.StringVar(&o.outDir, "o", "", "dir to write data files to as export does, the rows are inserted into the tables if empty")
+	fs.StringVar(&o.format, "format", "json", "data file format: json or csv")
+	fs.StringVar(&o.delimiter, "delimiter", ";", "csv delimiter")
+	fs.Uint64Var(&o.seed, "seed", 0, "seed of the random values, the same seed makes the same data; random if 0")
+	fs.Float64Var(&o.nullRate, "null-rate", 0.1, "share of null values in nullable columns, 0 to 1")
+	fs.IntVar(&o.batchSize, "batch-size", 10000, "rows bulk inserted per transaction")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 0, "how long to wait for another run loading the same database to finish, 0 fails right away")
+	fs.IntVar(&o.conv.SRID, "srid", 4326, "spatial reference id for geography and geometry values")
+}
+
+func runGenerate(cmd *command, args []string) {
+	var opts generateOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	tableRows, err := parseTableArgs("rows", opts.tableRows)
+	if err == nil {
+		err = opts.log.check()
+	}
+	switch {
+	case err != nil:
+	case opts.tables == "":
+		err = errors.New("no tables to generate rows for, give them with -t")
+	case opts.rows < 0:
+		err = fmt.Errorf("invalid -n %d", opts.rows)
+	case opts.format != "json" && opts.format != "csv":
+		err = fmt.Errorf("unknown format %q", opts.format)
+	case opts.nullRate < 0 || opts.nullRate > 1:
+		err = fmt.Errorf("invalid -null-rate %g, want 0 to 1", opts.nullRate)
+	case opts.batchSize <= 0:
+		err = fmt.Errorf("invalid -batch-size %d", opts.batchSize)
+	}
+	for _, v := range tableRows {
+		if n, convErr := strconv.Atoi(v); convErr != nil || n < 0 {
+			err = cmp.Or(err, fmt.Errorf("-rows: invalid row count %q", v))
+		}
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	generate(&opts, tableRows)
+}
+
+// generate makes up rows for the tables in order and writes them to data files or inserts them.
+// Foreign keys take the keys of the rows in the referenced tables, the ones generated before in
+// the run included, so the tables go parents first.
+func generate(opts *generateOptions, tableRows tableArgs) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.outDir == "" {
+		lock, err := acquireRunLock(ctx, db, opts.lockTimeout)
+		handleError(err, dbErrorCode(err, LockedCode))
+		defer lock.release()
+	}
+
+	seed := opts.seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	logger().Info("generating", "seed", seed)
+
+	var tables []tableRef
+	for _, name := range strings.Split(opts.tables, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tables = append(tables, parseTableRef(name))
+		}
+	}
+	width := max(2, len(strconv.Itoa(len(tables))))
+	var done []*tableGenerator
+	for i, ref := range tables {
+		n := opts.rows
+		if v, ok := tableRows.get(ref); ok {
+			n, _ = strconv.Atoi(v)
+		}
+		g, err := newTableGenerator(db, ref, rng, opts.nullRate, done)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+		start := time.Now()
+		if opts.outDir != "" {
+			dir := filepath.Join(opts.outDir, ref.schema)
+			handleError(os.MkdirAll(dir, 0o755), WriteScriptErrorCode)
+			filePath := filepath.Join(dir, fmt.Sprintf("%0*d_%s.%s", width, i+1, ref.name, opts.format))
+			handleError(g.writeFile(filePath, n, opts), WriteScriptErrorCode)
+			logger().Info("table generated", "table", ref.String(), "file", filePath, "rows", n, "duration", time.Since(start).Round(time.Millisecond).String())
+		} else {
+			inserted, err := g.insert(ctx, db, n, opts)
+			if errors.Is(err, errInterrupted) {
+				handleError(fmt.Errorf("interrupted, %d rows of %s inserted", inserted, ref), InterruptedCode)
+			}
+			handleError(err, dbErrorCode(err, InsertDataErrorCode))
+			logger().Info("table generated", "table", ref.String(), "rows", inserted, "duration", time.Since(start).Round(time.Millisecond).String())
+		}
+		done = append(done, g)
+	}
+	logger().Info("generate done", "tables", len(tables), "seed", seed)
+}
+
+// tableGenerator makes up the rows of a table.
+type tableGenerator struct {
+	table    *tableInfo
+	cols     []columnDefinition
+	fks      []*foreignKeyPool
+	keys     []*uniqueKey
+	rng      *rand.Rand
+	nullRate float64
+	// seq holds the next value of the integer columns making a unique key alone
+	seq map[string]int64
+	// unique is the counter of the suffix making the text values of unique keys unique
+	unique int64
+	// sample holds generated rows for the foreign keys of the tables after
+	sample []map[string]any
+	count  int
+}
+
+// foreignKeyPool is a foreign key of the table and the keys of the referenced rows.
+type foreignKeyPool struct {
+	name    string
+	columns []string
+	// nullable is set when the columns all take nulls
+	nullable bool
+	keys     [][]any
+}
+
+// uniqueKey is the columns of a primary key or unique index and the values generated.
+type uniqueKey struct {
+	name    string
+	columns []string
+	seen    map[string]struct{}
+}
+
+// newTableGenerator reads the definition, foreign keys and unique keys of the table, previous
+// are the generators of the tables generated before.
+func newTableGenerator(db *sqlx.DB, ref tableRef, rng *rand.Rand, nullRate float64, previous []*tableGenerator) (*tableGenerator, error) {
+	def, err := getTableDefinition(db, ref)
+	if err != nil {
+		return nil, err
+	}
+	ref = tableRef{schema: def.Schema, name: def.Name}
+	table, err := getTableInfo(db, ref)
+	if err != nil {
+		return nil, err
+	}
+	g := &tableGenerator{table: table, rng: rng, nullRate: nullRate, seq: make(map[string]int64), unique: rng.Int64N(1 << 30)}
+	for _, col := range def.Columns {
+		if _, skip := skipReason(table.schema[col.Name]); skip || col.Identity != nil || col.Computed != "" {
+			continue
+		}
+		g.cols = append(g.cols, col)
+	}
+	if len(g.cols) == 0 {
+		return nil, fmt.Errorf("no columns of table %s to generate", ref)
+	}
+	if err := g.loadForeignKeys(db, previous); err != nil {
+		return nil, err
+	}
+	if err := g.loadUniqueKeys(db); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// loadForeignKeys reads the foreign keys of the generated columns and samples the keys of the
+// referenced tables.
+func (g *tableGenerator) loadForeignKeys(db *sqlx.DB, previous []*tableGenerator) error {
+	var rows []struct {
+		Name             string `db:"fk_name"`
+		ParentSchema     string `db:"parent_schema"`
+		ParentName       string `db:"parent_name"`
+		Column           string `db:"column_name"`
+		ReferencedColumn string `db:"referenced_column"`
+	}
+	err := db.Select(&rows, `
+SELECT fk.name AS fk_name, OBJECT_SCHEMA_NAME(fk.referenced_object_id) AS parent_schema,
+  OBJECT_NAME(fk.referenced_object_id) AS parent_name,
+  COL_NAME(fkc.parent_object_id, fkc.parent_column_id) AS column_name,
+  COL_NAME(fkc.referenced_object_id, fkc.referenced_column_id) AS referenced_column
+FROM sys.foreign_keys fk
+JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id
+WHERE fk.parent_object_id = OBJECT_ID(@p1) AND fk.is_disabled = 0
+ORDER BY fk.name, fkc.constraint_column_id`, g.table.ref.quoted())
+	if err != nil {
+		return err
+	}
+	for len(rows) > 0 {
+		n := 1
+		for n < len(rows) && rows[n].Name == rows[0].Name {
+			n++
+		}
+		fk := &foreignKeyPool{name: rows[0].Name, nullable: true}
+		parent := tableRef{schema: rows[0].ParentSchema, name: rows[0].ParentName}
+		var referenced []string
+		generated := true
+		for _, row := range rows[:n] {
+			col := g.column(row.Column)
+			if col == nil {
+				generated = false
+				break
+			}
+			fk.columns = append(fk.columns, row.Column)
+			fk.nullable = fk.nullable && col.Nullable
+			referenced = append(referenced, row.ReferencedColumn)
+		}
+		rows = rows[n:]
+		if !generated {
+			continue
+		}
+		if fk.keys, err = sampleKeys(db, parent, referenced); err != nil {
+			return fmt.Errorf("foreign key %s: %w", fk.name, err)
+		}
+		for _, p := range previous {
+			if strings.EqualFold(p.table.ref.schema, parent.schema) && strings.EqualFold(p.table.ref.name, parent.name) {
+				fk.keys = append(fk.keys, p.sampleKeys(referenced)...)
+			}
+		}
+		if len(fk.keys) == 0 && !fk.nullable {
+			return fmt.Errorf("foreign key %s references %s, which has no rows: load or generate it first", fk.name, parent)
+		}
+		g.fks = append(g.fks, fk)
+	}
+	return nil
+}
+
+// sampleKeys returns distinct non null values of the columns of the table, as export writes them.
+func sampleKeys(db *sqlx.DB, ref tableRef, columns []string) ([][]any, error) {
+	table, err := getTableInfo(db, ref)
+	if err != nil {
+		return nil, err
+	}
+	conditions := make([]string, len(columns))
+	for i, col := range columns {
+		conditions[i] = quoteName(col) + " IS NOT NULL"
+	}
+	query := fmt.Sprintf("SELECT DISTINCT TOP (%d) %s FROM %s WHERE %s", keySample,
+		strings.Join(quoteNames(columns), ", "), ref.quoted(), strings.Join(conditions, " AND "))
+	logger().Debug("query", "table", ref.String(), "sql", query)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys [][]any
+	for rows.Next() {
+		key := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range key {
+			dest[i] = &key[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, col := range columns {
+			if key[i], err = exportValue(table.schema[col], key[i]); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// sampleKeys returns the values of the columns in the generated rows kept, if generated.
+func (g *tableGenerator) sampleKeys(columns []string) [][]any {
+	var keys [][]any
+	for _, row := range g.sample {
+		key := make([]any, len(columns))
+		for i, col := range columns {
+			key[i] = row[col]
+		}
+		if !slices.Contains(key, nil) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// loadUniqueKeys reads the primary key and unique indexes of the table. A unique key of a single
+// integer column not a foreign key takes values counting up from its largest one.
+func (g *tableGenerator) loadUniqueKeys(db *sqlx.DB) error {
+	var rows []struct {
+		Name   string `db:"index_name"`
+		Column string `db:"column_name"`
+	}
+	err := db.Select(&rows, `
+SELECT i.name AS index_name, c.name AS column_name
+FROM sys.indexes i
+JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE i.object_id = OBJECT_ID(@p1) AND i.is_unique = 1 AND ic.is_included_column = 0
+ORDER BY i.index_id, ic.key_ordinal`, g.table.ref.quoted())
+	if err != nil {
+		return err
+	}
+	for len(rows) > 0 {
+		n := 1
+		for n < len(rows) && rows[n].Name == rows[0].Name {
+			n++
+		}
+		key := &uniqueKey{name: rows[0].Name, seen: make(map[string]struct{})}
+		generated := true
+		for _, row := range rows[:n] {
+			// a key with an identity or computed column is unique by the server
+			generated = generated && g.column(row.Column) != nil
+			key.columns = append(key.columns, row.Column)
+		}
+		rows = rows[n:]
+		if !generated {
+			continue
+		}
+		g.keys = append(g.keys, key)
+		if len(key.columns) != 1 || g.foreignKey(key.columns[0]) != nil {
+			continue
+		}
+		col := g.column(key.columns[0])
+		if _, ok := integerRanges[col.DataType]; !ok {
+			continue
+		}
+		var last sql.NullInt64
+		query := fmt.Sprintf("SELECT MAX(CONVERT(bigint, %s)) FROM %s", quoteName(col.Name), g.table.ref.quoted())
+		if err := db.Get(&last, query); err != nil {
+			return err
+		}
+		g.seq[col.Name] = last.Int64 + 1
+	}
+	return nil
+}
+
+// column returns the generated column of the name, nil if the column is not generated.
+func (g *tableGenerator) column(name string) *columnDefinition {
+	i := slices.IndexFunc(g.cols, func(col columnDefinition) bool { return col.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &g.cols[i]
+}
+
+// foreignKey returns the foreign key of the column, nil if none.
+func (g *tableGenerator) foreignKey(name string) *foreignKeyPool {
+	for _, fk := range g.fks {
+		if slices.Contains(fk.columns, name) {
+			return fk
+		}
+	}
+	return nil
+}
+
+// row makes up the values of the next row, in the order of the generated columns and as the
+// loader reads them from a data file.
+func (g *tableGenerator) row() ([]any, error) {
+	values := make(map[string]any, len(g.cols))
+	for _, fk := range g.fks {
+		g.pick(fk, values)
+	}
+	for _, col := range g.cols {
+		if _, ok := values[col.Name]; !ok {
+			values[col.Name] = g.value(col)
+		}
+	}
+	for _, key := range g.keys {
+		for try := 0; ; try++ {
+			parts := make([]string, len(key.columns))
+			for i, col := range key.columns {
+				parts[i] = fmt.Sprint(values[col])
+			}
+			k := strings.Join(parts, "\x00")
+			if _, dup := key.seen[k]; !dup {
+				key.seen[k] = struct{}{}
+				break
+			}
+			if try == 100 {
+				return nil, fmt.Errorf("no unique value left for %s of %s", key.name, g.table.ref)
+			}
+			for _, col := range key.columns {
+				if fk := g.foreignKey(col); fk != nil {
+					g.pick(fk, values)
+				} else {
+					values[col] = g.value(*g.column(col))
+				}
+			}
+		}
+	}
+	// reservoir sampling keeps an even sample of the rows
+	g.count++
+	if len(g.sample) < keySample {
+		g.sample = append(g.sample, values)
+	} else if i := g.rng.IntN(g.count); i < keySample {
+		g.sample[i] = values
+	}
+	row := make([]any, len(g.cols))
+	for i, col := range g.cols {
+		row[i] = values[col.Name]
+	}
+	return row, nil
+}
+
+// pick sets the columns of the foreign key to a key of the referenced table, or to nulls.
+func (g *tableGenerator) pick(fk *foreignKeyPool, values map[string]any) {
+	if len(fk.keys) == 0 || fk.nullable && g.rng.Float64() < g.nullRate {
+		for _, col := range fk.columns {
+			values[col] = nil
+		}
+		return
+	}
+	key := fk.keys[g.rng.IntN(len(fk.keys))]
+	for i, col := range fk.columns {
+		values[col] = key[i]
+	}
+}
+
+// value makes up a value of the column type, drawn by the column name when it tells what the
+// column holds.
+func (g *tableGenerator) value(col columnDefinition) any {
+	if next, ok := g.seq[col.Name]; ok {
+		g.seq[col.Name]++
+		return json.Number(strconv.FormatInt(next, 10))
+	}
+	if col.Nullable && g.rng.Float64() < g.nullRate {
+		return nil
+	}
+	name := strings.ToLower(col.Name)
+	switch col.DataType {
+	case "bit":
+		return g.rng.IntN(2) == 1
+	case "tinyint", "smallint", "int", "bigint":
+		low, high := int64(1), int64(100000)
+		switch {
+		case hasAny(name, "qty", "quantity", "count"):
+			high = 20
+		case name == "age" || strings.HasSuffix(name, "_age"):
+			low, high = 18, 90
+		case hasAny(name, "year"):
+			low, high = 1990, 2030
+		case hasAny(name, "price", "amount", "total", "cost"):
+			high = 1000
+		}
+		bounds := integerRanges[col.DataType]
+		low, high = max(low, bounds[0]), min(high, bounds[1])
+		return json.Number(strconv.FormatInt(low+g.rng.Int64N(high-low+1), 10))
+	case "decimal", "numeric", "money", "smallmoney":
+		precision, scale := col.Precision, col.Scale
+		switch col.DataType {
+		case "money":
+			precision, scale = 19, 2
+		case "smallmoney":
+			precision, scale = 10, 2
+		}
+		high := 100000.0
+		if hasAny(name, "price", "amount", "total", "cost") {
+			high = 1000
+		}
+		if hasAny(name, "rate", "ratio", "percent", "discount") {
+			high = 1
+		}
+		high = min(high, math.Pow10(precision-scale)-math.Pow10(-scale))
+		return json.Number(strconv.FormatFloat(g.rng.Float64()*high, 'f', scale, 64))
+	case "float", "real":
+		return json.Number(strconv.FormatFloat(g.rng.Float64()*1000, 'f', 4, 64))
+	case "date", "datetime", "datetime2", "datetimeoffset", "smalldatetime", "time":
+		return g.dateTime(col.DataType, name)
+	case "uniqueidentifier":
+		var b [16]byte
+		for i := range b {
+			b[i] = byte(g.rng.UintN(256))
+		}
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return uuid.UUID(b).String()
+	case "binary", "varbinary", "image":
+		n := col.MaxLength
+		if n <= 0 || n > 16 {
+			n = 16
+		}
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(g.rng.UintN(256))
+		}
+		return "0x" + hex.EncodeToString(b)
+	case "xml":
+		return "<note>" + g.words(8) + "</note>"
+	case "geography", "geometry":
+		return fmt.Sprintf("POINT(%.6f %.6f)", g.rng.Float64()*360-180, g.rng.Float64()*180-90)
+	case "hierarchyid":
+		return fmt.Sprintf("/%d/%d/", g.rng.IntN(10)+1, g.rng.IntN(100)+1)
+	case "sql_variant":
+		return json.Number(strconv.Itoa(g.rng.IntN(100000)))
+	}
+	return g.text(col, name)
+}
+
+// dateTime makes up a date in the last three years, a birth date for the columns named so.
+func (g *tableGenerator) dateTime(dataType, name string) string {
+	now := time.Now().UTC().Truncate(24 * time.Hour)
+	from, span := now.AddDate(-3, 0, 0), now.Sub(now.AddDate(-3, 0, 0))
+	if hasAny(name, "birth", "dob") {
+		from = time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC)
+		span = time.Date(2005, 12, 31, 0, 0, 0, 0, time.UTC).Sub(from)
+	}
+	t := from.Add(time.Duration(g.rng.Int64N(int64(span/time.Second))) * time.Second)
+	switch dataType {
+	case "date":
+		return t.Format("2006-01-02")
+	case "time":
+		return t.Format("15:04:05")
+	case "smalldatetime":
+		return t.Format("2006-01-02T15:04")
+	case "datetimeoffset":
+		return t.Format(time.RFC3339)
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// text makes up a text value fitting the column length, drawn by the column name. The values
+// of unique keys end in a counter.
+func (g *tableGenerator) text(col columnDefinition, name string) string {
+	size := col.MaxLength
+	if size <= 0 {
+		size = 200
+	}
+	pick := func(values []string) string { return values[g.rng.IntN(len(values))] }
+	first, last := pick(firstNames), pick(lastNames)
+	var s, domain string
+	switch {
+	case size <= 3:
+		b := make([]byte, size)
+		for i := range b {
+			b[i] = byte('A' + g.rng.IntN(26))
+		}
+		s = string(b)
+	case hasAny(name, "email", "mail"):
+		s, domain = strings.ToLower(first+"."+last), "@"+pick(mailDomains)
+	case hasAny(name, "firstname", "first_name", "givenname", "given_name"):
+		s = first
+	case hasAny(name, "lastname", "last_name", "surname", "familyname", "family_name"):
+		s = last
+	case hasAny(name, "company", "supplier", "vendor", "customer"):
+		s = last + " " + pick(companySuffixes)
+	case hasAny(name, "city", "town"):
+		s = pick(cities)
+	case hasAny(name, "country"):
+		s = pick(countries)
+	case hasAny(name, "street", "address"):
+		s = fmt.Sprintf("%d %s", g.rng.IntN(200)+1, pick(streets))
+	case hasAny(name, "zip", "postal", "postcode"):
+		s = fmt.Sprintf("%05d", g.rng.IntN(100000))
+	case hasAny(name, "phone", "mobile", "fax"):
+		s = fmt.Sprintf("+1 555 %03d %04d", g.rng.IntN(1000), g.rng.IntN(10000))
+	case hasAny(name, "url", "website", "homepage"):
+		s = "https://www." + strings.ToLower(last) + ".com"
+	case hasAny(name, "user", "login"):
+		s = strings.ToLower(first[:1] + last)
+	case hasAny(name, "status", "state"):
+		s = pick(statuses)
+	case hasAny(name, "currency"):
+		s = pick(currencies)
+	case hasAny(name, "name"):
+		s = first + " " + last
+	case hasAny(name, "code", "sku", "number", "ref"):
+		s = fmt.Sprintf("%c%c-%05d", 'A'+g.rng.IntN(26), 'A'+g.rng.IntN(26), g.rng.IntN(100000))
+	case hasAny(name, "description", "comment", "note", "remark", "text", "body"):
+		s = g.words(5 + g.rng.IntN(20))
+	default:
+		s = g.words(1 + g.rng.IntN(4))
+	}
+	var suffix string
+	if slices.ContainsFunc(g.keys, func(key *uniqueKey) bool { return slices.Contains(key.columns, col.Name) }) {
+		suffix = strconv.FormatInt(g.unique, 36)
+		g.unique++
+	}
+	// the suffix and domain are kept whole, the value is cut to fit
+	runes := []rune(s)
+	keep := max(0, size-len(suffix)-len(domain))
+	if len(runes) > keep {
+		runes = runes[:keep]
+	}
+	s = string(runes) + suffix + domain
+	if r := []rune(s); len(r) > size {
+		s = string(r[len(r)-size:])
+	}
+	return s
+}
+
+// words makes up a sentence of n words.
+func (g *tableGenerator) words(n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = loremWords[g.rng.IntN(len(loremWords))]
+	}
+	s := strings.Join(words, " ")
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// hasAny tells whether the lower cased name holds any of the parts.
+func hasAny(name string, parts ...string) bool {
+	return slices.ContainsFunc(parts, func(part string) bool { return strings.Contains(name, part) })
+}
+
+// columnSchemas returns the schema of the generated columns.
+func (g *tableGenerator) columnSchemas() []ColumnSchema {
+	cols := make([]ColumnSchema, len(g.cols))
+	for i, col := range g.cols {
+		cols[i] = g.table.schema[col.Name]
+	}
+	return cols
+}
+
+// writeFile writes n rows to the data file.
+func (g *tableGenerator) writeFile(filePath string, n int, opts *generateOptions) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w rowWriter = &jsonRowWriter{w: f, cols: g.columnSchemas()}
+	if opts.format == "csv" {
+		w = newCsvRowWriter(f, g.columnSchemas(), opts.delimiter)
+	}
+	for range n {
+		row, err := g.row()
+		if err != nil {
+			return err
+		}
+		if err := w.write(row); err != nil {
+			return err
+		}
+	}
+	return errors.Join(w.close(), f.Close())
+}
+
+// insert bulk inserts n rows in transactions of the batch size and returns the rows inserted.
+func (g *tableGenerator) insert(ctx context.Context, db *sqlx.DB, n int, opts *generateOptions) (int, error) {
+	cols := g.columnSchemas()
+	inserted := 0
+	for inserted < n {
+		if ctx.Err() != nil {
+			return inserted, errInterrupted
+		}
+		rows := make([][]any, min(opts.batchSize, n-inserted))
+		for r := range rows {
+			row, err := g.row()
+			if err != nil {
+				return inserted, err
+			}
+			for i, v := range row {
+				if row[i], err = convertValue(cols[i], v, opts.conv); err != nil {
+					return inserted, fmt.Errorf("column %s value %v: %w", cols[i].ColumnName, v, err)
+				}
+			}
+			rows[r] = row
+		}
+		tx, err := db.Beginx()
+		if err != nil {
+			return inserted, err
+		}
+		if err := bulkInsert(tx, g.table, cols, rows, opts.conv); err != nil {
+			tx.Rollback()
+			return inserted, err
+		}
+		if err := tx.Commit(); err != nil {
+			return inserted, err
+		}
+		inserted += len(rows)
+		logger().Debug("batch inserted", "table", g.table.ref.String(), "rows", inserted)
+	}
+	return inserted, nil
+}
+
+// The words values are drawn from.
+var (
+	firstNames = []string{"Anna", "Ben", "Clara", "David", "Emma", "Felix", "Grace", "Hugo", "Ines", "Jonas",
+		"Karla", "Leon", "Maria", "Noah", "Olivia", "Paul", "Rosa", "Samuel", "Tara", "Victor"}
+	lastNames = []string{"Smith", "Mueller", "Garcia", "Rossi", "Dubois", "Novak", "Jansen", "Silva", "Kowalski", "Berg",
+		"Nielsen", "Weber", "Martin", "Lopez", "Fischer", "Brown", "Schmidt", "Costa", "Moreau", "Wagner"}
+	mailDomains     = []string{"example.com", "example.org", "example.net"}
+	companySuffixes = []string{"Ltd", "GmbH", "Inc", "AG", "& Co", "Group"}
+	cities          = []string{"Berlin", "Hamburg", "Munich", "Frankfurt", "Vienna", "Zurich", "Paris", "Lyon", "Madrid", "Lisbon",
+		"Rome", "Milan", "Amsterdam", "Brussels", "Copenhagen", "Stockholm", "Warsaw", "Prague", "London", "Dublin"}
+	countries = []string{"Germany", "Austria", "Switzerland", "France", "Spain", "Portugal", "Italy", "Netherlands",
+		"Belgium", "Denmark", "Sweden", "Poland", "Czechia", "United Kingdom", "Ireland"}
+	streets    = []string{"Main Street", "High Street", "Park Avenue", "Station Road", "Church Lane", "Mill Road", "Market Square", "Lake View"}
+	statuses   = []string{"new", "open", "pending", "shipped", "closed", "cancelled"}
+	currencies = []string{"EUR", "USD", "GBP", "CHF", "SEK", "PLN"}
+	loremWords = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do",
+		"eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim",
+		"minim", "veniam", "quis", "nostrud", "exercitation", "ullamco", "laboris", "nisi", "aliquip", "commodo"}
+)
@@ -0,0 +1,82 @@
+package loader
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+)
+
+// orderGenerator returns a generator of the rows of an Orders table keyed by Id counting up from
+// 100, unique by Code and referencing the customers 1 to 3.
+func orderGenerator(seed uint64) *tableGenerator {
+	def := &tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "CustomerId", DataType: "int", Nullable: true},
+		{Name: "Code", DataType: "varchar", MaxLength: 8},
+		{Name: "Email", DataType: "nvarchar", MaxLength: 20, Nullable: true},
+		{Name: "Quantity", DataType: "smallint"},
+		{Name: "Price", DataType: "decimal", Precision: 5, Scale: 2},
+		{Name: "OrderedAt", DataType: "datetime2", Scale: 0},
+		{Name: "Shipped", DataType: "bit"},
+		{Name: "Ref", DataType: "uniqueidentifier"},
+	}}
+	g := &tableGenerator{table: def.tableInfo(), cols: def.Columns, rng: rand.New(rand.NewPCG(seed, seed)), nullRate: 0.2,
+		seq: map[string]int64{"Id": 100}}
+	g.fks = []*foreignKeyPool{{name: "FK_Orders_Customers", columns: []string{"CustomerId"}, nullable: true, keys: [][]any{{1}, {2}, {3}}}}
+	g.keys = []*uniqueKey{
+		{name: "PK_Orders", columns: []string{"Id"}, seen: make(map[string]struct{})},
+		{name: "UQ_Orders_Code", columns: []string{"Code"}, seen: make(map[string]struct{})},
+	}
+	return g
+}
+
+func TestGenerateRows(t *testing.T) {
+	g := orderGenerator(1)
+	cols := g.columnSchemas()
+	codes := make(map[any]bool)
+	for n := range 500 {
+		row, err := g.row()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, col := range g.cols {
+			v := row[i]
+			if v == nil {
+				if !col.Nullable {
+					t.Fatalf("row %d: null %s", n, col.Name)
+				}
+				continue
+			}
+			// the values load into the columns as they are
+			if _, err := convertValue(cols[i], v, conversionOptions{Strict: true}); err != nil {
+				t.Fatalf("row %d: %s value %v: %v", n, col.Name, v, err)
+			}
+			if s, ok := v.(string); ok && col.MaxLength > 0 && utf8.RuneCountInString(s) > col.MaxLength {
+				t.Fatalf("row %d: %s value %q longer than %d", n, col.Name, s, col.MaxLength)
+			}
+		}
+		if id := row[0]; id != json.Number(strconv.Itoa(100+n)) {
+			t.Fatalf("row %d: Id %v, want %d", n, id, 100+n)
+		}
+		if customer := row[1]; customer != nil && !slices.Contains([]any{1, 2, 3}, customer) {
+			t.Fatalf("row %d: CustomerId %v not a customer", n, customer)
+		}
+		if codes[row[2]] {
+			t.Fatalf("row %d: Code %v twice", n, row[2])
+		}
+		codes[row[2]] = true
+	}
+
+	// the same seed makes the same rows
+	a, b := orderGenerator(7), orderGenerator(7)
+	for range 10 {
+		rowA, _ := a.row()
+		rowB, _ := b.row()
+		if !slices.Equal(rowA, rowB) {
+			t.Fatalf("rows %v and %v of the same seed", rowA, rowB)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Database states of the health report.
+const (
+	dbReachable   = "ok"
+	dbUnreachable = "unreachable"
+	// dbUnknown is for a mode without a connection of its own before its first run
+	dbUnknown = "unknown"
+)
+
+// healthState is what the /healthz and /readyz endpoints of the long running modes report.
+type healthState struct {
+	mu sync.Mutex
+	// db is pinged by /readyz, nil for the daemon whose runs connect on their own
+	db      *sqlx.DB
+	started time.Time
+	lastRun *runStatus
+	// queueDepth counts the requests, files or runs waiting or going on
+	queueDepth int
+	metrics    *metricSet
+}
+
+// runStatus is the outcome of the last run in the health report.
+type runStatus struct {
+	Status     string      `json:"status"`
+	ExitCode   AppExitCode `json:"exit_code"`
+	Error      string      `json:"error,omitempty"`
+	EndedAt    time.Time   `json:"ended_at"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// healthReport is the answer of /healthz and /readyz.
+type healthReport struct {
+	Status        string     `json:"status"`
+	Database      string     `json:"database"`
+	DatabaseError string     `json:"database_error,omitempty"`
+	UptimeSeconds int64      `json:"uptime_seconds"`
+	QueueDepth    int        `json:"queue_depth"`
+	LastRun       *runStatus `json:"last_run,omitempty"`
+}
+
+func newHealthState(db *sqlx.DB) *healthState {
+	return &healthState{db: db, started: time.Now(), metrics: newMetricSet()}
+}
+
+// runDone records the outcome of a run started at start.
+func (h *healthState) runDone(start time.Time, code AppExitCode) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := "success"
+	switch code {
+	case SuccessCode:
+	case PartialSuccessCode:
+		status = "partial"
+	default:
+		status = "failed"
+	}
+	h.lastRun = &runStatus{Status: status, ExitCode: code, EndedAt: time.Now(), DurationMs: time.Since(start).Milliseconds()}
+	h.metrics.runDone(status, time.Since(start))
+	if code != SuccessCode {
+		h.lastRun.Error = exitCodeDescription[code]
+	}
+}
+
+// addQueued changes the queue depth by n.
+func (h *healthState) addQueued(n int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queueDepth += n
+	h.metrics.set("uptomssql_queue_depth", float64(h.queueDepth))
+}
+
+// setQueued sets the queue depth.
+func (h *healthState) setQueued(n int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queueDepth = n
+	h.metrics.set("uptomssql_queue_depth", float64(h.queueDepth))
+}
+
+// report returns the state with the database pinged, or for the daemon judged by the last run.
+func (h *healthState) report(ctx context.Context) *healthReport {
+	h.mu.Lock()
+	r := &healthReport{
+		Status:        "ok",
+		Database:      dbUnknown,
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+		QueueDepth:    h.queueDepth,
+	}
+	if h.lastRun != nil {
+		run := *h.lastRun
+		r.LastRun = &run
+	}
+	h.mu.Unlock()
+
+	switch {
+	case h.db != nil:
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		r.Database = dbReachable
+		if err := h.db.PingContext(ctx); err != nil {
+			r.Database, r.DatabaseError = dbUnreachable, err.Error()
+		}
+	case r.LastRun != nil:
+		r.Database = dbReachable
+		if code := r.LastRun.ExitCode; code == ConnectErrorCode || code == ConnectionLostErrorCode {
+			r.Database = dbUnreachable
+		}
+	}
+	return r
+}
+
+// addRoutes adds GET /healthz, answering 200 while the process serves, and GET /readyz,
+// answering 503 when the database is unreachable, both with the health report, and GET /metrics
+// with the metrics of the runs in the Prometheus text format.
+func (h *healthState) addRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.report(r.Context()), false)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.report(r.Context()), true)
+	})
+	mux.HandleFunc("GET /metrics", h.metrics.serveHTTP)
+}
+
+func writeHealth(w http.ResponseWriter, r *healthReport, ready bool) {
+	status := http.StatusOK
+	if ready && r.Database == dbUnreachable {
+		r.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
+
+// serve answers the health endpoints on addr in the background, it fails right
+// away if it cannot listen.
+func (h *healthState) serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	h.addRoutes(mux)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger().Error("health endpoints stopped", "err", err)
+		}
+	}()
+	logger().Info("health endpoints started", "addr", listener.Addr().String())
+	return nil
+}
@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hintRules are the parse rules of the hints file besides date layouts: integers written in hex,
+// unix times in seconds or milliseconds and binary values in hex or base64 only.
+var hintRules = []string{"hex-int", "epoch", "epoch-ms", "hex", "base64"}
+
+var dateTypes = []string{"date", "datetime", "datetime2", "datetimeoffset", "smalldatetime", "time"}
+
+// hintsFile is the hints file, the parse rules by [table.]column.
+type hintsFile struct {
+	Columns map[string]string `yaml:"columns"`
+}
+
+// readColumnHints loads the hints file, the parse rules of columns overriding the conversion of
+// their type by table and lower cased column name, the rules of all tables under "". It returns
+// nil if path is empty.
+func readColumnHints(path string) (map[string]map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file hintsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var hints map[string]map[string]string
+	for name, rule := range file.Columns {
+		if err := checkHint(rule); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		setColumnEntry(&hints, name, rule)
+	}
+	return hints, nil
+}
+
+// checkHint checks the rule is one of hintRules or a date layout, which has to have an element
+// of the reference time, so it formats a time other than it as something else than itself.
+func checkHint(rule string) error {
+	if slices.Contains(hintRules, rule) {
+		return nil
+	}
+	if rule == "" || time.Date(1999, 12, 31, 23, 59, 58, 0, time.UTC).Format(rule) == rule {
+		return fmt.Errorf("unknown hint %q, want %s or a date layout like 02/01/2006", rule, strings.Join(hintRules, ", "))
+	}
+	return nil
+}
+
+// convertHinted converts val with the hint rule of the column instead of the defaults of its type.
+func convertHinted(col ColumnSchema, val any, rule string, opts conversionOptions) (any, error) {
+	s, err := stringOf(val)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimSpace(s)
+	switch {
+	case rule == "hex-int":
+		bounds, ok := integerRanges[col.DataType]
+		if !ok {
+			break
+		}
+		digits := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+		i, err := strconv.ParseInt(digits, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected hex integer, got %q", s)
+		}
+		return convertInteger(i, bounds)
+	case rule == "epoch" || rule == "epoch-ms":
+		if !slices.Contains(dateTypes, col.DataType) {
+			break
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected unix time, got %q", s)
+		}
+		t := time.Unix(n, 0)
+		if rule == "epoch-ms" {
+			t = time.UnixMilli(n)
+		}
+		opts.DateFormats = nil
+		return convertDateTime(col, t.UTC().Format(time.RFC3339Nano), opts)
+	case rule == "hex" || rule == "base64":
+		if col.DataType != "binary" && col.DataType != "varbinary" && col.DataType != "image" {
+			break
+		}
+		var b []byte
+		if rule == "hex" {
+			b, err = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+		} else {
+			b, err = base64.StdEncoding.DecodeString(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %w", rule, err)
+		}
+		return b, nil
+	default:
+		if !slices.Contains(dateTypes, col.DataType) {
+			break
+		}
+		opts.DateFormats = []string{rule}
+		return convertDateTime(col, s, opts)
+	}
+	return nil, fmt.Errorf("hint %q does not apply to %s column", rule, col.DataType)
+}
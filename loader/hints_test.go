@@ -0,0 +1,97 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-sql/civil"
+)
+
+func TestReadColumnHints(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"01_Orders.json": "[]", "02_Lines.json": "[]"})
+	hints := filepath.Join(t.TempDir(), "hints.yaml")
+	if err := os.WriteFile(hints, []byte("columns:\n  Orders.Flags: hex-int\n  flags: hex\n  BirthDate: 02/01/2006\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, source := sourceFor(t, "upload", "-d", dir, "-hints", hints)
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, _, err := source.planFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rules of the table take precedence over the ones of all tables
+	want := map[string]map[string]string{
+		"Orders": {"flags": "hex-int", "birthdate": "02/01/2006"},
+		"Lines":  {"flags": "hex", "birthdate": "02/01/2006"},
+	}
+	for _, plan := range plans {
+		hints := plan.conv.Hints
+		if fmt.Sprint(hints) != fmt.Sprint(want[plan.table.name]) {
+			t.Errorf("%s: hints %v, want %v", plan.table, hints, want[plan.table.name])
+		}
+	}
+
+	tests := []struct {
+		yaml    string
+		wantErr string
+	}{
+		{yaml: "columns:\n  Flags: octal\n", wantErr: `Flags: unknown hint "octal"`},
+		{yaml: "columns:\n  Flags: ''\n", wantErr: "unknown hint"},
+		{yaml: "columns: [", wantErr: "hints.yaml"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "hints.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readColumnHints(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: error %v, want %q", tt.yaml, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConvertHinted(t *testing.T) {
+	tests := []struct {
+		dataType string
+		rule     string
+		value    any
+		want     any
+		wantErr  string
+	}{
+		{dataType: "int", rule: "hex-int", value: "0xFF", want: int64(255)},
+		{dataType: "smallint", rule: "hex-int", value: "7fff", want: int64(32767)},
+		{dataType: "tinyint", rule: "hex-int", value: "1FF", wantErr: "out of range"},
+		{dataType: "int", rule: "hex-int", value: "0xZZ", wantErr: "expected hex integer"},
+		{dataType: "date", rule: "02/01/2006", value: "31/12/1999", want: civil.Date{Year: 1999, Month: 12, Day: 31}},
+		{dataType: "date", rule: "02/01/2006", value: "12/31/1999", wantErr: "12/31/1999"},
+		{dataType: "datetime2", rule: "epoch", value: "86400", want: civil.DateTime{Date: civil.Date{Year: 1970, Month: 1, Day: 2}}},
+		{dataType: "datetime2", rule: "epoch-ms", value: json.Number("1500"), want: civil.DateTime{Date: civil.Date{Year: 1970, Month: 1, Day: 1}, Time: civil.Time{Second: 1, Nanosecond: 500_000_000}}},
+		{dataType: "datetime2", rule: "epoch", value: "yesterday", wantErr: "expected unix time"},
+		{dataType: "varbinary", rule: "hex", value: "0x0A0b", want: []byte{0x0a, 0x0b}},
+		{dataType: "varbinary", rule: "base64", value: "Cgs=", want: []byte{0x0a, 0x0b}},
+		{dataType: "varbinary", rule: "base64", value: "Cgs", wantErr: "invalid base64 value"},
+		{dataType: "nvarchar", rule: "hex-int", value: "FF", wantErr: `hint "hex-int" does not apply to nvarchar column`},
+		{dataType: "int", rule: "02/01/2006", value: "31/12/1999", wantErr: "does not apply to int column"},
+	}
+	for _, tt := range tests {
+		col := ColumnSchema{ColumnName: "Value", DataType: tt.dataType, IsNullable: "YES"}
+		got, err := convertValue(col, tt.value, conversionOptions{Hints: map[string]string{"value": tt.rule}})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s %s %v: error %v, want %q", tt.dataType, tt.rule, tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+			t.Errorf("%s %s %v: got %#v, %v, want %#v", tt.dataType, tt.rule, tt.value, got, err, tt.want)
+		}
+	}
+}
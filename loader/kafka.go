@@ -0,0 +1,409 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang-sql/civil"
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// kafkaOptions are the flags of the kafka command.
+type kafkaOptions struct {
+	conn      connOptions
+	log       logOptions
+	brokers   string
+	group     string
+	topics    stringList
+	batchSize int
+	batchWait time.Duration
+	// deadLetterTopic receives the messages that are not records of the table, the consumer stops on them if empty
+	deadLetterTopic string
+	tls             bool
+	saslUser        string
+	saslPassword    string
+	healthAddr      string
+	conv            conversionOptions
+}
+
+func (o *kafkaOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.brokers, "brokers", "localhost:9092", "comma separated Kafka brokers, host:port")
+	fs.StringVar(&o.group, "group", "uptomssql", "consumer group the offsets are committed for")
+	fs.Var(&o.topics, "topic", "topic to consume and its table, e.g. 'orders=sales.Orders', the topic name is the table if none is given; repeat for more topics")
+	fs.IntVar(&o.batchSize, "batch-size", 1000, "records bulk inserted per transaction")
+	fs.DurationVar(&o.batchWait, "batch-wait", 5*time.Second, "longest time to wait for a batch to fill before inserting it")
+	fs.StringVar(&o.deadLetterTopic, "dead-letter-topic", "", "topic to send the messages failing to convert to, with the error in a header, instead of stopping")
+	fs.BoolVar(&o.tls, "tls", false, "connect to the brokers with TLS")
+	fs.StringVar(&o.saslUser, "sasl-user", "", "user for SASL PLAIN authentication to the brokers")
+	fs.StringVar(&o.saslPassword, "sasl-password", "", "password for SASL PLAIN authentication to the brokers")
+	fs.StringVar(&o.healthAddr, "health-addr", "", "answer GET /healthz, /readyz and /metrics on this address, host:port, none if empty")
+	fs.IntVar(&o.conv.SRID, "srid", 4326, "spatial reference id for geography and geometry values")
+}
+
+func runKafka(cmd *command, args []string) {
+	var opts kafkaOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	tables, err := parseSourceTables("topic", opts.topics)
+	if err == nil {
+		err = opts.log.check()
+	}
+	switch {
+	case err != nil:
+	case opts.batchSize <= 0:
+		err = fmt.Errorf("invalid -batch-size %d", opts.batchSize)
+	case opts.batchWait <= 0:
+		err = fmt.Errorf("invalid -batch-wait %s", opts.batchWait)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	consumeKafka(&opts, tables)
+}
+
+// parseSourceTables reads the source=table values of the -topic or -queue flag named.
+func parseSourceTables(flagName string, values []string) (map[string]tableRef, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("give the %ss to consume with -%s", flagName, flagName)
+	}
+	tables := make(map[string]tableRef, len(values))
+	for _, value := range values {
+		source, table, _ := strings.Cut(value, "=")
+		source, table = strings.TrimSpace(source), strings.TrimSpace(table)
+		if table == "" {
+			table = source
+		}
+		if source == "" {
+			return nil, fmt.Errorf("-%s %q: no %s", flagName, value, flagName)
+		}
+		if _, ok := tables[source]; ok {
+			return nil, fmt.Errorf("-%s %q: %s given twice", flagName, value, flagName)
+		}
+		tables[source] = parseTableRef(table)
+	}
+	return tables, nil
+}
+
+// dialer returns the dialer of the broker connections with TLS and SASL if set.
+func (o *kafkaOptions) dialer() *kafka.Dialer {
+	d := &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true}
+	if o.tls {
+		d.TLS = &tls.Config{}
+	}
+	if o.saslUser != "" {
+		d.SASLMechanism = plain.Mechanism{Username: o.saslUser, Password: o.saslPassword}
+	}
+	return d
+}
+
+// tableRecord is a record of a message converted to a row of its table.
+type tableRecord struct {
+	table *tableInfo
+	row   *rowValues
+}
+
+// consumeKafka inserts the json records of the topics into their tables in batches with the bulk
+// copy until interrupted. The offsets are committed once the batch is committed to the database,
+// a batch failing is read again by the next consumer: records are loaded at least once.
+func consumeKafka(opts *kafkaOptions, tables map[string]tableRef) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	infos := make(map[string]*tableInfo, len(tables))
+	for topic, ref := range tables {
+		table, err := getTableInfo(db, ref)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+		if len(table.columns) == 0 {
+			handleError(fmt.Errorf("table %s of topic %s not found", ref, topic), TableInfoErrorCode)
+		}
+		infos[topic] = table
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var health *healthState
+	if opts.healthAddr != "" {
+		health = newHealthState(db)
+		handleError(health.serve(opts.healthAddr), InternalErrorCode)
+	}
+
+	dialer := opts.dialer()
+	brokers := strings.Split(opts.brokers, ",")
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		GroupID:     opts.group,
+		GroupTopics: slices.Sorted(maps.Keys(tables)),
+		Dialer:      dialer,
+		MaxBytes:    10 << 20,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+	var deadLetters *kafka.Writer
+	if opts.deadLetterTopic != "" {
+		deadLetters = &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        opts.deadLetterTopic,
+			RequiredAcks: kafka.RequireAll,
+			Transport:    &kafka.Transport{TLS: dialer.TLS, SASL: dialer.SASLMechanism},
+		}
+		defer deadLetters.Close()
+	}
+	// buildRow of the uploader converts the records as upload does
+	u := &uploader{ctx: ctx, db: db, opts: &uploadOptions{sourceOptions: sourceOptions{conv: opts.conv}}, summary: newRunSummary(), log: logger()}
+
+	logger().Info("consumer started", "brokers", opts.brokers, "group", opts.group, "topics", len(tables))
+	for {
+		var messages []kafka.Message
+		var records []tableRecord
+		var rejected []kafka.Message
+		// the batch waits for its first message as long as it takes, then -batch-wait at most
+		fetchCtx, cancel := ctx, context.CancelFunc(func() {})
+		for len(records) < opts.batchSize {
+			msg, err := reader.FetchMessage(fetchCtx)
+			if err != nil {
+				if ctx.Err() != nil {
+					cancel()
+					logger().Info("consumer stopped")
+					return
+				}
+				if errors.Is(err, context.DeadlineExceeded) {
+					break
+				}
+				cancel()
+				handleError(err, ConnectErrorCode)
+			}
+			if len(messages) == 0 {
+				fetchCtx, cancel = context.WithTimeout(ctx, opts.batchWait)
+			}
+			messages = append(messages, msg)
+			health.setQueued(len(messages))
+			table := infos[msg.Topic]
+			rows, err := messageRows(u, table, msg.Value, opts.conv)
+			if err != nil {
+				err = fmt.Errorf("topic %s partition %d offset %d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+				if deadLetters == nil {
+					cancel()
+					handleError(err, ConversionErrorCode)
+				}
+				logger().Error("message sent to the dead letter topic", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, "err", err)
+				msg.Headers = append(msg.Headers, kafka.Header{Key: "uptomssql-error", Value: []byte(err.Error())})
+				rejected = append(rejected, msg)
+				continue
+			}
+			for _, row := range rows {
+				records = append(records, tableRecord{table: table, row: row})
+			}
+		}
+		cancel()
+
+		start := time.Now()
+		err := insertRecords(db, records, opts.conv)
+		if err != nil {
+			health.runDone(start, dbErrorCode(err, InsertDataErrorCode))
+		}
+		handleError(err, dbErrorCode(err, InsertDataErrorCode))
+		if len(rejected) > 0 {
+			dead := make([]kafka.Message, len(rejected))
+			for i, msg := range rejected {
+				dead[i] = kafka.Message{Key: msg.Key, Value: msg.Value, Headers: msg.Headers}
+			}
+			handleError(deadLetters.WriteMessages(context.Background(), dead...), InsertDataErrorCode)
+		}
+		// the batch is in the database, the offsets are committed even if interrupted now
+		handleError(reader.CommitMessages(context.Background(), messages...), ConnectErrorCode)
+		health.setQueued(0)
+		health.runDone(start, SuccessCode)
+		logger().Info("batch loaded", "messages", len(messages), "rows", len(records), "dead_letters", len(rejected), "duration", time.Since(start).Round(time.Millisecond).String())
+	}
+}
+
+// messageRows converts the json object or array of objects of a message to rows of the table,
+// records with no column of the table are skipped.
+func messageRows(u *uploader, table *tableInfo, value []byte, conv conversionOptions) ([]*rowValues, error) {
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+	var data any
+	if err := d.Decode(&data); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	if _, err := d.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("invalid json: data after the value")
+	}
+	var objects []any
+	switch v := data.(type) {
+	case map[string]any:
+		objects = []any{v}
+	case []any:
+		objects = v
+	default:
+		return nil, errors.New("expected a json object or array of objects")
+	}
+	var rows []*rowValues
+	for i, object := range objects {
+		record, ok := object.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("record %d: expected a json object", i+1)
+		}
+		row, err := u.buildRow(table, record, Json, conv)
+		if errors.Is(err, errNoData) {
+			logger().Warn("record skipped, no column to insert", "table", table.ref.String(), "record", i+1)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// insertRecords bulk inserts the records in one transaction in the order they came, the records
+// following each other of a table with the same columns together, so parents go in before the
+// children referencing them.
+func insertRecords(db *sqlx.DB, records []tableRecord, conv conversionOptions) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, run := range recordRuns(records) {
+		first := run[0]
+		rows := make([][]any, len(run))
+		for i, r := range run {
+			rows[i] = r.row.values
+		}
+		if err := bulkInsert(tx, first.table, first.row.columns, rows, conv); err != nil {
+			return fmt.Errorf("%s: %w", first.table.ref, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// recordRuns splits the records into the runs of records following each other of a table with
+// the same columns.
+func recordRuns(records []tableRecord) [][]tableRecord {
+	var runs [][]tableRecord
+	start := 0
+	for i := 1; i <= len(records); i++ {
+		if i < len(records) && records[i].table == records[start].table && slices.Equal(records[i].row.columns, records[start].row.columns) {
+			continue
+		}
+		runs = append(runs, records[start:i])
+		start = i
+	}
+	return runs
+}
+
+// bulkInsert bulk copies the rows, values of cols converted as for an insert, into the table in tx.
+// As in copy, the rows go through a stage table when the identity is kept or the bulk copy cannot
+// write a column type, spatial and hierarchyid values being parsed from their text there.
+func bulkInsert(tx *sqlx.Tx, table *tableInfo, cols []ColumnSchema, rows [][]any, conv conversionOptions) error {
+	names := make([]string, len(cols))
+	stageExprs := make([]string, len(cols))
+	selectExprs := make([]string, len(cols))
+	stage := copiesIdentity(table, cols)
+	for i, col := range cols {
+		names[i] = col.ColumnName
+		name := quoteName(col.ColumnName)
+		stageExprs[i], selectExprs[i] = name, name
+		switch {
+		case slices.Contains(table.identityColumns, col.ColumnName):
+			stageExprs[i] = fmt.Sprintf("%s * 1 AS %s", name, name)
+		case stageTypes[col.DataType] != "":
+			stageExprs[i] = fmt.Sprintf("CONVERT(%s, %s) AS %s", stageTypes[col.DataType], name, name)
+			stage = true
+		case placeholder(col, name, conv) != name:
+			stageExprs[i] = fmt.Sprintf("CONVERT(nvarchar(max), %s) AS %s", name, name)
+			selectExprs[i] = placeholder(col, name, conv)
+			stage = true
+		}
+	}
+	bulkTable := table.ref.quoted()
+	if stage {
+		bulkTable = copyStageTable
+		query := fmt.Sprintf("SELECT TOP 0 %s INTO %s FROM %s;", strings.Join(stageExprs, ", "), copyStageTable, table.ref.quoted())
+		logger().Debug("query", "table", table.ref.String(), "sql", query)
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	stmt, err := tx.Prepare(mssql.CopyIn(bulkTable, mssql.BulkOptions{KeepNulls: true, CheckConstraints: true, FireTriggers: true}, names...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	values := make([]any, len(cols))
+	for n, row := range rows {
+		for i, v := range row {
+			values[i] = bulkParam(v)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("row %d: %w", n+1, err)
+		}
+	}
+	// the last exec with no values sends the rows buffered
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	if !stage {
+		return nil
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s; DROP TABLE %s;", table.ref.quoted(),
+		strings.Join(quoteNames(names), ", "), strings.Join(selectExprs, ", "), copyStageTable, copyStageTable)
+	if copiesIdentity(table, cols) {
+		query = fmt.Sprintf("SET IDENTITY_INSERT %s ON; %s SET IDENTITY_INSERT %s OFF;", table.ref.quoted(), query, table.ref.quoted())
+	}
+	logger().Debug("query", "table", table.ref.String(), "sql", query)
+	_, err = tx.Exec(query)
+	return err
+}
+
+// bulkParam returns a value converted for an insert parameter as the bulk copy takes it.
+func bulkParam(v any) any {
+	switch v := v.(type) {
+	case mssql.VarChar:
+		return string(v)
+	case mssql.VarCharMax:
+		return string(v)
+	case mssql.DateTime1:
+		return time.Time(v)
+	case civil.Date:
+		return v.In(time.UTC)
+	case civil.DateTime:
+		return v.In(time.UTC)
+	case civil.Time:
+		return time.Date(1, 1, 1, v.Hour, v.Minute, v.Second, v.Nanosecond, time.UTC)
+	case driver.Valuer:
+		// the bytes of a uniqueidentifier in the order of the server
+		if value, err := v.Value(); err == nil {
+			return value
+		}
+	}
+	return v
+}
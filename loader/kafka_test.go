@@ -0,0 +1,44 @@
+package loader
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRecordRuns(t *testing.T) {
+	customers := &tableInfo{ref: tableRef{name: "Customers"}}
+	orders := &tableInfo{ref: tableRef{name: "Orders"}}
+	id := []ColumnSchema{{ColumnName: "Id"}}
+	idName := []ColumnSchema{{ColumnName: "Id"}, {ColumnName: "Name"}}
+	record := func(table *tableInfo, cols []ColumnSchema, id int) tableRecord {
+		return tableRecord{table: table, row: &rowValues{columns: cols, values: []any{id}}}
+	}
+	// a customer, its order, then another customer and its order: the runs keep the order they came in
+	records := []tableRecord{
+		record(customers, idName, 1),
+		record(customers, idName, 2),
+		record(orders, id, 10),
+		record(customers, idName, 3),
+		record(customers, id, 4),
+		record(orders, id, 11),
+		record(orders, id, 12),
+	}
+	var got [][]any
+	for _, run := range recordRuns(records) {
+		var ids []any
+		for _, r := range run {
+			if r.table != run[0].table || !slices.Equal(r.row.columns, run[0].row.columns) {
+				t.Fatalf("run mixes tables or columns: %v", run)
+			}
+			ids = append(ids, r.row.values[0])
+		}
+		got = append(got, ids)
+	}
+	want := [][]any{{1, 2}, {10}, {3}, {4}, {11, 12}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("runs = %v, want %v", got, want)
+	}
+	if runs := recordRuns(nil); len(runs) != 0 {
+		t.Errorf("runs of no record = %v", runs)
+	}
+}
@@ -0,0 +1,60 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// keyVaultTimeout bounds the sign in and the unwrap of a key, the run waits for them before reading
+// anything.
+const keyVaultTimeout = time.Minute
+
+// keyVaultKey is a key in Azure Key Vault, the latest version if version is empty.
+type keyVaultKey struct {
+	vault, name, version string
+}
+
+// parseKeyVaultKey reads the key id of a Key Vault key,
+// https://<vault>.vault.azure.net/keys/<name>[/<version>].
+func parseKeyVaultKey(id string) (keyVaultKey, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return keyVaultKey{}, fmt.Errorf("key vault key %q: %w", id, err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Scheme != "https" || u.Host == "" || len(parts) < 2 || len(parts) > 3 || parts[0] != "keys" || parts[1] == "" {
+		return keyVaultKey{}, fmt.Errorf("key vault key %q, want https://<vault>.vault.azure.net/keys/<name>[/<version>]", id)
+	}
+	key := keyVaultKey{vault: u.Scheme + "://" + u.Host, name: parts[1]}
+	if len(parts) == 3 {
+		key.version = parts[2]
+	}
+	return key, nil
+}
+
+// unwrap decrypts a key wrapped with the Key Vault key by RSA-OAEP-256, signed in with the default
+// Azure credential: environment variables, workload or managed identity, or the az cli login.
+func (k keyVaultKey) unwrap(wrapped []byte) ([]byte, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azkeys.NewClient(k.vault, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), keyVaultTimeout)
+	defer cancel()
+	alg := azkeys.EncryptionAlgorithmRSAOAEP256
+	res, err := client.UnwrapKey(ctx, k.name, k.version, azkeys.KeyOperationParameters{Algorithm: &alg, Value: wrapped}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap with key vault key %s: %w", k.name, err)
+	}
+	return res.Result, nil
+}
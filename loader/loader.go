@@ -0,0 +1,173 @@
+// Package loader loads json and csv data files into SQL Server tables. It is the uptomssql
+// command, which Main runs, and an Uploader embeds its upload in Go services and tests.
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Options are the settings of an Uploader, as the flags of the upload command are, zero values
+// taking the defaults of the flags.
+type Options struct {
+	// Dirs are the dirs or globs of the data files, test_data if none
+	Dirs []string
+	// File is a single data file to load instead of the dirs, into Table or the table of its name
+	File      string
+	Table     string
+	Recursive bool
+	// Only and Exclude are comma separated table names or regexps to load and to skip
+	Only    string
+	Exclude string
+	// Mode is insert, upsert, refresh or sync, insert if empty
+	Mode     string
+	Truncate bool
+	// Delimiter is the csv delimiter, ; if empty
+	Delimiter string
+	Encoding  string
+	// BatchSize is the rows per transaction, 0 commits every row on its own
+	BatchSize int
+	// MaxErrors is how many failing rows are set aside before the run stops, 0 stops on the first
+	// and -1 never
+	MaxErrors int
+	DryRun    bool
+	// Validate checks the files against the tables without writing
+	Validate bool
+	// Verify checks the tables against the files after the load
+	Verify bool
+	// Expect is the yaml file of the row counts the tables must have after the load
+	Expect string
+	// Assert is the yaml file of the queries that must return no row after the load
+	Assert string
+	// CheckDuplicates fails the run before it writes if rows of the files of a table share their
+	// primary key, or the columns of BusinessKeys by table
+	CheckDuplicates bool
+	BusinessKeys    map[string][]string
+	// CheckExisting fails, skips or upserts the rows whose key of a unique index a table row has,
+	// checked before the run writes, for SQL Server tables
+	CheckExisting string
+	// Inject sets the columns (keys) of every row of every table having them to the values
+	Inject map[string]string
+	// Driver is the server of the database, sqlserver, postgres or mysql, sqlserver if empty
+	Driver string
+	// LockTimeout is how long to wait for another run loading the database to finish, 0 fails
+	// right away
+	LockTimeout time.Duration
+	// Out receives the reports of the run, the summary and the dry run statements, none if nil
+	Out io.Writer
+	// Logger receives the log of the run, the default slog logger if nil
+	Logger *slog.Logger
+}
+
+// Uploader loads data files into the tables of a database.
+type Uploader struct {
+	db   *sqlx.DB
+	opts Options
+}
+
+// NewUploader returns an Uploader loading into db, opened with the sqlserver driver of
+// github.com/microsoft/go-mssqldb, or the pgx driver of github.com/jackc/pgx/v5/stdlib or the
+// mysql driver of github.com/go-sql-driver/mysql for the Driver postgres or mysql. A run holds a
+// connection of its own for the run lock, so db needs at least 2 open connections.
+func NewUploader(db *sql.DB, opts Options) *Uploader {
+	return &Uploader{db: sqlx.NewDb(db, sqlDriverName(opts.Driver)), opts: opts}
+}
+
+// Upload loads the data files of the options, it stops when ctx is done. The result holds the
+// outcome of every file, also when the run fails. The error is a *RunError with the return code
+// the upload command exits with.
+func (u *Uploader) Upload(ctx context.Context) (result *RunResult, err error) {
+	command := "upload"
+	if u.opts.Validate {
+		command = "validate"
+	}
+	result = newRunResult(command)
+	defer func() {
+		var runErr *RunError
+		if errors.As(err, &runErr) {
+			result.finish(runErr.Err, runErr.Code)
+		}
+	}()
+	opts, err := u.uploadOptions()
+	if err != nil {
+		return result, &RunError{Code: ValidationErrorCode, Err: err}
+	}
+	out := u.opts.Out
+	if out == nil {
+		out = io.Discard
+	}
+	if err := uploadTo(ctx, u.db, opts, result, out); err != nil {
+		return result, runError(err, InternalErrorCode)
+	}
+	result.finish(nil, SuccessCode)
+	return result, nil
+}
+
+// uploadOptions returns the options of the upload command for the Uploader.
+func (u *Uploader) uploadOptions() (*uploadOptions, error) {
+	// a run embedded does not ask to confirm deletes and draws no progress bar
+	opts := &uploadOptions{validate: u.opts.Validate, yes: true}
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	opts.addFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		return nil, err
+	}
+	opts.log.quiet = true
+	opts.log.logger = u.opts.Logger
+	opts.dirPaths = stringList(u.opts.Dirs)
+	opts.filePath = u.opts.File
+	opts.tableName = u.opts.Table
+	opts.recursive = u.opts.Recursive
+	opts.only = u.opts.Only
+	opts.exclude = u.opts.Exclude
+	if u.opts.Mode != "" {
+		opts.file.Mode = u.opts.Mode
+	}
+	opts.file.Truncate = u.opts.Truncate
+	if u.opts.Delimiter != "" {
+		opts.file.Delimiter = u.opts.Delimiter
+	}
+	opts.file.Encoding = u.opts.Encoding
+	opts.batchSize = u.opts.BatchSize
+	opts.maxErrors = u.opts.MaxErrors
+	opts.dryRun = u.opts.DryRun
+	opts.verify = u.opts.Verify
+	opts.expectFile = u.opts.Expect
+	opts.assertFile = u.opts.Assert
+	opts.checkDuplicates = u.opts.CheckDuplicates
+	opts.businessKeys = u.opts.BusinessKeys
+	opts.checkExisting = u.opts.CheckExisting
+	for col, value := range u.opts.Inject {
+		if opts.inject == nil {
+			opts.inject = make(map[string]any)
+		}
+		opts.inject[col] = value
+	}
+	opts.conn.driver = u.opts.Driver
+	opts.lockTimeout = u.opts.LockTimeout
+	if opts.maxErrors < -1 {
+		return nil, fmt.Errorf("invalid MaxErrors %d", opts.maxErrors)
+	}
+	if opts.lockTimeout < 0 {
+		return nil, fmt.Errorf("invalid LockTimeout %s", opts.lockTimeout)
+	}
+	if opts.checkExisting != "" && !slices.Contains(existingPolicies, opts.checkExisting) {
+		return nil, fmt.Errorf("invalid CheckExisting %q, fail, skip or upsert", opts.checkExisting)
+	}
+	if err := opts.conn.checkDriver(fs); err != nil {
+		return nil, err
+	}
+	if err := opts.sqlServerOnly(); err != nil {
+		return nil, err
+	}
+	return opts, opts.sourceOptions.check()
+}
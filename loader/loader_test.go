@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUploaderError(t *testing.T) {
+	db := sql.OpenDB(&fakeServer{})
+	defer db.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Orders.json"), []byte(`[{"Id": 1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the upload fails back to the caller, the process goes on
+	up := NewUploader(db, Options{File: filepath.Join(dir, "Orders.json"), DryRun: true})
+	result, err := up.Upload(context.Background())
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("error %v, want a *RunError", err)
+	}
+	if runErr.Code == SuccessCode {
+		t.Errorf("code %d, want a failure", runErr.Code)
+	}
+	if result == nil || result.ExitCode != runErr.Code {
+		t.Errorf("result %+v, want the code %d", result, runErr.Code)
+	}
+}
+
+func TestUploaderLogger(t *testing.T) {
+	db := sql.OpenDB(&fakeServer{})
+	defer db.Close()
+	// uploaders side by side log to their own logger
+	const runs = 4
+	logs := make([]bytes.Buffer, runs)
+	var wg sync.WaitGroup
+	errs := make([]error, runs)
+	for i := range runs {
+		dir := t.TempDir()
+		table := fmt.Sprintf("Orders%d", i)
+		if err := os.WriteFile(filepath.Join(dir, "01_"+table+".json"), []byte(`[{"Id": 1}]`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		log := slog.New(slog.NewTextHandler(&logs[i], nil))
+		up := NewUploader(db, Options{Dirs: []string{dir}, Exclude: table, DryRun: true, Logger: log})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = up.Upload(context.Background())
+		}()
+	}
+	wg.Wait()
+	for i := range runs {
+		if errs[i] != nil {
+			t.Fatalf("run %d: %v", i, errs[i])
+		}
+		got := logs[i].String()
+		for j := range runs {
+			table := fmt.Sprintf("table=Orders%d", j)
+			if strings.Contains(got, table) != (i == j) {
+				t.Errorf("log of run %d has %s %v, want %v:\n%s", i, table, !(i == j), i == j, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// runLockResource names the application lock a run holds on the database it loads.
+const runLockResource = "uptomssql"
+
+// errRunLockPool fails a run whose connection pool has a single connection, the run lock would
+// hold it and leave none to load with.
+var errRunLockPool = errors.New("the run lock holds a connection of its own, the connection pool needs at least 2 open connections")
+
+// runLock is an exclusive session lock, so no two runs load a database at once.
+// It is held by a connection of its own, closing it releases the lock.
+type runLock struct {
+	conn    *sqlx.Conn
+	dialect dialect
+	// db and timeout take the lock again when its connection is dropped
+	db      *sqlx.DB
+	timeout time.Duration
+}
+
+// acquireRunLock waits up to timeout for the lock, held by another run.
+func acquireRunLock(ctx context.Context, db *sqlx.DB, timeout time.Duration) (*runLock, error) {
+	if db.Stats().MaxOpenConnections == 1 {
+		return nil, errRunLockPool
+	}
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := dialectOf(db)
+	if err := d.lock(ctx, conn, timeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &runLock{conn: conn, dialect: d, db: db, timeout: timeout}, nil
+}
+
+// check takes the lock again on a new connection when the server dropped the one holding it,
+// which released it.
+func (l *runLock) check(ctx context.Context, log *slog.Logger) error {
+	if l == nil || l.conn.PingContext(ctx) == nil {
+		return nil
+	}
+	log.Warn("run lock connection lost, taking the lock again")
+	l.conn.Close()
+	lock, err := acquireRunLock(ctx, l.db, l.timeout)
+	if err != nil {
+		return err
+	}
+	*l = *lock
+	return nil
+}
+
+func (l *runLock) release() error {
+	if l == nil {
+		return nil
+	}
+	err := l.dialect.unlock(l.conn)
+	if closeErr := l.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxConns int
+		wantErr  error
+	}{
+		{name: "pool of one", maxConns: 1, wantErr: errRunLockPool},
+		{name: "pool of two", maxConns: 2},
+		{name: "unlimited pool", maxConns: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := sql.OpenDB(&fakeServer{})
+			defer db.Close()
+			db.SetMaxOpenConns(tt.maxConns)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			lock, err := acquireRunLock(ctx, sqlx.NewDb(db, "sqlserver"), 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer lock.release()
+			// the run loads on another connection while the lock is held
+			if err := db.PingContext(ctx); err != nil {
+				t.Errorf("no connection left for the run: %v", err)
+			}
+		})
+	}
+}
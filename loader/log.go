@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logOptions sets where and how the run is logged.
+type logOptions struct {
+	verbose bool
+	quiet   bool
+	format  string
+	file    string
+	noColor bool
+	// dir archives a full log of every run, kept for retention if set
+	dir       string
+	retention time.Duration
+	// logger is the logger of a run embedded by an Uploader, the default one if nil
+	logger *slog.Logger
+}
+
+// archivePrefix starts the names of the run logs in the archive dir.
+const archivePrefix = "uptomssql-"
+
+func (o *logOptions) addFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.verbose, "v", false, "log every statement executed")
+	fs.BoolVar(&o.quiet, "q", false, "log warnings and errors only, no per file progress")
+	fs.StringVar(&o.format, "log-format", "text", "log format: text or json")
+	fs.StringVar(&o.file, "log-file", "", "append the log to this file instead of stderr")
+	fs.StringVar(&o.dir, "log-dir", "", "write a debug level log of the run to a timestamped file in this dir, whatever -q or -v")
+	fs.DurationVar(&o.retention, "log-retention", 0, "remove the run logs in -log-dir older than this, e.g. 720h, 0 keeps them all")
+	fs.BoolVar(&o.noColor, "no-color", false, "no colors on a terminal, as with the NO_COLOR environment variable")
+}
+
+func (o *logOptions) check() error {
+	if o.verbose && o.quiet {
+		return errors.New("-v and -q exclude each other")
+	}
+	if o.format != "text" && o.format != "json" {
+		return fmt.Errorf("unknown log format %q", o.format)
+	}
+	return nil
+}
+
+// setup makes the logger of the options the default one.
+func (o *logOptions) setup() error {
+	if o.noColor {
+		noColor = true
+	}
+	var w io.Writer = os.Stderr
+	if colorFor(os.Stderr) {
+		w = levelColorWriter{w: os.Stderr}
+	}
+	if o.file != "" {
+		f, err := os.OpenFile(o.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	level := slog.LevelInfo
+	switch {
+	case o.verbose:
+		level = slog.LevelDebug
+	case o.quiet:
+		level = slog.LevelWarn
+	}
+	handler := o.newHandler(w, level)
+	if o.dir != "" {
+		f, err := o.archiveFile()
+		if err != nil {
+			return err
+		}
+		handler = teeHandler{handler, o.newHandler(f, slog.LevelDebug)}
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// logger returns the logger the commands log to, the one logOptions.setup makes the default.
+func logger() *slog.Logger {
+	return slog.Default()
+}
+
+// progressBar returns where to draw the progress bar, nil to log the progress instead.
+func (o *logOptions) progressBar() io.Writer {
+	logsToStderr := o.file == ""
+	if o.quiet || (o.verbose && logsToStderr) || !isTerminal(os.Stderr) {
+		return nil
+	}
+	return os.Stderr
+}
+
+func (o *logOptions) newHandler(w io.Writer, level slog.Level) slog.Handler {
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	if o.format == "json" {
+		return slog.NewJSONHandler(w, handlerOpts)
+	}
+	return slog.NewTextHandler(w, handlerOpts)
+}
+
+// archiveFile creates the log file of the run in the archive dir, removing the expired ones.
+func (o *logOptions) archiveFile() (*os.File, error) {
+	if err := os.MkdirAll(o.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if o.retention > 0 {
+		entries, err := os.ReadDir(o.dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !strings.HasPrefix(entry.Name(), archivePrefix) || time.Since(info.ModTime()) < o.retention {
+				continue
+			}
+			if err := os.Remove(filepath.Join(o.dir, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	name := fmt.Sprintf("%s%s-%d.log", archivePrefix, time.Now().Format("20060102T150405"), os.Getpid())
+	return os.Create(filepath.Join(o.dir, name))
+}
+
+// teeHandler passes the records to every handler enabled for their level.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slices.ContainsFunc(t, func(h slog.Handler) bool { return h.Enabled(ctx, level) })
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, record.Level) {
+			errs = append(errs, h.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
@@ -0,0 +1,226 @@
+package loader
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+type AppExitCode = int
+
+const (
+	SuccessCode AppExitCode = iota
+
+	ConnectErrorCode
+	TableInfoErrorCode
+	InsertDataErrorCode
+	UnmarshalErrorCode
+
+	ReadDirErrorCode
+	ReadFileErrorCode
+	OpenFileErrorCode
+
+	ValidationErrorCode
+	WriteScriptErrorCode
+	NotConfirmedCode
+
+	ConversionErrorCode
+	ConstraintErrorCode
+	ConnectionLostErrorCode
+	PartialSuccessCode
+	InternalErrorCode
+	InterruptedCode
+	LockedCode
+	ExportErrorCode
+	DiffFoundCode
+	VerifyFailedCode
+	ExpectationFailedCode
+	AssertionFailedCode
+	DuplicateKeysCode
+	UsageErrorCode
+)
+
+var exitCodeDescription = map[AppExitCode]string{
+	SuccessCode:          "success",
+	ConnectErrorCode:     "error on connect to db",
+	TableInfoErrorCode:   "error on get table info",
+	InsertDataErrorCode:  "error on data insert in table",
+	UnmarshalErrorCode:   "error on unmarshal inserted data",
+	ReadDirErrorCode:     "error on read dir",
+	ReadFileErrorCode:    "error on read file",
+	OpenFileErrorCode:    "error on open file",
+	ValidationErrorCode:  "data does not match table schema",
+	WriteScriptErrorCode: "error on write output file",
+	NotConfirmedCode:     "destructive run not confirmed",
+
+	ConversionErrorCode:     "data conversion failed",
+	ConstraintErrorCode:     "constraint violation on insert",
+	ConnectionLostErrorCode: "connection to db lost during the run",
+	PartialSuccessCode:      "done with rows rejected",
+	InternalErrorCode:       "internal error",
+	InterruptedCode:         "run interrupted",
+	LockedCode:              "another run is loading the database",
+	ExportErrorCode:         "error on read table data",
+	DiffFoundCode:           "tables differ from the data files",
+	VerifyFailedCode:        "table row counts or checksums do not match the data files",
+	ExpectationFailedCode:   "table row counts do not match the expectations",
+	AssertionFailedCode:     "assertion queries returned rows",
+	DuplicateKeysCode:       "rows of the data files share a key",
+	UsageErrorCode:          "invalid command, flags or arguments",
+}
+
+// RunError is the error a run stopped on, with the return code of the command.
+type RunError struct {
+	Code AppExitCode
+	Err  error
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("%s: %v", exitCodeDescription[e.Code], e.Err)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// runError returns err with the return code of the command for it, nil if err is nil. An error
+// having its code already keeps it.
+func runError(err error, errorCode AppExitCode) error {
+	var runErr *RunError
+	if err == nil || errors.As(err, &runErr) {
+		return err
+	}
+	return &RunError{Code: errorCode, Err: err}
+}
+
+// handleError exits the command with the return code of err, the code of a *RunError or else
+// errorCode, if err is set.
+func handleError(err error, errorCode AppExitCode) {
+	if err != nil {
+		var runErr *RunError
+		if errors.As(err, &runErr) {
+			err, errorCode = runErr.Err, runErr.Code
+		}
+		logger().Error(exitCodeDescription[errorCode], "code", errorCode, "err", err)
+		os.Exit(errorCode)
+	}
+}
+
+// command is a subcommand of the tool, run with the arguments following its name.
+type command struct {
+	name    string
+	summary string
+	run     func(cmd *command, args []string)
+}
+
+var commands = []*command{
+	{name: "upload", summary: "upload data files into the database tables", run: runUpload},
+	{name: "validate", summary: "check data files against the database tables without writing", run: runValidate},
+	{name: "watch", summary: "load data files into the database tables as they are added or changed", run: runWatch},
+	{name: "daemon", summary: "run upload or another command on a cron schedule until stopped", run: runDaemon},
+	{name: "serve", summary: "load json or csv data posted over http into the database tables", run: runServe},
+	{name: "kafka", summary: "insert the json records of Kafka topics into the database tables", run: runKafka},
+	{name: "queue", summary: "insert the json records of AMQP queues, Service Bus or RabbitMQ, into the database tables", run: runQueue},
+	{name: "diff", summary: "compare data files to the table rows by primary key without writing", run: runDiff},
+	{name: "verify", summary: "check the row counts and checksums of the tables against the data files", run: runVerify},
+	{name: "apply", summary: "apply NDJSON change events as inserts, updates and deletes", run: runApply},
+	{name: "migrate", summary: "load or revert versioned migration files up or down to a version", run: runMigrate},
+	{name: "restore", summary: "put back the tables of a snapshot written by upload -snapshot-before", run: runRestore},
+	{name: "copy", summary: "copy tables from a database to another with the bulk path, without files", run: runCopy},
+	{name: "schema", summary: "write the column definitions or CREATE TABLE scripts of tables", run: runSchema},
+	{name: "export", summary: "dump tables to data files the upload command loads", run: runExport},
+	{name: "generate", summary: "make up rows for tables, as data files or inserted, for volume tests", run: runGenerate},
+	{name: "bench", summary: "compare load strategies and batch sizes on a table with generated rows", run: runBench},
+}
+
+// defaultCommand runs when the arguments start with a flag, as before subcommands existed.
+const defaultCommand = "upload"
+
+// stringList is a flag value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: uptomssql %s [flags]\n\n%s\n\n", cmd.name, cmd.summary)
+		fs.PrintDefaults()
+		printReturnCodes()
+	}
+	return fs
+}
+
+// parseFlags parses the flags of a command, it exits with UsageErrorCode on an unknown or invalid
+// flag, the flag package printed the error and the usage.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(SuccessCode)
+	}
+	if err != nil {
+		os.Exit(UsageErrorCode)
+	}
+}
+
+// usageError prints the error of the flags and the usage of the command and exits with
+// UsageErrorCode.
+func usageError(fs *flag.FlagSet, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	fs.Usage()
+	os.Exit(UsageErrorCode)
+}
+
+func printReturnCodes() {
+	fmt.Fprintf(os.Stderr, "\nReturn codes:\n")
+	for i := range len(exitCodeDescription) {
+		fmt.Fprintf(os.Stderr, "  %d => %s\n", i, exitCodeDescription[i])
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: uptomssql <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun uptomssql <command> -h for the command flags.\n")
+	printReturnCodes()
+}
+
+// Main runs the command of the arguments of the process and exits with its return code.
+func Main() {
+	defer func() {
+		// a panic is a bug, it still exits with a code of the table
+		if r := recover(); r != nil {
+			logger().Debug("panic", "stack", string(debug.Stack()))
+			handleError(fmt.Errorf("%v", r), InternalErrorCode)
+		}
+	}()
+	name, args := defaultCommand, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(cmd, args)
+			os.Exit(SuccessCode)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(UsageErrorCode)
+}
@@ -0,0 +1,67 @@
+package loader
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestExitCodeDescriptions(t *testing.T) {
+	seen := make(map[string]AppExitCode)
+	for code := SuccessCode; code <= UsageErrorCode; code++ {
+		desc, ok := exitCodeDescription[code]
+		if !ok {
+			t.Errorf("exit code %d has no description", code)
+			continue
+		}
+		if other, dup := seen[desc]; dup {
+			t.Errorf("exit codes %d and %d share the description %q", other, code, desc)
+		}
+		seen[desc] = code
+	}
+}
+
+// TestMainProcess runs Main with the arguments after -- when started by TestUsageExitCode.
+func TestMainProcess(t *testing.T) {
+	if os.Getenv("UPTOMSSQL_TEST_MAIN") != "1" {
+		t.Skip("run by TestUsageExitCode")
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"uptomssql"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	Main()
+}
+
+func TestUsageExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "unknown command", args: []string{"unload"}, want: UsageErrorCode},
+		{name: "unknown flag", args: []string{"upload", "-no-such-flag"}, want: UsageErrorCode},
+		{name: "invalid flags", args: []string{"export", "-format", "xml"}, want: UsageErrorCode},
+		{name: "help", args: []string{"upload", "-h"}, want: SuccessCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainProcess$", "--"}, tt.args...)...)
+			cmd.Env = append(os.Environ(), "UPTOMSSQL_TEST_MAIN=1")
+			err := cmd.Run()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestNames are the file names a manifest is looked up by in a data dir.
+var manifestNames = []string{"manifest.yaml", "manifest.yml"}
+
+// Load modes of a file.
+const (
+	// InsertMode inserts every row
+	InsertMode = "insert"
+	// UpsertMode updates rows matched by primary key and inserts the others
+	UpsertMode = "upsert"
+	// RefreshMode deletes all rows of the table before inserting
+	RefreshMode = "refresh"
+	// SyncMode upserts every row and deletes the table rows missing from the data files
+	SyncMode = "sync"
+)
+
+var loadModes = []string{InsertMode, UpsertMode, RefreshMode, SyncMode}
+
+// fileOptions tune how a single data file is loaded.
+type fileOptions struct {
+	Table  string `yaml:"table"`
+	Schema string `yaml:"schema"`
+	// Database is the database of the table on the server, when not the one connected to
+	Database  string `yaml:"database"`
+	Mode      string `yaml:"mode"`
+	Truncate  bool   `yaml:"truncate"`
+	Delimiter string `yaml:"delimiter"`
+	Encoding  string `yaml:"encoding"`
+	// DateFormats are Go time layouts tried before the default ones for date and time values
+	DateFormats []string `yaml:"date_formats"`
+	// Columns renames file columns (keys) to table columns (values)
+	Columns map[string]string `yaml:"columns"`
+	// Transform sets columns (keys) to expressions of the row values (values)
+	Transform map[string]string `yaml:"transform"`
+	// Where is the expression of the row values the rows loaded match
+	Where string `yaml:"where"`
+	// Pipe is a shell command the file goes through before it is read, its output read instead
+	Pipe string `yaml:"pipe"`
+	// Templates expands tokens like ${NOW-7d} in the string values
+	Templates bool `yaml:"templates"`
+	// ExpectRows is the row count of the table after the load, like 120 or >= 100
+	ExpectRows string `yaml:"expect_rows"`
+	// Assert are queries by name run after the load that must return no row
+	Assert map[string]string `yaml:"assert"`
+	// Audit fills columns (keys) missing from the rows with run_time, run_id, user or file (values)
+	Audit map[string]string `yaml:"audit"`
+}
+
+// merge returns the options with the values set in other taking precedence.
+func (o fileOptions) merge(other fileOptions) fileOptions {
+	if other.Table != "" {
+		o.Table = other.Table
+	}
+	if other.Schema != "" {
+		o.Schema = other.Schema
+	}
+	if other.Database != "" {
+		o.Database = other.Database
+	}
+	if other.Mode != "" {
+		o.Mode = other.Mode
+	}
+	o.Truncate = o.Truncate || other.Truncate
+	o.Templates = o.Templates || other.Templates
+	if other.Delimiter != "" {
+		o.Delimiter = other.Delimiter
+	}
+	if other.Encoding != "" {
+		o.Encoding = other.Encoding
+	}
+	if len(other.DateFormats) > 0 {
+		o.DateFormats = other.DateFormats
+	}
+	if len(other.Columns) > 0 {
+		columns := maps.Clone(o.Columns)
+		if columns == nil {
+			columns = make(map[string]string)
+		}
+		maps.Copy(columns, other.Columns)
+		o.Columns = columns
+	}
+	if other.Pipe != "" {
+		o.Pipe = other.Pipe
+	}
+	if other.Where != "" {
+		o.Where = other.Where
+	}
+	if other.ExpectRows != "" {
+		o.ExpectRows = other.ExpectRows
+	}
+	if len(other.Assert) > 0 {
+		assert := maps.Clone(o.Assert)
+		if assert == nil {
+			assert = make(map[string]string)
+		}
+		maps.Copy(assert, other.Assert)
+		o.Assert = assert
+	}
+	if len(other.Audit) > 0 {
+		audit := maps.Clone(o.Audit)
+		if audit == nil {
+			audit = make(map[string]string)
+		}
+		maps.Copy(audit, other.Audit)
+		o.Audit = audit
+	}
+	if len(other.Transform) > 0 {
+		transform := maps.Clone(o.Transform)
+		if transform == nil {
+			transform = make(map[string]string)
+		}
+		maps.Copy(transform, other.Transform)
+		o.Transform = transform
+	}
+	return o
+}
+
+func (o fileOptions) check() error {
+	if o.Mode != "" && !slices.Contains(loadModes, o.Mode) {
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	}
+	if len([]rune(o.Delimiter)) > 1 {
+		return fmt.Errorf("delimiter %q is not a single character", o.Delimiter)
+	}
+	if o.ExpectRows != "" {
+		if _, err := parseRowExpectation(o.ExpectRows); err != nil {
+			return fmt.Errorf("expect_rows: %w", err)
+		}
+	}
+	if err := checkAudit(o.Audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sidecarSuffixes are appended to a data file name to get the file with its options.
+var sidecarSuffixes = []string{".meta.yaml", ".meta.yml"}
+
+func isSidecar(fileName string) bool {
+	return slices.ContainsFunc(sidecarSuffixes, func(suffix string) bool {
+		return strings.HasSuffix(fileName, suffix)
+	})
+}
+
+// readSidecar loads the options of the data file from its sidecar file, if there is one.
+func readSidecar(filePath string) (fileOptions, error) {
+	var opts fileOptions
+	for _, suffix := range sidecarSuffixes {
+		data, err := os.ReadFile(filePath + suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return opts, err
+		}
+		if err := yaml.Unmarshal(data, &opts); err != nil {
+			return opts, fmt.Errorf("%s: %w", filePath+suffix, err)
+		}
+		if err := opts.check(); err != nil {
+			return opts, fmt.Errorf("%s: %w", filePath+suffix, err)
+		}
+		break
+	}
+	return opts, nil
+}
+
+// manifestEntry is a file listed in a manifest.
+type manifestEntry struct {
+	File        string `yaml:"file"`
+	fileOptions `yaml:",inline"`
+}
+
+// manifest lists the files of a data dir in load order along with their options.
+type manifest struct {
+	Files []manifestEntry `yaml:"files"`
+}
+
+// readManifest loads the manifest of dir, it returns nil if the dir has none.
+func readManifest(dir string) (*manifest, error) {
+	for _, name := range manifestNames {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var m manifest
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, entry := range m.Files {
+			if entry.File == "" {
+				return nil, fmt.Errorf("%s: entry without file", name)
+			}
+			if err := entry.check(); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", name, entry.File, err)
+			}
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// files returns the data files the manifest lists, paths are relative to dir.
+func (m *manifest) files(dir string) []dataFile {
+	files := make([]dataFile, 0, len(m.Files))
+	for _, entry := range m.Files {
+		files = append(files, dataFile{
+			path:  filepath.Join(dir, entry.File),
+			table: tableRef{database: entry.Database, schema: entry.Schema, name: entry.Table},
+			opts:  entry.fileOptions,
+		})
+	}
+	return files
+}
@@ -0,0 +1,122 @@
+package loader
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tableMapping adapts the columns of the files of a table to it: renames, drops, constants and
+// concatenations.
+type tableMapping struct {
+	// Rename renames file columns (keys) to table columns (values)
+	Rename map[string]string `yaml:"rename"`
+	Drop   []string          `yaml:"drop"`
+	// Set sets columns to a constant value in every row
+	Set map[string]any `yaml:"set"`
+	// Concat sets columns to the values of other columns joined
+	Concat map[string]concatMapping `yaml:"concat"`
+}
+
+// concatMapping joins the values of columns with a separator, null values are left out.
+type concatMapping struct {
+	Columns   []string `yaml:"columns"`
+	Separator string   `yaml:"separator"`
+}
+
+// mappingRules is the mapping file, the mappings by table.
+type mappingRules struct {
+	Tables map[string]*tableMapping `yaml:"tables"`
+}
+
+// readMappingRules loads the mapping file, it returns nil if path is empty.
+func readMappingRules(path string) (*mappingRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules mappingRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for table, m := range rules.Tables {
+		if m == nil {
+			continue
+		}
+		for col, val := range m.Set {
+			if m.Set[col], err = dataValue(val); err != nil {
+				return nil, fmt.Errorf("%s: %s: set %s: %w", path, table, col, err)
+			}
+		}
+		for col, c := range m.Concat {
+			if len(c.Columns) == 0 {
+				return nil, fmt.Errorf("%s: %s: concat %s: no columns", path, table, col)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// forTable returns the mapping of the table, given by schema.table or table name, nil if none.
+func (m *mappingRules) forTable(table tableRef) *tableMapping {
+	if m == nil {
+		return nil
+	}
+	mapping, _ := tableEntry(m.Tables, table)
+	return mapping
+}
+
+// apply maps the columns of the records in place: renames, then drops, concatenations of the
+// columns renamed and constants.
+func (m *tableMapping) apply(records []dataRecord, ext Format) {
+	if m == nil {
+		return
+	}
+	for _, record := range records {
+		renameColumns([]dataRecord{record}, m.Rename)
+		for _, col := range m.Drop {
+			delete(record.values, col)
+		}
+		for col, c := range m.Concat {
+			var parts []string
+			for _, from := range c.Columns {
+				val := record.values[from]
+				if val == nil || ext == Csv && val == "NULL" {
+					continue
+				}
+				s, err := stringOf(val)
+				if err != nil {
+					s = fmt.Sprint(val)
+				}
+				parts = append(parts, s)
+			}
+			record.values[col] = nil
+			if len(parts) > 0 {
+				record.values[col] = strings.Join(parts, c.Separator)
+			}
+		}
+		maps.Copy(record.values, m.Set)
+	}
+}
+
+// tableEntry returns the entry of the table in a map by table name, the one by schema.table
+// before the one by name, case insensitive.
+func tableEntry[T any](entries map[string]T, table tableRef) (T, bool) {
+	var entry T
+	found := false
+	for name, e := range entries {
+		if strings.EqualFold(name, table.String()) {
+			return e, true
+		}
+		if !found && strings.EqualFold(name, table.name) {
+			entry, found = e, true
+		}
+	}
+	return entry, found
+}
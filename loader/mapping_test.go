@@ -0,0 +1,75 @@
+package loader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMapping(t *testing.T) {
+	table := (&tableDefinition{Schema: "crm", Name: "Customers", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "FullName", DataType: "nvarchar", MaxLength: 100, Nullable: true},
+		{Name: "Source", DataType: "varchar", MaxLength: 10},
+		{Name: "Rank", DataType: "int"},
+	}}).tableInfo()
+	rules := filepath.Join(t.TempDir(), "mapping.yaml")
+	err := os.WriteFile(rules, []byte(`tables:
+  crm.Customers:
+    rename: {customer_id: Id}
+    drop: [internal_note]
+    set: {Source: import, Rank: 1}
+    concat:
+      FullName: {columns: [first, last], separator: " "}
+  Customers:
+    set: {Source: wrong}
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := planRecords(t, table, "01_crm.Customers.json", `[
+		{"customer_id": 1, "first": "Ann", "last": "Lee", "internal_note": "vip"},
+		{"customer_id": 2, "first": "Bob", "last": null}
+	]`, "-mapping", rules, "-name-template", "{order}_{schema}.{table}.{ext}")
+	want := []map[string]any{
+		{"Id": json.Number("1"), "FullName": "Ann Lee", "Source": "import", "Rank": json.Number("1"), "first": "Ann", "last": "Lee"},
+		{"Id": json.Number("2"), "FullName": "Bob", "Source": "import", "Rank": json.Number("1"), "first": "Bob", "last": nil},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("%d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		if len(records[i].values) != len(want[i]) {
+			t.Errorf("record %d: %v, want %v", i+1, records[i].values, want[i])
+			continue
+		}
+		for col, val := range want[i] {
+			if records[i].values[col] != val {
+				t.Errorf("record %d: %s = %#v, want %#v", i+1, col, records[i].values[col], val)
+			}
+		}
+	}
+}
+
+func TestReadMappingRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{name: "concat without columns", yaml: "tables:\n  Orders:\n    concat: {Label: {separator: '-'}}\n", wantErr: "concat Label: no columns"},
+		{name: "set of a list", yaml: "tables:\n  Orders:\n    set: {Tags: [a, b]}\n", wantErr: "set Tags"},
+		{name: "invalid", yaml: "tables: [", wantErr: "mapping.yaml"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "mapping.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readMappingRules(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
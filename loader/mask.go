@@ -0,0 +1,228 @@
+package loader
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Masking strategies of a column.
+const (
+	// maskHash replaces the value with the hex SHA-256 of it and the salt, equal values stay equal
+	maskHash = "hash"
+	// maskEncrypt replaces the value with its AES-GCM encryption, base64 encoded
+	maskEncrypt = "encrypt"
+	// maskFake replaces the value with a made up one of its kind, the same for equal values
+	maskFake = "fake"
+	// maskPartial keeps the first and last characters and stars out the others
+	maskPartial = "partial"
+	// maskFixed replaces every value with the same one
+	maskFixed = "fixed"
+)
+
+var maskStrategies = []string{maskHash, maskEncrypt, maskFake, maskPartial, maskFixed}
+
+// maskRule is how the values of a column are masked before they are loaded.
+type maskRule struct {
+	Mask string `yaml:"mask"`
+	// As is the kind of fake value, e.g. email or city, by default the column name tells
+	As        string `yaml:"as"`
+	KeepFirst int    `yaml:"keep_first"`
+	KeepLast  int    `yaml:"keep_last"`
+	// Value is the fixed value, null if not given
+	Value any `yaml:"value"`
+	// Length cuts hashes and fake values to this many characters, 0 for no limit
+	Length int `yaml:"length"`
+	// SaltEnv and KeyEnv name the environment variables holding the salt of hash and fake and
+	// the base64 AES key of encrypt
+	SaltEnv string `yaml:"salt_env"`
+	KeyEnv  string `yaml:"key_env"`
+	// KeyVaultKey is the id of the Azure Key Vault key the key of KeyEnv is wrapped with, it is
+	// unwrapped by Key Vault
+	KeyVaultKey string `yaml:"key_vault_key"`
+
+	salt []byte
+	aead cipher.AEAD
+}
+
+// maskRules is the rules file, the rules of the columns by table.
+type maskRules struct {
+	Tables map[string]map[string]maskRule `yaml:"tables"`
+}
+
+// columnMasks are the rules of the columns of a table, by lower cased column name.
+type columnMasks map[string]maskRule
+
+// readMaskRules loads the rules file, it returns nil if path is empty.
+func readMaskRules(path string) (*maskRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules maskRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for table, columns := range rules.Tables {
+		for column, rule := range columns {
+			if err := rule.check(); err != nil {
+				return nil, fmt.Errorf("%s: %s.%s: %w", path, table, column, err)
+			}
+			if err := rule.loadSecrets(); err != nil {
+				return nil, fmt.Errorf("%s: %s.%s: %w", path, table, column, err)
+			}
+			columns[column] = rule
+		}
+	}
+	return &rules, nil
+}
+
+func (r maskRule) check() error {
+	switch {
+	case !slices.Contains(maskStrategies, r.Mask):
+		return fmt.Errorf("unknown mask %q, want one of %s", r.Mask, strings.Join(maskStrategies, ", "))
+	case r.KeepFirst < 0 || r.KeepLast < 0:
+		return fmt.Errorf("negative keep_first or keep_last")
+	case r.Length < 0:
+		return fmt.Errorf("negative length %d", r.Length)
+	case (r.Mask == maskHash || r.Mask == maskFake) && r.SaltEnv == "":
+		return fmt.Errorf("%s needs the salt_env holding the salt", r.Mask)
+	case r.Mask == maskEncrypt && r.KeyEnv == "":
+		return fmt.Errorf("encrypt needs the key_env holding the key")
+	case r.KeyVaultKey != "" && r.Mask != maskEncrypt:
+		return fmt.Errorf("key_vault_key is for encrypt")
+	case r.KeyVaultKey != "":
+		_, err := parseKeyVaultKey(r.KeyVaultKey)
+		return err
+	}
+	return nil
+}
+
+// loadSecrets reads the salt and key of the rule from the environment, they are never in the file.
+// A key wrapped with a Key Vault key is unwrapped by Key Vault.
+func (r *maskRule) loadSecrets() error {
+	if r.SaltEnv != "" {
+		salt, ok := os.LookupEnv(r.SaltEnv)
+		if !ok || salt == "" {
+			return fmt.Errorf("salt environment variable %s not set", r.SaltEnv)
+		}
+		r.salt = []byte(salt)
+	}
+	if r.Mask != maskEncrypt {
+		return nil
+	}
+	encoded, ok := os.LookupEnv(r.KeyEnv)
+	if !ok || encoded == "" {
+		return fmt.Errorf("key environment variable %s not set", r.KeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("key in %s is not base64: %w", r.KeyEnv, err)
+	}
+	if r.KeyVaultKey != "" {
+		vaultKey, err := parseKeyVaultKey(r.KeyVaultKey)
+		if err != nil {
+			return err
+		}
+		if key, err = vaultKey.unwrap(key); err != nil {
+			return fmt.Errorf("key in %s: %w", r.KeyEnv, err)
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("key in %s: %w, want 16, 24 or 32 bytes", r.KeyEnv, err)
+	}
+	r.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// forTable returns the rules of the table, given by schema.table or table name.
+func (m *maskRules) forTable(table tableRef) columnMasks {
+	if m == nil {
+		return nil
+	}
+	columns, ok := tableEntry(m.Tables, table)
+	if !ok {
+		return nil
+	}
+	masks := make(columnMasks, len(columns))
+	for name, rule := range columns {
+		masks[strings.ToLower(name)] = rule
+	}
+	return masks
+}
+
+// apply masks the values of the records in place, nulls stay null.
+func (m columnMasks) apply(records []dataRecord, ext Format) {
+	if len(m) == 0 {
+		return
+	}
+	for _, record := range records {
+		for name, val := range record.values {
+			rule, ok := m[strings.ToLower(name)]
+			if !ok || val == nil || ext == Csv && val == "NULL" {
+				continue
+			}
+			record.values[name] = rule.mask(name, val)
+		}
+	}
+}
+
+// mask returns the masked value of the column.
+func (r maskRule) mask(column string, val any) any {
+	if r.Mask == maskFixed {
+		return r.Value
+	}
+	s, err := stringOf(val)
+	if err != nil {
+		s = fmt.Sprint(val)
+	}
+	switch r.Mask {
+	case maskHash:
+		sum := sha256.Sum256(append(slices.Clip(r.salt), s...))
+		return cut(hex.EncodeToString(sum[:]), r.Length)
+	case maskEncrypt:
+		// the nonce goes first, as decrypting needs it
+		nonce := make([]byte, r.aead.NonceSize())
+		rand.Read(nonce)
+		return base64.StdEncoding.EncodeToString(r.aead.Seal(nonce, nonce, []byte(s), nil))
+	case maskFake:
+		// seeded by the value, equal values get the same fake one across files and runs
+		sum := sha256.Sum256(append(slices.Clip(r.salt), s...))
+		seed := binary.BigEndian.Uint64(sum[:8])
+		g := &tableGenerator{rng: mathrand.New(mathrand.NewPCG(seed, seed))}
+		kind := r.As
+		if kind == "" {
+			kind = column
+		}
+		return g.text(columnDefinition{Name: kind, MaxLength: r.Length}, strings.ToLower(kind))
+	}
+	runes := []rune(s)
+	if r.KeepFirst+r.KeepLast >= len(runes) {
+		// nothing would be hidden
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:r.KeepFirst]) + strings.Repeat("*", len(runes)-r.KeepFirst-r.KeepLast) + string(runes[len(runes)-r.KeepLast:])
+}
+
+// cut returns the first n characters of s, all of them if n is 0.
+func cut(s string, n int) string {
+	if runes := []rune(s); n > 0 && len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
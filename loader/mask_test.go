@@ -0,0 +1,159 @@
+package loader
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestMaskRuleCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    maskRule
+		wantErr string
+	}{
+		{name: "hash", rule: maskRule{Mask: maskHash, Length: 12, SaltEnv: "PSEUDO_SALT"}},
+		{name: "hash without salt", rule: maskRule{Mask: maskHash}, wantErr: "hash needs the salt_env"},
+		{name: "fake without salt", rule: maskRule{Mask: maskFake, As: "city"}, wantErr: "fake needs the salt_env"},
+		{name: "partial", rule: maskRule{Mask: maskPartial, KeepFirst: 1, KeepLast: 2}},
+		{name: "unknown", rule: maskRule{Mask: "scramble"}, wantErr: "unknown mask"},
+		{name: "negative keep", rule: maskRule{Mask: maskPartial, KeepLast: -1}, wantErr: "negative keep_first or keep_last"},
+		{name: "negative length", rule: maskRule{Mask: maskHash, Length: -1, SaltEnv: "PSEUDO_SALT"}, wantErr: "negative length"},
+		{name: "encrypt without key", rule: maskRule{Mask: maskEncrypt}, wantErr: "needs the key_env"},
+	}
+	for _, tt := range tests {
+		err := tt.rule.check()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   maskRule
+		column string
+		value  any
+		want   any
+	}{
+		{name: "hash", rule: maskRule{Mask: maskHash}, value: "ada@example.com",
+			want: "b5fc85e55755f9e0d030a10ab4429b6b2944855f9a0d60077fe832becbc41d72"},
+		{name: "hash salted", rule: maskRule{Mask: maskHash, salt: []byte("pepper")}, value: "ada@example.com",
+			want: "f3de2501fc91c4a9ae4d4ed50bef931c9901aabe5ea36979890a69204e7eb2b1"},
+		{name: "hash number cut", rule: maskRule{Mask: maskHash, Length: 8}, value: 42, want: "73475cb4"},
+		{name: "partial", rule: maskRule{Mask: maskPartial, KeepFirst: 2, KeepLast: 2}, value: "4111222233334444", want: "41************44"},
+		{name: "partial runes", rule: maskRule{Mask: maskPartial, KeepFirst: 1}, value: "Jürgen", want: "J*****"},
+		{name: "partial hides short values", rule: maskRule{Mask: maskPartial, KeepFirst: 2, KeepLast: 2}, value: "abc", want: "***"},
+		{name: "fixed", rule: maskRule{Mask: maskFixed, Value: "redacted"}, value: "secret", want: "redacted"},
+		{name: "fixed null", rule: maskRule{Mask: maskFixed}, value: "secret", want: nil},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.mask(tt.column, tt.value); got != tt.want {
+			t.Errorf("%s: mask(%v) = %v, want %v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMaskFake(t *testing.T) {
+	rule := maskRule{Mask: maskFake}
+	email, _ := rule.mask("Email", "ada@example.com").(string)
+	if !strings.Contains(email, "@") || email == "ada@example.com" {
+		t.Errorf("fake email %q", email)
+	}
+	if again := rule.mask("Email", "ada@example.com"); again != email {
+		t.Errorf("fake email %q, then %q for the same value", email, again)
+	}
+	if salted := (maskRule{Mask: maskFake, salt: []byte("pepper")}).mask("Email", "ada@example.com"); salted == email {
+		t.Errorf("fake email %q the same with a salt", salted)
+	}
+	city, _ := (maskRule{Mask: maskFake, As: "city", Length: 4}).mask("Location", "Berlin").(string)
+	if len([]rune(city)) > 4 || city == "" {
+		t.Errorf("fake city %q, want up to 4 characters", city)
+	}
+}
+
+func TestMaskEncrypt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	t.Setenv("TEST_MASK_KEY", base64.StdEncoding.EncodeToString(key))
+	rule := maskRule{Mask: maskEncrypt, KeyEnv: "TEST_MASK_KEY"}
+	if err := rule.loadSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := rule.mask("Iban", "DE89370400440532013000").(string)
+	second, _ := rule.mask("Iban", "DE89370400440532013000").(string)
+	if first == second {
+		t.Error("encrypted values equal, want a new nonce each time")
+	}
+	data, err := base64.StdEncoding.DecodeString(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil || string(plain) != "DE89370400440532013000" {
+		t.Errorf("decrypted %q, %v", plain, err)
+	}
+
+	for _, tt := range []struct {
+		name, key, wantErr string
+	}{
+		{name: "unset", wantErr: "not set"},
+		{name: "not base64", key: "not base64!", wantErr: "is not base64"},
+		{name: "short", key: base64.StdEncoding.EncodeToString([]byte("short")), wantErr: "want 16, 24 or 32 bytes"},
+	} {
+		t.Setenv("TEST_MASK_KEY", tt.key)
+		rule := maskRule{Mask: maskEncrypt, KeyEnv: "TEST_MASK_KEY"}
+		if err := rule.loadSecrets(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMaskApply(t *testing.T) {
+	masks := columnMasks{"name": {Mask: maskFixed, Value: "x"}}
+	records := []dataRecord{
+		{values: map[string]any{"Name": "Ada", "City": "Berlin"}},
+		{values: map[string]any{"Name": nil}},
+		{values: map[string]any{"Name": "NULL"}},
+	}
+	masks.apply(records, Csv)
+	if records[0].values["Name"] != "x" || records[0].values["City"] != "Berlin" {
+		t.Errorf("row 1 %v", records[0].values)
+	}
+	if records[1].values["Name"] != nil || records[2].values["Name"] != "NULL" {
+		t.Errorf("nulls masked: %v, %v", records[1].values, records[2].values)
+	}
+}
+
+func TestParseKeyVaultKey(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    keyVaultKey
+		wantErr bool
+	}{
+		{id: "https://kv.vault.azure.net/keys/mask", want: keyVaultKey{vault: "https://kv.vault.azure.net", name: "mask"}},
+		{id: "https://kv.vault.azure.net/keys/mask/0f1e2d", want: keyVaultKey{vault: "https://kv.vault.azure.net", name: "mask", version: "0f1e2d"}},
+		{id: "https://kv.vault.azure.net/secrets/mask", wantErr: true},
+		{id: "http://kv.vault.azure.net/keys/mask", wantErr: true},
+		{id: "https://kv.vault.azure.net/keys/", wantErr: true},
+		{id: "kv/keys/mask", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseKeyVaultKey(tt.id)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseKeyVaultKey(%q) = %+v, %v, want %+v", tt.id, got, err, tt.want)
+		}
+	}
+	for _, rule := range []maskRule{
+		{Mask: maskHash, KeyVaultKey: "https://kv.vault.azure.net/keys/mask"},
+		{Mask: maskEncrypt, KeyEnv: "DIAG_KEY", KeyVaultKey: "https://kv.vault.azure.net/mask"},
+	} {
+		if err := rule.check(); err == nil {
+			t.Errorf("rule %+v checked, want an error", rule)
+		}
+	}
+}
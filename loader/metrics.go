@@ -0,0 +1,218 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricBuckets are the upper bounds in seconds of the duration histograms, from the commit of
+// a batch to a whole run.
+var metricBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}
+
+// metricFamilies are the metrics of the tool, in the order they are written.
+var metricFamilies = []struct{ name, kind, help string }{
+	{"uptomssql_runs_total", "counter", "Runs finished, by status."},
+	{"uptomssql_run_duration_seconds", "histogram", "Duration of the runs."},
+	{"uptomssql_files_total", "counter", "Data files handled, by table and status."},
+	{"uptomssql_file_duration_seconds", "histogram", "Duration of the loads of data files, by table."},
+	{"uptomssql_rows_total", "counter", "Rows of the data files, by table and outcome: loaded (valid in validate runs), rejected, invalid or empty."},
+	{"uptomssql_batch_duration_seconds", "histogram", "Duration of the transactions of rows, from their first statement to the commit, by table."},
+	{"uptomssql_queue_depth", "gauge", "Requests, files or runs waiting or going on."},
+}
+
+// metricPoint is the value of a metric for a set of labels, a histogram counting observations by
+// bucket of metricBuckets, the last count being above them all.
+type metricPoint struct {
+	// labels are the label name and value pairs
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+}
+
+// metricSet holds the metrics of a process, written in the Prometheus text format on /metrics,
+// pushed to a Pushgateway or exported over OTLP at the end of a run. Its methods do nothing on a
+// nil set.
+type metricSet struct {
+	mu      sync.Mutex
+	started time.Time
+	// points are the metric values by name and labels
+	points map[string]map[string]*metricPoint
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{started: time.Now(), points: make(map[string]map[string]*metricPoint)}
+}
+
+// metricLabels returns the labels of the name and value pairs, as written after the metric name.
+func metricLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var b strings.Builder
+	b.WriteString("{")
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], escape.Replace(pairs[i+1]))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// point returns the point of the metric for the labels, the set being locked.
+func (m *metricSet) point(name string, labels []string) *metricPoint {
+	if m.points[name] == nil {
+		m.points[name] = make(map[string]*metricPoint)
+	}
+	key := metricLabels(labels...)
+	p := m.points[name][key]
+	if p == nil {
+		p = &metricPoint{labels: labels}
+		m.points[name][key] = p
+	}
+	return p
+}
+
+// add adds v to a counter.
+func (m *metricSet) add(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.point(name, labels).value += v
+}
+
+// set sets a gauge.
+func (m *metricSet) set(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.point(name, labels).value = v
+}
+
+// observe adds a duration to a histogram.
+func (m *metricSet) observe(name string, d time.Duration, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.point(name, labels)
+	if p.counts == nil {
+		p.counts = make([]uint64, len(metricBuckets)+1)
+	}
+	seconds := d.Seconds()
+	i, _ := slices.BinarySearch(metricBuckets, seconds)
+	p.counts[i]++
+	p.sum += seconds
+}
+
+// runDone counts a run finished with the status after the duration.
+func (m *metricSet) runDone(status string, d time.Duration) {
+	m.add("uptomssql_runs_total", 1, "status", status)
+	m.observe("uptomssql_run_duration_seconds", d)
+}
+
+// addFiles counts the files of a run result and their rows.
+func (m *metricSet) addFiles(result *RunResult) {
+	done := "loaded"
+	if result.Command == "validate" {
+		done = "valid"
+	}
+	for _, f := range result.Files {
+		m.add("uptomssql_files_total", 1, "table", f.Table, "status", f.Status)
+		if f.Status == fileSkipped {
+			continue
+		}
+		m.observe("uptomssql_file_duration_seconds", time.Duration(f.DurationMs)*time.Millisecond, "table", f.Table)
+		ok := f.Rows - f.RejectedRows - f.InvalidRows - f.EmptyRows
+		for _, rows := range []struct {
+			outcome string
+			n       int
+		}{{done, ok}, {"rejected", f.RejectedRows}, {"invalid", f.InvalidRows}, {"empty", f.EmptyRows}} {
+			if rows.n > 0 {
+				m.add("uptomssql_rows_total", float64(rows.n), "table", f.Table, "outcome", rows.outcome)
+			}
+		}
+	}
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metricSet) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b bytes.Buffer
+	for _, family := range metricFamilies {
+		points := m.points[family.name]
+		if len(points) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, labels := range slices.Sorted(maps.Keys(points)) {
+			p := points[labels]
+			if family.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", family.name, labels, formatMetric(p.value))
+				continue
+			}
+			var count uint64
+			for i, bound := range metricBuckets {
+				count += p.counts[i]
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", family.name, metricLabels(append(p.labels, "le", formatMetric(bound))...), count)
+			}
+			count += p.counts[len(metricBuckets)]
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", family.name, metricLabels(append(p.labels, "le", "+Inf")...), count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", family.name, labels, formatMetric(p.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", family.name, labels, count)
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// serveHTTP answers GET /metrics.
+func (m *metricSet) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// push replaces the metrics of the group of the Pushgateway url, like
+// http://pushgateway:9091/metrics/job/seed, with the metrics of the set.
+func (m *metricSet) push(url string) error {
+	var body bytes.Buffer
+	if err := m.write(&body); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package loader
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrationName matches migration files, V001__Customers.json and its down spec V001__Customers.down.json.
+var migrationName = regexp.MustCompile(`^V(\d+)__(.+?)(\.down)?\.(\w+)$`)
+
+// migrationNameTemplate resolves the table of a migration file as data files are.
+const migrationNameTemplate = "V{order}__{table}.{ext}"
+
+// migrateOptions are the flags of the migrate command.
+type migrateOptions struct {
+	sourceOptions
+	conn connOptions
+	log  logOptions
+	dir  string
+	// to is the version to migrate to, -1 for the latest
+	to          int
+	dryRun      bool
+	yes         bool
+	batchSize   int
+	lockTimeout time.Duration
+}
+
+func (o *migrateOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	o.sourceOptions.addFormatFlags(fs)
+	fs.StringVar(&o.dir, "d", "migrations", "dir of the migration files, V<version>__<table>.<ext> and their V<version>__<table>.down.<ext> delete specs")
+	fs.IntVar(&o.to, "to", -1, "version to migrate up or down to, -1 for the latest, 0 reverts all")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print the files that would be loaded or reverted, without writing")
+	fs.BoolVar(&o.yes, "yes", false, "do not ask to confirm deleting table rows on a server other than localhost")
+	fs.IntVar(&o.batchSize, "batch-size", 0, "rows per transaction, 0 commits every row on its own")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 0, "how long to wait for another run loading the same database to finish, 0 fails right away")
+}
+
+func runMigrate(cmd *command, args []string) {
+	var opts migrateOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.file.check()
+	if err == nil {
+		err = opts.log.check()
+	}
+	if err == nil && opts.to < -1 {
+		err = fmt.Errorf("invalid -to %d", opts.to)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	opts.nameTmpl = migrationNameTemplate
+	opts.file.Mode = InsertMode
+	migrate(&opts)
+}
+
+// migrationFile is a data file of a migration with its down spec, if any.
+type migrationFile struct {
+	up   *filePlan
+	down *filePlan
+}
+
+// migration is the files of a version, in load order.
+type migration struct {
+	version int
+	files   []*migrationFile
+}
+
+// loadMigrations reads the migration files of the dir, in version order.
+func loadMigrations(opts *migrateOptions) ([]*migration, error) {
+	source, err := newFileSource(&opts.sourceOptions, logger())
+	if err != nil {
+		return nil, err
+	}
+	files, err := dirFiles(longPath(opts.dir), walkOptions{followLinks: true}, logger())
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(files, func(a, b dataFile) int {
+		return naturalCompare(filepath.Base(a.path), filepath.Base(b.path))
+	})
+	versions := make(map[int]*migration)
+	downs := make(map[string]dataFile)
+	for _, file := range files {
+		name := filepath.Base(file.path)
+		m := migrationName.FindStringSubmatch(name)
+		if m == nil {
+			logger().Warn("skip file, not named as a migration", "file", name)
+			continue
+		}
+		if m[3] != "" {
+			downs[strings.ToLower(m[1]+"__"+m[2])] = file
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: version %s: %w", name, m[1], err)
+		}
+		if versions[version] == nil {
+			versions[version] = &migration{version: version}
+		}
+		up, err := source.planFile(file)
+		if err != nil {
+			return nil, err
+		}
+		versions[version].files = append(versions[version].files, &migrationFile{up: up})
+	}
+	var migrations []*migration
+	for _, mig := range versions {
+		for _, file := range mig.files {
+			m := migrationName.FindStringSubmatch(file.up.name)
+			key := strings.ToLower(m[1] + "__" + m[2])
+			if down, ok := downs[key]; ok {
+				down.table = file.up.table
+				if file.down, err = source.planFile(down); err != nil {
+					return nil, err
+				}
+				delete(downs, key)
+			}
+		}
+		migrations = append(migrations, mig)
+	}
+	for _, file := range files {
+		m := migrationName.FindStringSubmatch(filepath.Base(file.path))
+		if m == nil || m[3] == "" {
+			continue
+		}
+		if _, left := downs[strings.ToLower(m[1]+"__"+m[2])]; left {
+			return nil, fmt.Errorf("down spec %s has no migration file", filepath.Base(file.path))
+		}
+	}
+	slices.SortFunc(migrations, func(a, b *migration) int {
+		return cmp.Compare(a.version, b.version)
+	})
+	return migrations, nil
+}
+
+// migrate loads the files of the versions up to -to not loaded yet, or reverts the files loaded
+// of the versions past it, newest first, recording them in the runs table.
+func migrate(opts *migrateOptions) {
+	migrations, err := loadMigrations(opts)
+	handleError(err, ReadDirErrorCode)
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	if !opts.dryRun {
+		lock, err := acquireRunLock(context.Background(), db, opts.lockTimeout)
+		handleError(err, dbErrorCode(err, LockedCode))
+		defer lock.release()
+	}
+	runs, err := openRunLog(db, !opts.dryRun)
+	handleError(err, dbErrorCode(err, TableInfoErrorCode))
+
+	to := opts.to
+	if to == -1 && len(migrations) > 0 {
+		to = migrations[len(migrations)-1].version
+	}
+	var up, down []*migrationFile
+	current := 0
+	for _, mig := range migrations {
+		for _, file := range mig.files {
+			file.up.sum, err = fileSHA256(file.up.path)
+			handleError(err, ReadFileErrorCode)
+			e, ok := runs.entry(file.up)
+			applied := ok && !e.down
+			if applied {
+				current = mig.version
+				if e.sum != file.up.sum {
+					logger().Warn("migration file changed since it was loaded", "file", file.up.name, "table", file.up.table.String())
+				}
+			}
+			switch {
+			case mig.version <= to && !applied:
+				up = append(up, file)
+			case mig.version > to && applied:
+				down = append(down, file)
+			}
+		}
+	}
+	slices.Reverse(down)
+	logger().Info("migrate", "dir", opts.dir, "version", current, "to", to, "load", len(up), "revert", len(down))
+
+	if opts.dryRun {
+		for _, file := range down {
+			fmt.Printf("would revert %s => %s\n", file.up.name, file.up.table)
+		}
+		for _, file := range up {
+			fmt.Printf("would load %s => %s\n", file.up.name, file.up.table)
+		}
+		return
+	}
+	if !opts.yes {
+		var tables []string
+		for _, file := range down {
+			tables = append(tables, file.up.table.String()+": rows of "+file.up.name)
+		}
+		plans := make([]*filePlan, len(up))
+		for i, file := range up {
+			plans[i] = file.up
+		}
+		handleError(confirmDeletes(opts.conn.dataSource, opts.conn.initialCatalog, append(tables, destructiveTables(plans, false)...)), NotConfirmedCode)
+	}
+	for _, file := range down {
+		revertMigration(db, runs, file)
+	}
+	if len(up) > 0 {
+		upOpts := &uploadOptions{sourceOptions: opts.sourceOptions, conn: opts.conn, log: opts.log, batchSize: opts.batchSize, track: true, force: true}
+		u := &uploader{ctx: context.Background(), db: db, opts: upOpts, summary: newRunSummary(), result: newRunResult("migrate"), out: os.Stdout, runs: runs,
+			tables: newTableCache(db, logger()), log: logger()}
+		for _, file := range up {
+			u.plans = append(u.plans, file.up)
+		}
+		handleError(u.uploadFiles(u.plans), InsertDataErrorCode)
+		u.summary.print(u.out)
+	}
+	logger().Info("migrate done", "version", to)
+}
+
+// revertMigration deletes the rows of the down spec of the file, or else the rows of the file by
+// primary key, and records the revert in the same transaction.
+func revertMigration(db *sqlx.DB, runs *runLog, file *migrationFile) {
+	table, err := getTableInfo(db, file.up.table)
+	handleError(err, dbErrorCode(err, TableInfoErrorCode))
+	if len(table.columns) == 0 {
+		handleError(fmt.Errorf("table %s not found", file.up.table), TableInfoErrorCode)
+	}
+	spec, keys := file.down, []string(nil)
+	if spec == nil {
+		if len(table.primaryKey) == 0 {
+			handleError(fmt.Errorf("%s has no down spec and table %s no primary key to delete its rows by", file.up.name, table.ref), TableInfoErrorCode)
+		}
+		spec, keys = file.up, table.primaryKey
+	}
+	records, err := readRecords(spec.path, spec.ext, spec.opts, logger())
+	handleError(err, ReadFileErrorCode)
+	renameColumns(records, spec.opts.Columns)
+
+	tx, err := db.Beginx()
+	handleError(err, dbErrorCode(err, InsertDataErrorCode))
+	defer tx.Rollback()
+	deleted := 0
+	for i, record := range records {
+		query, values, err := deleteQuery(table, record, keys, spec)
+		if err != nil {
+			handleError(fmt.Errorf("%s row %d (line %d): %w", spec.name, i+1, record.line, err), ConversionErrorCode)
+		}
+		logger().Debug("query", "table", table.ref.String(), "row", i+1, "sql", query)
+		res, err := tx.Exec(query, values...)
+		handleError(err, dbErrorCode(err, InsertDataErrorCode))
+		n, _ := res.RowsAffected()
+		deleted += int(n)
+	}
+	stmt := runs.record(file.up, deleted, runDown)
+	_, err = tx.Exec(stmt.query, stmt.values...)
+	handleError(err, dbErrorCode(err, InsertDataErrorCode))
+	handleError(tx.Commit(), InsertDataErrorCode)
+	logger().Info("file reverted", "file", file.up.name, "table", table.ref.String(), "spec", spec.name, "rows", deleted)
+}
+
+// deleteQuery makes the DELETE of the rows matching the values of the record for the columns,
+// all columns of the record when none are given.
+func deleteQuery(table *tableInfo, record dataRecord, columns []string, plan *filePlan) (string, []any, error) {
+	if columns == nil {
+		for _, name := range table.columns {
+			if _, ok := record.values[name]; ok {
+				columns = append(columns, name)
+			}
+		}
+		if len(columns) < len(record.values) {
+			for name := range record.values {
+				if _, ok := table.schema[name]; !ok {
+					return "", nil, fmt.Errorf("column %s not in table %s", name, table.ref)
+				}
+			}
+		}
+		if len(columns) == 0 {
+			return "", nil, errors.New("no column to match the rows to delete")
+		}
+	}
+	var conditions []string
+	var values []any
+	for _, name := range columns {
+		col := table.schema[name]
+		val, ok := record.values[name]
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s", errRequiredMissing, name)
+		}
+		if val == nil || plan.ext == Csv && val == "NULL" {
+			conditions = append(conditions, quoteName(name)+" IS NULL")
+			continue
+		}
+		v, err := convertValue(col, val, plan.conv)
+		if err != nil {
+			return "", nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		values = append(values, v)
+		conditions = append(conditions, fmt.Sprintf("%s = %s", quoteName(name), placeholder(col, fmt.Sprintf("@p%d", len(values)), plan.conv)))
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s;", table.ref.quoted(), strings.Join(conditions, " AND ")), values, nil
+}
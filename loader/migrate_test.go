@@ -0,0 +1,97 @@
+package loader
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// migrationsIn returns the options of migrate reading the migrations of dir.
+func migrationsIn(dir string) *migrateOptions {
+	opts := &migrateOptions{dir: dir, to: -1}
+	opts.nameTmpl = migrationNameTemplate
+	opts.file.Mode = InsertMode
+	return opts
+}
+
+func TestLoadMigrations(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"V10__Lines.json":           "[]",
+		"V002__Orders.json":         "[]",
+		"V001__Customers.json":      "[]",
+		"V001__Customers.down.json": `[{"Id": 1}]`,
+		"V002__Regions.json":        "[]",
+		"README.md":                 "migrations",
+	})
+	migrations, err := loadMigrations(migrationsIn(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, mig := range migrations {
+		for _, file := range mig.files {
+			entry := file.up.table.name
+			if file.down != nil {
+				entry += " down " + file.down.name + " of " + file.down.table.name
+			}
+			got = append(got, entry)
+		}
+	}
+	// versions in number order, the files of a version in name order
+	want := []string{"Customers down V001__Customers.down.json of Customers", "Orders", "Regions", "Lines"}
+	if !slices.Equal(got, want) {
+		t.Errorf("migrations %q, want %q", got, want)
+	}
+	if versions := []int{migrations[0].version, migrations[1].version, migrations[2].version}; !slices.Equal(versions, []int{1, 2, 10}) {
+		t.Errorf("versions %v", versions)
+	}
+
+	writeFiles(t, dir, map[string]string{"V003__Stock.down.json": "[]"})
+	if _, err := loadMigrations(migrationsIn(dir)); err == nil || !strings.Contains(err.Error(), "V003__Stock.down.json has no migration file") {
+		t.Errorf("error %v, want the down spec without its file", err)
+	}
+}
+
+func TestDeleteQuery(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50, Nullable: true},
+	}}).tableInfo()
+	plan := &filePlan{name: "V001__Orders.down.json", ext: Json}
+	tests := []struct {
+		name       string
+		values     map[string]any
+		columns    []string
+		want       string
+		wantValues []any
+		wantErr    string
+	}{
+		{name: "all columns", values: map[string]any{"Id": "1", "Customer": "Ann"},
+			want: "DELETE FROM [dbo].[Orders] WHERE [Id] = @p1 AND [Customer] = @p2;", wantValues: []any{int64(1), "Ann"}},
+		{name: "null", values: map[string]any{"Customer": nil},
+			want: "DELETE FROM [dbo].[Orders] WHERE [Customer] IS NULL;"},
+		{name: "columns given", values: map[string]any{"Id": "2", "Customer": "Bob"}, columns: []string{"Id"},
+			want: "DELETE FROM [dbo].[Orders] WHERE [Id] = @p1;", wantValues: []any{int64(2)}},
+		{name: "column missing", values: map[string]any{"Customer": "Bob"}, columns: []string{"Id"}, wantErr: "Id"},
+		{name: "unknown column", values: map[string]any{"Id": "1", "Region": "EU"}, wantErr: "column Region not in table"},
+		{name: "nothing to match", values: map[string]any{}, wantErr: "no column"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, values, err := deleteQuery(table, dataRecord{values: tt.values}, tt.columns, plan)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.want || !slices.Equal(values, tt.wantValues) {
+				t.Errorf("got %s %v, want %s %v", query, values, tt.want, tt.wantValues)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"strings"
+	"unicode"
+)
+
+// moneyLocales are the decimal separator and grouping characters of the -money-locale values.
+var moneyLocales = map[string]struct {
+	decimal  rune
+	grouping string
+}{
+	"en": {'.', ","},
+	"de": {',', "."},
+	"fr": {',', ""},
+	"ch": {'.', "'"},
+}
+
+// normalizeMoney returns a money value like €1.234,50, (1,234.50) or 1 234,50 EUR as a plain
+// number: currency symbols and codes, spaces and grouping characters stripped, the decimal
+// separator a dot and accounting parentheses a minus sign. Other characters are kept, for the
+// value to fail to convert.
+func normalizeMoney(s, locale, grouping string) string {
+	decimal := '.'
+	if l, ok := moneyLocales[locale]; ok {
+		decimal = l.decimal
+		grouping += l.grouping
+	}
+	// currency codes go before or after the number, letters inside are exponents
+	trim := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.Is(unicode.Sc, r) }
+	s = strings.TrimFunc(s, trim)
+	negative := false
+	if len(s) > 1 && s[0] == '(' && s[len(s)-1] == ')' {
+		negative = true
+		s = strings.TrimFunc(s[1:len(s)-1], trim)
+	}
+	var b strings.Builder
+	if negative {
+		b.WriteByte('-')
+	}
+	for _, r := range s {
+		switch {
+		case r == decimal:
+			b.WriteByte('.')
+		case strings.ContainsRune(grouping, r), unicode.IsSpace(r), unicode.Is(unicode.Sc, r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,31 @@
+package loader
+
+import "testing"
+
+func TestNormalizeMoney(t *testing.T) {
+	tests := []struct {
+		value, locale, grouping string
+		want                    string
+	}{
+		{"€1.234,50", "de", "", "1234.50"},
+		{"1.234,50 EUR", "de", "", "1234.50"},
+		{"(1.234,50)", "de", "", "-1234.50"},
+		{"$1,234.50", "en", "", "1234.50"},
+		{"(1,234.50)", "en", "", "-1234.50"},
+		{"( $1,234.50 )", "en", "", "-1234.50"},
+		{"1 234,50 €", "fr", "", "1234.50"},
+		{"1 234,50", "fr", "", "1234.50"},
+		{"CHF 1'234.50", "ch", "", "1234.50"},
+		{"1_000.5", "", "_", "1000.5"},
+		{"1 000.5", "", "", "1000.5"},
+		{"1.5E3", "en", "", "1.5E3"},
+		{"-12.30", "en", "", "-12.30"},
+		{"12..3", "en", "", "12..3"},
+		{"1,234.50", "", "", "1,234.50"},
+	}
+	for _, tt := range tests {
+		if got := normalizeMoney(tt.value, tt.locale, tt.grouping); got != tt.want {
+			t.Errorf("normalizeMoney(%q, %q, %q) = %q, want %q", tt.value, tt.locale, tt.grouping, got, tt.want)
+		}
+	}
+}
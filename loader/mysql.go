@@ -0,0 +1,204 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// mySqlTypes are the SQL Server types the values of MySQL column types convert as, the integer
+// types aside.
+var mySqlTypes = map[string]string{
+	"decimal":    "decimal",
+	"float":      "real",
+	"double":     "float",
+	"bit":        "bit",
+	"date":       "date",
+	"datetime":   "datetime2",
+	"timestamp":  "datetime2",
+	"time":       "time",
+	"year":       "smallint",
+	"char":       "nchar",
+	"varchar":    "nvarchar",
+	"tinytext":   "nvarchar",
+	"text":       "nvarchar",
+	"mediumtext": "nvarchar",
+	"longtext":   "nvarchar",
+	"enum":       "nvarchar",
+	"set":        "nvarchar",
+	"json":       "nvarchar",
+	"binary":     "binary",
+	"varbinary":  "varbinary",
+	"tinyblob":   "varbinary",
+	"blob":       "varbinary",
+	"mediumblob": "varbinary",
+	"longblob":   "varbinary",
+}
+
+// mySqlDeadlocks are the error numbers of a deadlock and a lock wait timeout, the server rolls
+// the transaction back on them.
+var mySqlDeadlocks = []uint16{1205, 1213}
+
+// mySqlColumn is a column with the full type and the extra attributes MySQL tells of it.
+type mySqlColumn struct {
+	ColumnSchema
+	ColumnType string `db:"COLUMN_TYPE"`
+	Extra      string `db:"EXTRA"`
+}
+
+// mySqlType returns the SQL Server type of a MySQL column, integers of the type holding their
+// range and tinyint(1) as the bit of the booleans.
+func mySqlType(col mySqlColumn) string {
+	unsigned := strings.HasSuffix(col.ColumnType, "unsigned")
+	switch col.DataType {
+	case "tinyint":
+		switch {
+		case col.ColumnType == "tinyint(1)":
+			return "bit"
+		case unsigned:
+			return "tinyint"
+		}
+		return "smallint"
+	case "smallint":
+		if unsigned {
+			return "int"
+		}
+		return "smallint"
+	case "mediumint":
+		return "int"
+	case "int":
+		if unsigned {
+			return "bigint"
+		}
+		return "int"
+	case "bigint":
+		return "bigint"
+	}
+	if t, ok := mySqlTypes[col.DataType]; ok {
+		return t
+	}
+	return unsupportedType(mySqlDriver, col.DataType)
+}
+
+// mySql is the dialect of MySQL, the schema of a table is its database.
+type mySql struct{}
+
+func (mySql) quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (mySql) param(int) string {
+	return "?"
+}
+
+// tableInfo reads the table of the name given in any case, in the current database if no
+// schema is given.
+func (d mySql) tableInfo(db *sqlx.DB, table tableRef) (*tableInfo, error) {
+	info := &tableInfo{ref: table, schema: make(map[string]ColumnSchema)}
+	err := db.QueryRowx(`
+SELECT TABLE_SCHEMA, TABLE_NAME
+FROM INFORMATION_SCHEMA.TABLES
+WHERE LOWER(TABLE_NAME) = LOWER(?) AND TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE())
+ORDER BY TABLE_NAME = ? DESC
+LIMIT 1`, table.name, table.schema, table.name).Scan(&info.ref.schema, &info.ref.name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cols []mySqlColumn
+	err = db.Select(&cols, `
+SELECT COLUMN_NAME AS COLUMN_NAME, IS_NULLABLE AS IS_NULLABLE, COLUMN_DEFAULT AS COLUMN_DEFAULT, DATA_TYPE AS DATA_TYPE,
+  CHARACTER_MAXIMUM_LENGTH AS CHARACTER_MAXIMUM_LENGTH, NUMERIC_SCALE AS NUMERIC_SCALE, DATETIME_PRECISION AS DATETIME_PRECISION,
+  COLUMN_TYPE AS COLUMN_TYPE, EXTRA AS EXTRA
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_NAME = ? AND TABLE_SCHEMA = ?
+ORDER BY ORDINAL_POSITION`, info.ref.name, info.ref.schema)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range cols {
+		col.DataType = mySqlType(col)
+		info.schema[col.ColumnName] = col.ColumnSchema
+		info.columns = append(info.columns, col.ColumnName)
+		switch {
+		case strings.Contains(col.Extra, "auto_increment"):
+			info.identityColumns = append(info.identityColumns, col.ColumnName)
+		case col.Extra == "VIRTUAL GENERATED" || col.Extra == "STORED GENERATED":
+			info.computeColumns = append(info.computeColumns, col.ColumnName)
+		}
+	}
+	info.primaryKey, err = primaryKeyOf(db, d, info.ref)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (mySql) insertQuery(table *tableInfo, columns, placeholders []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", table.quotedName(), strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+}
+
+// upsertQuery inserts with ON DUPLICATE KEY UPDATE, a row of nothing but the primary key sets
+// the key to itself.
+func (d mySql) upsertQuery(table *tableInfo, columns, placeholders, update []string) string {
+	var set []string
+	for _, col := range update {
+		set = append(set, fmt.Sprintf("%s = VALUES(%s)", col, col))
+	}
+	if len(set) == 0 {
+		key := d.quote(table.primaryKey[0])
+		set = append(set, fmt.Sprintf("%s = %s", key, key))
+	}
+	insert := strings.TrimSuffix(d.insertQuery(table, columns, placeholders), ";")
+	return fmt.Sprintf("%s ON DUPLICATE KEY UPDATE %s;", insert, strings.Join(set, ", "))
+}
+
+func (mySql) identityInsert() bool {
+	return false
+}
+
+// syncIdentity returns none, inserts move the auto increment of the table past the values.
+func (mySql) syncIdentity(*tableInfo) []*insertStatement {
+	return nil
+}
+
+func (mySql) bind(v any) any {
+	return portableValue(v)
+}
+
+// lock takes a named lock of the database, the names are of the whole server.
+func (mySql) lock(ctx context.Context, conn *sqlx.Conn, timeout time.Duration) error {
+	var locked sql.NullInt64
+	err := conn.GetContext(ctx, &locked, "SELECT GET_LOCK(CONCAT(?, '.', DATABASE()), ?)", runLockResource, math.Ceil(timeout.Seconds()))
+	if err == nil && locked.Int64 != 1 {
+		err = errLockNotGranted
+	}
+	return err
+}
+
+func (mySql) unlock(conn *sqlx.Conn) error {
+	_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(CONCAT(?, '.', DATABASE()))", runLockResource)
+	return err
+}
+
+func (mySql) savepoint(name string) (string, string) {
+	return "SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name
+}
+
+// txAlive is true but after a deadlock or lock wait timeout, a failed statement is rolled back
+// on its own.
+func (mySql) txAlive(_ *sqlx.Tx, err error) bool {
+	var mySqlErr *mysql.MySQLError
+	return !errors.As(err, &mySqlErr) || !slices.Contains(mySqlDeadlocks, mySqlErr.Number)
+}
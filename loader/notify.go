@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds the post of a notification, a webhook down does not hold the run.
+const notifyTimeout = 10 * time.Second
+
+// notifyOptions posts the outcome of a run to a webhook when it finishes.
+type notifyOptions struct {
+	url string
+	// format is the body posted: json, the run result, or the message of slack or teams
+	format string
+}
+
+func (o *notifyOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.url, "notify-url", "", "post the outcome of the run to this webhook url when it finishes, also when it fails")
+	fs.StringVar(&o.format, "notify-format", "json", "body of the -notify-url post: json (the run result), slack or teams")
+}
+
+func (o *notifyOptions) check() error {
+	switch o.format {
+	case "json", "slack", "teams":
+		return nil
+	}
+	return fmt.Errorf("unknown notify format %q", o.format)
+}
+
+// send posts the outcome of the finished run, a failed post is logged and leaves the outcome as is.
+func (o *notifyOptions) send(result *RunResult) {
+	if o.url == "" {
+		return
+	}
+	body, err := o.body(result)
+	if err == nil {
+		err = postJson(o.url, body)
+	}
+	if err != nil {
+		logger().Error("notify", "url", redactURL(o.url), "err", err)
+		return
+	}
+	logger().Debug("notified", "url", redactURL(o.url), "status", result.Status)
+}
+
+func (o *notifyOptions) body(result *RunResult) ([]byte, error) {
+	var msg any = result
+	switch o.format {
+	case "slack":
+		msg = slackMessage(result)
+	case "teams":
+		msg = teamsMessage(result)
+	}
+	return json.Marshal(msg)
+}
+
+func postJson(hookURL string, body []byte) error {
+	client := http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(hookURL, "application/json", bytes.NewReader(body))
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// the url of the error holds the secret of the hook
+		return urlErr.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// redactURL leaves the path of a webhook url out of the logs, it holds the secret of the hook.
+func redactURL(hookURL string) string {
+	scheme, rest, ok := strings.Cut(hookURL, "://")
+	if !ok {
+		return "..."
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	return scheme + "://" + host + "/..."
+}
+
+// runFacts are the lines of a notification message: files, rows, duration and the error.
+func runFacts(result *RunResult) [][2]string {
+	var rows, rejected, failed int
+	for _, f := range result.Files {
+		rows += f.Rows
+		rejected += f.RejectedRows + f.InvalidRows
+		if f.Status == fileFailed {
+			failed++
+		}
+	}
+	host, _ := os.Hostname()
+	facts := [][2]string{
+		{"Files", fmt.Sprintf("%d (%d failed)", len(result.Files), failed)},
+		{"Rows", fmt.Sprintf("%d (%d rejected)", rows, rejected)},
+		{"Duration", (time.Duration(result.DurationMs) * time.Millisecond).String()},
+		{"Host", host},
+	}
+	if result.Error != "" {
+		facts = append(facts, [2]string{"Error", result.Error})
+	}
+	return facts
+}
+
+func runTitle(result *RunResult) string {
+	return fmt.Sprintf("uptomssql %s %s (exit code %d)", result.Command, result.Status, result.ExitCode)
+}
+
+// slackMessage is an incoming webhook message, colored by the run status.
+func slackMessage(result *RunResult) map[string]any {
+	color := "good"
+	switch result.Status {
+	case "failed":
+		color = "danger"
+	case "partial":
+		color = "warning"
+	}
+	var fields []map[string]any
+	for _, fact := range runFacts(result) {
+		fields = append(fields, map[string]any{"title": fact[0], "value": fact[1], "short": fact[0] != "Error"})
+	}
+	return map[string]any{
+		"text":        runTitle(result),
+		"attachments": []map[string]any{{"color": color, "fields": fields}},
+	}
+}
+
+// teamsMessage is an adaptive card, as Teams workflow webhooks take.
+func teamsMessage(result *RunResult) map[string]any {
+	color := "Good"
+	switch result.Status {
+	case "failed":
+		color = "Attention"
+	case "partial":
+		color = "Warning"
+	}
+	var facts []map[string]any
+	for _, fact := range runFacts(result) {
+		facts = append(facts, map[string]any{"title": fact[0], "value": fact[1]})
+	}
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]any{
+			{"type": "TextBlock", "text": runTitle(result), "weight": "Bolder", "color": color, "wrap": true},
+			{"type": "FactSet", "facts": facts},
+		},
+	}
+	return map[string]any{
+		"type":        "message",
+		"attachments": []map[string]any{{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	}
+}
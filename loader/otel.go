@@ -0,0 +1,364 @@
+package loader
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// otelSystems are the db.system.name attribute of the servers, by -driver flag.
+var otelSystems = map[string]string{
+	sqlServerDriver: "microsoft.sql_server",
+	postgresDriver:  "postgresql",
+	mySqlDriver:     "mysql",
+}
+
+// otelOptions exports the spans and metrics of a run with OTLP over HTTP when it finishes.
+type otelOptions struct {
+	endpoint string
+}
+
+func (o *otelOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.endpoint, "otlp-endpoint", "", "export the spans and metrics of the run to this OTLP/HTTP collector url when it finishes, by default $OTEL_EXPORTER_OTLP_ENDPOINT")
+}
+
+// start returns the tracer of a run, nil when there is no collector to export to. The run joins
+// the trace of the W3C $TRACEPARENT, as set by the pipeline running it.
+func (o *otelOptions) start(conn connOptions) *tracer {
+	endpoint := o.endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return nil
+	}
+	t := &tracer{endpoint: strings.TrimSuffix(endpoint, "/"), batches: make(map[*FileResult][]batchSpan)}
+	t.service = os.Getenv("OTEL_SERVICE_NAME")
+	if t.service == "" {
+		t.service = "uptomssql"
+	}
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			continue
+		}
+		if v, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		t.headers = append(t.headers, [2]string{strings.TrimSpace(name), value})
+	}
+	if parent := os.Getenv("TRACEPARENT"); parent != "" {
+		if err := t.join(parent); err != nil {
+			logger().Warn("trace parent ignored", "traceparent", parent, "err", err)
+		}
+	}
+	if t.traceID == "" {
+		t.traceID = randomID(16)
+	}
+	driver := conn.driver
+	if driver == "" {
+		driver = sqlServerDriver
+	}
+	t.attrs = []any{"db.system.name", otelSystems[driver], "server.address", conn.dataSource, "db.namespace", conn.initialCatalog}
+	return t
+}
+
+// batchSpan is a transaction of rows, from its first statement to the commit.
+type batchSpan struct {
+	id         string
+	start, end time.Time
+	rows       int
+}
+
+// tracer records the spans of a run: the run, a span per data file under it and a span per
+// transaction under the file. Its methods do nothing on a nil tracer.
+type tracer struct {
+	endpoint string
+	headers  [][2]string
+	service  string
+	// traceID and parentID are the trace of the run and the span it is under, in hex
+	traceID, parentID string
+	// attrs are the attributes of the database of the run
+	attrs []any
+
+	mu      sync.Mutex
+	batches map[*FileResult][]batchSpan
+	// err is the error stopping the run
+	err error
+}
+
+// join parses a W3C traceparent header, version-traceid-parentid-flags.
+func (t *tracer) join(traceparent string) error {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return errors.New("not version-traceid-parentid-flags")
+	}
+	for _, id := range parts[1:3] {
+		if _, err := hex.DecodeString(id); err != nil || strings.Trim(id, "0") == "" {
+			return fmt.Errorf("invalid id %q", id)
+		}
+	}
+	t.traceID, t.parentID = parts[1], parts[2]
+	return nil
+}
+
+// batchDone records a transaction of the file committed.
+func (t *tracer) batchDone(file *FileResult, started time.Time, rows int) {
+	if t == nil || file == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batches[file] = append(t.batches[file], batchSpan{id: randomID(8), start: started, end: time.Now(), rows: rows})
+}
+
+// fail records the error stopping the run, its server error number goes with the span of the
+// file it stopped.
+func (t *tracer) fail(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+}
+
+// export posts the spans of the finished run, and the metrics if there are, to the collector.
+func (t *tracer) export(result *RunResult, metrics *metricSet) error {
+	if t == nil {
+		return nil
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource":   t.resource(),
+			"scopeSpans": []any{map[string]any{"scope": otelScope(), "spans": t.spans(result)}},
+		}},
+	})
+	if err == nil {
+		err = t.post("/v1/traces", body)
+	}
+	if err != nil || metrics == nil {
+		return err
+	}
+	body, err = json.Marshal(map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource":     t.resource(),
+			"scopeMetrics": []any{map[string]any{"scope": otelScope(), "metrics": metrics.otlp()}},
+		}},
+	})
+	if err == nil {
+		err = t.post("/v1/metrics", body)
+	}
+	return err
+}
+
+// spans returns the spans of the run in the OTLP JSON encoding.
+func (t *tracer) spans(result *RunResult) []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	runID := randomID(8)
+	end := result.StartedAt.Add(time.Duration(result.DurationMs) * time.Millisecond)
+	run := otelSpan(t.traceID, runID, t.parentID, "uptomssql "+result.Command, 1, result.StartedAt, end,
+		append(slices.Clone(t.attrs), "uptomssql.command", result.Command, "uptomssql.status", result.Status, "uptomssql.exit_code", int(result.ExitCode)))
+	if result.Error != "" {
+		t.setError(run, result.Error, t.err)
+	}
+	spans := []any{run}
+	for _, f := range result.Files {
+		if f.Status == fileSkipped {
+			continue
+		}
+		fileID := randomID(8)
+		fileEnd := f.StartedAt.Add(time.Duration(f.DurationMs) * time.Millisecond)
+		file := otelSpan(t.traceID, fileID, runID, "load "+f.Table, 1, f.StartedAt, fileEnd,
+			append(slices.Clone(t.attrs), "db.collection.name", f.Table, "uptomssql.file", f.File, "uptomssql.status", f.Status,
+				"uptomssql.rows", f.Rows, "uptomssql.rejected_rows", f.RejectedRows, "uptomssql.invalid_rows", f.InvalidRows))
+		if f.Status == fileFailed {
+			var err error
+			if t.err != nil && t.err.Error() == f.Error {
+				err = t.err
+			}
+			t.setError(file, f.Error, err)
+		}
+		spans = append(spans, file)
+		for _, b := range t.batches[f] {
+			spans = append(spans, otelSpan(t.traceID, b.id, fileID, "commit "+f.Table, 3, b.start, b.end,
+				append(slices.Clone(t.attrs), "db.collection.name", f.Table, "uptomssql.rows", b.rows)))
+		}
+	}
+	return spans
+}
+
+// setError sets the error status of a span, with the number of the server error if err is one.
+func (t *tracer) setError(span map[string]any, message string, err error) {
+	span["status"] = map[string]any{"code": 2, "message": message}
+	if code := sqlErrorCode(err); code != "" {
+		span["attributes"] = append(span["attributes"].([]any), otelAttrs("db.response.status_code", code)...)
+	}
+}
+
+func (t *tracer) resource() map[string]any {
+	host, _ := os.Hostname()
+	return map[string]any{"attributes": otelAttrs("service.name", t.service, "host.name", host)}
+}
+
+func (t *tracer) post(path string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, t.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range t.headers {
+		req.Header.Set(header[0], header[1])
+	}
+	client := http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s to %s", resp.Status, path)
+	}
+	return nil
+}
+
+// otlp returns the metrics in the OTLP JSON encoding, the counters as cumulative sums from the
+// start of the set.
+func (m *metricSet) otlp() []any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start, now := otelTime(m.started), otelTime(time.Now())
+	var metrics []any
+	for _, family := range metricFamilies {
+		points := m.points[family.name]
+		if len(points) == 0 {
+			continue
+		}
+		var data []any
+		for _, key := range slices.Sorted(maps.Keys(points)) {
+			p := points[key]
+			point := map[string]any{"attributes": otelAttrs(toAny(p.labels)...), "startTimeUnixNano": start, "timeUnixNano": now}
+			if family.kind != "histogram" {
+				point["asDouble"] = p.value
+				data = append(data, point)
+				continue
+			}
+			var count uint64
+			var buckets []string
+			for _, n := range p.counts {
+				count += n
+				buckets = append(buckets, strconv.FormatUint(n, 10))
+			}
+			point["count"] = strconv.FormatUint(count, 10)
+			point["sum"] = p.sum
+			point["bucketCounts"] = buckets
+			point["explicitBounds"] = metricBuckets
+			data = append(data, point)
+		}
+		metric := map[string]any{"name": family.name, "description": family.help}
+		switch family.kind {
+		case "counter":
+			metric["sum"] = map[string]any{"dataPoints": data, "aggregationTemporality": 2, "isMonotonic": true}
+		case "gauge":
+			metric["gauge"] = map[string]any{"dataPoints": data}
+		case "histogram":
+			metric["unit"] = "s"
+			metric["histogram"] = map[string]any{"dataPoints": data, "aggregationTemporality": 2}
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+// sqlErrorCode returns the error number or code the server failed a statement with, empty for
+// other errors.
+func sqlErrorCode(err error) string {
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		return strconv.Itoa(int(sqlErr.SQLErrorNumber()))
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	var mySqlErr *mysql.MySQLError
+	if errors.As(err, &mySqlErr) {
+		return strconv.Itoa(int(mySqlErr.Number))
+	}
+	return ""
+}
+
+func otelScope() map[string]any {
+	return map[string]any{"name": "uptomssql"}
+}
+
+// otelSpan returns a span of the kind, 1 internal or 3 client, with the attribute name and
+// value pairs.
+func otelSpan(traceID, spanID, parentID, name string, kind int, start, end time.Time, attrs []any) map[string]any {
+	span := map[string]any{
+		"traceId":           traceID,
+		"spanId":            spanID,
+		"name":              name,
+		"kind":              kind,
+		"startTimeUnixNano": otelTime(start),
+		"endTimeUnixNano":   otelTime(end),
+		"attributes":        otelAttrs(attrs...),
+	}
+	if parentID != "" {
+		span["parentSpanId"] = parentID
+	}
+	return span
+}
+
+// otelAttrs returns the attributes of name and value pairs, the values strings or ints.
+func otelAttrs(pairs ...any) []any {
+	attrs := []any{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		var value map[string]any
+		switch v := pairs[i+1].(type) {
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		attrs = append(attrs, map[string]any{"key": pairs[i], "value": value})
+	}
+	return attrs
+}
+
+func otelTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func toAny(values []string) []any {
+	res := make([]any, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
+
+// randomID returns n random bytes in hex, as the ids of traces and spans.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
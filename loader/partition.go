@@ -0,0 +1,262 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// stageSuffix is appended to the name of a table loaded with -partition-switch to name its
+// staging table.
+const stageSuffix = "_uptomssql_stage"
+
+// partitionSwitch loads a partitioned table through a staging table on the same partition scheme,
+// with the same indexes and check constraints, whose partitions are switched into the table once
+// its rows are in.
+type partitionSwitch struct {
+	table *tableInfo
+	stage *tableInfo
+	// function and column give the partition of a row, $PARTITION.function(column)
+	function string
+	column   string
+	// create are the statements making the empty staging table
+	create []string
+}
+
+// partitionScheme is the partition scheme of a table, by its heap or clustered index.
+type partitionScheme struct {
+	DataSpace int    `db:"data_space_id"`
+	Scheme    string `db:"scheme"`
+	Function  string `db:"function"`
+	Column    string `db:"column_name"`
+}
+
+// switchIndex is an index of the table the staging table has to have too.
+type switchIndex struct {
+	name       string
+	kind       int
+	unique     bool
+	primaryKey bool
+	filter     string
+	aligned    bool
+	keys       []string
+	included   []string
+}
+
+// newPartitionSwitch returns the switch loading the table, or the reason it cannot be loaded so.
+func newPartitionSwitch(db *sqlx.DB, table *tableInfo) (*partitionSwitch, string, error) {
+	if _, ok := table.dialect.(sqlServer); !ok || table.view {
+		return nil, "not a SQL Server table", nil
+	}
+	catalog := table.ref.catalog()
+	var schemes []partitionScheme
+	err := db.Select(&schemes, `
+SELECT ps.data_space_id, ps.name AS scheme, pf.name AS function, c.name AS column_name
+FROM `+catalog+`sys.indexes i
+JOIN `+catalog+`sys.partition_schemes ps ON ps.data_space_id = i.data_space_id
+JOIN `+catalog+`sys.partition_functions pf ON pf.function_id = ps.function_id
+JOIN `+catalog+`sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.partition_ordinal = 1
+JOIN `+catalog+`sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE i.object_id = OBJECT_ID(@p1) AND i.index_id IN (0, 1)`, table.ref.quoted())
+	if err != nil {
+		return nil, "", err
+	}
+	if len(schemes) == 0 {
+		return nil, "not partitioned", nil
+	}
+	if len(table.computeColumns) > 0 {
+		// SELECT INTO makes them plain columns, the tables would differ
+		return nil, "has computed or GENERATED ALWAYS columns", nil
+	}
+	scheme := schemes[0]
+	indexes, err := getSwitchIndexes(db, table, scheme.DataSpace)
+	if err != nil {
+		return nil, "", err
+	}
+	var checks []struct {
+		Name       string `db:"name"`
+		Definition string `db:"definition"`
+	}
+	err = db.Select(&checks, `
+SELECT name, definition
+FROM `+catalog+`sys.check_constraints
+WHERE parent_object_id = OBJECT_ID(@p1) AND is_disabled = 0`, table.ref.quoted())
+	if err != nil {
+		return nil, "", err
+	}
+
+	stageRef := table.ref
+	stageRef.name += stageSuffix
+	stage := *table
+	stage.ref = stageRef
+	quoted := stage.quotedName()
+	on := fmt.Sprintf(" ON %s(%s)", quoteName(scheme.Scheme), quoteName(scheme.Column))
+	create := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoted),
+		fmt.Sprintf("SELECT TOP 0 * INTO %s FROM %s;", quoted, table.quotedName()),
+	}
+	if len(indexes) == 0 || indexes[0].kind != 1 && indexes[0].kind != 5 {
+		// a clustered index built on the scheme and dropped leaves a partitioned heap
+		index := quoteName("ix" + stageSuffix)
+		create = append(create, fmt.Sprintf("CREATE CLUSTERED INDEX %s ON %s (%s)%s;", index, quoted, quoteName(scheme.Column), on),
+			fmt.Sprintf("DROP INDEX %s ON %s;", index, quoted))
+	}
+	for _, index := range indexes {
+		switch {
+		case !index.aligned:
+			return nil, fmt.Sprintf("index %s is not on the partition scheme", index.name), nil
+		case index.kind != 1 && index.kind != 2 && index.kind != 5:
+			return nil, fmt.Sprintf("index %s is neither rowstore nor clustered columnstore", index.name), nil
+		}
+		create = append(create, index.create(quoted, on))
+	}
+	for _, check := range checks {
+		create = append(create, fmt.Sprintf("ALTER TABLE %s WITH CHECK ADD CONSTRAINT %s CHECK %s;", quoted, quoteName(check.Name+stageSuffix), check.Definition))
+	}
+	if table.hasIdentity() {
+		// the staging table goes on past the identity of the table, not from its seed
+		name := "N" + quoteString(table.quotedName())
+		create = append(create, fmt.Sprintf("DECLARE @seed bigint = IDENT_CURRENT(%s) + IDENT_INCR(%s); DBCC CHECKIDENT (N%s, RESEED, @seed);",
+			name, name, quoteString(quoted)))
+	}
+	return &partitionSwitch{table: table, stage: &stage, function: scheme.Function, column: scheme.Column, create: create}, "", nil
+}
+
+// getSwitchIndexes returns the indexes of the table, the clustered one first, aligned if on the
+// data space of the table.
+func getSwitchIndexes(db *sqlx.DB, table *tableInfo, dataSpace int) ([]switchIndex, error) {
+	catalog := table.ref.catalog()
+	var rows []struct {
+		ID         int    `db:"index_id"`
+		Name       string `db:"name"`
+		Kind       int    `db:"type"`
+		Unique     bool   `db:"is_unique"`
+		PrimaryKey bool   `db:"is_primary_key"`
+		Filter     string `db:"filter"`
+		DataSpace  int    `db:"data_space_id"`
+		Column     string `db:"column_name"`
+		Descending bool   `db:"is_descending_key"`
+		Included   bool   `db:"is_included_column"`
+	}
+	err := db.Select(&rows, `
+SELECT i.index_id, i.name, i.type, i.is_unique, i.is_primary_key, ISNULL(i.filter_definition, '') AS filter, i.data_space_id,
+  ISNULL(c.name, '') AS column_name, ISNULL(ic.is_descending_key, 0) AS is_descending_key, ISNULL(ic.is_included_column, 0) AS is_included_column
+FROM `+catalog+`sys.indexes i
+LEFT JOIN `+catalog+`sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND (ic.key_ordinal > 0 OR ic.is_included_column = 1)
+LEFT JOIN `+catalog+`sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE i.object_id = OBJECT_ID(@p1) AND i.type > 0
+ORDER BY i.index_id, ic.is_included_column, ic.key_ordinal, ic.index_column_id`, table.ref.quoted())
+	if err != nil {
+		return nil, err
+	}
+	var indexes []switchIndex
+	lastID := -1
+	for _, row := range rows {
+		if row.ID != lastID {
+			lastID = row.ID
+			indexes = append(indexes, switchIndex{name: row.Name, kind: row.Kind, unique: row.Unique, primaryKey: row.PrimaryKey,
+				filter: row.Filter, aligned: row.DataSpace == dataSpace})
+		}
+		index := &indexes[len(indexes)-1]
+		switch {
+		case row.Column == "":
+		case row.Included:
+			index.included = append(index.included, quoteName(row.Column))
+		case row.Descending:
+			index.keys = append(index.keys, quoteName(row.Column)+" DESC")
+		default:
+			index.keys = append(index.keys, quoteName(row.Column))
+		}
+	}
+	return indexes, nil
+}
+
+// create returns the statement making the index on the staging table, on the partition scheme.
+// Constraint names are unique in a schema, the one of the primary key gets the suffix too.
+func (i switchIndex) create(table, on string) string {
+	kind := "NONCLUSTERED"
+	if i.kind == 1 {
+		kind = "CLUSTERED"
+	}
+	if i.kind == 5 {
+		return fmt.Sprintf("CREATE CLUSTERED COLUMNSTORE INDEX %s ON %s%s;", quoteName(i.name), table, on)
+	}
+	keys := strings.Join(i.keys, ", ")
+	if i.primaryKey {
+		return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY %s (%s)%s;", table, quoteName(i.name+stageSuffix), kind, keys, on)
+	}
+	query := "CREATE "
+	if i.unique {
+		query += "UNIQUE "
+	}
+	query += fmt.Sprintf("%s INDEX %s ON %s (%s)", kind, quoteName(i.name), table, keys)
+	if len(i.included) > 0 {
+		query += fmt.Sprintf(" INCLUDE (%s)", strings.Join(i.included, ", "))
+	}
+	if i.filter != "" {
+		query += " WHERE " + i.filter
+	}
+	return query + on + ";"
+}
+
+// stageTable returns the switch the rows of the file go through with -partition-switch, the
+// staging table made unless the file is resumed, or nil for the files loaded into their table.
+func (u *uploader) stageTable(table *tableInfo, opts fileOptions, fileName string, resumed bool) (*partitionSwitch, error) {
+	if opts.Mode != InsertMode && opts.Mode != RefreshMode {
+		u.log.Warn("file loaded into the table, partitions are switched in insert and refresh mode", "file", fileName, "table", table.ref.String(), "mode", opts.Mode)
+		return nil, nil
+	}
+	sw, reason, err := newPartitionSwitch(u.db, table)
+	if err != nil {
+		return nil, runError(err, dbErrorCode(err, TableInfoErrorCode))
+	}
+	if sw == nil {
+		u.log.Info("file loaded into the table, no partition switch", "file", fileName, "table", table.ref.String(), "reason", reason)
+		return nil, nil
+	}
+	if u.opts.dryRun {
+		fmt.Fprintf(u.out, "would load %s through %s, switching its partitions into the table\n", fileName, sw.stage.ref)
+		return sw, nil
+	}
+	if resumed {
+		return sw, nil
+	}
+	for _, query := range sw.create {
+		u.log.Debug("query", "table", sw.stage.ref.String(), "sql", query)
+		if _, err := u.db.Exec(query); err != nil {
+			return nil, runError(err, dbErrorCode(err, InsertDataErrorCode))
+		}
+	}
+	u.log.Info("staging table created", "file", fileName, "table", table.ref.String(), "stage", sw.stage.ref.String())
+	return sw, nil
+}
+
+// switchPartitions moves the partitions of the staging table holding rows into the table, which
+// has to have them empty, and drops the staging table. The statements go in the last transaction
+// of the file.
+func (u *uploader) switchPartitions(sw *partitionSwitch) error {
+	var partitions []int
+	err := u.db.Select(&partitions, fmt.Sprintf("SELECT DISTINCT %s$PARTITION.%s(%s) FROM %s ORDER BY 1",
+		sw.table.ref.catalog(), quoteName(sw.function), quoteName(sw.column), sw.stage.quotedName()))
+	if err != nil {
+		return runError(err, dbErrorCode(err, InsertDataErrorCode))
+	}
+	queries := make([]string, 0, len(partitions)+2)
+	for _, n := range partitions {
+		queries = append(queries, fmt.Sprintf("ALTER TABLE %s SWITCH PARTITION %d TO %s PARTITION %d;", sw.stage.quotedName(), n, sw.table.quotedName(), n))
+	}
+	queries = append(queries, fmt.Sprintf("DROP TABLE %s;", sw.stage.quotedName()))
+	if sw.table.hasIdentity() {
+		// a switch leaves the identity of the table behind the values switched in
+		queries = append(queries, fmt.Sprintf("DBCC CHECKIDENT (N%s, RESEED);", quoteString(sw.table.quotedName())))
+	}
+	for _, query := range queries {
+		u.log.Debug("query", "table", sw.table.ref.String(), "sql", query)
+		if _, err := u.batch.exec(query); err != nil {
+			return runError(err, dbErrorCode(err, InsertDataErrorCode))
+		}
+	}
+	u.log.Info("partitions switched", "table", sw.table.ref.String(), "partitions", len(partitions))
+	return nil
+}
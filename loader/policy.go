@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"gopkg.in/yaml.v3"
+)
+
+// The actions of an error policy rule on a statement failing with the errors it matches.
+const (
+	retryAction     = "retry"
+	skipAction      = "skip"
+	abortFileAction = "abort-file"
+	abortRunAction  = "abort-run"
+)
+
+var errorActions = []string{retryAction, skipAction, abortFileAction, abortRunAction}
+
+// errFileAborted is wrapped by the error of a file an error policy rule aborted, the run goes on
+// with the next file.
+var errFileAborted = errors.New("file aborted by the error policy")
+
+// errorPolicy is what to do with the rows whose insert fails with SQL Server errors, read from a
+// yaml file. The first rule matching the error applies, errors no rule matches fail the run or
+// are set aside with -max-errors.
+type errorPolicy struct {
+	Rules []errorRule `yaml:"rules"`
+}
+
+// errorRule matches errors by number or severity class.
+type errorRule struct {
+	Errors []int32 `yaml:"errors"`
+	Class  uint8   `yaml:"class"`
+	Action string  `yaml:"action"`
+	// Retries is how many times retry runs the statement again, waiting Wait before each; an
+	// error left after is handled as with no rule
+	Retries int           `yaml:"retries"`
+	Wait    time.Duration `yaml:"wait"`
+}
+
+// readErrorPolicy reads the policy file, nil for no file.
+func readErrorPolicy(path string) (*errorPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p errorPolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range p.Rules {
+		switch {
+		case len(rule.Errors) == 0 && rule.Class == 0:
+			err = errors.New("no errors or class to match")
+		case !slices.Contains(errorActions, rule.Action):
+			err = fmt.Errorf("unknown action %q, want %s", rule.Action, strings.Join(errorActions, ", "))
+		case rule.Action == retryAction && rule.Retries <= 0:
+			err = errors.New("retry needs retries")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", path, i+1, err)
+		}
+	}
+	return &p, nil
+}
+
+// match returns the rule of the error, nil for none.
+func (p *errorPolicy) match(err error) *errorRule {
+	var sqlErr mssql.Error
+	if p == nil || !errors.As(err, &sqlErr) {
+		return nil
+	}
+	for i, rule := range p.Rules {
+		if slices.Contains(rule.Errors, sqlErr.SQLErrorNumber()) || rule.Class != 0 && rule.Class == sqlErr.SQLErrorClass() {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// action returns the action of the rule, empty for no rule.
+func (r *errorRule) action() string {
+	if r == nil {
+		return ""
+	}
+	return r.Action
+}
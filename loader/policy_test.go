@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestReadErrorPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{name: "rules", yaml: "rules:\n  - errors: [1205]\n    action: retry\n    retries: 3\n    wait: 1s\n  - class: 16\n    action: skip\n"},
+		{name: "nothing to match", yaml: "rules:\n  - action: skip\n", wantErr: "rule 1: no errors or class to match"},
+		{name: "unknown action", yaml: "rules:\n  - errors: [2627]\n    action: ignore\n", wantErr: `rule 1: unknown action "ignore"`},
+		{name: "retry without retries", yaml: "rules:\n  - errors: [1205]\n    action: retry\n", wantErr: "rule 1: retry needs retries"},
+		{name: "invalid yaml", yaml: "rules: [", wantErr: "policy.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := readErrorPolicy(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Rules) != 2 || p.Rules[0].Wait != time.Second {
+				t.Errorf("rules %+v", p.Rules)
+			}
+		})
+	}
+	if p, err := readErrorPolicy(""); p != nil || err != nil {
+		t.Errorf("no file: %v, %v", p, err)
+	}
+}
+
+func TestErrorPolicyMatch(t *testing.T) {
+	p := &errorPolicy{Rules: []errorRule{
+		{Errors: []int32{1205}, Action: retryAction, Retries: 3},
+		{Errors: []int32{2627, 2601}, Action: skipAction},
+		{Class: 16, Action: abortFileAction},
+	}}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "deadlock", err: mssql.Error{Number: 1205, Class: 13}, want: retryAction},
+		{name: "duplicate key", err: mssql.Error{Number: 2601, Class: 14}, want: skipAction},
+		{name: "wrapped", err: fmt.Errorf("row 3: %w", mssql.Error{Number: 2627, Class: 14}), want: skipAction},
+		{name: "by class", err: mssql.Error{Number: 8114, Class: 16}, want: abortFileAction},
+		{name: "no rule", err: mssql.Error{Number: 547, Class: 15}},
+		{name: "not a server error", err: errors.New("conversion failed")},
+		{name: "no error"},
+	}
+	for _, tt := range tests {
+		if got := p.match(tt.err).action(); got != tt.want {
+			t.Errorf("%s: action %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	var none *errorPolicy
+	if got := none.match(mssql.Error{Number: 1205}).action(); got != "" {
+		t.Errorf("no policy: action %q", got)
+	}
+}
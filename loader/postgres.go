@@ -0,0 +1,187 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// postgresTypes are the SQL Server types the values of PostgreSQL column types convert as.
+var postgresTypes = map[string]string{
+	"smallint":                    "smallint",
+	"integer":                     "int",
+	"bigint":                      "bigint",
+	"boolean":                     "bit",
+	"numeric":                     "decimal",
+	"money":                       "money",
+	"real":                        "real",
+	"double precision":            "float",
+	"date":                        "date",
+	"timestamp without time zone": "datetime2",
+	"timestamp with time zone":    "datetimeoffset",
+	"time without time zone":      "time",
+	"character":                   "nchar",
+	"character varying":           "nvarchar",
+	"text":                        "nvarchar",
+	"json":                        "nvarchar",
+	"jsonb":                       "nvarchar",
+	"uuid":                        "uniqueidentifier",
+	"bytea":                       "varbinary",
+	"xml":                         "xml",
+}
+
+// postgresColumn is a column with whether PostgreSQL generates its values.
+type postgresColumn struct {
+	ColumnSchema
+	// Identity columns are identity or serial ones, Generated ones are computed
+	Identity  bool `db:"IDENTITY"`
+	Generated bool `db:"GENERATED"`
+}
+
+// postgres is the dialect of PostgreSQL, connected to with the pgx driver.
+type postgres struct{}
+
+func (postgres) quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (postgres) param(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// tableInfo reads the table of the name given in any case, folded to lower case by the server
+// when created unquoted, in the current schema if none is given.
+func (d postgres) tableInfo(db *sqlx.DB, table tableRef) (*tableInfo, error) {
+	info := &tableInfo{ref: table, schema: make(map[string]ColumnSchema)}
+	err := db.QueryRowx(`
+SELECT table_schema, table_name
+FROM information_schema.tables
+WHERE lower(table_name) = lower($1) AND table_schema = COALESCE(NULLIF($2, ''), current_schema())
+ORDER BY table_name = $1 DESC
+LIMIT 1`, table.name, table.schema).Scan(&info.ref.schema, &info.ref.name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cols []postgresColumn
+	err = db.Select(&cols, `
+SELECT column_name AS "COLUMN_NAME", is_nullable AS "IS_NULLABLE", column_default AS "COLUMN_DEFAULT", data_type AS "DATA_TYPE",
+  character_maximum_length AS "CHARACTER_MAXIMUM_LENGTH", numeric_scale AS "NUMERIC_SCALE", datetime_precision AS "DATETIME_PRECISION",
+  is_identity = 'YES' OR COALESCE(column_default LIKE 'nextval(%', false) AS "IDENTITY", is_generated = 'ALWAYS' AS "GENERATED"
+FROM information_schema.columns
+WHERE table_name = $1 AND table_schema = $2
+ORDER BY ordinal_position`, info.ref.name, info.ref.schema)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range cols {
+		if t, ok := postgresTypes[col.DataType]; ok {
+			col.DataType = t
+		} else {
+			col.DataType = unsupportedType(postgresDriver, col.DataType)
+		}
+		info.schema[col.ColumnName] = col.ColumnSchema
+		info.columns = append(info.columns, col.ColumnName)
+		if col.Identity {
+			info.identityColumns = append(info.identityColumns, col.ColumnName)
+		}
+		if col.Generated {
+			info.computeColumns = append(info.computeColumns, col.ColumnName)
+		}
+	}
+	info.primaryKey, err = primaryKeyOf(db, d, info.ref)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// insertQuery overrides the identity values with the ones of the row.
+func (postgres) insertQuery(table *tableInfo, columns, placeholders []string) string {
+	var overriding string
+	if table.hasIdentity() {
+		overriding = " OVERRIDING SYSTEM VALUE"
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s)%s VALUES (%s);", table.quotedName(), strings.Join(columns, ", "), overriding, strings.Join(placeholders, ", "))
+}
+
+// upsertQuery inserts with ON CONFLICT on the primary key.
+func (d postgres) upsertQuery(table *tableInfo, columns, placeholders, update []string) string {
+	keys := make([]string, len(table.primaryKey))
+	for i, key := range table.primaryKey {
+		keys[i] = d.quote(key)
+	}
+	action := "NOTHING"
+	if len(update) > 0 {
+		set := make([]string, len(update))
+		for i, col := range update {
+			set[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+		}
+		action = "UPDATE SET " + strings.Join(set, ", ")
+	}
+	insert := strings.TrimSuffix(d.insertQuery(table, columns, placeholders), ";")
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO %s;", insert, strings.Join(keys, ", "), action)
+}
+
+func (postgres) identityInsert() bool {
+	return false
+}
+
+// syncIdentity sets the sequences of the identity and serial columns past their greatest value,
+// the inserts of values leave them where they were.
+func (d postgres) syncIdentity(table *tableInfo) []*insertStatement {
+	var stmts []*insertStatement
+	for _, col := range table.identityColumns {
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX(%s), 0) + 1, false) FROM %s;", d.quote(col), table.quotedName())
+		stmts = append(stmts, &insertStatement{query: query, values: []any{table.quotedName(), col}})
+	}
+	return stmts
+}
+
+func (postgres) bind(v any) any {
+	return portableValue(v)
+}
+
+// lock takes a session advisory lock, trying again every second up to timeout.
+func (postgres) lock(ctx context.Context, conn *sqlx.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := conn.GetContext(ctx, &locked, "SELECT pg_try_advisory_lock(hashtext($1))", runLockResource); err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errLockNotGranted
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (postgres) unlock(conn *sqlx.Conn) error {
+	_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", runLockResource)
+	return err
+}
+
+func (postgres) savepoint(name string) (string, string) {
+	return "SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name
+}
+
+// txAlive is false, a failed statement aborts the transaction.
+func (postgres) txAlive(*sqlx.Tx, error) bool {
+	return false
+}
@@ -0,0 +1,93 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// progressDrawInterval is how often the progress bar is redrawn on a terminal
+	progressDrawInterval = 200 * time.Millisecond
+	// progressLogInterval is how often progress is logged when stderr is not a terminal
+	progressLogInterval = 30 * time.Second
+	progressBarWidth    = 30
+)
+
+// isTerminal tells whether the file is a character device, like an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress reports the rows done of a file, as a bar on a terminal or else as periodic log records.
+type progress struct {
+	file  string
+	total int
+	done  int
+	// offset is the byte offset in the data past the last row done, as read
+	offset int64
+	start  time.Time
+	last   time.Time
+	// bar receives the progress bar, log records are written when nil
+	bar io.Writer
+	// drawn tells whether the bar is on screen and needs clearing
+	drawn bool
+	log   *slog.Logger
+}
+
+func newProgress(file string, total int, bar io.Writer, log *slog.Logger) *progress {
+	now := time.Now()
+	return &progress{file: file, total: total, start: now, last: now, bar: bar, log: log}
+}
+
+func (p *progress) add(rows int) {
+	p.done += rows
+	interval := progressLogInterval
+	if p.bar != nil {
+		interval = progressDrawInterval
+	}
+	if time.Since(p.last) < interval {
+		return
+	}
+	p.last = time.Now()
+	if p.bar != nil {
+		p.draw()
+		return
+	}
+	rate, eta := p.rate()
+	p.log.Info("progress", "file", p.file, "rows", p.done, "total", p.total, "offset", p.offset, "rows_per_sec", int(rate), "eta", eta.String())
+}
+
+// rate returns the rows per second so far and the estimated time left.
+func (p *progress) rate() (float64, time.Duration) {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed == 0 || p.done == 0 {
+		return 0, 0
+	}
+	rate := float64(p.done) / elapsed
+	eta := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+	return rate, eta.Round(time.Second)
+}
+
+func (p *progress) draw() {
+	rate, eta := p.rate()
+	filled := 0
+	if p.total > 0 {
+		filled = p.done * progressBarWidth / p.total
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	fmt.Fprintf(p.bar, "\r%s [%s] %d/%d rows, %.0f rows/s, eta %s\033[K", p.file, bar, p.done, p.total, rate, eta)
+	p.drawn = true
+}
+
+// finish clears the bar, the file result is logged after.
+func (p *progress) finish() {
+	if p.drawn {
+		fmt.Fprint(p.bar, "\r\033[K")
+		p.drawn = false
+	}
+}
@@ -0,0 +1,288 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// deadLetterCondition is the error condition rejecting a message to the dead letter queue of
+// Service Bus, other brokers dead letter rejected messages whatever the condition if set up to.
+const deadLetterCondition amqp.ErrCond = "com.microsoft:dead-letter"
+
+// dataErrors are the server error numbers of values the column cannot take: truncated, out of
+// range or failing to convert. With the constraint errors they make a message poison.
+var dataErrors = []int32{220, 241, 242, 245, 2628, 4815, 4816, 8114, 8115, 8152}
+
+// isPoison tells whether the insert failed on the data of the message, it would fail again.
+func isPoison(err error) bool {
+	var sqlErr mssql.Error
+	return dbErrorCode(err, InsertDataErrorCode) == ConstraintErrorCode ||
+		errors.As(err, &sqlErr) && slices.Contains(dataErrors, sqlErr.SQLErrorNumber())
+}
+
+// queueOptions are the flags of the queue command.
+type queueOptions struct {
+	conn         connOptions
+	log          logOptions
+	url          string
+	queues       stringList
+	saslUser     string
+	saslPassword string
+	batchSize    int
+	batchWait    time.Duration
+	healthAddr   string
+	conv         conversionOptions
+}
+
+func (o *queueOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.url, "url", "", "AMQP 1.0 broker, e.g. amqps://<namespace>.servicebus.windows.net or amqp://rabbitmq:5672")
+	fs.Var(&o.queues, "queue", "queue to consume and its table, e.g. 'orders=sales.Orders', the queue name is the table if none is given; repeat for more queues")
+	fs.StringVar(&o.saslUser, "sasl-user", "", "user for SASL PLAIN authentication, the shared access key name for Service Bus")
+	fs.StringVar(&o.saslPassword, "sasl-password", "", "password for SASL PLAIN authentication, the shared access key for Service Bus")
+	fs.IntVar(&o.batchSize, "batch-size", 100, "messages bulk inserted per transaction")
+	fs.DurationVar(&o.batchWait, "batch-wait", 5*time.Second, "longest time to wait for a batch to fill before inserting it")
+	fs.StringVar(&o.healthAddr, "health-addr", "", "answer GET /healthz, /readyz and /metrics on this address, host:port, none if empty")
+	fs.IntVar(&o.conv.SRID, "srid", 4326, "spatial reference id for geography and geometry values")
+}
+
+func runQueue(cmd *command, args []string) {
+	var opts queueOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	tables, err := parseSourceTables("queue", opts.queues)
+	if err == nil {
+		err = opts.log.check()
+	}
+	switch {
+	case err != nil:
+	case opts.url == "":
+		err = errors.New("give the broker with -url")
+	case opts.batchSize <= 0:
+		err = fmt.Errorf("invalid -batch-size %d", opts.batchSize)
+	case opts.batchWait <= 0:
+		err = fmt.Errorf("invalid -batch-wait %s", opts.batchWait)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	consumeQueues(&opts, tables)
+}
+
+// queueConsumer inserts the messages of a queue into its table.
+type queueConsumer struct {
+	opts     *queueOptions
+	db       *sqlx.DB
+	name     string
+	table    *tableInfo
+	receiver *amqp.Receiver
+	health   *healthState
+	// u converts the records as upload does
+	u *uploader
+}
+
+// consumeQueues inserts the json records of the messages of the queues into their tables in
+// batches with the bulk copy until interrupted. Messages are accepted once their batch is
+// committed to the database, the ones of a batch failing are delivered again: records are
+// loaded at least once. Poison messages, not records of the table or failing to insert on
+// their own, are rejected to the dead letter queue.
+func consumeQueues(opts *queueOptions, tables map[string]tableRef) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var health *healthState
+	if opts.healthAddr != "" {
+		health = newHealthState(db)
+		handleError(health.serve(opts.healthAddr), InternalErrorCode)
+	}
+
+	connOpts := &amqp.ConnOptions{}
+	if opts.saslUser != "" {
+		connOpts.SASLType = amqp.SASLTypePlain(opts.saslUser, opts.saslPassword)
+	}
+	conn, err := amqp.Dial(ctx, opts.url, connOpts)
+	handleError(err, ConnectErrorCode)
+	defer conn.Close()
+	session, err := conn.NewSession(ctx, nil)
+	handleError(err, ConnectErrorCode)
+
+	var consumers []*queueConsumer
+	for queue, ref := range tables {
+		table, err := getTableInfo(db, ref)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+		if len(table.columns) == 0 {
+			handleError(fmt.Errorf("table %s of queue %s not found", ref, queue), TableInfoErrorCode)
+		}
+		receiver, err := session.NewReceiver(ctx, queue, &amqp.ReceiverOptions{Credit: int32(opts.batchSize)})
+		handleError(err, ConnectErrorCode)
+		consumers = append(consumers, &queueConsumer{
+			opts: opts, db: db, name: queue, table: table, receiver: receiver, health: health,
+			u: &uploader{ctx: ctx, db: db, opts: &uploadOptions{sourceOptions: sourceOptions{conv: opts.conv}}, summary: newRunSummary(), log: logger()},
+		})
+	}
+
+	logger().Info("consumer started", "url", opts.url, "queues", len(consumers))
+	var wg sync.WaitGroup
+	for _, c := range consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx)
+		}()
+	}
+	wg.Wait()
+	logger().Info("consumer stopped")
+}
+
+// queueMessage is a message received with the rows of its records.
+type queueMessage struct {
+	msg  *amqp.Message
+	rows []*rowValues
+}
+
+// run inserts the messages of the queue in batches until ctx is done, the messages of the batch
+// being filled are delivered again.
+func (c *queueConsumer) run(ctx context.Context) {
+	for {
+		var batch []queueMessage
+		// the batch waits for its first message as long as it takes, then -batch-wait at most
+		receiveCtx, cancel := ctx, context.CancelFunc(func() {})
+		for received := 0; received < c.opts.batchSize; received++ {
+			msg, err := c.receiver.Receive(receiveCtx, nil)
+			if err != nil {
+				cancel()
+				if ctx.Err() != nil {
+					return
+				}
+				if errors.Is(err, context.DeadlineExceeded) {
+					break
+				}
+				handleError(fmt.Errorf("queue %s: %w", c.name, err), ConnectErrorCode)
+			}
+			if received == 0 {
+				receiveCtx, cancel = context.WithTimeout(ctx, c.opts.batchWait)
+			}
+			c.health.addQueued(1)
+			rows, err := messageRows(c.u, c.table, messageBody(msg), c.opts.conv)
+			if err != nil {
+				c.reject(msg, err)
+				continue
+			}
+			batch = append(batch, queueMessage{msg: msg, rows: rows})
+		}
+		cancel()
+		c.insert(batch)
+	}
+}
+
+// insert inserts the batch in one transaction and accepts its messages. When it fails on the
+// data, every message is inserted on its own and the poison ones are rejected; on other errors
+// the consumer stops, the messages are delivered again.
+func (c *queueConsumer) insert(batch []queueMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	start := time.Now()
+	var records []tableRecord
+	for _, m := range batch {
+		for _, row := range m.rows {
+			records = append(records, tableRecord{table: c.table, row: row})
+		}
+	}
+	err := insertRecords(c.db, records, c.opts.conv)
+	if err == nil {
+		for _, m := range batch {
+			c.accept(m.msg)
+		}
+		c.health.runDone(start, SuccessCode)
+		logger().Info("batch loaded", "queue", c.name, "messages", len(batch), "rows", len(records), "duration", time.Since(start).Round(time.Millisecond).String())
+		return
+	}
+	if !isPoison(err) {
+		c.fail(start, err)
+	}
+	logger().Warn("batch failed, inserting its messages one by one", "queue", c.name, "messages", len(batch), "err", err)
+	rejected := 0
+	for _, m := range batch {
+		records := make([]tableRecord, len(m.rows))
+		for i, row := range m.rows {
+			records[i] = tableRecord{table: c.table, row: row}
+		}
+		err := insertRecords(c.db, records, c.opts.conv)
+		switch {
+		case err == nil:
+			c.accept(m.msg)
+		case isPoison(err):
+			c.reject(m.msg, err)
+			rejected++
+		default:
+			c.fail(start, err)
+		}
+	}
+	code := SuccessCode
+	if rejected > 0 {
+		code = PartialSuccessCode
+	}
+	c.health.runDone(start, code)
+	logger().Info("batch loaded", "queue", c.name, "messages", len(batch), "dead_letters", rejected, "duration", time.Since(start).Round(time.Millisecond).String())
+}
+
+// fail stops the consumer on the error of the batch started at start.
+func (c *queueConsumer) fail(start time.Time, err error) {
+	code := dbErrorCode(err, InsertDataErrorCode)
+	c.health.runDone(start, code)
+	handleError(fmt.Errorf("queue %s: %w", c.name, err), code)
+}
+
+func (c *queueConsumer) accept(msg *amqp.Message) {
+	c.health.addQueued(-1)
+	// the rows are committed, a message not settled is delivered again
+	handleError(c.receiver.AcceptMessage(context.Background(), msg), ConnectErrorCode)
+}
+
+// reject sends the message to the dead letter queue with the error.
+func (c *queueConsumer) reject(msg *amqp.Message, err error) {
+	c.health.addQueued(-1)
+	logger().Error("message rejected to the dead letter queue", "queue", c.name, "err", err)
+	amqpErr := &amqp.Error{
+		Condition:   deadLetterCondition,
+		Description: err.Error(),
+		Info:        map[string]any{"DeadLetterReason": "uptomssql", "DeadLetterErrorDescription": err.Error()},
+	}
+	handleError(c.receiver.RejectMessage(context.Background(), msg, amqpErr), ConnectErrorCode)
+}
+
+// messageBody returns the data of the message, its data sections or a string or binary value.
+func messageBody(msg *amqp.Message) []byte {
+	if len(msg.Data) > 0 {
+		var data []byte
+		for _, section := range msg.Data {
+			data = append(data, section...)
+		}
+		return data
+	}
+	switch v := msg.Value.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-amqp"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestIsPoison(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "truncated", err: fmt.Errorf("insert: %w", mssql.Error{Number: 2628}), want: true},
+		{name: "conversion", err: mssql.Error{Number: 245}, want: true},
+		{name: "foreign key", err: mssql.Error{Number: 547}, want: true},
+		{name: "deadlock", err: mssql.Error{Number: 1205}},
+		{name: "connection lost", err: io.EOF},
+		{name: "other", err: errors.New("timeout")},
+	}
+	for _, tt := range tests {
+		if got := isPoison(tt.err); got != tt.want {
+			t.Errorf("%s: isPoison(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMessageBody(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *amqp.Message
+		want string
+	}{
+		{name: "data sections", msg: &amqp.Message{Data: [][]byte{[]byte(`[{"Id": 1},`), []byte(`{"Id": 2}]`)}}, want: `[{"Id": 1},{"Id": 2}]`},
+		{name: "string value", msg: &amqp.Message{Value: `{"Id": 1}`}, want: `{"Id": 1}`},
+		{name: "binary value", msg: &amqp.Message{Value: []byte(`{"Id": 1}`)}, want: `{"Id": 1}`},
+		{name: "other value", msg: &amqp.Message{Value: int64(1)}},
+	}
+	for _, tt := range tests {
+		if got := string(messageBody(tt.msg)); got != tt.want {
+			t.Errorf("%s: body %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMessageRows(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int", Nullable: true},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 5, Nullable: true},
+	}}).tableInfo()
+	u := &uploader{opts: &uploadOptions{}, summary: newRunSummary(), log: logger()}
+	tests := []struct {
+		name     string
+		body     string
+		wantRows int
+		wantErr  string
+	}{
+		{name: "object", body: `{"Id": 1, "Customer": "Ann"}`, wantRows: 1},
+		{name: "array", body: `[{"Id": 1}, {"Id": 2, "Customer": null}]`, wantRows: 2},
+		{name: "record of no column skipped", body: `[{"Id": 1}, {"Other": 2}]`, wantRows: 1},
+		{name: "invalid json", body: `{"Id": `, wantErr: "invalid json"},
+		{name: "data after", body: `{"Id": 1} {"Id": 2}`, wantErr: "data after the value"},
+		{name: "not an object", body: `[{"Id": 1}, 2]`, wantErr: "record 2: expected a json object"},
+		{name: "scalar", body: `"Ann"`, wantErr: "expected a json object or array"},
+		{name: "value not fitting", body: `{"Id": "x"}`, wantErr: "record 1"},
+	}
+	for _, tt := range tests {
+		rows, err := messageRows(u, table, []byte(tt.body), conversionOptions{})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(rows) != tt.wantRows {
+			t.Errorf("%s: %d rows, %v, want %d", tt.name, len(rows), err, tt.wantRows)
+		}
+	}
+}
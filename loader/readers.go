@@ -0,0 +1,410 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+type Format = int
+
+const (
+	Json Format = iota
+	Csv
+)
+
+// RowReader reads the rows of a data file of a format registered with RegisterFormat. Next
+// returns the values of the next row by column, as encoding/json decodes them with numbers
+// as json.Number, and io.EOF after the last row. A reader with a Line() int method tells the
+// line the row starts on for the errors, one with an InputOffset() int64 method the byte offset
+// past the row for the progress, rows are read one at a time either way.
+type RowReader interface {
+	Next() (map[string]any, error)
+}
+
+// NewRowReader returns the RowReader of the content of a data file, decoded to utf-8 when the
+// file has an encoding. path is the path of the file.
+type NewRowReader func(r io.Reader, path string) (RowReader, error)
+
+// formats are the extensions of the formats registered, rowReaders their readers.
+var (
+	formats    = map[string]Format{"json": Json, "csv": Csv}
+	rowReaders = map[Format]NewRowReader{}
+)
+
+// RegisterFormat makes the data files with the extension read by the readers newReader returns.
+// Programs embedding the package register their formats before they load files or run Main,
+// it panics if the extension has a format already.
+func RegisterFormat(ext string, newReader NewRowReader) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if _, ok := formats[ext]; ok {
+		panic(fmt.Sprintf("loader: format %q registered twice", ext))
+	}
+	format := Format(len(formats))
+	formats[ext] = format
+	rowReaders[format] = newReader
+}
+
+func getFileFormat(strFormat string) (Format, error) {
+	format, ok := formats[strings.ToLower(strFormat)]
+	if !ok {
+		return 0, fmt.Errorf("incorrect format %q", strFormat)
+	}
+	return format, nil
+}
+
+// openDataFile opens the data file decoding it from the encoding given to utf-8.
+func openDataFile(filePath string, encoding string) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == "" {
+		return file, nil
+	}
+	enc, err := htmlindex.Get(encoding)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("encoding %q: %w", encoding, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{transform.NewReader(file, enc.NewDecoder()), file}, nil
+}
+
+// pipeData runs the shell command with the data on stdin and returns its stdout as it writes it,
+// in the place of the data. The command gets the path of the data file in UPTOMSSQL_FILE. A
+// command failing fails the read of the end of its output, with what it wrote to stderr.
+func pipeData(data io.Reader, command, filePath string, log *slog.Logger) (io.ReadCloser, error) {
+	shell := []string{"sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+	cmd := exec.Command(shell[0], append(shell[1:], command)...)
+	p := &pipeOutput{cmd: cmd, command: command, filePath: filePath, log: log}
+	cmd.Stdin, cmd.Stderr = data, &p.stderr
+	cmd.Env = append(os.Environ(), "UPTOMSSQL_FILE="+filePath)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: pipe %q: %w", filePath, command, err)
+	}
+	p.out = out
+	return p, nil
+}
+
+// pipeOutput is the stdout of a pipe command, read as the command writes it.
+type pipeOutput struct {
+	out               io.ReadCloser
+	cmd               *exec.Cmd
+	stderr            bytes.Buffer
+	command, filePath string
+	log               *slog.Logger
+	// done is set once the command exited, err tells how
+	done bool
+	err  error
+}
+
+func (p *pipeOutput) Read(b []byte) (int, error) {
+	n, err := p.out.Read(b)
+	if err == io.EOF {
+		if err := p.wait(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// Close waits for the command and returns how it failed. A command whose output was not read to
+// its end is stopped, its exit is no error then.
+func (p *pipeOutput) Close() error {
+	if p.done {
+		return p.err
+	}
+	p.out.Close()
+	if err := p.wait(); err != nil {
+		p.log.Debug("pipe stopped", "file", p.filePath, "err", err)
+	}
+	return nil
+}
+
+// wait waits for the command to exit, the error has what it wrote to stderr.
+func (p *pipeOutput) wait() error {
+	if p.done {
+		return p.err
+	}
+	p.done = true
+	if err := p.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		p.err = fmt.Errorf("%s: pipe %q: %w", p.filePath, p.command, err)
+	}
+	return p.err
+}
+
+// dataRecord is a row of a data file, column name -> value, with the line it starts on.
+type dataRecord struct {
+	line   int
+	values map[string]any
+	// fields are the csv header columns in file order
+	fields []string
+	// err is why the row failed to transform, it is rejected
+	err error
+	// next is the position in the data past the row, where reading goes on after it
+	next filePosition
+}
+
+// filePosition is a position in the data of a file, as read after -encoding, by byte offset and
+// the line of the byte there. A zero position is the start of the file.
+type filePosition struct {
+	offset int64
+	line   int
+}
+
+// lineReader passes the data through, keeping the bytes read past the last offset asked for so
+// the line of later offsets can be told. The decoders read ahead a buffer or a row at most.
+type lineReader struct {
+	r io.Reader
+	// window holds the bytes read from pos on
+	window []byte
+	pos    filePosition
+}
+
+func newLineReader(r io.Reader, from filePosition) *lineReader {
+	if from.line == 0 {
+		from.line = 1
+	}
+	return &lineReader{r: r, pos: from}
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.window = append(l.window, p[:n]...)
+	return n, err
+}
+
+// at returns the position of the offset, at or past the last one asked for and not past the
+// bytes read.
+func (l *lineReader) at(offset int64) filePosition {
+	i := min(int(offset-l.pos.offset), len(l.window))
+	l.pos.line += bytes.Count(l.window[:i], []byte("\n"))
+	l.pos.offset += int64(i)
+	l.window = append(l.window[:0], l.window[i:]...)
+	return l.pos
+}
+
+// readRecords reads all rows of the data file.
+func readRecords(filePath string, ext Format, opts fileOptions, log *slog.Logger) ([]dataRecord, error) {
+	var allRecords []dataRecord
+	err := eachRecord(filePath, ext, opts, filePosition{}, log, func(record dataRecord) bool {
+		allRecords = append(allRecords, record)
+		return true
+	})
+	return allRecords, err
+}
+
+// canSeek tells whether reading the file can start past its first rows: json and csv files read
+// as they are, whose offsets are the ones of the file.
+func canSeek(ext Format, opts fileOptions) bool {
+	return (ext == Json || ext == Csv) && opts.Pipe == "" && opts.Encoding == ""
+}
+
+// eachRecord reads the rows of the data file one by one, from the position of a row given by an
+// earlier read or the start, passing them to fn until it returns false. Only the row read and
+// what the decoder reads ahead are held in memory. The error is a *RunError.
+func eachRecord(filePath string, ext Format, opts fileOptions, from filePosition, log *slog.Logger, fn func(dataRecord) bool) error {
+	file, err := openDataFile(filePath, opts.Encoding)
+	if err != nil {
+		return runError(err, OpenFileErrorCode)
+	}
+	defer file.Close()
+	if opts.Pipe != "" {
+		piped, err := pipeData(file, opts.Pipe, filePath, log)
+		if err != nil {
+			return runError(err, ReadFileErrorCode)
+		}
+		defer piped.Close()
+		file = piped
+	}
+	if from.offset > 0 && !canSeek(ext, opts) {
+		return runError(fmt.Errorf("%s: reading cannot start at offset %d", filePath, from.offset), InternalErrorCode)
+	}
+
+	switch ext {
+	case Json:
+		return eachJsonRecord(file, filePath, from, fn)
+	case Csv:
+		return eachCsvRecord(file, filePath, opts, from, fn)
+	}
+	r, err := rowReaders[ext](file, filePath)
+	if err != nil {
+		return runError(fmt.Errorf("%s: %w", filePath, err), UnmarshalErrorCode)
+	}
+	lines, _ := r.(interface{ Line() int })
+	offsets, _ := r.(interface{ InputOffset() int64 })
+	for n := 1; ; n++ {
+		values, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return runError(fmt.Errorf("%s row %d: %w", filePath, n, err), UnmarshalErrorCode)
+		}
+		record := dataRecord{line: n, values: values}
+		if lines != nil {
+			record.line = lines.Line()
+		}
+		if offsets != nil {
+			record.next.offset = offsets.InputOffset()
+		}
+		if !fn(record) {
+			return nil
+		}
+	}
+}
+
+// eachJsonRecord reads the objects of the json array of the file, from the position past one of
+// them if given.
+func eachJsonRecord(file io.ReadCloser, filePath string, from filePosition, fn func(dataRecord) bool) error {
+	var r io.Reader
+	// shift turns the offsets of the decoder into offsets of the file
+	var shift int64
+	lines := newLineReader(file, from)
+	if from.offset == 0 {
+		r = lines
+	} else {
+		if _, err := file.(io.Seeker).Seek(from.offset, io.SeekStart); err != nil {
+			return runError(err, ReadFileErrorCode)
+		}
+		// past an object comes a comma and the next one, or the end of the array
+		br := bufio.NewReader(lines)
+		skipped := 0
+		for {
+			c, err := br.ReadByte()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return runError(err, ReadFileErrorCode)
+			}
+			skipped++
+			if c == ']' {
+				return nil
+			}
+			if c == ',' {
+				break
+			}
+			if !strings.ContainsRune(" \t\r\n", rune(c)) {
+				return runError(fmt.Errorf("%s offset %d: no row starts there", filePath, from.offset), UnmarshalErrorCode)
+			}
+		}
+		// the rest reads as an array again
+		r = io.MultiReader(strings.NewReader("["), br)
+		shift = from.offset + int64(skipped) - 1
+	}
+
+	// numbers are kept as text so decimals reach the column unrounded
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	_, err := d.Token()
+	for err == nil && d.More() {
+		var raw json.RawMessage
+		if err = d.Decode(&raw); err != nil {
+			break
+		}
+		end := shift + d.InputOffset()
+		record := dataRecord{line: lines.at(end - int64(len(raw))).line}
+		values := json.NewDecoder(bytes.NewReader(raw))
+		values.UseNumber()
+		if err = values.Decode(&record.values); err != nil {
+			break
+		}
+		record.next = lines.at(end)
+		if !fn(record) {
+			return nil
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("%s line %d: %w", filePath, lines.at(max(shift+d.InputOffset(), lines.pos.offset)).line, err)
+	}
+	return runError(err, UnmarshalErrorCode)
+}
+
+// eachCsvRecord reads the rows of the csv file, from the position past one of them if given.
+func eachCsvRecord(file io.ReadCloser, filePath string, opts fileOptions, from filePosition, fn func(dataRecord) bool) error {
+	newReader := func(r io.Reader) *csv.Reader {
+		cr := csv.NewReader(r)
+		cr.Comma = ';'
+		if opts.Delimiter != "" {
+			cr.Comma = []rune(opts.Delimiter)[0]
+		}
+		return cr
+	}
+	lines := newLineReader(file, filePosition{})
+	r := newReader(lines)
+	headers, err := r.Read()
+	if err != nil {
+		return runError(err, UnmarshalErrorCode)
+	}
+	// offsets and lines of the reader are past the position it starts at
+	start := filePosition{line: 1}
+	if from.offset > 0 {
+		if _, err := file.(io.Seeker).Seek(from.offset, io.SeekStart); err != nil {
+			return runError(err, ReadFileErrorCode)
+		}
+		lines = newLineReader(file, from)
+		r = newReader(lines)
+		start = from
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return runError(fmt.Errorf("%s: %w", filePath, err), UnmarshalErrorCode)
+		}
+		row := make(map[string]any, len(headers))
+		for i, header := range headers {
+			row[header] = record[i]
+		}
+		line, _ := r.FieldPos(0)
+		next := lines.at(start.offset + r.InputOffset())
+		if !fn(dataRecord{line: start.line + line - 1, values: row, fields: headers, next: next}) {
+			return nil
+		}
+	}
+}
+
+// renameColumns renames the file columns of the records to the table columns they map to.
+func renameColumns(records []dataRecord, columns map[string]string) {
+	if len(columns) == 0 {
+		return
+	}
+	for _, record := range records {
+		renamed := make(map[string]any, len(columns))
+		for from, to := range columns {
+			if val, ok := record.values[from]; ok {
+				delete(record.values, from)
+				renamed[to] = val
+			}
+		}
+		maps.Copy(record.values, renamed)
+	}
+}
@@ -0,0 +1,67 @@
+package loader
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPipeData(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands for sh")
+	}
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		wantErr string
+	}{
+		{name: "output", command: "tr a-z A-Z", want: `[{"ID":1}]`},
+		{name: "file path", command: `printf %s "$UPTOMSSQL_FILE"`, want: "data/01_Orders.json"},
+		{name: "failing", command: "cat; echo bad input >&2; exit 3", want: `[{"id":1}]`, wantErr: "exit status 3: bad input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := pipeData(strings.NewReader(`[{"id":1}]`), tt.command, "data/01_Orders.json", logger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(out)
+			if string(data) != tt.want {
+				t.Errorf("output %q, want %q", data, tt.want)
+			}
+			closeErr := out.Close()
+			if tt.wantErr == "" {
+				if err != nil || closeErr != nil {
+					t.Errorf("errors %v, %v", err, closeErr)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("read error %v, want %q", err, tt.wantErr)
+			}
+			if closeErr == nil || !strings.Contains(closeErr.Error(), tt.wantErr) {
+				t.Errorf("close error %v, want %q", closeErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipeDataStopped(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands for sh")
+	}
+	// the output of yes never ends, it is read as written and the command stopped on close
+	out, err := pipeData(strings.NewReader(""), "yes", "data/01_Orders.json", logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(out, b); err != nil || string(b) != "y\ny\n" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if err := out.Close(); err != nil {
+		t.Errorf("close error %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	// the types of the values of records besides the basic ones, as read, transformed or injected
+	gob.Register(json.Number(""))
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+}
+
+// byteUnits are the units of a -max-memory size.
+var byteUnits = map[string]int64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+
+// parseByteSize reads a size like 512MB or 2GB.
+func parseByteSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	digits := strings.TrimRight(s, "BKMG")
+	unit, ok := byteUnits[s[len(digits):]]
+	n, err := strconv.ParseInt(strings.TrimSpace(digits), 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, want a number of bytes like 512MB or 2GB", size)
+	}
+	return n * unit, nil
+}
+
+// memoryBudget is the size of the rows of the data files held in memory at a time, shared by the
+// files read together. Rows past it are spilled to temporary files.
+type memoryBudget struct {
+	limit int64
+	used  int64
+}
+
+// take reserves n bytes, false if the budget has not as much left.
+func (b *memoryBudget) take(n int64) bool {
+	if b == nil {
+		return true
+	}
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *memoryBudget) release(n int64) {
+	if b != nil {
+		b.used -= n
+	}
+}
+
+// recordSet holds the records of a data file in order, in memory while the budget allows and in a
+// temporary file after that.
+type recordSet struct {
+	name   string
+	budget *memoryBudget
+	// size is the estimated size of the records in memory, taken from the budget
+	size    int64
+	records []dataRecord
+	spill   *os.File
+	w       *bufio.Writer
+	enc     *gob.Encoder
+	spilled int
+	log     *slog.Logger
+	// readErr is the error reading the spilled records back failed on
+	readErr error
+}
+
+// spilledRecord is a record as written to the spill file.
+type spilledRecord struct {
+	Line     int
+	Values   map[string]any
+	Fields   []string
+	Err      string
+	Next     int64
+	NextLine int
+}
+
+func newRecordSet(name string, budget *memoryBudget, log *slog.Logger) *recordSet {
+	return &recordSet{name: name, budget: budget, log: log}
+}
+
+// add appends the record, spilled once the budget is used up.
+func (s *recordSet) add(record dataRecord) error {
+	if s.spill == nil {
+		n := recordSize(record)
+		if s.budget.take(n) {
+			s.size += n
+			s.records = append(s.records, record)
+			return nil
+		}
+		f, err := os.CreateTemp("", "uptomssql-*.spill")
+		if err != nil {
+			return runError(err, OpenFileErrorCode)
+		}
+		s.spill, s.w = f, bufio.NewWriter(f)
+		s.enc = gob.NewEncoder(s.w)
+		s.log.Info("memory budget used up, rows spilled to disk", "file", s.name, "rows_in_memory", len(s.records), "spill", f.Name())
+	}
+	r := spilledRecord{Line: record.line, Values: record.values, Fields: record.fields, Next: record.next.offset, NextLine: record.next.line}
+	if record.err != nil {
+		r.Err = record.err.Error()
+	}
+	if err := s.enc.Encode(r); err != nil {
+		return runError(fmt.Errorf("%s line %d: spilling row: %w", s.name, record.line, err), ReadFileErrorCode)
+	}
+	s.spilled++
+	return nil
+}
+
+// len returns the number of records.
+func (s *recordSet) len() int {
+	return len(s.records) + s.spilled
+}
+
+// all returns the records by index, the ones spilled read back from disk. Reading them back
+// failing ends the records early, err tells.
+func (s *recordSet) all() iter.Seq2[int, dataRecord] {
+	return func(yield func(int, dataRecord) bool) {
+		for i, record := range s.records {
+			if !yield(i, record) {
+				return
+			}
+		}
+		if s.spill == nil {
+			return
+		}
+		if err := s.w.Flush(); err != nil {
+			s.readErr = runError(err, ReadFileErrorCode)
+			return
+		}
+		if _, err := s.spill.Seek(0, io.SeekStart); err != nil {
+			s.readErr = runError(err, ReadFileErrorCode)
+			return
+		}
+		// the next add appends to the file
+		defer s.spill.Seek(0, io.SeekEnd)
+		dec := gob.NewDecoder(bufio.NewReader(s.spill))
+		for i := len(s.records); i < s.len(); i++ {
+			var r spilledRecord
+			if err := dec.Decode(&r); err != nil {
+				s.readErr = runError(fmt.Errorf("%s: reading spilled rows: %w", s.name, err), ReadFileErrorCode)
+				return
+			}
+			record := dataRecord{line: r.Line, values: r.Values, fields: r.Fields, next: filePosition{r.Next, r.NextLine}}
+			if record.values == nil {
+				record.values = make(map[string]any)
+			}
+			if r.Err != "" {
+				record.err = errors.New(r.Err)
+			}
+			if !yield(i, record) {
+				return
+			}
+		}
+	}
+}
+
+// err returns the error the last read of the records failed on, nil if they were all read.
+func (s *recordSet) err() error {
+	return s.readErr
+}
+
+// close gives the memory of the records back to the budget and removes the spill file.
+func (s *recordSet) close() {
+	s.budget.release(s.size)
+	s.size, s.records = 0, nil
+	if s.spill != nil {
+		s.spill.Close()
+		os.Remove(s.spill.Name())
+		s.spill = nil
+	}
+}
+
+// recordSize estimates the memory a record takes, its map and values.
+func recordSize(record dataRecord) int64 {
+	n := int64(64 + 16*len(record.fields))
+	for name, val := range record.values {
+		n += 48 + int64(len(name)) + valueSize(val)
+	}
+	return n
+}
+
+func valueSize(val any) int64 {
+	switch v := val.(type) {
+	case string:
+		return 16 + int64(len(v))
+	case json.Number:
+		return 16 + int64(len(v))
+	case map[string]any:
+		return recordSize(dataRecord{values: v})
+	case []any:
+		n := int64(24)
+		for _, item := range v {
+			n += 16 + valueSize(item)
+		}
+		return n
+	}
+	return 16
+}
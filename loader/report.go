@@ -0,0 +1,209 @@
+package loader
+
+import (
+	"fmt"
+	"html/template"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// reportSampleRows are the failing rows of a file kept for the report.
+const reportSampleRows = 10
+
+// runReport collects what the HTML report of a run shows besides the run result: the first
+// failing rows of every file. Its methods do nothing on a nil report.
+type runReport struct {
+	path   string
+	redact []string
+	// samples are the first failing rows by file
+	samples map[*FileResult][]rowError
+}
+
+func newRunReport(path, redact string) *runReport {
+	if path == "" {
+		return nil
+	}
+	return &runReport{path: path, redact: parseRedact(redact), samples: make(map[*FileResult][]rowError)}
+}
+
+// addRow keeps a row rejected or invalid in the file, up to reportSampleRows.
+func (r *runReport) addRow(file *FileResult, e rowError) {
+	if r == nil || file == nil || len(r.samples[file]) >= reportSampleRows {
+		return
+	}
+	e.Values = redactValues(e.Values, r.redact)
+	r.samples[file] = append(r.samples[file], e)
+}
+
+// reportTable is a line of the tables of the report, the files of a table summed up.
+type reportTable struct {
+	Table                                 string
+	Files, Rows, Rejected, Invalid, Empty int
+	Deleted                               int
+	Duration                              time.Duration
+	Failed                                bool
+}
+
+// reportTiming is a bar of the timings chart, placed in percents of the run duration.
+type reportTiming struct {
+	File, Table, Status string
+	Duration            time.Duration
+	Left, Width         float64
+}
+
+// reportSample is a failing row of a file, its values as column=value.
+type reportSample struct {
+	Row, Line int
+	Values    string
+	Error     string
+}
+
+type reportFile struct {
+	File, Table string
+	Samples     []reportSample
+}
+
+// write writes the report of the finished run to its file.
+func (r *runReport) write(result *RunResult) error {
+	if r == nil {
+		return nil
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(f, r.data(result))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *runReport) data(result *RunResult) map[string]any {
+	var tables []*reportTable
+	byTable := make(map[string]*reportTable)
+	var timings []reportTiming
+	var samples []reportFile
+	var warnings []string
+	runDuration := max(time.Duration(result.DurationMs)*time.Millisecond, time.Millisecond)
+	for _, f := range result.Files {
+		t := byTable[f.Table]
+		if t == nil {
+			t = &reportTable{Table: f.Table}
+			byTable[f.Table] = t
+			tables = append(tables, t)
+		}
+		if f.Status == fileSkipped {
+			continue
+		}
+		d := time.Duration(f.DurationMs) * time.Millisecond
+		t.Files++
+		t.Rows += f.Rows
+		t.Rejected += f.RejectedRows
+		t.Invalid += f.InvalidRows
+		t.Empty += f.EmptyRows
+		t.Deleted += f.DeletedRows
+		t.Duration += d
+		t.Failed = t.Failed || f.Status == fileFailed
+		timings = append(timings, reportTiming{
+			File:     f.File,
+			Table:    f.Table,
+			Status:   f.Status,
+			Duration: d,
+			Left:     100 * float64(f.StartedAt.Sub(result.StartedAt)) / float64(runDuration),
+			Width:    max(100*float64(d)/float64(runDuration), 0.5),
+		})
+		if f.EmptyRows > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: %d rows skipped with no column to insert", f.File, f.EmptyRows))
+		}
+		if f.RejectedRows > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: %d rows rejected", f.File, f.RejectedRows))
+		}
+		if rows := r.samples[f]; len(rows) > 0 {
+			file := reportFile{File: f.File, Table: f.Table}
+			for _, e := range rows {
+				var values []string
+				for _, col := range slices.Sorted(maps.Keys(e.Values)) {
+					values = append(values, fmt.Sprintf("%s=%v", col, e.Values[col]))
+				}
+				file.Samples = append(file.Samples, reportSample{Row: e.Row, Line: e.Line, Values: strings.Join(values, ", "), Error: e.Error})
+			}
+			samples = append(samples, file)
+		}
+	}
+	for _, table := range slices.Sorted(maps.Keys(result.SkippedColumns)) {
+		columns := result.SkippedColumns[table]
+		for _, column := range slices.Sorted(maps.Keys(columns)) {
+			warnings = append(warnings, fmt.Sprintf("%s.%s not loaded: %s", table, column, columns[column]))
+		}
+	}
+	host, _ := os.Hostname()
+	return map[string]any{
+		"Title":    runTitle(result),
+		"Result":   result,
+		"Duration": time.Duration(result.DurationMs) * time.Millisecond,
+		"Host":     host,
+		"Tables":   tables,
+		"Timings":  timings,
+		"Warnings": warnings,
+		"Samples":  samples,
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+td.n { text-align: right; }
+.success, .done { color: #1a7f37; }
+.failed { color: #cf222e; }
+.partial, .invalid { color: #9a6700; }
+.chart { position: relative; height: 1.4em; width: 40em; background: #f3f3f3; }
+.bar { position: absolute; height: 100%; background: #4c8dd6; }
+.bar.failed { background: #cf222e; }
+.bar.partial, .bar.invalid { background: #d4a72c; }
+code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="{{.Result.Status}}">{{.Result.Status}}, exit code {{.Result.ExitCode}}{{with .Result.Error}}: {{.}}{{end}}</p>
+<p>Started {{.Result.StartedAt.Format "2006-01-02 15:04:05 MST"}} on {{.Host}}, took {{.Duration}}.</p>
+
+<h2>Tables</h2>
+<table>
+<tr><th>Table</th><th>Files</th><th>Rows</th><th>Rejected</th><th>Invalid</th><th>Empty</th><th>Deleted</th><th>Duration</th></tr>
+{{range .Tables}}<tr{{if .Failed}} class="failed"{{end}}><td>{{.Table}}</td><td class="n">{{.Files}}</td><td class="n">{{.Rows}}</td><td class="n">{{.Rejected}}</td><td class="n">{{.Invalid}}</td><td class="n">{{.Empty}}</td><td class="n">{{.Deleted}}</td><td class="n">{{.Duration}}</td></tr>
+{{end}}</table>
+
+<h2>Timings</h2>
+<table>
+<tr><th>File</th><th>Status</th><th>Duration</th><th></th></tr>
+{{range .Timings}}<tr><td>{{.File}}</td><td class="{{.Status}}">{{.Status}}</td><td class="n">{{.Duration}}</td><td><div class="chart"><div class="bar {{.Status}}" style="left: {{printf "%.2f" .Left}}%; width: {{printf "%.2f" .Width}}%"></div></div></td></tr>
+{{end}}</table>
+{{with .Warnings}}
+<h2>Warnings</h2>
+<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{range .Result.Files}}{{if .Error}}
+<h2>Error in {{.File}}</h2>
+<p class="failed"><code>{{.Error}}</code></p>
+{{end}}{{end}}{{range .Samples}}
+<h2>Failing rows of {{.File}}</h2>
+<table>
+<tr><th>Row</th><th>Line</th><th>Values</th><th>Error</th></tr>
+{{range .Samples}}<tr><td class="n">{{.Row}}</td><td class="n">{{.Line}}</td><td><code>{{.Values}}</code></td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
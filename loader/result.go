@@ -0,0 +1,147 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// File statuses of the run result.
+const (
+	fileDone    = "done"
+	fileFailed  = "failed"
+	fileSkipped = "skipped"
+	fileInvalid = "invalid"
+	// filePartial is a file loaded with some rows rejected
+	filePartial = "partial"
+)
+
+// FileResult is the outcome of one data file in the run result.
+type FileResult struct {
+	File  string `json:"file"`
+	Table string `json:"table"`
+	// Target is the server and database of the file, when the run loads several -target
+	Target      string `json:"target,omitempty"`
+	Status      string `json:"status"`
+	Rows        int    `json:"rows"`
+	InvalidRows int    `json:"invalid_rows,omitempty"`
+	// EmptyRows have no column to insert and are skipped
+	EmptyRows int `json:"empty_rows,omitempty"`
+	// RejectedRows are set aside with -continue-on-error
+	RejectedRows int `json:"rejected_rows,omitempty"`
+	// DeletedRows are the table rows missing from the data files deleted in sync mode
+	DeletedRows int `json:"deleted_rows,omitempty"`
+	// Offset is the byte offset in the data past the last row committed
+	Offset     int64     `json:"offset,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// RunResult is the machine readable outcome of a run, written with -output json and returned by
+// Uploader.Upload.
+type RunResult struct {
+	Command        string                       `json:"command"`
+	Status         string                       `json:"status"`
+	ExitCode       AppExitCode                  `json:"exit_code"`
+	Error          string                       `json:"error,omitempty"`
+	StartedAt      time.Time                    `json:"started_at"`
+	DurationMs     int64                        `json:"duration_ms"`
+	Files          []*FileResult                `json:"files"`
+	SkippedColumns map[string]map[string]string `json:"skipped_columns,omitempty"`
+
+	// current is the file being loaded, failed when the run stops
+	current *FileResult
+	// target is the server and database the files are loaded into, when the run loads several
+	target string
+}
+
+func newRunResult(command string) *RunResult {
+	return &RunResult{Command: command, StartedAt: time.Now(), Files: []*FileResult{}}
+}
+
+func (r *RunResult) startFile(file string, table tableRef) *FileResult {
+	r.current = &FileResult{File: file, Table: table.String(), Target: r.target, StartedAt: time.Now()}
+	r.Files = append(r.Files, r.current)
+	return r.current
+}
+
+func (r *RunResult) skipFile(file string, table tableRef) {
+	r.Files = append(r.Files, &FileResult{File: file, Table: table.String(), Target: r.target, Status: fileSkipped, StartedAt: time.Now()})
+}
+
+// endFile sets the status of the current file from the error it ended with.
+func (r *RunResult) endFile(err error) {
+	f := r.current
+	if f == nil {
+		return
+	}
+	r.current = nil
+	f.DurationMs = time.Since(f.StartedAt).Milliseconds()
+	switch {
+	case err != nil:
+		f.Status = fileFailed
+		f.Error = err.Error()
+	case f.InvalidRows > 0:
+		f.Status = fileInvalid
+	case f.RejectedRows > 0:
+		f.Status = filePartial
+	default:
+		f.Status = fileDone
+	}
+}
+
+// finish sets the run outcome, err is the one stopping the run if any.
+func (r *RunResult) finish(err error, code AppExitCode) {
+	r.endFile(err)
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+	r.ExitCode = code
+	r.Status = "success"
+	if err != nil {
+		r.Status = "failed"
+		r.Error = err.Error()
+	}
+	if code == PartialSuccessCode {
+		r.Status = "partial"
+	}
+}
+
+// printFiles lists the files handled so far with their status.
+func (r *RunResult) printFiles(w io.Writer) {
+	color := colorFor(w)
+	for _, f := range r.Files {
+		status := f.Status
+		switch status {
+		case fileFailed:
+			status = paint(color, colorRed, status)
+		case filePartial, fileInvalid:
+			status = paint(color, colorYellow, status)
+		}
+		table := f.Table
+		if f.Target != "" {
+			table = f.Target + " " + table
+		}
+		fmt.Fprintf(w, "  %s => %s: %s, %d rows\n", f.File, table, status, f.Rows)
+	}
+}
+
+func (r *RunResult) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// save writes the result to the file, or to stdout when no file is given.
+func (r *RunResult) save(path string) error {
+	if path == "" {
+		return r.write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(r.write(f), f.Close())
+}
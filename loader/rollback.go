@@ -0,0 +1,199 @@
+package loader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// rollbackScript collects the primary keys of the rows a run inserts, written as a script
+// deleting them to undo the run.
+type rollbackScript struct {
+	path string
+	// tables are in load order, with the keys of their rows inserted
+	tables []*rollbackTable
+	// written is set once the script is written, the end of a run and its failure write it once
+	written bool
+	log     *slog.Logger
+}
+
+type rollbackTable struct {
+	table *tableInfo
+	conv  conversionOptions
+	keys  [][]any
+	// missing counts the rows inserted without values for the whole primary key
+	missing int
+}
+
+func newRollbackScript(path string, log *slog.Logger) *rollbackScript {
+	return &rollbackScript{path: path, log: log}
+}
+
+// add records the key of a row inserted into the table, keys is the one the insert returned if
+// the server generated it.
+func (r *rollbackScript) add(table *tableInfo, row *rowValues, keys []any, conv conversionOptions) {
+	if r == nil {
+		return
+	}
+	var t *rollbackTable
+	if i := slices.IndexFunc(r.tables, func(t *rollbackTable) bool { return t.table.ref == table.ref }); i >= 0 {
+		t = r.tables[i]
+	} else {
+		t = &rollbackTable{table: table, conv: conv}
+		r.tables = append(r.tables, t)
+	}
+	if keys != nil {
+		// refreshed in place when the batch runs the insert again
+		t.keys = append(t.keys, keys)
+		return
+	}
+	key := make([]any, len(table.primaryKey))
+	for i, name := range table.primaryKey {
+		j := slices.IndexFunc(row.columns, func(c ColumnSchema) bool { return c.ColumnName == name })
+		if j < 0 {
+			t.missing++
+			return
+		}
+		key[i] = row.values[j]
+	}
+	if len(key) == 0 {
+		t.missing++
+		return
+	}
+	t.keys = append(t.keys, key)
+}
+
+// returnKeys makes the insert of a row into a SQL Server table return its primary key when the
+// row leaves a key column to the server, an IDENTITY or a default like NEWID(), so the rollback
+// script gets the key generated. The key goes through a table variable, as OUTPUT without INTO
+// fails on tables with triggers.
+func returnKeys(table *tableInfo, row *rowValues, stmt *insertStatement) {
+	if _, ok := table.dialect.(sqlServer); !ok || len(table.primaryKey) == 0 {
+		return
+	}
+	generated := slices.ContainsFunc(table.primaryKey, func(name string) bool {
+		return !slices.ContainsFunc(row.columns, func(c ColumnSchema) bool { return c.ColumnName == name })
+	})
+	columns := make([]string, len(row.columns))
+	for i, col := range row.columns {
+		columns[i] = quoteName(col.ColumnName)
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s)", table.quotedName(), strings.Join(columns, ", "))
+	if !generated || !strings.HasPrefix(stmt.query, insert) {
+		return
+	}
+	declared := make([]string, len(table.primaryKey))
+	inserted := make([]string, len(table.primaryKey))
+	selected := make([]string, len(table.primaryKey))
+	for i, name := range table.primaryKey {
+		declared[i] = fmt.Sprintf("k%d sql_variant", i+1)
+		inserted[i] = "INSERTED." + quoteName(name)
+		selected[i] = fmt.Sprintf("k%d", i+1)
+	}
+	stmt.query = fmt.Sprintf("DECLARE @keys TABLE (%s); %s OUTPUT %s INTO @keys%s SELECT %s FROM @keys;",
+		strings.Join(declared, ", "), insert, strings.Join(inserted, ", "), stmt.query[len(insert):], strings.Join(selected, ", "))
+	stmt.keys = make([]any, len(table.primaryKey))
+}
+
+// write writes the DELETE of every row recorded, children before their parents.
+func (r *rollbackScript) write(db *sqlx.DB) error {
+	if r == nil || r.written {
+		return nil
+	}
+	r.written = true
+	order, err := deleteOrder(db, r.tables)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	script := newSqlScript(f)
+	rows := 0
+	for _, t := range order {
+		if t.missing > 0 {
+			r.log.Warn("rows inserted without primary key values not in the rollback script", "table", t.table.ref.String(), "rows", t.missing)
+		}
+		if len(t.keys) == 0 {
+			continue
+		}
+		conditions := make([]string, len(t.table.primaryKey))
+		for i, name := range t.table.primaryKey {
+			conditions[i] = fmt.Sprintf("%s = %s", quoteName(name), placeholder(t.table.schema[name], fmt.Sprintf("@p%d", i+1), t.conv))
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s;", t.table.ref.quoted(), strings.Join(conditions, " AND "))
+		if err := script.writeQuery(fmt.Sprintf("\n-- %d rows of %s", len(t.keys), t.table.ref)); err != nil {
+			return err
+		}
+		for _, key := range t.keys {
+			if err := script.writeStatement(&insertStatement{query: query, values: key}); err != nil {
+				return err
+			}
+		}
+		if err := script.endFile(); err != nil {
+			return err
+		}
+		rows += len(t.keys)
+	}
+	if err := script.close(); err != nil {
+		return err
+	}
+	r.log.Info("rollback script written", "file", r.path, "rows", rows)
+	return f.Close()
+}
+
+// foreignKey links the table of a foreign key to the table it references.
+type foreignKey struct {
+	ChildSchema  string `db:"child_schema"`
+	ChildName    string `db:"child_name"`
+	ParentSchema string `db:"parent_schema"`
+	ParentName   string `db:"parent_name"`
+}
+
+// deleteOrder orders the tables so that a table referencing another by foreign key comes
+// before it, else in the reverse of the load order.
+func deleteOrder(db *sqlx.DB, tables []*rollbackTable) ([]*rollbackTable, error) {
+	var fks []foreignKey
+	err := db.Select(&fks, `
+SELECT OBJECT_SCHEMA_NAME(parent_object_id) AS child_schema, OBJECT_NAME(parent_object_id) AS child_name,
+  OBJECT_SCHEMA_NAME(referenced_object_id) AS parent_schema, OBJECT_NAME(referenced_object_id) AS parent_name
+FROM sys.foreign_keys
+WHERE parent_object_id <> referenced_object_id`)
+	if err != nil {
+		return nil, err
+	}
+	return sortForDelete(tables, fks), nil
+}
+
+// sortForDelete orders the tables for deleteOrder by the foreign keys of the database.
+func sortForDelete(tables []*rollbackTable, fks []foreignKey) []*rollbackTable {
+	is := func(ref tableRef, schema, name string) bool {
+		// foreign keys do not cross databases
+		return ref.database == "" && strings.EqualFold(ref.name, name) && (ref.schema == "" || strings.EqualFold(ref.schema, schema))
+	}
+	// references tells whether child has a foreign key to parent
+	references := func(child, parent *rollbackTable) bool {
+		return slices.ContainsFunc(fks, func(fk foreignKey) bool {
+			return is(child.table.ref, fk.ChildSchema, fk.ChildName) && is(parent.table.ref, fk.ParentSchema, fk.ParentName)
+		})
+	}
+	left := slices.Clone(tables)
+	slices.Reverse(left)
+	order := make([]*rollbackTable, 0, len(tables))
+	for len(left) > 0 {
+		// the first table no other table left references, or the first one on a cycle
+		next := slices.IndexFunc(left, func(parent *rollbackTable) bool {
+			return !slices.ContainsFunc(left, func(child *rollbackTable) bool { return child != parent && references(child, parent) })
+		})
+		next = max(next, 0)
+		order = append(order, left[next])
+		left = slices.Delete(left, next, next+1)
+	}
+	return order
+}
@@ -0,0 +1,124 @@
+package loader
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortForDelete(t *testing.T) {
+	table := func(schema, name string) *rollbackTable {
+		return &rollbackTable{table: &tableInfo{ref: tableRef{schema: schema, name: name}}}
+	}
+	fk := func(child, parent string) foreignKey {
+		return foreignKey{ChildSchema: "dbo", ChildName: child, ParentSchema: "dbo", ParentName: parent}
+	}
+	tests := []struct {
+		name   string
+		tables []string
+		fks    []foreignKey
+		want   []string
+	}{
+		{name: "reverse load order", tables: []string{"A", "B", "C"}, want: []string{"C", "B", "A"}},
+		{name: "children first", tables: []string{"Lines", "Orders", "Customers"},
+			fks:  []foreignKey{fk("Lines", "Orders"), fk("Orders", "Customers")},
+			want: []string{"Lines", "Orders", "Customers"}},
+		{name: "loaded in order", tables: []string{"Customers", "Orders", "Lines"},
+			fks:  []foreignKey{fk("Lines", "Orders"), fk("Orders", "Customers")},
+			want: []string{"Lines", "Orders", "Customers"}},
+		{name: "unrelated tables keep the reverse order", tables: []string{"Customers", "Lines", "Products", "Orders"},
+			fks:  []foreignKey{fk("Lines", "Orders")},
+			want: []string{"Products", "Lines", "Orders", "Customers"}},
+		{name: "cycle", tables: []string{"A", "B"}, fks: []foreignKey{fk("A", "B"), fk("B", "A")}, want: []string{"B", "A"}},
+		{name: "other schema", tables: []string{"Orders", "Lines"},
+			fks:  []foreignKey{{ChildSchema: "sales", ChildName: "Orders", ParentSchema: "sales", ParentName: "Lines"}},
+			want: []string{"Lines", "Orders"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := make([]*rollbackTable, len(tt.tables))
+			for i, name := range tt.tables {
+				tables[i] = table("dbo", name)
+			}
+			var got []string
+			for _, table := range sortForDelete(tables, tt.fks) {
+				got = append(got, table.table.ref.name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("order %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollbackScriptAdd(t *testing.T) {
+	id := ColumnSchema{ColumnName: "Id", DataType: "int"}
+	name := ColumnSchema{ColumnName: "Name", DataType: "nvarchar"}
+	orders := &tableInfo{ref: tableRef{name: "Orders"}, primaryKey: []string{"Id"}}
+	logs := &tableInfo{ref: tableRef{name: "Logs"}}
+	r := newRollbackScript("rollback.sql", logger())
+	r.add(orders, &rowValues{columns: []ColumnSchema{id, name}, values: []any{int64(1), "a"}}, nil, conversionOptions{})
+	r.add(orders, &rowValues{columns: []ColumnSchema{name}, values: []any{"b"}}, nil, conversionOptions{})
+	r.add(orders, &rowValues{columns: []ColumnSchema{name}, values: []any{"e"}}, []any{int64(2)}, conversionOptions{})
+	r.add(orders, &rowValues{columns: []ColumnSchema{name, id}, values: []any{"c", int64(3)}}, nil, conversionOptions{})
+	r.add(logs, &rowValues{columns: []ColumnSchema{name}, values: []any{"d"}}, nil, conversionOptions{})
+	if len(r.tables) != 2 {
+		t.Fatalf("tables %d, want 2", len(r.tables))
+	}
+	if got := r.tables[0]; !slices.EqualFunc(got.keys, [][]any{{int64(1)}, {int64(2)}, {int64(3)}}, slices.Equal) || got.missing != 1 {
+		t.Errorf("Orders keys %v, missing %d", got.keys, got.missing)
+	}
+	if got := r.tables[1]; len(got.keys) != 0 || got.missing != 1 {
+		t.Errorf("Logs keys %v, missing %d", got.keys, got.missing)
+	}
+	var none *rollbackScript
+	none.add(orders, &rowValues{}, nil, conversionOptions{})
+}
+
+func TestReturnKeys(t *testing.T) {
+	id := ColumnSchema{ColumnName: "Id", DataType: "int"}
+	name := ColumnSchema{ColumnName: "Name", DataType: "nvarchar"}
+	orders := &tableInfo{ref: tableRef{schema: "dbo", name: "Orders"}, primaryKey: []string{"Id"}, dialect: sqlServer{}}
+	tests := []struct {
+		name    string
+		table   *tableInfo
+		columns []ColumnSchema
+		want    string
+		keys    int
+	}{
+		{
+			name:    "generated",
+			table:   orders,
+			columns: []ColumnSchema{name},
+			want:    "DECLARE @keys TABLE (k1 sql_variant); INSERT INTO [dbo].[Orders] ([Name]) OUTPUT INSERTED.[Id] INTO @keys VALUES (@p1); SELECT k1 FROM @keys;",
+			keys:    1,
+		},
+		{
+			name:    "given",
+			table:   orders,
+			columns: []ColumnSchema{id, name},
+			want:    "INSERT INTO [dbo].[Orders] ([Id], [Name]) VALUES (@p1, @p2);",
+		},
+		{
+			name:    "postgres",
+			table:   &tableInfo{ref: tableRef{schema: "public", name: "orders"}, primaryKey: []string{"Id"}, dialect: postgres{}},
+			columns: []ColumnSchema{name},
+			want:    `INSERT INTO "public"."orders" ("Name") VALUES ($1);`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := &rowValues{columns: tt.columns, values: make([]any, len(tt.columns))}
+			placeholders := make([]string, len(tt.columns))
+			quoted := make([]string, len(tt.columns))
+			for i, col := range tt.columns {
+				quoted[i] = tt.table.dialect.quote(col.ColumnName)
+				placeholders[i] = tt.table.dialect.param(i + 1)
+			}
+			stmt := &insertStatement{query: tt.table.dialect.insertQuery(tt.table, quoted, placeholders)}
+			returnKeys(tt.table, row, stmt)
+			if stmt.query != tt.want || len(stmt.keys) != tt.keys {
+				t.Errorf("query %s with %d keys, want %s with %d", stmt.query, len(stmt.keys), tt.want, tt.keys)
+			}
+		})
+	}
+}
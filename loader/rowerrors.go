@@ -0,0 +1,75 @@
+package loader
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+)
+
+// redactedValue replaces the values of redacted columns in the error log.
+const redactedValue = "***"
+
+// rowError is a failing row as written to the error log, one json object per line.
+type rowError struct {
+	File   string         `json:"file"`
+	Table  string         `json:"table"`
+	Row    int            `json:"row"`
+	Line   int            `json:"line"`
+	Values map[string]any `json:"values"`
+	Error  string         `json:"error"`
+}
+
+// errorLog records the rows failing to convert or insert with their source, nil records nothing.
+type errorLog struct {
+	f   *os.File
+	enc *json.Encoder
+	// redact lists the lower cased columns whose values are not written, * for all
+	redact []string
+}
+
+func openErrorLog(path string, redact string) (*errorLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &errorLog{f: f, enc: json.NewEncoder(f), redact: parseRedact(redact)}, nil
+}
+
+// parseRedact returns the lower cased columns of a -redact list.
+func parseRedact(redact string) []string {
+	var columns []string
+	for _, col := range strings.Split(redact, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			columns = append(columns, strings.ToLower(col))
+		}
+	}
+	return columns
+}
+
+// redactValues returns the values with the ones of the redacted columns replaced.
+func redactValues(values map[string]any, redact []string) map[string]any {
+	res := make(map[string]any, len(values))
+	for col, val := range values {
+		if slices.Contains(redact, "*") || slices.Contains(redact, strings.ToLower(col)) {
+			val = redactedValue
+		}
+		res[col] = val
+	}
+	return res
+}
+
+func (l *errorLog) add(e rowError) error {
+	if l == nil {
+		return nil
+	}
+	e.Values = redactValues(e.Values, l.redact)
+	return l.enc.Encode(e)
+}
+
+func (l *errorLog) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
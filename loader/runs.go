@@ -0,0 +1,131 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// runsTable records the data files loaded with -track and the migrations applied and reverted,
+// a row per file load or revert.
+const runsTable = "[dbo].[__uptomssql_runs]"
+
+// createRunsTable creates the runs table, or adds the migration columns to one of an older version.
+const createRunsTable = `IF OBJECT_ID(N'dbo.__uptomssql_runs', N'U') IS NULL
+CREATE TABLE [dbo].[__uptomssql_runs] (
+    [id] int IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    [file_name] nvarchar(260) NOT NULL,
+    [table_name] nvarchar(300) NOT NULL,
+    [sha256] char(64) NOT NULL,
+    [row_count] int NOT NULL,
+    [version] int NULL,
+    [direction] varchar(4) NOT NULL DEFAULT 'up',
+    [loaded_at] datetime2(3) NOT NULL DEFAULT SYSUTCDATETIME()
+);
+ELSE IF COL_LENGTH(N'dbo.__uptomssql_runs', N'version') IS NULL
+ALTER TABLE [dbo].[__uptomssql_runs] ADD [version] int NULL, [direction] varchar(4) NOT NULL DEFAULT 'up';`
+
+// Directions of the runs table rows.
+const (
+	runUp   = "up"
+	runDown = "down"
+)
+
+// runEntry is the last row of a file in the runs table.
+type runEntry struct {
+	sum string
+	// down is a migration file reverted
+	down bool
+}
+
+// runLog holds the last load or revert of every file recorded in the runs table.
+type runLog struct {
+	// entries are by file name and table, as runKey makes them
+	entries map[string]runEntry
+}
+
+// openRunLog reads the runs table, creating it first when the run writes to the database.
+// A missing table is an empty log.
+func openRunLog(db *sqlx.DB, create bool) (*runLog, error) {
+	l := &runLog{entries: make(map[string]runEntry)}
+	if create {
+		if _, err := db.Exec(createRunsTable); err != nil {
+			return nil, err
+		}
+	} else {
+		var id sql.NullInt64
+		if err := db.Get(&id, "SELECT OBJECT_ID(N'dbo.__uptomssql_runs', N'U')"); err != nil || !id.Valid {
+			return l, err
+		}
+	}
+	var rows []struct {
+		File      string `db:"file_name"`
+		Table     string `db:"table_name"`
+		Sum       string `db:"sha256"`
+		Direction string `db:"direction"`
+	}
+	err := db.Select(&rows, `
+SELECT file_name, table_name, sha256, direction FROM (
+  SELECT file_name, table_name, sha256, direction, ROW_NUMBER() OVER (PARTITION BY file_name, table_name ORDER BY id DESC) AS n
+  FROM `+runsTable+`) AS runs
+WHERE n = 1`)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		l.entries[runKey(row.File, row.Table)] = runEntry{sum: row.Sum, down: row.Direction == runDown}
+	}
+	return l, nil
+}
+
+// runKey matches files by name and table case insensitively, as the server compares them.
+func runKey(file, table string) string {
+	return strings.ToLower(file + "\x00" + table)
+}
+
+// entry returns the last row recorded for the file, if any.
+func (l *runLog) entry(plan *filePlan) (runEntry, bool) {
+	e, ok := l.entries[runKey(plan.name, plan.table.String())]
+	return e, ok
+}
+
+// loaded tells whether the file is loaded, and not reverted since, with the same content.
+func (l *runLog) loaded(plan *filePlan) bool {
+	e, ok := l.entry(plan)
+	return ok && !e.down && e.sum == plan.sum
+}
+
+// record returns the statement recording the load or revert of the file, run in its last transaction.
+// Migration files are recorded with their version.
+func (l *runLog) record(plan *filePlan, rows int, direction string) *insertStatement {
+	l.entries[runKey(plan.name, plan.table.String())] = runEntry{sum: plan.sum, down: direction == runDown}
+	var version any
+	if m := migrationName.FindStringSubmatch(plan.name); m != nil {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		version = n
+	}
+	return &insertStatement{
+		query:  "INSERT INTO " + runsTable + " ([file_name], [table_name], [sha256], [row_count], [version], [direction]) VALUES (@p1, @p2, @p3, @p4, @p5, @p6);",
+		values: []any{plan.name, plan.table.String(), plan.sum, int64(rows), version, direction},
+	}
+}
+
+// fileSHA256 returns the hex SHA-256 of the file content.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
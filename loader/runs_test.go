@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRunLog(t *testing.T) {
+	const lastRuns = `
+SELECT file_name, table_name, sha256, direction FROM (
+  SELECT file_name, table_name, sha256, direction, ROW_NUMBER() OVER (PARTITION BY file_name, table_name ORDER BY id DESC) AS n
+  FROM [dbo].[__uptomssql_runs]) AS runs
+WHERE n = 1`
+	server := &fakeServer{results: map[string]*fakeResult{
+		lastRuns: {columns: []string{"file_name", "table_name", "sha256", "direction"}, rows: [][]driver.Value{
+			{"01_Customers.json", "Customers", "aaa", "up"},
+			{"V002__orders.json", "Orders", "bbb", "down"},
+		}},
+	}}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	runs, err := openRunLog(sqlx.NewDb(db, "sqlserver"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(server.committed, []string{createRunsTable}) {
+		t.Errorf("statements %q, want the runs table created", server.committed)
+	}
+
+	tests := []struct {
+		name string
+		plan *filePlan
+		want bool
+	}{
+		{name: "unchanged", plan: &filePlan{name: "01_Customers.json", table: tableRef{name: "Customers"}, sum: "aaa"}, want: true},
+		{name: "case insensitive", plan: &filePlan{name: "01_customers.JSON", table: tableRef{name: "CUSTOMERS"}, sum: "aaa"}, want: true},
+		{name: "changed", plan: &filePlan{name: "01_Customers.json", table: tableRef{name: "Customers"}, sum: "ccc"}},
+		{name: "other table", plan: &filePlan{name: "01_Customers.json", table: tableRef{schema: "crm", name: "Customers"}, sum: "aaa"}},
+		{name: "reverted", plan: &filePlan{name: "V002__orders.json", table: tableRef{name: "Orders"}, sum: "bbb"}},
+		{name: "new", plan: &filePlan{name: "02_Orders.json", table: tableRef{name: "Orders"}, sum: "ddd"}},
+	}
+	for _, tt := range tests {
+		if got := runs.loaded(tt.plan); got != tt.want {
+			t.Errorf("%s: loaded %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a load recorded counts for the rest of the run
+	plan := &filePlan{name: "V002__orders.json", table: tableRef{name: "Orders"}, sum: "eee"}
+	stmt := runs.record(plan, 5, runUp)
+	if !runs.loaded(plan) {
+		t.Error("file recorded not loaded")
+	}
+	want := []any{"V002__orders.json", "Orders", "eee", int64(5), int64(2), runUp}
+	if !slices.Equal(stmt.values, want) {
+		t.Errorf("values %v, want %v", stmt.values, want)
+	}
+	if stmt = runs.record(tests[0].plan, 1, runUp); stmt.values[4] != nil {
+		t.Errorf("version %v of a data file, want none", stmt.values[4])
+	}
+}
+
+func TestFileSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01_Orders.json")
+	if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("sha256 %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,353 @@
+package loader
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ColumnSchema struct {
+	ColumnName    string         `db:"COLUMN_NAME"`
+	IsNullable    string         `db:"IS_NULLABLE"`
+	ColumnDefault sql.NullString `db:"COLUMN_DEFAULT"`
+	DataType      string         `db:"DATA_TYPE"`
+	// MaxLength, NumericScale and DatetimePrecision are the size of text, decimal and time
+	// columns, not valid for others
+	MaxLength         sql.NullInt64 `db:"CHARACTER_MAXIMUM_LENGTH"`
+	NumericScale      sql.NullInt64 `db:"NUMERIC_SCALE"`
+	DatetimePrecision sql.NullInt64 `db:"DATETIME_PRECISION"`
+}
+
+// tableRef names a table, the schema is empty when not given and the database when the table is
+// in the one connected to.
+type tableRef struct {
+	database string
+	schema   string
+	name     string
+}
+
+// parseTableRef reads a table name optionally prefixed with its schema and database, like
+// sales.Orders or audit.dbo.Events, or the same with the names in brackets.
+func parseTableRef(s string) tableRef {
+	if ref, ok := parseQuotedTableRef(s); ok {
+		return ref
+	}
+	if database, rest, ok := strings.Cut(s, "."); ok && strings.Contains(rest, ".") {
+		schema, name, _ := strings.Cut(rest, ".")
+		return tableRef{database: database, schema: schema, name: name}
+	}
+	if schema, name, ok := strings.Cut(s, "."); ok {
+		return tableRef{schema: schema, name: name}
+	}
+	return tableRef{name: s}
+}
+
+// quotedTableRef matches a table name of one to three names in brackets, like [audit].[dbo].[Events].
+var quotedTableRef = regexp.MustCompile(`^\[((?:[^\]]|\]\])+)\](?:\.\[((?:[^\]]|\]\])+)\])?(?:\.\[((?:[^\]]|\]\])+)\])?$`)
+
+// parseQuotedTableRef reads a table name written as quoted does, ok false if it is not.
+func parseQuotedTableRef(s string) (tableRef, bool) {
+	m := quotedTableRef.FindStringSubmatch(s)
+	if m == nil {
+		return tableRef{}, false
+	}
+	var names []string
+	for _, name := range m[1:] {
+		if name != "" {
+			names = append(names, strings.ReplaceAll(name, "]]", "]"))
+		}
+	}
+	switch len(names) {
+	case 3:
+		return tableRef{database: names[0], schema: names[1], name: names[2]}, true
+	case 2:
+		return tableRef{schema: names[0], name: names[1]}, true
+	}
+	return tableRef{name: names[0]}, true
+}
+
+func (t tableRef) String() string {
+	switch {
+	case t.database != "":
+		// db..name is the table in the default schema of the database
+		return t.database + "." + t.schema + "." + t.name
+	case t.schema != "":
+		return t.schema + "." + t.name
+	}
+	return t.name
+}
+
+// quoted returns the name to use in statements.
+func (t tableRef) quoted() string {
+	return t.quotedWith(quoteName)
+}
+
+// quotedWith returns the name quoted by quote, the schema left empty in db..name.
+func (t tableRef) quotedWith(quote func(string) string) string {
+	name := quote(t.name)
+	if t.schema != "" {
+		name = quote(t.schema) + "." + name
+	}
+	if t.database != "" {
+		if t.schema == "" {
+			name = "." + name
+		}
+		name = quote(t.database) + "." + name
+	}
+	return name
+}
+
+// catalog returns the prefix of the system views of the database of the table in queries, empty
+// for the database connected to.
+func (t tableRef) catalog() string {
+	if t.database == "" {
+		return ""
+	}
+	return quoteName(t.database) + "."
+}
+
+// databaseID returns the expression of the id of the database of the table, for OBJECT_NAME and
+// OBJECT_SCHEMA_NAME.
+func (t tableRef) databaseID() string {
+	if t.database == "" {
+		return "DB_ID()"
+	}
+	return "DB_ID(N" + quoteString(t.database) + ")"
+}
+
+func quoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// tableInfo is the metadata of a target table needed to insert into it.
+type tableInfo struct {
+	ref             tableRef
+	schema          map[string]ColumnSchema
+	columns         []string // column names in table order
+	identityColumns []string
+	// computeColumns are the columns set by the server, computed or GENERATED ALWAYS, the ones
+	// of generatedColumns
+	computeColumns   []string
+	generatedColumns []string
+	primaryKey       []string
+	// encryptedColumns are the Always Encrypted columns, their values encrypted by the driver
+	encryptedColumns []string
+	// view is set for a view loaded through, by its INSTEAD OF triggers or into its base table
+	view bool
+	// dialect is the SQL of the server of the table
+	dialect dialect
+}
+
+// neverLoaded tells why values of the column are never inserted, if so: of a type never
+// inserted, computed or GENERATED ALWAYS.
+func (t *tableInfo) neverLoaded(col string) (string, bool) {
+	switch {
+	case slices.Contains(t.generatedColumns, col):
+		return "GENERATED ALWAYS, set by the server", true
+	case slices.Contains(t.computeColumns, col):
+		return "computed by the server", true
+	}
+	return skipReason(t.schema[col])
+}
+
+func (t *tableInfo) hasIdentity() bool {
+	return len(t.identityColumns) > 0
+}
+
+// quotedName returns the table name to use in statements on its server.
+func (t *tableInfo) quotedName() string {
+	return t.ref.quotedWith(t.dialect.quote)
+}
+
+func getTableInfo(db *sqlx.DB, table tableRef) (*tableInfo, error) {
+	d := dialectOf(db)
+	if _, ok := d.(sqlServer); !ok && table.database != "" {
+		return nil, fmt.Errorf("table %s: tables of other databases are for SQL Server", table)
+	}
+	info, err := d.tableInfo(db, table)
+	if err != nil {
+		return nil, err
+	}
+	info.dialect = d
+	return info, nil
+}
+
+func (sqlServer) tableInfo(db *sqlx.DB, table tableRef) (*tableInfo, error) {
+	objectType, err := getObjectType(db, table)
+	if err != nil {
+		return nil, err
+	}
+	if objectType == "SN" {
+		base, err := getSynonymBase(db, table)
+		if err != nil {
+			return nil, err
+		}
+		if t, err := getObjectType(db, base); err != nil || t == "SN" {
+			return nil, cmp.Or(err, fmt.Errorf("synonym %s refers to synonym %s", table, base))
+		}
+		logger().Debug("synonym resolved", "table", table.String(), "base", base.String())
+		// the rows go to the base table, IDENTITY_INSERT takes no synonym
+		return sqlServer{}.tableInfo(db, base)
+	}
+	schema, columns, err := getTableSchema(db, table)
+	if err != nil {
+		return nil, err
+	}
+	var identityColumns []string
+	// a view takes no IDENTITY_INSERT, the identity is its base table's business
+	if objectType != "V" {
+		identityColumns, err = getIdentityColumns(db, table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	computeColumns, generatedColumns, err := getComputeColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	primaryKey, err := getPrimaryKey(db, table)
+	if err != nil {
+		return nil, err
+	}
+	encryptedColumns, err := getEncryptedColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	return &tableInfo{
+		ref:              table,
+		schema:           schema,
+		columns:          columns,
+		identityColumns:  identityColumns,
+		computeColumns:   computeColumns,
+		generatedColumns: generatedColumns,
+		primaryKey:       primaryKey,
+		encryptedColumns: encryptedColumns,
+		view:             objectType == "V",
+	}, nil
+}
+
+// getObjectType returns the sys.objects type of the table, U for a table, V for a view, SN for a
+// synonym, empty if there is none.
+func getObjectType(db *sqlx.DB, table tableRef) (string, error) {
+	var types []string
+	query := `SELECT RTRIM(type) FROM ` + table.catalog() + `sys.objects WHERE object_id = OBJECT_ID(@p1)`
+	if err := db.Select(&types, query, table.quoted()); err != nil {
+		return "", err
+	}
+	if len(types) == 0 {
+		return "", nil
+	}
+	return types[0], nil
+}
+
+// getSynonymBase returns the object the synonym refers to, in the database of the synonym unless
+// it names another one.
+func getSynonymBase(db *sqlx.DB, table tableRef) (tableRef, error) {
+	var name string
+	query := `SELECT base_object_name FROM ` + table.catalog() + `sys.synonyms WHERE object_id = OBJECT_ID(@p1)`
+	if err := db.Get(&name, query, table.quoted()); err != nil {
+		return tableRef{}, err
+	}
+	base, ok := parseQuotedTableRef(name)
+	if !ok {
+		return tableRef{}, fmt.Errorf("synonym %s refers to %s, not a table of this server", table, name)
+	}
+	if base.database == "" {
+		base.database = table.database
+	}
+	return base, nil
+}
+
+// getTableSchema returns the table columns by name and the column names in table order.
+func getTableSchema(db *sqlx.DB, table tableRef) (map[string]ColumnSchema, []string, error) {
+	query := `
+SELECT COLUMN_NAME, IS_NULLABLE, COLUMN_DEFAULT, DATA_TYPE, CHARACTER_MAXIMUM_LENGTH, NUMERIC_SCALE, DATETIME_PRECISION
+FROM ` + table.catalog() + `INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_NAME = @p1 AND (@p2 = '' OR TABLE_SCHEMA = @p2)
+ORDER BY ORDINAL_POSITION`
+
+	var cols []ColumnSchema
+	if err := db.Select(&cols, query, table.name, table.schema); err != nil {
+		return nil, nil, err
+	}
+
+	schema := make(map[string]ColumnSchema)
+	names := make([]string, 0, len(cols))
+	for _, col := range cols {
+		schema[col.ColumnName] = col
+		names = append(names, col.ColumnName)
+	}
+	return schema, names, nil
+}
+
+func getIdentityColumns(db *sqlx.DB, table tableRef) ([]string, error) {
+	query := `
+SELECT name
+FROM ` + table.catalog() + `sys.identity_columns
+where OBJECT_NAME(object_id, ` + table.databaseID() + `) = @p1 AND (@p2 = '' OR OBJECT_SCHEMA_NAME(object_id, ` + table.databaseID() + `) = @p2)`
+	var res []string
+	if err := db.Select(&res, query, table.name, table.schema); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// getComputeColumns returns the computed columns of the table and the GENERATED ALWAYS ones, like
+// the period columns of temporal tables, the server sets both.
+func getComputeColumns(db *sqlx.DB, table tableRef) ([]string, []string, error) {
+	query := `
+SELECT name, CAST(CASE WHEN is_computed = 1 THEN 0 ELSE 1 END AS bit) AS generated
+FROM ` + table.catalog() + `sys.columns
+WHERE OBJECT_NAME(object_id, ` + table.databaseID() + `) = @p1 AND (@p2 = '' OR OBJECT_SCHEMA_NAME(object_id, ` + table.databaseID() + `) = @p2)
+  AND (is_computed = 1 OR generated_always_type <> 0)`
+	var cols []struct {
+		Name      string `db:"name"`
+		Generated bool   `db:"generated"`
+	}
+	if err := db.Select(&cols, query, table.name, table.schema); err != nil {
+		return nil, nil, err
+	}
+	var computed, generated []string
+	for _, col := range cols {
+		computed = append(computed, col.Name)
+		if col.Generated {
+			generated = append(generated, col.Name)
+		}
+	}
+	return computed, generated, nil
+}
+
+// getEncryptedColumns returns the Always Encrypted columns of the table.
+func getEncryptedColumns(db *sqlx.DB, table tableRef) ([]string, error) {
+	query := `
+SELECT name
+FROM ` + table.catalog() + `sys.columns
+WHERE OBJECT_NAME(object_id, ` + table.databaseID() + `) = @p1 AND (@p2 = '' OR OBJECT_SCHEMA_NAME(object_id, ` + table.databaseID() + `) = @p2)
+  AND encryption_type IS NOT NULL
+ORDER BY column_id`
+	var res []string
+	if err := db.Select(&res, query, table.name, table.schema); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func getPrimaryKey(db *sqlx.DB, table tableRef) ([]string, error) {
+	query := `
+SELECT kcu.COLUMN_NAME
+FROM ` + table.catalog() + `INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc
+JOIN ` + table.catalog() + `INFORMATION_SCHEMA.KEY_COLUMN_USAGE kcu
+  ON kcu.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND kcu.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+WHERE tc.CONSTRAINT_TYPE = 'PRIMARY KEY' AND tc.TABLE_NAME = @p1 AND (@p2 = '' OR tc.TABLE_SCHEMA = @p2)
+ORDER BY kcu.ORDINAL_POSITION`
+	var res []string
+	if err := db.Select(&res, query, table.name, table.schema); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,32 @@
+package loader
+
+import "testing"
+
+func TestParseTableRef(t *testing.T) {
+	tests := []struct {
+		s    string
+		want tableRef
+	}{
+		{"Orders", tableRef{name: "Orders"}},
+		{"sales.Orders", tableRef{schema: "sales", name: "Orders"}},
+		{"audit.dbo.Events", tableRef{database: "audit", schema: "dbo", name: "Events"}},
+		{"audit..Events", tableRef{database: "audit", name: "Events"}},
+		{"[Order Lines]", tableRef{name: "Order Lines"}},
+		{"[sales].[Order.Lines]", tableRef{schema: "sales", name: "Order.Lines"}},
+		{"[audit].[dbo].[Events]", tableRef{database: "audit", schema: "dbo", name: "Events"}},
+		{"[odd]]name]", tableRef{name: "odd]name"}},
+	}
+	for _, tt := range tests {
+		got := parseTableRef(tt.s)
+		if got != tt.want {
+			t.Errorf("parseTableRef(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+		// the default schema of another database has no quoted form of its own
+		if got.database != "" && got.schema == "" {
+			continue
+		}
+		if back := parseTableRef(got.quoted()); back != got {
+			t.Errorf("parseTableRef(%q) = %+v, want %+v", got.quoted(), back, got)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package loader
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// schemaOptions are the flags of the schema command.
+type schemaOptions struct {
+	conn   connOptions
+	log    logOptions
+	tables string
+	format string
+	output string
+}
+
+func (o *schemaOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.tables, "t", "", "comma separated tables (or schema.tables) to describe, all tables of the database if empty")
+	fs.StringVar(&o.format, "format", "json", "output format: json schema snapshot of the columns and keys, or sql CREATE TABLE scripts")
+	fs.StringVar(&o.output, "o", "", "file to write to instead of stdout")
+}
+
+func runSchema(cmd *command, args []string) {
+	var opts schemaOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.log.check()
+	if err == nil && opts.format != "json" && opts.format != "sql" {
+		err = fmt.Errorf("unknown format %q", opts.format)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+
+	var tables []tableRef
+	for _, name := range strings.Split(opts.tables, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tables = append(tables, parseTableRef(name))
+		}
+	}
+	if len(tables) == 0 {
+		tables, err = getTables(db)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+	}
+	defs := make([]*tableDefinition, 0, len(tables))
+	for _, ref := range tables {
+		def, err := getTableDefinition(db, ref)
+		handleError(err, dbErrorCode(err, TableInfoErrorCode))
+		defs = append(defs, def)
+	}
+
+	var w io.Writer = os.Stdout
+	if opts.output != "" {
+		f, err := os.Create(opts.output)
+		handleError(err, WriteScriptErrorCode)
+		defer f.Close()
+		w = f
+	}
+	if opts.format == "sql" {
+		for _, def := range defs {
+			_, err = fmt.Fprintf(w, "%s\nGO\n\n", def.createTable())
+			handleError(err, WriteScriptErrorCode)
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		handleError(enc.Encode(schemaSnapshot{Version: schemaSnapshotVersion, Tables: defs}), WriteScriptErrorCode)
+	}
+	logger().Info("schema written", "tables", len(defs), "format", opts.format)
+}
+
+// schemaSnapshotVersion is the version of the json format of the schema command. Fields are only
+// added within a version, a change breaking readers of the format gets a new one.
+const schemaSnapshotVersion = 1
+
+// schemaSnapshot is the json the schema command writes and validate -schema reads.
+type schemaSnapshot struct {
+	Version int                `json:"version"`
+	Tables  []*tableDefinition `json:"tables"`
+}
+
+// tableDefinition describes a table as its catalog views do.
+type tableDefinition struct {
+	Schema      string                 `json:"schema"`
+	Name        string                 `json:"name"`
+	Columns     []columnDefinition     `json:"columns"`
+	PrimaryKey  []string               `json:"primary_key,omitempty"`
+	ForeignKeys []foreignKeyDefinition `json:"foreign_keys,omitempty"`
+}
+
+// foreignKeyDefinition is a foreign key of a table, its columns in the order of the referenced
+// ones.
+type foreignKeyDefinition struct {
+	Name              string   `json:"name"`
+	Columns           []string `json:"columns"`
+	ReferencedSchema  string   `json:"referenced_schema"`
+	ReferencedTable   string   `json:"referenced_table"`
+	ReferencedColumns []string `json:"referenced_columns"`
+}
+
+// columnDefinition describes a column, lengths are in characters for text types and -1 for max.
+type columnDefinition struct {
+	Name      string              `json:"name"`
+	DataType  string              `json:"data_type"`
+	MaxLength int                 `json:"max_length,omitempty"`
+	Precision int                 `json:"precision,omitempty"`
+	Scale     int                 `json:"scale,omitempty"`
+	Nullable  bool                `json:"nullable"`
+	Default   string              `json:"default,omitempty"`
+	Identity  *identityDefinition `json:"identity,omitempty"`
+	// Computed is the expression of a computed column, Generated set for a GENERATED ALWAYS
+	// column, like the period columns of temporal tables
+	Computed  string `json:"computed,omitempty"`
+	Generated bool   `json:"generated,omitempty"`
+	Collation string `json:"collation,omitempty"`
+}
+
+// identityDefinition is the seed and increment of an identity column.
+type identityDefinition struct {
+	Seed      int64 `json:"seed"`
+	Increment int64 `json:"increment"`
+}
+
+// getTables lists the user tables of the database by schema and name.
+func getTables(db *sqlx.DB) ([]tableRef, error) {
+	query := `
+SELECT SCHEMA_NAME(schema_id) AS schema_name, name
+FROM sys.tables
+WHERE is_ms_shipped = 0
+ORDER BY schema_name, name`
+	var rows []struct {
+		Schema string `db:"schema_name"`
+		Name   string `db:"name"`
+	}
+	if err := db.Select(&rows, query); err != nil {
+		return nil, err
+	}
+	tables := make([]tableRef, len(rows))
+	for i, row := range rows {
+		tables[i] = tableRef{schema: row.Schema, name: row.Name}
+	}
+	return tables, nil
+}
+
+func getTableDefinition(db *sqlx.DB, table tableRef) (*tableDefinition, error) {
+	query := `
+SELECT OBJECT_SCHEMA_NAME(c.object_id) AS schema_name, c.name,
+  COALESCE(bt.name, t.name) AS data_type, c.max_length, c.precision, c.scale, c.is_nullable, c.is_identity,
+  CAST(CASE WHEN c.generated_always_type <> 0 THEN 1 ELSE 0 END AS bit) AS generated,
+  CONVERT(bigint, ic.seed_value) AS seed_value, CONVERT(bigint, ic.increment_value) AS increment_value,
+  dc.definition AS default_definition, cc.definition AS computed_definition, c.collation_name
+FROM sys.columns c
+JOIN sys.tables tb ON tb.object_id = c.object_id
+JOIN sys.types t ON t.user_type_id = c.user_type_id
+LEFT JOIN sys.types bt ON t.is_user_defined = 1 AND t.is_assembly_type = 0 AND bt.user_type_id = t.system_type_id
+LEFT JOIN sys.default_constraints dc ON dc.object_id = c.default_object_id
+LEFT JOIN sys.identity_columns ic ON ic.object_id = c.object_id AND ic.column_id = c.column_id
+LEFT JOIN sys.computed_columns cc ON cc.object_id = c.object_id AND cc.column_id = c.column_id
+WHERE tb.name = @p1 AND (@p2 = '' OR OBJECT_SCHEMA_NAME(c.object_id) = @p2)
+ORDER BY c.column_id`
+	var cols []struct {
+		Schema    string         `db:"schema_name"`
+		Name      string         `db:"name"`
+		DataType  string         `db:"data_type"`
+		MaxLength int            `db:"max_length"`
+		Precision int            `db:"precision"`
+		Scale     int            `db:"scale"`
+		Nullable  bool           `db:"is_nullable"`
+		Identity  bool           `db:"is_identity"`
+		Generated bool           `db:"generated"`
+		Seed      sql.NullInt64  `db:"seed_value"`
+		Increment sql.NullInt64  `db:"increment_value"`
+		Default   sql.NullString `db:"default_definition"`
+		Computed  sql.NullString `db:"computed_definition"`
+		Collation sql.NullString `db:"collation_name"`
+	}
+	if err := db.Select(&cols, query, table.name, table.schema); err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	def := &tableDefinition{Schema: cols[0].Schema, Name: table.name}
+	for _, col := range cols {
+		if col.Schema != def.Schema {
+			return nil, fmt.Errorf("table %s is in more than one schema, give it as schema.table", table)
+		}
+		maxLength := col.MaxLength
+		switch {
+		case maxLength == -1:
+		case col.DataType == "nchar" || col.DataType == "nvarchar":
+			maxLength /= 2
+		case !strings.HasSuffix(col.DataType, "char") && !strings.HasSuffix(col.DataType, "binary"):
+			// the storage size of fixed size types
+			maxLength = 0
+		}
+		precision, scale := 0, 0
+		switch col.DataType {
+		case "decimal", "numeric":
+			precision, scale = col.Precision, col.Scale
+		case "datetime2", "datetimeoffset", "time":
+			scale = col.Scale
+		}
+		var identity *identityDefinition
+		if col.Identity {
+			identity = &identityDefinition{Seed: col.Seed.Int64, Increment: col.Increment.Int64}
+		}
+		def.Columns = append(def.Columns, columnDefinition{
+			Name:      col.Name,
+			DataType:  col.DataType,
+			MaxLength: maxLength,
+			Precision: precision,
+			Scale:     scale,
+			Nullable:  col.Nullable,
+			Identity:  identity,
+			Default:   col.Default.String,
+			Computed:  col.Computed.String,
+			Generated: col.Generated,
+			Collation: col.Collation.String,
+		})
+	}
+	ref := tableRef{schema: def.Schema, name: def.Name}
+	var err error
+	if def.PrimaryKey, err = getPrimaryKey(db, ref); err != nil {
+		return nil, err
+	}
+	def.ForeignKeys, err = getForeignKeys(db, ref)
+	return def, err
+}
+
+// getForeignKeys reads the foreign keys of the table by name.
+func getForeignKeys(db *sqlx.DB, table tableRef) ([]foreignKeyDefinition, error) {
+	var rows []struct {
+		Name             string `db:"fk_name"`
+		Column           string `db:"column_name"`
+		ReferencedSchema string `db:"referenced_schema"`
+		ReferencedTable  string `db:"referenced_table"`
+		ReferencedColumn string `db:"referenced_column"`
+	}
+	err := db.Select(&rows, `
+SELECT fk.name AS fk_name, COL_NAME(fkc.parent_object_id, fkc.parent_column_id) AS column_name,
+  OBJECT_SCHEMA_NAME(fk.referenced_object_id) AS referenced_schema, OBJECT_NAME(fk.referenced_object_id) AS referenced_table,
+  COL_NAME(fkc.referenced_object_id, fkc.referenced_column_id) AS referenced_column
+FROM sys.foreign_keys fk
+JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id
+WHERE fk.parent_object_id = OBJECT_ID(@p1)
+ORDER BY fk.name, fkc.constraint_column_id`, table.quoted())
+	if err != nil {
+		return nil, err
+	}
+	var fks []foreignKeyDefinition
+	for _, row := range rows {
+		if n := len(fks); n == 0 || fks[n-1].Name != row.Name {
+			fks = append(fks, foreignKeyDefinition{Name: row.Name, ReferencedSchema: row.ReferencedSchema, ReferencedTable: row.ReferencedTable})
+		}
+		fk := &fks[len(fks)-1]
+		fk.Columns = append(fk.Columns, row.Column)
+		fk.ReferencedColumns = append(fk.ReferencedColumns, row.ReferencedColumn)
+	}
+	return fks, nil
+}
+
+// typeName writes the column type as declared, with its length or precision.
+func (c columnDefinition) typeName() string {
+	switch {
+	case c.MaxLength == -1:
+		return c.DataType + "(max)"
+	case c.MaxLength > 0:
+		return fmt.Sprintf("%s(%d)", c.DataType, c.MaxLength)
+	case c.DataType == "decimal" || c.DataType == "numeric":
+		return fmt.Sprintf("%s(%d, %d)", c.DataType, c.Precision, c.Scale)
+	case c.DataType == "datetime2" || c.DataType == "datetimeoffset" || c.DataType == "time":
+		return fmt.Sprintf("%s(%d)", c.DataType, c.Scale)
+	}
+	return c.DataType
+}
+
+// createTable writes the CREATE TABLE statement of the table.
+func (t *tableDefinition) createTable() string {
+	var lines []string
+	for _, col := range t.Columns {
+		line := "    " + quoteName(col.Name)
+		if col.Computed != "" {
+			lines = append(lines, line+" AS "+col.Computed)
+			continue
+		}
+		line += " " + col.typeName()
+		if col.Collation != "" {
+			line += " COLLATE " + col.Collation
+		}
+		if col.Identity != nil {
+			line += fmt.Sprintf(" IDENTITY(%d, %d)", col.Identity.Seed, col.Identity.Increment)
+		}
+		if col.Nullable {
+			line += " NULL"
+		} else {
+			line += " NOT NULL"
+		}
+		if col.Default != "" {
+			line += " DEFAULT " + col.Default
+		}
+		lines = append(lines, line)
+	}
+	if len(t.PrimaryKey) > 0 {
+		keys := make([]string, len(t.PrimaryKey))
+		for i, key := range t.PrimaryKey {
+			keys[i] = quoteName(key)
+		}
+		lines = append(lines, "    PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	ref := tableRef{schema: t.Schema, name: t.Name}
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n);", ref.quoted(), strings.Join(lines, ",\n"))
+}
+
+// readSchemaSnapshot reads the tables of a json file written by the schema command, also the
+// bare array of tables of the versions before the format had one.
+func readSchemaSnapshot(path string) ([]*tableDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot schemaSnapshot
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &snapshot.Tables)
+	} else if err = json.Unmarshal(data, &snapshot); err == nil && snapshot.Version > schemaSnapshotVersion {
+		err = fmt.Errorf("version %d, newer than the %d read", snapshot.Version, schemaSnapshotVersion)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schema snapshot %s: %w", path, err)
+	}
+	return snapshot.Tables, nil
+}
+
+// tableInfo returns the info of the table as looked up from the database, but for the Always
+// Encrypted columns the definition has not.
+func (t *tableDefinition) tableInfo() *tableInfo {
+	info := &tableInfo{ref: tableRef{schema: t.Schema, name: t.Name}, schema: make(map[string]ColumnSchema),
+		primaryKey: t.PrimaryKey, dialect: sqlServer{}}
+	for _, col := range t.Columns {
+		info.schema[col.Name] = col.columnSchema()
+		info.columns = append(info.columns, col.Name)
+		if col.Identity != nil {
+			info.identityColumns = append(info.identityColumns, col.Name)
+		}
+		if col.Computed != "" || col.Generated {
+			info.computeColumns = append(info.computeColumns, col.Name)
+		}
+		if col.Generated {
+			info.generatedColumns = append(info.generatedColumns, col.Name)
+		}
+	}
+	return info
+}
+
+// columnSchema returns the column as INFORMATION_SCHEMA.COLUMNS describes it.
+func (c columnDefinition) columnSchema() ColumnSchema {
+	col := ColumnSchema{ColumnName: c.Name, IsNullable: "NO", DataType: c.DataType,
+		ColumnDefault: sql.NullString{String: c.Default, Valid: c.Default != ""}}
+	if c.Nullable {
+		col.IsNullable = "YES"
+	}
+	if c.MaxLength != 0 {
+		col.MaxLength = sql.NullInt64{Int64: int64(c.MaxLength), Valid: true}
+	}
+	switch c.DataType {
+	case "decimal", "numeric":
+		col.NumericScale = sql.NullInt64{Int64: int64(c.Scale), Valid: true}
+	case "bigint", "int", "smallint", "tinyint":
+		col.NumericScale = sql.NullInt64{Valid: true}
+	case "money", "smallmoney":
+		col.NumericScale = sql.NullInt64{Int64: 4, Valid: true}
+	case "datetime2", "datetimeoffset", "time":
+		col.DatetimePrecision = sql.NullInt64{Int64: int64(c.Scale), Valid: true}
+	case "datetime":
+		col.DatetimePrecision = sql.NullInt64{Int64: 3, Valid: true}
+	case "date", "smalldatetime":
+		col.DatetimePrecision = sql.NullInt64{Valid: true}
+	}
+	return col
+}
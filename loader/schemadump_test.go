@@ -0,0 +1,110 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadSchemaSnapshot(t *testing.T) {
+	const orders = `{"schema": "dbo", "name": "Orders", "columns": [
+		{"name": "Id", "data_type": "int", "nullable": false, "identity": {"seed": 1, "increment": 1}},
+		{"name": "Total", "data_type": "decimal", "precision": 10, "scale": 2, "nullable": true}
+	], "primary_key": ["Id"]}`
+	tests := []struct {
+		name    string
+		json    string
+		want    []string
+		wantErr string
+	}{
+		{name: "version 0 array", json: "[" + orders + "]", want: []string{"dbo.Orders"}},
+		{name: "version 0 array with space", json: "\n  [" + orders + "]", want: []string{"dbo.Orders"}},
+		{name: "version 1", json: `{"version": 1, "tables": [` + orders + `]}`, want: []string{"dbo.Orders"}},
+		{name: "empty", json: `{"version": 1, "tables": []}`},
+		{name: "newer version", json: `{"version": 2, "tables": []}`, wantErr: "version 2, newer than the 1 read"},
+		{name: "invalid", json: `{"version": 1, "tables": {}}`, wantErr: "schema snapshot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "schema.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			defs, err := readSchemaSnapshot(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, def := range defs {
+				got = append(got, def.Schema+"."+def.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("tables %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableDefinitionInfo(t *testing.T) {
+	def := &tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int", Identity: &identityDefinition{Seed: 1, Increment: 1}},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50},
+		{Name: "Total", DataType: "decimal", Precision: 10, Scale: 2, Nullable: true, Default: "((0))"},
+		{Name: "Created", DataType: "datetime2", Scale: 3, Nullable: true},
+		{Name: "Net", DataType: "decimal", Computed: "([Total]/(1.19))"},
+		{Name: "ValidFrom", DataType: "datetime2", Scale: 7, Generated: true},
+	}}
+	info := def.tableInfo()
+	if info.ref != (tableRef{schema: "dbo", name: "Orders"}) {
+		t.Errorf("ref %v", info.ref)
+	}
+	if !slices.Equal(info.identityColumns, []string{"Id"}) {
+		t.Errorf("identity columns %v", info.identityColumns)
+	}
+	if !slices.Equal(info.computeColumns, []string{"Net", "ValidFrom"}) || !slices.Equal(info.generatedColumns, []string{"ValidFrom"}) {
+		t.Errorf("computed columns %v, generated %v", info.computeColumns, info.generatedColumns)
+	}
+	customer, total, created := info.schema["Customer"], info.schema["Total"], info.schema["Created"]
+	if customer.IsNullable != "NO" || customer.MaxLength.Int64 != 50 || !isRequired(customer) {
+		t.Errorf("Customer %+v", customer)
+	}
+	if total.IsNullable != "YES" || total.NumericScale.Int64 != 2 || total.ColumnDefault.String != "((0))" {
+		t.Errorf("Total %+v", total)
+	}
+	if !created.DatetimePrecision.Valid || created.DatetimePrecision.Int64 != 3 {
+		t.Errorf("Created %+v", created)
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	def := &tableDefinition{Schema: "sales", Name: "Orders", PrimaryKey: []string{"Id", "Line"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int", Identity: &identityDefinition{Seed: 100, Increment: 1}},
+		{Name: "Line", DataType: "smallint"},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50, Collation: "Latin1_General_CI_AS"},
+		{Name: "Notes", DataType: "nvarchar", MaxLength: -1, Nullable: true},
+		{Name: "Total", DataType: "decimal", Precision: 10, Scale: 2, Nullable: true, Default: "((0))"},
+		{Name: "Created", DataType: "datetime2", Scale: 3},
+		{Name: "Net", DataType: "decimal", Computed: "([Total]/(1.19))"},
+	}}
+	want := `CREATE TABLE [sales].[Orders] (
+    [Id] int IDENTITY(100, 1) NOT NULL,
+    [Line] smallint NOT NULL,
+    [Customer] nvarchar(50) COLLATE Latin1_General_CI_AS NOT NULL,
+    [Notes] nvarchar(max) NULL,
+    [Total] decimal(10, 2) NULL DEFAULT ((0)),
+    [Created] datetime2(3) NOT NULL,
+    [Net] AS ([Total]/(1.19)),
+    PRIMARY KEY ([Id], [Line])
+);`
+	if got := def.createTable(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// scriptBatchRows is the number of rows between GO batch separators in a script.
+const scriptBatchRows = 1000
+
+var paramRef = regexp.MustCompile(`@p(\d+)\b`)
+
+// sqlScript writes statements with their values as literals to a script
+// run by sqlcmd or SSMS, in GO separated batches.
+type sqlScript struct {
+	w *bufio.Writer
+	// identityTable is the table of the current file with identity insert on
+	identityTable string
+	batchRows     int
+}
+
+func newSqlScript(w io.Writer) *sqlScript {
+	s := &sqlScript{w: bufio.NewWriter(w)}
+	fmt.Fprintf(s.w, "-- generated by uptomssql at %s\nSET NOCOUNT ON;\nGO\n", time.Now().Format(time.RFC3339))
+	return s
+}
+
+func (s *sqlScript) beginFile(fileName string, table *tableInfo) error {
+	fmt.Fprintf(s.w, "\n-- %s => %s\n", fileName, table.ref)
+	s.batchRows = 0
+	if table.hasIdentity() {
+		s.identityTable = table.ref.quoted()
+		fmt.Fprintf(s.w, "SET IDENTITY_INSERT %s ON;\n", s.identityTable)
+	}
+	return nil
+}
+
+func (s *sqlScript) writeQuery(query string) error {
+	_, err := fmt.Fprintln(s.w, query)
+	return err
+}
+
+// writeStatement writes the statement with its parameters replaced by the literal values.
+func (s *sqlScript) writeStatement(stmt *insertStatement) error {
+	var err error
+	query := paramRef.ReplaceAllStringFunc(stmt.query, func(ref string) string {
+		i, _ := strconv.Atoi(ref[2:])
+		lit, e := sqlLiteral(stmt.values[i-1])
+		if e != nil && err == nil {
+			err = e
+		}
+		return lit
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.writeQuery(query); err != nil {
+		return err
+	}
+	s.batchRows++
+	if s.batchRows%scriptBatchRows == 0 {
+		_, err = fmt.Fprintln(s.w, "GO")
+	}
+	return err
+}
+
+func (s *sqlScript) endFile() error {
+	if s.identityTable != "" {
+		fmt.Fprintf(s.w, "SET IDENTITY_INSERT %s OFF;\n", s.identityTable)
+		s.identityTable = ""
+	}
+	_, err := fmt.Fprintln(s.w, "GO")
+	return err
+}
+
+func (s *sqlScript) close() error {
+	return s.w.Flush()
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlLiteral writes a value as converted for binding as a T-SQL literal.
+func sqlLiteral(val any) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", fmt.Errorf("%v has no sql literal", v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		return "N" + quoteString(v), nil
+	case mssql.VarChar:
+		return quoteString(string(v)), nil
+	case mssql.VarCharMax:
+		return quoteString(string(v)), nil
+	case []byte:
+		return "0x" + strings.ToUpper(hex.EncodeToString(v)), nil
+	case mssql.UniqueIdentifier:
+		return quoteString(v.String()), nil
+	case civil.Date:
+		return quoteString(v.String()), nil
+	case civil.Time:
+		return quoteString(timeLiteral(v)), nil
+	case civil.DateTime:
+		return quoteString(v.Date.String() + "T" + timeLiteral(v.Time)), nil
+	case mssql.DateTime1:
+		return quoteString(time.Time(v).Format("2006-01-02T15:04:05.000")), nil
+	case time.Time:
+		return quoteString(v.Format("2006-01-02T15:04:05.9999999Z07:00")), nil
+	}
+	return "", fmt.Errorf("no sql literal for %T", val)
+}
+
+// timeLiteral writes the time with the 7 fractional digits the server takes at most.
+func timeLiteral(t civil.Time) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%07d", t.Hour, t.Minute, t.Second, t.Nanosecond/100)
+}
@@ -0,0 +1,350 @@
+package loader
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v3"
+)
+
+// serveOptions are the flags of the serve command.
+type serveOptions struct {
+	conn connOptions
+	log  logOptions
+	addr string
+	// token is the bearer token requests must give, none if empty
+	token       string
+	maxBody     int64
+	lockTimeout time.Duration
+}
+
+func (o *serveOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.StringVar(&o.addr, "addr", "localhost:8080", "address to listen on, host:port")
+	fs.StringVar(&o.token, "token", "", "bearer token requests must give in the Authorization header, none needed if empty")
+	fs.Int64Var(&o.maxBody, "max-body", 100, "largest request body accepted in MB")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", time.Minute, "how long a load waits for another one loading the same database to finish")
+}
+
+func runServe(cmd *command, args []string) {
+	var opts serveOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.log.check()
+	if err == nil && opts.maxBody <= 0 {
+		err = fmt.Errorf("invalid -max-body %d", opts.maxBody)
+	}
+	if err == nil && opts.token == "" && !isLoopback(opts.addr) {
+		// the requests load and delete rows, the network must not get to them unasked
+		err = fmt.Errorf("-token is needed to listen on %s, other than localhost", opts.addr)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	serve(&opts)
+}
+
+// isLoopback tells whether the listen address, host:port, takes connections of the local
+// machine only.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loadServer loads the data of the requests, each in an upload run of its own.
+type loadServer struct {
+	opts *serveOptions
+	exe  string
+	// ctx is done when the server stops, interrupting the runs
+	ctx    context.Context
+	health *healthState
+}
+
+// serve answers load requests until interrupted, the runs going on get a minute to stop.
+func serve(opts *serveOptions) {
+	exe, err := os.Executable()
+	handleError(err, InternalErrorCode)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the connection is for the readiness probe, it is not checked at start
+	db, err := sqlx.Open("sqlserver", opts.conn.connectionString())
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+
+	s := &loadServer{opts: opts, exe: exe, ctx: ctx, health: newHealthState(db)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tables/{target}", s.handleLoad)
+	s.health.addRoutes(mux)
+	server := &http.Server{Addr: opts.addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		logger().Info("server stopping")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger().Error("server stop", "err", err)
+		}
+	}()
+	logger().Info("server started", "addr", opts.addr, "pid", os.Getpid())
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		handleError(err, InternalErrorCode)
+	}
+	<-done
+	logger().Info("server stopped")
+}
+
+// loadParams are the query parameters going to the manifest entry of the files, the others
+// are flags of the upload run.
+var loadParams = []string{"mode", "truncate", "delimiter", "encoding"}
+
+// runParams are the query parameters passed as flags to the upload run, with their type.
+var runParams = map[string]string{"batch-size": "int", "max-errors": "int", "dry-run": "bool", "track": "bool", "force": "bool"}
+
+// handleLoad loads the body of POST /tables/{table}:load into the table, a json or csv data
+// file or multipart files, and answers the run result.
+func (s *loadServer) handleLoad(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("target"), ":load")
+	if !ok || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.opts.token != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+	}
+	ref := parseTableRef(name)
+	entry := manifestEntry{fileOptions: fileOptions{Table: ref.name, Schema: ref.schema, Database: ref.database}}
+	query := r.URL.Query()
+	entry.Mode = query.Get("mode")
+	entry.Delimiter = query.Get("delimiter")
+	entry.Encoding = query.Get("encoding")
+	var err error
+	if v := query.Get("truncate"); v != "" {
+		if entry.Truncate, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("truncate: invalid value %q", v))
+			return
+		}
+	}
+	if err := entry.check(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var flags []string
+	for param, values := range query {
+		kind, ok := runParams[param]
+		switch {
+		case ok && kind == "int":
+			_, err = strconv.Atoi(values[0])
+		case ok && kind == "bool":
+			_, err = strconv.ParseBool(values[0])
+		case !slices.Contains(loadParams, param):
+			err = errors.New("unknown parameter")
+		default:
+			continue
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", param, err))
+			return
+		}
+		flags = append(flags, fmt.Sprintf("-%s=%s", param, values[0]))
+	}
+
+	dir, err := os.MkdirTemp("", "uptomssql-serve-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	r.Body = http.MaxBytesReader(w, r.Body, s.opts.maxBody<<20)
+	files, status, err := saveBody(r, dir)
+	if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	m := manifest{}
+	for _, file := range files {
+		entry.File = file
+		m.Files = append(m.Files, entry)
+	}
+	data, err := yaml.Marshal(&m)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, manifestNames[0]), data, 0o644)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resultPath := filepath.Join(dir, "result.json")
+	args, env := s.runArgs(dir, resultPath, flags)
+	logger().Info("load request", "table", ref.String(), "files", len(files), "remote", r.RemoteAddr)
+	s.health.addQueued(1)
+	start := time.Now()
+	code := runChild(s.ctx, s.exe, args, env)
+	s.health.addQueued(-1)
+	s.health.runDone(start, code)
+	result, err := os.ReadFile(resultPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("run ended without result, code %d: %s", code, exitCodeDescription[code]))
+		return
+	}
+	var run RunResult
+	if err := json.Unmarshal(result, &run); err == nil {
+		s.health.metrics.addFiles(&run)
+	}
+	logger().Info("load request done", "table", ref.String(), "code", code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(loadStatus(code))
+	w.Write(result)
+}
+
+// runArgs returns the arguments and the environment of the upload run loading the files of dir
+// with the flags, its result written to resultPath.
+func (s *loadServer) runArgs(dir, resultPath string, flags []string) (args, env []string) {
+	args = []string{"upload", "-d", dir, "-yes", "-output", "json", "-output-file", resultPath,
+		"-lock-timeout", s.opts.lockTimeout.String(), "-log-format", s.opts.log.format,
+		"-s", s.opts.conn.dataSource, "-c", s.opts.conn.initialCatalog, "-u", s.opts.conn.userId}
+	// the password goes in the environment, the arguments are seen by every user of the machine
+	env = append(os.Environ(), passwordEnv+"="+s.opts.conn.password)
+	// the runs log as the server does
+	switch {
+	case s.opts.log.quiet:
+		args = append(args, "-q")
+	case s.opts.log.verbose:
+		args = append(args, "-v")
+	}
+	if s.opts.log.file != "" {
+		args = append(args, "-log-file", s.opts.log.file)
+	}
+	if s.opts.log.noColor {
+		args = append(args, "-no-color")
+	}
+	return append(args, flags...), env
+}
+
+// saveBody writes the data of the request to files in dir and returns their names, the body
+// or every file of a multipart form. The status is the one to answer on error.
+func saveBody(r *http.Request, dir string) ([]string, int, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var file string
+	switch mediaType {
+	case "application/json":
+		file = "body.json"
+	case "text/csv":
+		file = "body.csv"
+	case "multipart/form-data":
+	default:
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q, want application/json, text/csv or multipart/form-data", mediaType)
+	}
+	if file != "" {
+		if err := saveFile(filepath.Join(dir, file), r.Body); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		return []string{file}, 0, nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	var files []string
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if part.FileName() == "" {
+			// a form field, not a file
+			continue
+		}
+		fileName := filepath.Base(part.FileName())
+		if _, err := getFileFormat(strings.TrimPrefix(filepath.Ext(fileName), ".")); err != nil {
+			return nil, http.StatusUnsupportedMediaType, fmt.Errorf("%s: %w", fileName, err)
+		}
+		// the files keep their names in the run result, each in a dir of its own
+		file := filepath.Join(strconv.Itoa(len(files)+1), fileName)
+		if err := os.Mkdir(filepath.Join(dir, filepath.Dir(file)), 0o755); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if err := saveFile(filepath.Join(dir, file), part); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, http.StatusBadRequest, errors.New("no file in the form")
+	}
+	return files, 0, nil
+}
+
+func saveFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// loadStatus is the http status answering a run ended with the exit code.
+func loadStatus(code AppExitCode) int {
+	switch code {
+	case SuccessCode, PartialSuccessCode:
+		return http.StatusOK
+	case ValidationErrorCode, ConversionErrorCode, ConstraintErrorCode, UnmarshalErrorCode, ReadFileErrorCode, TableInfoErrorCode:
+		return http.StatusUnprocessableEntity
+	case LockedCode:
+		return http.StatusConflict
+	case ConnectErrorCode, ConnectionLostErrorCode, InterruptedCode:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError answers the error as json.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
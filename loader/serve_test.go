@@ -0,0 +1,42 @@
+package loader
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "localhost:8080", want: true},
+		{addr: "127.0.0.1:80", want: true},
+		{addr: "[::1]:80", want: true},
+		{addr: ":8080", want: false},
+		{addr: "0.0.0.0:8080", want: false},
+		{addr: "10.0.0.5:80", want: false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.addr); got != tt.want {
+			t.Errorf("isLoopback(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRunArgsPassword(t *testing.T) {
+	s := &loadServer{opts: &serveOptions{}}
+	s.opts.conn.dataSource = "db1"
+	s.opts.conn.password = "secret"
+	args, env := s.runArgs("dir", "dir/result.json", []string{"-mode", "sync"})
+	// the arguments of a process are seen by every user of the machine
+	if slices.Contains(args, "-p") || slices.Contains(args, "secret") {
+		t.Errorf("args %v, want no password", args)
+	}
+	if !slices.Contains(env, passwordEnv+"=secret") {
+		t.Errorf("environment has no %s", passwordEnv)
+	}
+	if !slices.Equal(args[len(args)-2:], []string{"-mode", "sync"}) {
+		t.Errorf("args %v, want the flags of the request last", args)
+	}
+}
@@ -0,0 +1,140 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v3"
+)
+
+// snapshotEntry is a file of the snapshot manifest, with its table only as the other options are the defaults.
+type snapshotEntry struct {
+	File     string `yaml:"file"`
+	Table    string `yaml:"table"`
+	Schema   string `yaml:"schema,omitempty"`
+	Database string `yaml:"database,omitempty"`
+}
+
+// snapshotTables exports all rows of the tables to json data files in dir, with a manifest listing
+// them in load order, so the dir loads back as is and restore puts the tables back.
+func snapshotTables(db *sqlx.DB, tables []tableRef, dir string, conn *connOptions, log *slog.Logger) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var m struct {
+		Files []snapshotEntry `yaml:"files"`
+	}
+	width := max(2, len(strconv.Itoa(len(tables))))
+	for i, ref := range tables {
+		table, err := getTableInfo(db, ref)
+		if err != nil {
+			return err
+		}
+		if len(table.columns) == 0 {
+			return fmt.Errorf("table %s not found", ref)
+		}
+		name := fmt.Sprintf("%0*d_%s.json", width, i+1, ref)
+		rows, err := exportTable(db, table, tableExport{}, filepath.Join(dir, name), &exportOptions{format: "json"})
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		log.Info("table snapshot written", "table", ref.String(), "file", name, "rows", rows)
+		m.Files = append(m.Files, snapshotEntry{File: name, Table: ref.name, Schema: ref.schema, Database: ref.database})
+	}
+	data, err := yaml.Marshal(&m)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("# snapshot of server %s, database %s at %s, put back with: uptomssql restore %s\n",
+		conn.dataSource, conn.initialCatalog, time.Now().Format(time.RFC3339), dir)
+	return os.WriteFile(filepath.Join(dir, manifestNames[0]), append([]byte(header), data...), 0o644)
+}
+
+// restoreOptions are the flags of the restore command.
+type restoreOptions struct {
+	conn        connOptions
+	log         logOptions
+	yes         bool
+	batchSize   int
+	lockTimeout time.Duration
+}
+
+func (o *restoreOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	fs.BoolVar(&o.yes, "yes", false, "do not ask to confirm deleting table rows on a server other than localhost")
+	fs.IntVar(&o.batchSize, "batch-size", 500, "rows per transaction, 0 commits every row on its own")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 0, "how long to wait for another run loading the same database to finish, 0 fails right away")
+}
+
+func runRestore(cmd *command, args []string) {
+	var opts restoreOptions
+	fs := newFlagSet(cmd)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: uptomssql %s [flags] <snapshot dir>\n\n%s\n\n", cmd.name, cmd.summary)
+		fs.PrintDefaults()
+		printReturnCodes()
+	}
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.log.check()
+	if err == nil && fs.NArg() != 1 {
+		err = errors.New("give the snapshot dir to restore")
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+	restore(&opts, fs.Arg(0))
+}
+
+// restore deletes all rows of the tables of the snapshot, children first, and loads the snapshot files.
+func restore(opts *restoreOptions, dir string) {
+	m, err := readManifest(dir)
+	handleError(err, ReadFileErrorCode)
+	if m == nil {
+		handleError(fmt.Errorf("%s is not a snapshot, it has no manifest", dir), ReadDirErrorCode)
+	}
+	source := &sourceOptions{file: fileOptions{Mode: InsertMode, Delimiter: ";"}, nameTmpl: defaultNameTemplate, conv: conversionOptions{SRID: 4326}}
+	fsrc, err := newFileSource(source, logger())
+	handleError(err, ReadDirErrorCode)
+	plans, _, err := fsrc.planFiles(m.files(dir))
+	handleError(err, ReadFileErrorCode)
+	tables, _ := groupByTable(plans)
+
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	lock, err := acquireRunLock(context.Background(), db, opts.lockTimeout)
+	handleError(err, dbErrorCode(err, LockedCode))
+	defer lock.release()
+	if !opts.yes {
+		names := make([]string, len(tables))
+		for i, ref := range tables {
+			names[i] = ref.String() + ": all rows"
+		}
+		handleError(confirmDeletes(opts.conn.dataSource, opts.conn.initialCatalog, names), NotConfirmedCode)
+	}
+	for _, ref := range slices.Backward(tables) {
+		query := fmt.Sprintf("DELETE FROM %s;", ref.quoted())
+		logger().Debug("query", "table", ref.String(), "sql", query)
+		res, err := db.Exec(query)
+		handleError(err, dbErrorCode(err, InsertDataErrorCode))
+		n, _ := res.RowsAffected()
+		logger().Info("table rows deleted", "table", ref.String(), "rows", n)
+	}
+	upOpts := &uploadOptions{sourceOptions: *source, conn: opts.conn, log: opts.log, batchSize: opts.batchSize}
+	u := &uploader{ctx: context.Background(), db: db, opts: upOpts, summary: newRunSummary(), result: newRunResult("restore"), out: os.Stdout, plans: plans,
+		tables: newTableCache(db, logger()), log: logger()}
+	handleError(u.uploadFiles(plans), InsertDataErrorCode)
+	logger().Info("restore done", "snapshot", dir, "tables", len(tables))
+}
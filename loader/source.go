@@ -0,0 +1,551 @@
+package loader
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// sourceOptions are the flags selecting the data files and how to read them.
+type sourceOptions struct {
+	dirPaths  stringList
+	filePath  string
+	tableName string
+	recursive bool
+	symlinks  string
+	nameTmpl  string
+	file      fileOptions
+	conv      conversionOptions
+	only      string
+	exclude   string
+	// maskFile is the rules file masking column values before they are loaded
+	maskFile string
+	// mappingFile is the file mapping the columns of the files to the tables
+	mappingFile string
+	// hintsFile is the file of the parse rules of columns with unusual formats
+	hintsFile string
+	// auditUser is the user audit columns are filled with, the OS user if empty
+	auditUser string
+	// inject are the values set in the columns of that name of every row
+	inject map[string]any
+	// selection are the rows of every file loaded, all if zero
+	selection rowSelection
+	// columns and skipColumns are the columns of the files loaded and left out, by table
+	columns, skipColumns map[string][]string
+	// columnTimezones are the timezones of columns by table, all tables under ""
+	columnTimezones map[string]map[string]*time.Location
+	// absent are the policies of the columns missing or null in a row by table, all tables under ""
+	absent map[string]map[string]string
+	// maxMemory is the size of the rows held in memory, the others spilled to disk, 0 for no limit
+	maxMemory int64
+}
+
+func (o *sourceOptions) addFlags(fs *flag.FlagSet) {
+	fs.Var(&o.dirPaths, "d", "path or glob of dir or files with data, repeatable (default test_data)")
+	fs.StringVar(&o.filePath, "f", "", "path to a single data file instead of the dir")
+	fs.StringVar(&o.tableName, "table", "", "target table (or schema.table, database.schema.table) for the -f file, instead of the one in the file name")
+	fs.StringVar(&o.nameTmpl, "name-template", defaultNameTemplate, "data file name template of {order}, {schema}, {table}, {ext} and ignored {fields}")
+	fs.StringVar(&o.only, "only", "", "comma separated table names or regexps to load, others are skipped")
+	fs.StringVar(&o.exclude, "exclude", "", "comma separated table names or regexps to skip")
+	fs.BoolVar(&o.recursive, "r", false, "load subdirs of the -d dirs too, their names are the schema of the tables")
+	fs.StringVar(&o.symlinks, "symlinks", "follow", "symlinked files and dirs in the -d dirs: follow or skip")
+	fs.Func("transform", "column=expression, set the column of every row to the expression of its values, e.g. 'Email=lower(Email)', repeatable", func(s string) error {
+		col, expression, ok := strings.Cut(s, "=")
+		if !ok || strings.TrimSpace(col) == "" {
+			return fmt.Errorf("%q is not column=expression", s)
+		}
+		if o.file.Transform == nil {
+			o.file.Transform = make(map[string]string)
+		}
+		o.file.Transform[strings.TrimSpace(col)] = expression
+		return nil
+	})
+	fs.Func("inject", "column=value, set the column of every row of every table having it to the value, e.g. TenantId=42, repeatable", func(s string) error {
+		col, value, ok := strings.Cut(s, "=")
+		if !ok || strings.TrimSpace(col) == "" {
+			return fmt.Errorf("%q is not column=value", s)
+		}
+		if o.inject == nil {
+			o.inject = make(map[string]any)
+		}
+		o.inject[strings.TrimSpace(col)] = value
+		return nil
+	})
+	fs.Func("columns", "table=column,column, load only these columns of the files of the table, the others left to their defaults, e.g. Orders=Id,CustomerId,Total, repeatable", func(s string) error {
+		return addTableColumns(&o.columns, s)
+	})
+	fs.Func("skip-columns", "table=column,column, leave these columns of the files of the table out, e.g. Orders=Notes, repeatable", func(s string) error {
+		return addTableColumns(&o.skipColumns, s)
+	})
+	fs.IntVar(&o.selection.offset, "offset", 0, "skip the first rows of every data file, after -where")
+	fs.IntVar(&o.selection.limit, "limit", 0, "load at most this many rows of every data file, after -where, 0 for all")
+	fs.Float64Var(&o.selection.samplePercent, "sample-percent", 0, "load about this percent of the rows of every data file, the same rows every run, e.g. 1 for 1%")
+	fs.StringVar(&o.file.Where, "where", "", "load only the rows matching this expression of their values, e.g. 'Country == \"DE\" && Active'")
+	fs.Func("max-memory", "size of the rows of the data files held in memory, e.g. 512MB, the rows past it are buffered in temporary files (default no limit)", func(s string) error {
+		n, err := parseByteSize(s)
+		o.maxMemory = n
+		return err
+	})
+	fs.StringVar(&o.file.Pipe, "pipe", "", "shell command every data file goes through before it is read, e.g. 'jq .items', its output is read instead")
+	fs.Func("absent", "[table.]column=default|null, what a column missing or null in a row gets: default leaves it out of the insert so its DEFAULT applies, null inserts NULL; column * for all of them, repeatable", func(s string) error {
+		return o.addAbsentPolicy(s)
+	})
+	fs.BoolVar(&o.file.Templates, "templates", false, "expand ${NOW}, ${NOW-7d}, ${TODAY}, ${UUID}, ${RUN_ID} and ${ENV:NAME} in string values")
+	fs.StringVar(&o.mappingFile, "mapping", "", "yaml file renaming, dropping, setting constant and concatenated columns of the files per table")
+	fs.StringVar(&o.maskFile, "mask", "", "yaml rules file masking column values (hash, encrypt, fake, partial or fixed) before they are loaded")
+	fs.StringVar(&o.hintsFile, "hints", "", "yaml file of parse rules of [table.]columns overriding their type, e.g. Orders.Flags: hex-int or Customers.BirthDate: 02/01/2006")
+	o.addFormatFlags(fs)
+}
+
+// addFormatFlags adds the flags of how the data files are read.
+func (o *sourceOptions) addFormatFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.file.Delimiter, "delimiter", ";", "csv delimiter")
+	fs.StringVar(&o.file.Encoding, "encoding", "", "encoding of the data files, e.g. windows-1252 (default utf-8)")
+	fs.IntVar(&o.conv.SRID, "srid", 4326, "spatial reference id for geography and geometry values")
+	fs.BoolVar(&o.conv.ValidateXML, "validate-xml", false, "check xml values are well-formed before insert")
+	fs.Func("money-locale", "strip currency symbols, spaces and grouping from decimal and money values written as in this locale: en (1,234.50), de (1.234,50), fr (1 234,50) or ch (1'234.50)", func(s string) error {
+		if _, ok := moneyLocales[s]; !ok {
+			return fmt.Errorf("unknown money locale %q", s)
+		}
+		o.conv.MoneyLocale = s
+		return nil
+	})
+	fs.Func("timezone", "IANA timezone of the date and time values without offset, e.g. Europe/Berlin or Local (default UTC)", func(s string) error {
+		loc, err := time.LoadLocation(s)
+		o.conv.Timezone = loc
+		return err
+	})
+	fs.Func("column-timezone", "[table.]column=timezone, timezone of the values of a column overriding -timezone, e.g. Orders.ShippedAt=America/New_York, repeatable", func(s string) error {
+		return o.addColumnTimezone(s)
+	})
+	fs.BoolVar(&o.conv.ConvertTimezone, "convert-timezone", false, "convert date and time values with an offset to the timezone of their column, instead of keeping the offset or wall clock written")
+	fs.BoolVar(&o.conv.Strict, "strict", false, "make rows invalid whose values would be changed on the way: text truncated, decimals and times rounded, offsets dropped, json stored as text, columns unknown or computed")
+	fs.StringVar(&o.conv.MoneyGrouping, "money-grouping", "", "more grouping characters to strip from decimal and money values, with or without -money-locale")
+}
+
+// addAuditFlags adds the flags of the audit columns, for the commands writing rows.
+func (o *sourceOptions) addAuditFlags(fs *flag.FlagSet) {
+	fs.Func("audit", "column=value, fill the column of every row missing it in the tables having it with run_time, run_id, user or file, e.g. CreatedAt=run_time, repeatable", func(s string) error {
+		col, source, ok := strings.Cut(s, "=")
+		if !ok || strings.TrimSpace(col) == "" {
+			return fmt.Errorf("%q is not column=value", s)
+		}
+		if o.file.Audit == nil {
+			o.file.Audit = make(map[string]string)
+		}
+		o.file.Audit[strings.TrimSpace(col)] = strings.TrimSpace(source)
+		return nil
+	})
+	fs.StringVar(&o.auditUser, "audit-user", "", "user the -audit columns of value user are filled with, by default the OS user")
+}
+
+func (o *sourceOptions) check() error {
+	if len(o.dirPaths) == 0 {
+		o.dirPaths = stringList{"test_data"}
+	}
+	if err := o.file.check(); err != nil {
+		return err
+	}
+	if err := o.selection.check(); err != nil {
+		return err
+	}
+	if o.symlinks != "follow" && o.symlinks != "skip" {
+		return fmt.Errorf("invalid -symlinks %q, follow or skip", o.symlinks)
+	}
+	return nil
+}
+
+// fileSource resolves the data files of the options to the tables they go to.
+type fileSource struct {
+	opts     *sourceOptions
+	nameTmpl *nameTemplate
+	filter   *tableFilter
+	masks    *maskRules
+	mappings *mappingRules
+	hints    map[string]map[string]string
+	// budget is the memory the rows of the files read take, shared by all of them
+	budget *memoryBudget
+	log    *slog.Logger
+}
+
+func newFileSource(opts *sourceOptions, log *slog.Logger) (*fileSource, error) {
+	nameTmpl, err := parseNameTemplate(opts.nameTmpl)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseTableFilter(opts.only, opts.exclude)
+	if err != nil {
+		return nil, err
+	}
+	masks, err := readMaskRules(opts.maskFile)
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := readMappingRules(opts.mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	hints, err := readColumnHints(opts.hintsFile)
+	if err != nil {
+		return nil, err
+	}
+	var budget *memoryBudget
+	if opts.maxMemory > 0 {
+		budget = &memoryBudget{limit: opts.maxMemory}
+	}
+	return &fileSource{opts: opts, nameTmpl: nameTmpl, filter: filter, masks: masks, mappings: mappings, hints: hints, budget: budget, log: log}, nil
+}
+
+// files lists the -f file or else the data files of the -d dirs.
+func (s *fileSource) files() ([]dataFile, error) {
+	if s.opts.filePath != "" {
+		return []dataFile{{path: s.opts.filePath, table: parseTableRef(s.opts.tableName)}}, nil
+	}
+	return collectFiles(s.opts.dirPaths, walkOptions{recursive: s.opts.recursive, followLinks: s.opts.symlinks == "follow"}, s.log)
+}
+
+// filePlan is a data file with its table, format and options resolved.
+type filePlan struct {
+	path    string
+	name    string
+	table   tableRef
+	ext     Format
+	opts    fileOptions
+	conv    conversionOptions
+	mapping *tableMapping
+	masks   columnMasks
+	// transform and where are compiled from the options
+	transform *rowTransform
+	where     *vm.Program
+	// sum is the SHA-256 of the file content, with -track
+	sum string
+	// audit are the values of the audit columns of the file
+	audit map[string]any
+	// inject are the values of the -inject columns
+	inject map[string]any
+	// selection are the rows of the file loaded
+	selection rowSelection
+	// columns are the columns of the file loaded, nil for all
+	columns *columnSelection
+	// skipRecords are the rows left out of the load by index, their keys in the table with
+	// -check-existing skip
+	skipRecords map[int]bool
+	// budget is the memory the rows read take, nil for no limit
+	budget *memoryBudget
+	log    *slog.Logger
+}
+
+// planFiles resolves the files, returning apart the ones whose table is filtered out.
+func (s *fileSource) planFiles(files []dataFile) (plans, skipped []*filePlan, err error) {
+	plans = make([]*filePlan, 0, len(files))
+	for _, file := range files {
+		plan, err := s.planFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !s.filter.match(plan.table) {
+			s.log.Info("skip file, table filtered out", "file", plan.name, "table", plan.table.String())
+			skipped = append(skipped, plan)
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	return plans, skipped, nil
+}
+
+// groupByTable returns the tables of the plans in the order first seen and the plans of each.
+func groupByTable(plans []*filePlan) ([]tableRef, map[tableRef][]*filePlan) {
+	var tables []tableRef
+	tablePlans := make(map[tableRef][]*filePlan)
+	for _, plan := range plans {
+		if tablePlans[plan.table] == nil {
+			tables = append(tables, plan.table)
+		}
+		tablePlans[plan.table] = append(tablePlans[plan.table], plan)
+	}
+	return tables, tablePlans
+}
+
+// planFile resolves the table of the file, given or else named by the file, and its options.
+func (s *fileSource) planFile(file dataFile) (*filePlan, error) {
+	sidecar, err := readSidecar(file.path)
+	if err != nil {
+		return nil, runError(err, ReadFileErrorCode)
+	}
+	opts := s.opts.file.merge(file.opts).merge(sidecar)
+	if sidecar.Table != "" {
+		file.table = tableRef{schema: sidecar.Schema, name: sidecar.Table}
+	}
+
+	fileName := filepath.Base(file.path)
+	extName := strings.TrimPrefix(filepath.Ext(fileName), ".")
+	if file.table.name == "" {
+		parts, err := s.nameTmpl.parse(fileName)
+		if err != nil {
+			return nil, runError(err, ReadDirErrorCode)
+		}
+		file.table.name = parts.table
+		if parts.schema != "" {
+			file.table.schema = parts.schema
+		}
+		if parts.database != "" {
+			file.table.database = parts.database
+		}
+		// a table named like [audit].[dbo].[Events]
+		if ref, ok := parseQuotedTableRef(parts.table); ok {
+			file.table.name = ref.name
+			if ref.schema != "" {
+				file.table.schema = ref.schema
+			}
+			if ref.database != "" {
+				file.table.database = ref.database
+			}
+		}
+		extName = parts.ext
+	}
+	if opts.Database != "" {
+		file.table.database = opts.Database
+	}
+	conv := s.opts.conv
+	conv.DateFormats = opts.DateFormats
+	conv.ColumnTimezones = columnEntries(s.opts.columnTimezones, file.table)
+	conv.Hints = columnEntries(s.hints, file.table)
+	conv.Absent = columnEntries(s.opts.absent, file.table)
+	ext, err := getFileFormat(extName)
+	if err != nil {
+		return nil, runError(fmt.Errorf("%s: %w", fileName, err), ReadFileErrorCode)
+	}
+	transform, err := compileTransform(opts.Transform)
+	if err != nil {
+		return nil, runError(fmt.Errorf("%s: %w", fileName, err), ReadFileErrorCode)
+	}
+	where, err := compileWhere(opts.Where)
+	if err != nil {
+		return nil, runError(fmt.Errorf("%s: %w", fileName, err), ReadFileErrorCode)
+	}
+	return &filePlan{path: file.path, name: fileName, table: file.table, ext: ext, opts: opts, conv: conv,
+		mapping: s.mappings.forTable(file.table), masks: s.masks.forTable(file.table), transform: transform, where: where,
+		audit: auditValues(opts.Audit, s.opts.auditUser, fileName), inject: s.opts.inject, selection: s.opts.selection,
+		columns: s.opts.columnsFor(file.table), budget: s.budget, log: s.log}, nil
+}
+
+// records reads the rows of the file with the columns renamed and mapped to the table columns,
+// the -columns kept, the -inject columns set, templates expanded, filtered, selected by -offset, -sample-percent and
+// -limit, transformed and masked. Rows failing to expand, filter or transform have the error set.
+// The rows are read one at a time, the ones past the memory budget spilled to disk; the set is
+// closed by the caller, also when reading fails.
+func (p *filePlan) records(table *tableInfo) (*recordSet, error) {
+	return p.recordsFrom(table, filePosition{})
+}
+
+// recordsFrom reads the rows of the file like records, from the position past a row read before.
+func (p *filePlan) recordsFrom(table *tableInfo, from filePosition) (*recordSet, error) {
+	set := newRecordSet(p.name, p.budget, p.log)
+	read, matched := 0, 0
+	var addErr error
+	err := eachRecord(p.path, p.ext, p.opts, from, p.log, func(record dataRecord) bool {
+		read++
+		records := []dataRecord{record}
+		renameColumns(records, p.opts.Columns)
+		p.mapping.apply(records, p.ext)
+		p.columns.apply(records)
+		injectColumns(records, p.inject, table)
+		if p.opts.Templates {
+			expandTemplates(records)
+		}
+		if records = filterRecords(records, p.where, table, p.ext); len(records) == 0 {
+			return true
+		}
+		matched++
+		keep, more := p.selection.keep(matched-1, set.len(), records[0], p.name)
+		if keep {
+			record = records[0]
+			if record.err == nil {
+				record.err = p.transform.apply(record, table, p.ext)
+			}
+			p.masks.apply(records, p.ext)
+			if addErr = set.add(record); addErr != nil {
+				return false
+			}
+		}
+		return more
+	})
+	if err = cmp.Or(err, addErr); err != nil {
+		return set, err
+	}
+	if p.where != nil {
+		p.log.Info("rows filtered out", "file", p.name, "table", p.table.String(), "rows", read-matched)
+	}
+	if p.selection != (rowSelection{}) {
+		p.log.Info("rows selected", "file", p.name, "table", p.table.String(), "rows", set.len(), "of", matched)
+	}
+	return set, nil
+}
+
+// seekable tells whether reading the file can go on from the position of a row: the rows before
+// it have no bearing on the ones after, no -offset or -limit counts them and none is left out by
+// index.
+func (p *filePlan) seekable() bool {
+	return canSeek(p.ext, p.opts) && p.selection.offset == 0 && p.selection.limit == 0 && len(p.skipRecords) == 0
+}
+
+// rowSelection picks the rows of a file loaded for a smoke load of large files: the rows past the
+// offset, sampled, up to the limit.
+type rowSelection struct {
+	offset, limit int
+	// samplePercent keeps the rows whose hash of file name and line falls under it, so the same
+	// rows are kept in every run
+	samplePercent float64
+}
+
+func (s rowSelection) check() error {
+	switch {
+	case s.offset < 0:
+		return fmt.Errorf("invalid -offset %d", s.offset)
+	case s.limit < 0:
+		return fmt.Errorf("invalid -limit %d", s.limit)
+	case s.samplePercent < 0 || s.samplePercent > 100:
+		return fmt.Errorf("invalid -sample-percent %g, want 0 to 100", s.samplePercent)
+	}
+	return nil
+}
+
+// keep tells whether the nth row read, from 0, is loaded with kept rows loaded before it, and
+// whether rows after it can be, so reading stops at the limit.
+func (s rowSelection) keep(n, kept int, record dataRecord, fileName string) (bool, bool) {
+	switch {
+	case s.limit > 0 && kept >= s.limit:
+		return false, false
+	case n < s.offset:
+		return false, true
+	case s.samplePercent > 0:
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s\x00%d", fileName, record.line)
+		if float64(h.Sum64()%1_000_000)/10_000 >= s.samplePercent {
+			return false, true
+		}
+	}
+	return true, s.limit <= 0 || kept+1 < s.limit
+}
+
+// columnSelection is the columns of the files of a table loaded, by -columns and -skip-columns.
+type columnSelection struct {
+	only, skip []string
+}
+
+// addTableColumns adds the columns of a table=column,column flag value to the columns by table.
+func addTableColumns(tables *map[string][]string, s string) error {
+	table, list, ok := strings.Cut(s, "=")
+	var columns []string
+	for _, col := range strings.Split(list, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			columns = append(columns, col)
+		}
+	}
+	if !ok || strings.TrimSpace(table) == "" || len(columns) == 0 {
+		return fmt.Errorf("%q is not table=column,column", s)
+	}
+	if *tables == nil {
+		*tables = make(map[string][]string)
+	}
+	table = strings.TrimSpace(table)
+	(*tables)[table] = append((*tables)[table], columns...)
+	return nil
+}
+
+// columnsFor returns the columns of the files of the table loaded, nil for all.
+func (o *sourceOptions) columnsFor(table tableRef) *columnSelection {
+	only, _ := tableEntry(o.columns, table)
+	skip, _ := tableEntry(o.skipColumns, table)
+	if only == nil && skip == nil {
+		return nil
+	}
+	return &columnSelection{only: only, skip: skip}
+}
+
+// apply removes the columns not loaded from the records, matched in any case.
+func (c *columnSelection) apply(records []dataRecord) {
+	if c == nil {
+		return
+	}
+	has := func(list []string, col string) bool {
+		return slices.ContainsFunc(list, func(name string) bool { return strings.EqualFold(name, col) })
+	}
+	for _, record := range records {
+		for col := range record.values {
+			if c.only != nil && !has(c.only, col) || has(c.skip, col) {
+				delete(record.values, col)
+			}
+		}
+	}
+}
+
+// addColumnTimezone adds a [table.]column=timezone flag value to the column timezones.
+func (o *sourceOptions) addColumnTimezone(s string) error {
+	name, zone, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%q is not [table.]column=timezone", s)
+	}
+	loc, err := time.LoadLocation(strings.TrimSpace(zone))
+	if err != nil {
+		return err
+	}
+	setColumnEntry(&o.columnTimezones, name, loc)
+	return nil
+}
+
+// addAbsentPolicy adds a [table.]column=policy flag value to the policies of the columns missing
+// or null in a row.
+func (o *sourceOptions) addAbsentPolicy(s string) error {
+	name, policy, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%q is not [table.]column=policy", s)
+	}
+	policy = strings.TrimSpace(policy)
+	if policy != absentDefault && policy != absentNull {
+		return fmt.Errorf("unknown policy %q, want default or null", policy)
+	}
+	setColumnEntry(&o.absent, name, policy)
+	return nil
+}
+
+// setColumnEntry sets the value of a [table.]column name in the entries by table and lower cased
+// column, the columns of all tables under "".
+func setColumnEntry[T any](entries *map[string]map[string]T, name string, value T) {
+	var table, column string
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		table, column = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+	} else {
+		column = strings.TrimSpace(name)
+	}
+	if *entries == nil {
+		*entries = make(map[string]map[string]T)
+	}
+	if (*entries)[table] == nil {
+		(*entries)[table] = make(map[string]T)
+	}
+	(*entries)[table][strings.ToLower(column)] = value
+}
+
+// columnEntries returns the values of the columns of the table by lower cased name, the ones
+// given for the table over the ones for all tables.
+func columnEntries[T any](entries map[string]map[string]T, table tableRef) map[string]T {
+	if len(entries) == 0 {
+		return nil
+	}
+	values := make(map[string]T)
+	maps.Copy(values, entries[""])
+	if forTable, ok := tableEntry(entries, table); ok {
+		maps.Copy(values, forTable)
+	}
+	return values
+}
@@ -0,0 +1,281 @@
+package loader
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sourceFor returns the options of the command with the arguments and their file source.
+func sourceFor(t *testing.T, command string, args ...string) (*uploadOptions, *fileSource) {
+	t.Helper()
+	opts := &uploadOptions{}
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	opts.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.sourceOptions.check(); err != nil {
+		t.Fatal(err)
+	}
+	source, err := newFileSource(&opts.sourceOptions, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts, source
+}
+
+// sourceError returns the error of the upload arguments, in parsing or checking them.
+func sourceError(args ...string) error {
+	opts := &uploadOptions{}
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return opts.sourceOptions.check()
+}
+
+// writeFiles writes the files of the names with their content in dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPlanFilesNameTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		file     string
+		want     tableRef
+		wantExt  Format
+	}{
+		{name: "default", file: "01_Order.Lines.json", want: tableRef{name: "Order.Lines"}, wantExt: Json},
+		{name: "schema", template: "{order}_{schema}.{table}.{ext}", file: "01_sales.Orders.json", want: tableRef{schema: "sales", name: "Orders"}, wantExt: Json},
+		{name: "export tool", template: "{table}-{time}.{ext}", file: "Orders-20240102T1015.csv", want: tableRef{name: "Orders"}, wantExt: Csv},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{tt.file: "[]"})
+			args := []string{"-d", dir}
+			if tt.template != "" {
+				args = append(args, "-name-template", tt.template)
+			}
+			_, source := sourceFor(t, "upload", args...)
+			files, err := source.files()
+			if err != nil {
+				t.Fatal(err)
+			}
+			plans, _, err := source.planFiles(files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plans) != 1 || plans[0].table != tt.want || plans[0].ext != tt.wantExt {
+				t.Fatalf("plans %+v, want table %v of %v", plans, tt.want, tt.wantExt)
+			}
+		})
+	}
+	// files of other tools do not match the default template
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Orders-20240102T1015.csv": "Id\n"})
+	_, source := sourceFor(t, "upload", "-d", dir)
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := source.planFiles(files); err == nil {
+		t.Error("no error for a file name not matching the template")
+	}
+}
+
+func TestPlanFilesFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"01_Customers.json":     "[]",
+		"02_CustomerNotes.json": "[]",
+		"03_Orders.json":        "[]",
+		"04_OrderLines.json":    "[]",
+	})
+	tests := []struct {
+		name        string
+		args        []string
+		want        []string
+		wantSkipped []string
+	}{
+		{name: "all", want: []string{"Customers", "CustomerNotes", "Orders", "OrderLines"}},
+		{name: "only list", args: []string{"-only", "orders, Customers"}, want: []string{"Customers", "Orders"}, wantSkipped: []string{"CustomerNotes", "OrderLines"}},
+		{name: "only regexp", args: []string{"-only", "Order.*"}, want: []string{"Orders", "OrderLines"}, wantSkipped: []string{"Customers", "CustomerNotes"}},
+		{name: "alternation", args: []string{"-only", "Orders|CustomerNotes"}, want: []string{"CustomerNotes", "Orders"}, wantSkipped: []string{"Customers", "OrderLines"}},
+		{name: "exclude", args: []string{"-exclude", "Customer.*"}, want: []string{"Orders", "OrderLines"}, wantSkipped: []string{"Customers", "CustomerNotes"}},
+		{name: "exclude wins", args: []string{"-only", "Customer.*", "-exclude", "CustomerNotes"}, want: []string{"Customers"}, wantSkipped: []string{"CustomerNotes", "Orders", "OrderLines"}},
+	}
+	tables := func(plans []*filePlan) []string {
+		var names []string
+		for _, plan := range plans {
+			names = append(names, plan.table.name)
+		}
+		return names
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, source := sourceFor(t, "upload", append([]string{"-d", dir}, tt.args...)...)
+			files, err := source.files()
+			if err != nil {
+				t.Fatal(err)
+			}
+			plans, skipped, err := source.planFiles(files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tables(plans); !slices.Equal(got, tt.want) {
+				t.Errorf("tables %v, want %v", got, tt.want)
+			}
+			if got := tables(skipped); !slices.Equal(got, tt.wantSkipped) {
+				t.Errorf("skipped %v, want %v", got, tt.wantSkipped)
+			}
+		})
+	}
+	// a pattern matches the table name or schema.table
+	filter, err := parseTableFilter(`sales\.Orders`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.match(tableRef{schema: "sales", name: "Orders"}) || filter.match(tableRef{schema: "dbo", name: "Orders"}) {
+		t.Error("schema.table pattern matched the wrong tables")
+	}
+	if _, err := parseTableFilter("Orders(", ""); err == nil {
+		t.Error("no error for a bad pattern")
+	}
+}
+
+// planRecords plans the file of the name and content with the arguments and reads its rows for the table.
+func planRecords(t *testing.T, table *tableInfo, name, content string, args ...string) []dataRecord {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{name: content})
+	_, source := sourceFor(t, "upload", append([]string{"-d", dir}, args...)...)
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, _, err := source.planFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := plans[0].records(table)
+	defer set.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []dataRecord
+	for _, record := range set.all() {
+		records = append(records, record)
+	}
+	if err := set.err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestRowSelection(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Lines", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Even", DataType: "bit"},
+	}}).tableInfo()
+	data := "Id;Even\n"
+	for i := 1; i <= 10; i++ {
+		data += fmt.Sprintf("%d;%t\n", i, i%2 == 0)
+	}
+	ids := func(records []dataRecord) []string {
+		var ids []string
+		for _, record := range records {
+			ids = append(ids, record.values["Id"].(string))
+		}
+		return ids
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "limit", args: []string{"-limit", "3"}, want: []string{"1", "2", "3"}},
+		{name: "offset", args: []string{"-offset", "8"}, want: []string{"9", "10"}},
+		{name: "offset and limit", args: []string{"-offset", "2", "-limit", "2"}, want: []string{"3", "4"}},
+		{name: "after where", args: []string{"-where", "Even", "-offset", "1", "-limit", "2"}, want: []string{"4", "6"}},
+		{name: "offset past the rows", args: []string{"-offset", "20"}},
+		{name: "whole sample", args: []string{"-sample-percent", "100"}, want: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}},
+	}
+	for _, tt := range tests {
+		if got := ids(planRecords(t, table, "01_Lines.csv", data, tt.args...)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: rows %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a sample is the same rows every run, about the percent of them
+	data = "Id;Even\n"
+	for i := 1; i <= 1000; i++ {
+		data += fmt.Sprintf("%d;%t\n", i, i%2 == 0)
+	}
+	sample := ids(planRecords(t, table, "01_Lines.csv", data, "-sample-percent", "10"))
+	if len(sample) < 50 || len(sample) > 150 {
+		t.Errorf("%d rows of a 10%% sample of 1000", len(sample))
+	}
+	if again := ids(planRecords(t, table, "01_Lines.csv", data, "-sample-percent", "10")); !slices.Equal(again, sample) {
+		t.Error("sample of other rows in the second run")
+	}
+	if limited := ids(planRecords(t, table, "01_Lines.csv", data, "-sample-percent", "10", "-limit", "5")); !slices.Equal(limited, sample[:5]) {
+		t.Errorf("limited sample %v, want %v", limited, sample[:5])
+	}
+
+	for _, args := range [][]string{{"-limit", "-1"}, {"-offset", "-1"}, {"-sample-percent", "101"}} {
+		if err := sourceError(args...); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestColumnSelection(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Total", DataType: "int", Nullable: true},
+		{Name: "Notes", DataType: "nvarchar", MaxLength: 100, Nullable: true},
+	}}).tableInfo()
+	data := `[{"Id": 1, "total": 5, "Notes": "x"}]`
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "all", want: []string{"Id", "Notes", "total"}},
+		{name: "only", args: []string{"-columns", "Orders=Id, Total"}, want: []string{"Id", "total"}},
+		{name: "skip", args: []string{"-skip-columns", "orders=notes"}, want: []string{"Id", "total"}},
+		{name: "both", args: []string{"-columns", "Orders=Id,Notes", "-skip-columns", "Orders=Notes"}, want: []string{"Id"}},
+		{name: "other table", args: []string{"-columns", "Lines=Id"}, want: []string{"Id", "Notes", "total"}},
+	}
+	for _, tt := range tests {
+		records := planRecords(t, table, "01_Orders.json", data, tt.args...)
+		if len(records) != 1 {
+			t.Fatalf("%s: %d records, want 1", tt.name, len(records))
+		}
+		got := slices.Sorted(maps.Keys(records[0].values))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: columns %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	for _, arg := range []string{"Orders", "=Id", "Orders= , "} {
+		if err := sourceError("-columns", arg); err == nil {
+			t.Errorf("-columns %q: no error", arg)
+		}
+	}
+}
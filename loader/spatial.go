@@ -0,0 +1,124 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// convertSpatial returns the WKT text for a geography/geometry value given
+// either as WKT or as GeoJSON (decoded object or text).
+func convertSpatial(val any) (any, error) {
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return geoJsonToWkt(v)
+	case string:
+		s := strings.TrimSpace(v)
+		if !strings.HasPrefix(s, "{") {
+			return s, nil
+		}
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(s), &obj); err != nil {
+			return nil, fmt.Errorf("invalid geojson: %w", err)
+		}
+		return geoJsonToWkt(obj)
+	}
+	return nil, fmt.Errorf("expected wkt or geojson, got %v", val)
+}
+
+func geoJsonToWkt(obj map[string]any) (string, error) {
+	typ, _ := obj["type"].(string)
+	switch typ {
+	case "Feature":
+		geom, ok := obj["geometry"].(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("geojson feature without geometry")
+		}
+		return geoJsonToWkt(geom)
+	case "GeometryCollection":
+		geoms, _ := obj["geometries"].([]any)
+		parts := make([]string, 0, len(geoms))
+		for _, g := range geoms {
+			m, ok := g.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("invalid geojson geometry collection member")
+			}
+			wkt, err := geoJsonToWkt(m)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, wkt)
+		}
+		if len(parts) == 0 {
+			return "GEOMETRYCOLLECTION EMPTY", nil
+		}
+		return "GEOMETRYCOLLECTION (" + strings.Join(parts, ", ") + ")", nil
+	}
+
+	// nesting depth of the coordinates array for each geometry type
+	depth := map[string]int{
+		"Point":           0,
+		"MultiPoint":      1,
+		"LineString":      1,
+		"MultiLineString": 2,
+		"Polygon":         2,
+		"MultiPolygon":    3,
+	}
+	d, ok := depth[typ]
+	if !ok {
+		return "", fmt.Errorf("unsupported geojson type %q", typ)
+	}
+	coords, err := wktCoordinates(obj["coordinates"], d)
+	if err != nil {
+		return "", fmt.Errorf("invalid geojson %s: %w", typ, err)
+	}
+	if d == 0 {
+		return "POINT (" + coords + ")", nil
+	}
+	if typ == "MultiPoint" && coords != "EMPTY" {
+		// members are written as (x y) to keep to the OGC form
+		points := strings.Split(strings.Trim(coords, "()"), ", ")
+		coords = "((" + strings.Join(points, "), (") + "))"
+	}
+	return strings.ToUpper(typ) + " " + coords, nil
+}
+
+// wktCoordinates formats GeoJSON coordinates nested depth levels above a single position.
+func wktCoordinates(val any, depth int) (string, error) {
+	arr, ok := val.([]any)
+	if !ok {
+		return "", fmt.Errorf("coordinates must be an array")
+	}
+	if depth == 0 {
+		if len(arr) < 2 {
+			return "", fmt.Errorf("position needs at least two numbers")
+		}
+		nums := make([]string, len(arr))
+		for i, n := range arr {
+			switch f := n.(type) {
+			case float64:
+				nums[i] = strconv.FormatFloat(f, 'f', -1, 64)
+			case json.Number:
+				nums[i] = f.String()
+			default:
+				return "", fmt.Errorf("position values must be numbers")
+			}
+		}
+		return strings.Join(nums, " "), nil
+	}
+	if len(arr) == 0 {
+		return "EMPTY", nil
+	}
+	parts := make([]string, len(arr))
+	for i, item := range arr {
+		s, err := wktCoordinates(item, depth-1)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = s
+	}
+	return "(" + strings.Join(parts, ", ") + ")", nil
+}
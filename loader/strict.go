@@ -0,0 +1,77 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// With -strict the values the server or the conversion would change without error are invalid:
+// text longer than its column, decimals with more digits than its scale, times more precise than
+// their column or with an offset or time of day the column drops, and nested json stored as text.
+
+// checkText checks a value of a text column fits it as it is.
+func checkText(col ColumnSchema, val any) error {
+	switch val.(type) {
+	case map[string]any, []any:
+		return fmt.Errorf("nested json value would be stored as text")
+	}
+	s, err := stringOf(val)
+	if err != nil {
+		return err
+	}
+	// -1 is max
+	if n := utf8.RuneCountInString(s); col.MaxLength.Valid && col.MaxLength.Int64 > 0 && int64(n) > col.MaxLength.Int64 {
+		return fmt.Errorf("value of %d characters would be truncated to %d", n, col.MaxLength.Int64)
+	}
+	return nil
+}
+
+// checkScale checks a decimal value written out has no more decimals than the column scale,
+// trailing zeros aside.
+func checkScale(col ColumnSchema, s string) error {
+	_, decimals, _ := strings.Cut(s, ".")
+	decimals = strings.TrimRight(decimals, "0")
+	if col.NumericScale.Valid && int64(len(decimals)) > col.NumericScale.Int64 {
+		return fmt.Errorf("value %s would be rounded to %d decimals", s, col.NumericScale.Int64)
+	}
+	return nil
+}
+
+// checkTime checks the column keeps all of a date or time value, its offset kept tells whether
+// the offset it has goes to the column.
+func checkTime(col ColumnSchema, t time.Time, offsetKept bool) error {
+	if _, offset := t.Zone(); offset != 0 && !offsetKept && col.DataType != "datetimeoffset" && col.DataType != "time" {
+		return fmt.Errorf("offset of %s would be dropped, convert it with -convert-timezone", t.Format(time.RFC3339))
+	}
+	ns := t.Nanosecond()
+	switch col.DataType {
+	case "date":
+		if t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0 || ns != 0 {
+			return fmt.Errorf("time of day of %s would be dropped", t.Format(time.RFC3339Nano))
+		}
+	case "smalldatetime":
+		if t.Second() != 0 || ns != 0 {
+			return fmt.Errorf("%s would be rounded to the minute", t.Format(time.RFC3339Nano))
+		}
+	case "datetime":
+		// datetime has ticks of 1/300 second, .000, .003 and .007 ms
+		if ms := ns / int(time.Millisecond); ns%int(time.Millisecond) != 0 || ms%10 != 0 && ms%10 != 3 && ms%10 != 7 {
+			return fmt.Errorf("%s would be rounded to 1/300 second", t.Format(time.RFC3339Nano))
+		}
+	default:
+		digits := int64(7)
+		if col.DatetimePrecision.Valid {
+			digits = col.DatetimePrecision.Int64
+		}
+		unit := 1
+		for range 9 - digits {
+			unit *= 10
+		}
+		if ns%unit != 0 {
+			return fmt.Errorf("%s would be rounded to %d fractional digits", t.Format(time.RFC3339Nano), digits)
+		}
+	}
+	return nil
+}
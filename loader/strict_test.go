@@ -0,0 +1,87 @@
+package loader
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestCheckText(t *testing.T) {
+	col := ColumnSchema{ColumnName: "Name", DataType: "nvarchar", MaxLength: sql.NullInt64{Int64: 5, Valid: true}}
+	unlimited := ColumnSchema{ColumnName: "Notes", DataType: "nvarchar", MaxLength: sql.NullInt64{Int64: -1, Valid: true}}
+	tests := []struct {
+		name    string
+		col     ColumnSchema
+		value   any
+		wantErr bool
+	}{
+		{name: "fits", col: col, value: "Hello"},
+		{name: "characters not bytes", col: col, value: "Grüße"},
+		{name: "truncated", col: col, value: "Hello!", wantErr: true},
+		{name: "max", col: unlimited, value: "a long text of any length"},
+		{name: "nested object", col: unlimited, value: map[string]any{"a": 1}, wantErr: true},
+		{name: "nested array", col: unlimited, value: []any{1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkText(tt.col, tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckScale(t *testing.T) {
+	col := ColumnSchema{ColumnName: "Price", DataType: "decimal", NumericScale: sql.NullInt64{Int64: 2, Valid: true}}
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"12", false},
+		{"12.5", false},
+		{"12.50", false},
+		{"12.5000", false},
+		{"12.505", true},
+		{"-0.001", true},
+	}
+	for _, tt := range tests {
+		if err := checkScale(col, tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("checkScale(%q): error %v, want error %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckTime(t *testing.T) {
+	column := func(dataType string, precision int64) ColumnSchema {
+		return ColumnSchema{ColumnName: "At", DataType: dataType, DatetimePrecision: sql.NullInt64{Int64: precision, Valid: precision >= 0}}
+	}
+	plus2 := time.FixedZone("", 2*60*60)
+	at := func(hour, min, sec, ns int, loc *time.Location) time.Time {
+		return time.Date(2024, 3, 1, hour, min, sec, ns, loc)
+	}
+	tests := []struct {
+		name       string
+		col        ColumnSchema
+		t          time.Time
+		offsetKept bool
+		wantErr    bool
+	}{
+		{name: "date", col: column("date", -1), t: at(0, 0, 0, 0, time.UTC)},
+		{name: "date with time", col: column("date", -1), t: at(10, 0, 0, 0, time.UTC), wantErr: true},
+		{name: "smalldatetime", col: column("smalldatetime", -1), t: at(10, 30, 0, 0, time.UTC)},
+		{name: "smalldatetime seconds", col: column("smalldatetime", -1), t: at(10, 30, 15, 0, time.UTC), wantErr: true},
+		{name: "datetime ticks", col: column("datetime", -1), t: at(10, 30, 15, 125*int(time.Millisecond), time.UTC), wantErr: true},
+		{name: "datetime 3 ms", col: column("datetime", -1), t: at(10, 30, 15, 3*int(time.Millisecond), time.UTC)},
+		{name: "datetime 7 ms", col: column("datetime", -1), t: at(10, 30, 15, 127*int(time.Millisecond), time.UTC)},
+		{name: "datetime2 100ns", col: column("datetime2", 7), t: at(10, 30, 15, 1234567*100, time.UTC)},
+		{name: "datetime2 ns", col: column("datetime2", 7), t: at(10, 30, 15, 1, time.UTC), wantErr: true},
+		{name: "datetime2(0) ms", col: column("datetime2", 0), t: at(10, 30, 15, int(time.Millisecond), time.UTC), wantErr: true},
+		{name: "datetime2(3) ms", col: column("datetime2", 3), t: at(10, 30, 15, int(time.Millisecond), time.UTC)},
+		{name: "offset dropped", col: column("datetime2", 7), t: at(10, 0, 0, 0, plus2), wantErr: true},
+		{name: "offset converted", col: column("datetime2", 7), t: at(10, 0, 0, 0, plus2), offsetKept: true},
+		{name: "datetimeoffset keeps the offset", col: column("datetimeoffset", 7), t: at(10, 0, 0, 0, plus2)},
+	}
+	for _, tt := range tests {
+		if err := checkTime(tt.col, tt.t, tt.offsetKept); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
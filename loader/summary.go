@@ -0,0 +1,85 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// runSummary collects what happened during the run to report it at the end.
+type runSummary struct {
+	// skipped holds table -> column -> reason for columns whose values were not inserted
+	skipped map[string]map[string]string
+	// emptyRows counts by table the rows skipped for having no column to insert
+	emptyRows map[string]int
+	// neverLoaded holds table -> column -> reason for the columns of the tables loaded that are never
+	// inserted, values or not
+	neverLoaded map[string]map[string]string
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{skipped: make(map[string]map[string]string), emptyRows: make(map[string]int), neverLoaded: make(map[string]map[string]string)}
+}
+
+// addTable adds the columns of a table loaded the tool never inserts.
+func (s *runSummary) addTable(table *tableInfo) {
+	name := table.ref.String()
+	if _, ok := s.neverLoaded[name]; ok {
+		return
+	}
+	s.neverLoaded[name] = make(map[string]string)
+	for _, col := range table.columns {
+		if reason, ok := table.neverLoaded(col); ok {
+			s.neverLoaded[name][col] = reason
+		}
+	}
+}
+
+func (s *runSummary) emptyRow(table string) {
+	s.emptyRows[table]++
+}
+
+func (s *runSummary) skipColumn(table, column, reason string) {
+	if s.skipped[table] == nil {
+		s.skipped[table] = make(map[string]string)
+	}
+	s.skipped[table][column] = reason
+}
+
+func (s *runSummary) print(w io.Writer) {
+	color := colorFor(w)
+	var lines []string
+	for _, table := range slices.Sorted(maps.Keys(s.neverLoaded)) {
+		columns := s.neverLoaded[table]
+		for _, column := range slices.Sorted(maps.Keys(columns)) {
+			line := fmt.Sprintf("  %s.%s: %s", table, column, columns[column])
+			if _, ok := s.skipped[table][column]; ok {
+				line += ", values in the files left out"
+			}
+			lines = append(lines, line)
+		}
+	}
+	for _, table := range slices.Sorted(maps.Keys(s.skipped)) {
+		// tables of rows inserted on their own, like change events
+		if _, ok := s.neverLoaded[table]; ok {
+			continue
+		}
+		columns := s.skipped[table]
+		for _, column := range slices.Sorted(maps.Keys(columns)) {
+			lines = append(lines, fmt.Sprintf("  %s.%s: %s, values left out", table, column, columns[column]))
+		}
+	}
+	if len(lines) > 0 {
+		fmt.Fprintln(w, paint(color, colorYellow, "Columns never loaded:"))
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if len(s.emptyRows) > 0 {
+		fmt.Fprintln(w, paint(color, colorYellow, "Skipped rows with no column to insert:"))
+		for _, table := range slices.Sorted(maps.Keys(s.emptyRows)) {
+			fmt.Fprintf(w, "  %s: %d\n", table, s.emptyRows[table])
+		}
+	}
+}
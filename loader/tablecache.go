@@ -0,0 +1,219 @@
+package loader
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// prefetchChunk is the tables looked up by a prefetch query, two parameters each, under the 2100
+// parameters of a SQL Server request.
+const prefetchChunk = 500
+
+// tableCache holds the table info of a run, so the files of a table and the checks before and
+// after the load do not query it again.
+type tableCache struct {
+	db     *sqlx.DB
+	tables map[tableRef]*tableInfo
+	// snapshot are the tables looked up instead of the database, with no db
+	snapshot []*tableDefinition
+	log      *slog.Logger
+}
+
+func newTableCache(db *sqlx.DB, log *slog.Logger) *tableCache {
+	return &tableCache{db: db, tables: make(map[tableRef]*tableInfo), log: log}
+}
+
+// newSnapshotCache returns a cache of the tables of a schema snapshot, for runs with no
+// connection.
+func newSnapshotCache(defs []*tableDefinition, log *slog.Logger) *tableCache {
+	return &tableCache{tables: make(map[tableRef]*tableInfo), snapshot: defs, log: log}
+}
+
+// get returns the info of the table, looked up the first time.
+func (c *tableCache) get(ref tableRef) (*tableInfo, error) {
+	if table, ok := c.tables[ref]; ok {
+		return table, nil
+	}
+	var table *tableInfo
+	var err error
+	if c.db == nil {
+		table, err = c.snapshotTable(ref)
+	} else {
+		table, err = getTableInfo(c.db, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.tables[ref] = table
+	return table, nil
+}
+
+// snapshotTable looks the table up in the snapshot, names matched regardless of case as by the
+// server. A table not in it has no columns.
+func (c *tableCache) snapshotTable(ref tableRef) (*tableInfo, error) {
+	if ref.database != "" {
+		return nil, fmt.Errorf("table %s: the schema snapshot has the tables of one database", ref)
+	}
+	var found *tableDefinition
+	for _, def := range c.snapshot {
+		if !strings.EqualFold(def.Name, ref.name) || ref.schema != "" && !strings.EqualFold(def.Schema, ref.schema) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("table %s is in more than one schema, give it as schema.table", ref)
+		}
+		found = def
+	}
+	if found == nil {
+		return &tableInfo{ref: ref, schema: make(map[string]ColumnSchema), dialect: sqlServer{}}, nil
+	}
+	return found.tableInfo(), nil
+}
+
+// prefetch looks up the info of the tables of the run with a query per kind of metadata for all
+// of them in a database, instead of four per table. Other servers than SQL Server are looked up
+// table by table.
+func (c *tableCache) prefetch(refs []tableRef) error {
+	if c.db == nil {
+		return nil
+	}
+	if _, ok := dialectOf(c.db).(sqlServer); !ok {
+		return nil
+	}
+	var databases []string
+	byDatabase := make(map[string][]tableRef)
+	for _, ref := range refs {
+		if _, ok := byDatabase[ref.database]; !ok {
+			databases = append(databases, ref.database)
+		}
+		byDatabase[ref.database] = append(byDatabase[ref.database], ref)
+	}
+	for _, database := range databases {
+		refs := byDatabase[database]
+		for start := 0; start < len(refs); start += prefetchChunk {
+			if err := c.prefetchChunk(refs[start:min(start+prefetchChunk, len(refs))]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prefetchChunk looks up tables of the same database.
+func (c *tableCache) prefetchChunk(refs []tableRef) error {
+	catalog, databaseID := refs[0].catalog(), refs[0].databaseID()
+	// the tables as rows of (schema, name, index), an empty schema matching any
+	values := make([]string, len(refs))
+	args := make([]any, 0, 2*len(refs))
+	infos := make([]*tableInfo, len(refs))
+	for i, ref := range refs {
+		values[i] = fmt.Sprintf("(@p%d, @p%d, %d)", 2*i+1, 2*i+2, i)
+		args = append(args, ref.schema, ref.name)
+		infos[i] = &tableInfo{ref: ref, schema: make(map[string]ColumnSchema), dialect: sqlServer{}}
+	}
+	tables := fmt.Sprintf("(VALUES %s) t(s, n, i)", strings.Join(values, ", "))
+
+	var objects []struct {
+		Index int    `db:"i"`
+		Type  string `db:"type"`
+	}
+	err := c.db.Select(&objects, `
+SELECT t.i, RTRIM(o.type) AS type
+FROM `+catalog+`sys.objects o
+JOIN `+tables+` ON o.name = t.n AND (t.s = '' OR OBJECT_SCHEMA_NAME(o.object_id, `+databaseID+`) = t.s)
+WHERE o.type IN ('V', 'SN')`, args...)
+	if err != nil {
+		return err
+	}
+	// synonyms are left to get, which looks up their base table
+	synonyms := make(map[int]bool)
+	for _, o := range objects {
+		if o.Type == "SN" {
+			synonyms[o.Index] = true
+		} else {
+			infos[o.Index].view = true
+		}
+	}
+
+	var columns []struct {
+		ColumnSchema
+		Index int `db:"i"`
+	}
+	err = c.db.Select(&columns, `
+SELECT t.i, c.COLUMN_NAME, c.IS_NULLABLE, c.COLUMN_DEFAULT, c.DATA_TYPE, c.CHARACTER_MAXIMUM_LENGTH, c.NUMERIC_SCALE, c.DATETIME_PRECISION
+FROM `+catalog+`INFORMATION_SCHEMA.COLUMNS c
+JOIN `+tables+` ON c.TABLE_NAME = t.n AND (t.s = '' OR c.TABLE_SCHEMA = t.s)
+ORDER BY t.i, c.ORDINAL_POSITION`, args...)
+	if err != nil {
+		return err
+	}
+	for _, col := range columns {
+		info := infos[col.Index]
+		info.schema[col.ColumnName] = col.ColumnSchema
+		info.columns = append(info.columns, col.ColumnName)
+	}
+
+	var special []struct {
+		Index     int    `db:"i"`
+		Name      string `db:"name"`
+		Identity  bool   `db:"is_identity"`
+		Computed  bool   `db:"is_computed"`
+		Generated bool   `db:"generated"`
+		Encrypted bool   `db:"encrypted"`
+	}
+	err = c.db.Select(&special, `
+SELECT t.i, c.name, c.is_identity, c.is_computed, CAST(CASE WHEN c.generated_always_type <> 0 THEN 1 ELSE 0 END AS bit) AS generated,
+  CAST(CASE WHEN c.encryption_type IS NOT NULL THEN 1 ELSE 0 END AS bit) AS encrypted
+FROM `+catalog+`sys.columns c
+JOIN `+tables+` ON OBJECT_NAME(c.object_id, `+databaseID+`) = t.n AND (t.s = '' OR OBJECT_SCHEMA_NAME(c.object_id, `+databaseID+`) = t.s)
+WHERE c.is_identity = 1 OR c.is_computed = 1 OR c.generated_always_type <> 0 OR c.encryption_type IS NOT NULL
+ORDER BY t.i, c.column_id`, args...)
+	if err != nil {
+		return err
+	}
+	for _, col := range special {
+		info := infos[col.Index]
+		if col.Identity && !info.view {
+			info.identityColumns = append(info.identityColumns, col.Name)
+		}
+		if col.Computed || col.Generated {
+			info.computeColumns = append(info.computeColumns, col.Name)
+		}
+		if col.Generated && !col.Computed {
+			info.generatedColumns = append(info.generatedColumns, col.Name)
+		}
+		if col.Encrypted {
+			info.encryptedColumns = append(info.encryptedColumns, col.Name)
+		}
+	}
+
+	var keys []struct {
+		Index  int    `db:"i"`
+		Column string `db:"COLUMN_NAME"`
+	}
+	err = c.db.Select(&keys, `
+SELECT t.i, kcu.COLUMN_NAME
+FROM `+catalog+`INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc
+JOIN `+catalog+`INFORMATION_SCHEMA.KEY_COLUMN_USAGE kcu
+  ON kcu.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND kcu.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+JOIN `+tables+` ON tc.TABLE_NAME = t.n AND (t.s = '' OR tc.TABLE_SCHEMA = t.s)
+WHERE tc.CONSTRAINT_TYPE = 'PRIMARY KEY'
+ORDER BY t.i, kcu.ORDINAL_POSITION`, args...)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		infos[key.Index].primaryKey = append(infos[key.Index].primaryKey, key.Column)
+	}
+
+	for i, info := range infos {
+		if !synonyms[i] {
+			c.tables[info.ref] = info
+		}
+	}
+	c.log.Debug("table info prefetched", "tables", len(refs))
+	return nil
+}
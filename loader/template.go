@@ -0,0 +1,118 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runID and runStarted are the same for all values of a run, so ${RUN_ID} and ${NOW} of rows
+// loaded together match.
+var (
+	runID      = uuid.NewString()
+	runStarted = time.Now().UTC()
+)
+
+// templateToken matches the tokens of string values, ${NAME} or $${NAME} for a literal ${NAME}.
+var templateToken = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// expandTemplates replaces the tokens in the string values of the records in place, the rows with
+// an unknown token have the error set.
+func expandTemplates(records []dataRecord) {
+	for i, record := range records {
+		for name, val := range record.values {
+			s, ok := val.(string)
+			if !ok || !strings.Contains(s, "${") {
+				continue
+			}
+			expanded, err := expandTemplate(s)
+			if err != nil {
+				if records[i].err == nil {
+					records[i].err = fmt.Errorf("column %s: %w", name, err)
+				}
+				continue
+			}
+			record.values[name] = expanded
+		}
+	}
+}
+
+// expandTemplate replaces the tokens of s:
+//
+//	${NOW}, ${NOW-7d}, ${NOW+2h}  the start of the run in UTC, shifted by s, m, h, d or w
+//	${TODAY}, ${TODAY-1d}         the date of the run, shifted by d or w
+//	${UUID}                       a new uuid for every token
+//	${RUN_ID}                     the uuid of the run
+//	${ENV:NAME}                   the environment variable, which must be set
+func expandTemplate(s string) (string, error) {
+	var err error
+	out := templateToken.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		token := strings.TrimSpace(match[2 : len(match)-1])
+		value, tokenErr := templateValue(token)
+		if tokenErr != nil && err == nil {
+			err = tokenErr
+		}
+		return value
+	})
+	return out, err
+}
+
+func templateValue(token string) (string, error) {
+	if name, ok := strings.CutPrefix(token, "ENV:"); ok {
+		value, set := os.LookupEnv(name)
+		if !set {
+			return "", fmt.Errorf("template ${%s}: environment variable %s not set", token, name)
+		}
+		return value, nil
+	}
+	switch token {
+	case "UUID":
+		return uuid.NewString(), nil
+	case "RUN_ID":
+		return runID, nil
+	}
+	for _, base := range []string{"NOW", "TODAY"} {
+		offset, ok := strings.CutPrefix(token, base)
+		if !ok || offset != "" && offset[0] != '+' && offset[0] != '-' {
+			continue
+		}
+		shift, err := parseShift(offset, base == "NOW")
+		if err != nil {
+			return "", fmt.Errorf("template ${%s}: %w", token, err)
+		}
+		t := runStarted.Add(shift)
+		if base == "TODAY" {
+			return t.Format(time.DateOnly), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	return "", fmt.Errorf("unknown template ${%s}", token)
+}
+
+// parseShift parses a signed number of s, m, h, d or w, only d and w without the time of day.
+func parseShift(offset string, timeOfDay bool) (time.Duration, error) {
+	if offset == "" {
+		return 0, nil
+	}
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if timeOfDay {
+		units['s'], units['m'], units['h'] = time.Second, time.Minute, time.Hour
+	}
+	unit, ok := units[offset[len(offset)-1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit of offset %q, want d or w, or s, m or h with NOW", offset)
+	}
+	n, err := strconv.Atoi(offset[:len(offset)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q", offset)
+	}
+	return time.Duration(n) * unit, nil
+}
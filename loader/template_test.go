@@ -0,0 +1,49 @@
+package loader
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	t.Setenv("TEST_TEMPLATE_REGION", "eu")
+	now := runStarted
+	tests := []struct {
+		s       string
+		want    string
+		wantErr string
+	}{
+		{s: "no tokens", want: "no tokens"},
+		{s: "${NOW}", want: now.Format(time.RFC3339Nano)},
+		{s: "${NOW-7d}", want: now.AddDate(0, 0, -7).Format(time.RFC3339Nano)},
+		{s: "${ NOW+2h }", want: now.Add(2 * time.Hour).Format(time.RFC3339Nano)},
+		{s: "${NOW-30m}", want: now.Add(-30 * time.Minute).Format(time.RFC3339Nano)},
+		{s: "${TODAY}", want: now.Format(time.DateOnly)},
+		{s: "from ${TODAY-1w} on", want: "from " + now.AddDate(0, 0, -7).Format(time.DateOnly) + " on"},
+		{s: "${RUN_ID}", want: runID},
+		{s: "region ${ENV:TEST_TEMPLATE_REGION}", want: "region eu"},
+		{s: "$${NOW} stays", want: "${NOW} stays"},
+		{s: "${TODAY+2h}", wantErr: "unknown unit"},
+		{s: "${NOW-xd}", wantErr: "invalid offset"},
+		{s: "${NOWISH}", wantErr: "unknown template ${NOWISH}"},
+		{s: "${ENV:TEST_TEMPLATE_UNSET}", wantErr: "environment variable TEST_TEMPLATE_UNSET not set"},
+	}
+	for _, tt := range tests {
+		got, err := expandTemplate(tt.s)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandTemplate(%q): error %v, want %q", tt.s, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+	first, _ := expandTemplate("${UUID}")
+	second, _ := expandTemplate("${UUID}")
+	if len(first) != 36 || first == second {
+		t.Errorf("uuids %q, %q", first, second)
+	}
+}
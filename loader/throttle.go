@@ -0,0 +1,77 @@
+package loader
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// throttleErrors are the SQL Server error numbers of Azure SQL turning requests down for a while:
+// service busy, too many requests and resource limits reached.
+var throttleErrors = []int32{40501, 49918, 49919, 49920, 10928, 10929}
+
+// transientErrors are the error numbers a connection is tried again on, the throttling ones and
+// a database not available yet, like an auto-paused serverless database resuming, or
+// reconfigured.
+var transientErrors = append([]int32{40613, 40197}, throttleErrors...)
+
+const (
+	// resumeWindow is how long the first connection is tried again while the database is not
+	// available, an auto-paused serverless database takes about a minute to resume
+	resumeWindow = 2 * time.Minute
+	// throttleRetries is how many times a statement throttled by the server is tried again
+	throttleRetries = 8
+	// throttleWait is the first wait before a retry, doubled on every throttling in a row up to
+	// maxThrottleWait and halved by every commit without
+	throttleWait    = 2 * time.Second
+	maxThrottleWait = time.Minute
+)
+
+// isSqlError tells whether err is a SQL Server error of one of the numbers.
+func isSqlError(err error, numbers []int32) bool {
+	var sqlErr mssql.Error
+	return errors.As(err, &sqlErr) && slices.Contains(numbers, sqlErr.SQLErrorNumber())
+}
+
+// ping checks the connection works, trying again within the resume window while the database is
+// not available.
+func ping(db *sqlx.DB, log *slog.Logger) error {
+	deadline := time.Now().Add(resumeWindow)
+	wait := time.Second
+	for {
+		err := db.Ping()
+		if err == nil || !isSqlError(err, transientErrors) || time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		log.Warn("database not available, connecting again", "err", err, "wait", wait)
+		time.Sleep(wait)
+		wait = min(2*wait, maxThrottleWait)
+	}
+}
+
+// retryThrottled waits for the server to take requests again after the statement of the open
+// transaction failed with err and runs the statements of the transaction again in a new one,
+// half as many rows committed at a time from now on. Statements out of a transaction are just
+// run again by exec.
+func (b *batch) retryThrottled(attempt int, err error) error {
+	b.wait = min(max(2*b.wait, throttleWait), maxThrottleWait)
+	if b.size > 1 {
+		b.size = max(1, b.size/2)
+	}
+	b.log.Warn("throttled by the server, trying again", "err", err, "attempt", attempt, "wait", b.wait, "batch_size", b.size)
+	if b.tx != nil {
+		// the server may have rolled it back already
+		_ = b.tx.Rollback()
+		b.tx = nil
+	}
+	select {
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	case <-time.After(b.wait):
+	}
+	return b.replay()
+}
@@ -0,0 +1,154 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// rowTransform sets columns of the rows to expressions of their values, in the language of
+// github.com/expr-lang/expr, e.g. lower(Email) or Price * Qty.
+type rowTransform struct {
+	columns  []string
+	programs map[string]*vm.Program
+}
+
+// compileTransform compiles the expressions by column, it returns nil if there are none.
+func compileTransform(exprs map[string]string) (*rowTransform, error) {
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	t := &rowTransform{columns: slices.Sorted(maps.Keys(exprs)), programs: make(map[string]*vm.Program, len(exprs))}
+	for _, col := range t.columns {
+		program, err := expr.Compile(exprs[col], expr.AllowUndefinedVariables())
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", col, err)
+		}
+		t.programs[col] = program
+	}
+	return t, nil
+}
+
+// apply sets the columns of the record, the expressions all see the values the row had before.
+func (t *rowTransform) apply(record dataRecord, table *tableInfo, ext Format) error {
+	if t == nil {
+		return nil
+	}
+	env := exprEnv(record, table, ext)
+	for _, col := range t.columns {
+		out, err := expr.Run(t.programs[col], env)
+		if err != nil {
+			return fmt.Errorf("transform %s: %w", col, err)
+		}
+		if record.values[col], err = dataValue(out); err != nil {
+			return fmt.Errorf("transform %s: %w", col, err)
+		}
+	}
+	return nil
+}
+
+// compileWhere compiles the filter expression of the rows, it returns nil if there is none.
+func compileWhere(where string) (*vm.Program, error) {
+	if where == "" {
+		return nil, nil
+	}
+	program, err := expr.Compile(where, expr.AllowUndefinedVariables(), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("where: %w", err)
+	}
+	return program, nil
+}
+
+// filterRecords returns the records matching the filter, the ones it fails on with the error set.
+func filterRecords(records []dataRecord, where *vm.Program, table *tableInfo, ext Format) []dataRecord {
+	if where == nil {
+		return records
+	}
+	kept := records[:0]
+	for _, record := range records {
+		out, err := expr.Run(where, exprEnv(record, table, ext))
+		if err != nil {
+			record.err = fmt.Errorf("where: %w", err)
+		}
+		if match, _ := out.(bool); match || err != nil {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+// exprEnv returns the values of the record as expressions take them: the values of integer,
+// decimal, float and bit columns of the table as numbers and booleans, also in csv files, other
+// json numbers as numbers and nulls as nil.
+func exprEnv(record dataRecord, table *tableInfo, ext Format) map[string]any {
+	env := make(map[string]any, len(record.values))
+	for name, val := range record.values {
+		if ext == Csv && val == "NULL" {
+			val = nil
+		}
+		var s string
+		switch v := val.(type) {
+		case json.Number:
+			s = v.String()
+		case string:
+			s = strings.TrimSpace(v)
+		default:
+			env[name] = val
+			continue
+		}
+		col, inTable := table.schema[name]
+		_, isNumber := val.(json.Number)
+		switch {
+		case col.DataType == "bit":
+			if b, err := convertBit(s); err == nil {
+				val = b
+			}
+		case slices.Contains(numericTypes, col.DataType) || !inTable && isNumber:
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				val = i
+			} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+				val = f
+			}
+		}
+		env[name] = val
+	}
+	return env
+}
+
+// numericTypes are the column types whose values expressions take as numbers.
+var numericTypes = []string{"tinyint", "smallint", "int", "bigint", "decimal", "numeric", "money", "smallmoney", "float", "real"}
+
+// dataValue returns the result of an expression as a value of a json data file.
+func dataValue(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, string, bool, json.Number:
+		return v, nil
+	case int:
+		return json.Number(strconv.Itoa(v)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("result %v is not a number", v)
+		}
+		// 15 digits leave out the noise of binary fractions, 12.3 * 3 is 36.9
+		s := strconv.FormatFloat(v, 'g', 15, 64)
+		if strings.ContainsAny(s, "eE") {
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return json.Number(s), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return v.String(), nil
+	}
+	return nil, fmt.Errorf("unsupported result %v of type %T", v, v)
+}
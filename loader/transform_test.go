@@ -0,0 +1,110 @@
+package loader
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lineTable is the table of the transform and filter tests.
+func lineTable() *tableInfo {
+	return (&tableDefinition{Schema: "dbo", Name: "Lines", Columns: []columnDefinition{
+		{Name: "Email", DataType: "nvarchar", MaxLength: 100},
+		{Name: "Country", DataType: "char", MaxLength: 2},
+		{Name: "Price", DataType: "decimal", Precision: 10, Scale: 2},
+		{Name: "Qty", DataType: "int"},
+		{Name: "Total", DataType: "decimal", Precision: 10, Scale: 2, Nullable: true},
+		{Name: "Active", DataType: "bit"},
+	}}).tableInfo()
+}
+
+func TestRowTransform(t *testing.T) {
+	// csv values are text, the expressions take the numbers of the numeric columns as numbers
+	records := planRecords(t, lineTable(), "01_Lines.csv", "Email;Price;Qty\nAnn@Example.COM;2.5;4\nbob@example.com;1;NULL\n",
+		"-transform", "Email=lower(Email)", "-transform", "Total=Qty == nil ? nil : Price * Qty")
+	tests := []struct {
+		email string
+		total any
+	}{
+		{email: "ann@example.com", total: json.Number("10")},
+		{email: "bob@example.com", total: nil},
+	}
+	if len(records) != len(tests) {
+		t.Fatalf("%d records, want %d", len(records), len(tests))
+	}
+	for i, tt := range tests {
+		if records[i].err != nil {
+			t.Fatalf("record %d: %v", i+1, records[i].err)
+		}
+		if got := records[i].values["Email"]; got != tt.email {
+			t.Errorf("record %d: Email %v, want %v", i+1, got, tt.email)
+		}
+		if got := records[i].values["Total"]; got != tt.total {
+			t.Errorf("record %d: Total %#v, want %#v", i+1, got, tt.total)
+		}
+	}
+
+	// the expressions all see the values the row had before
+	swap, err := compileTransform(map[string]string{"Price": "Qty", "Qty": "Price"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := dataRecord{values: map[string]any{"Price": json.Number("2"), "Qty": json.Number("3")}}
+	if err := swap.apply(record, lineTable(), Json); err != nil {
+		t.Fatal(err)
+	}
+	if record.values["Price"] != json.Number("3") || record.values["Qty"] != json.Number("2") {
+		t.Errorf("values %v, want Price and Qty swapped", record.values)
+	}
+
+	if _, err := compileTransform(map[string]string{"Total": "Price *"}); err == nil || !strings.Contains(err.Error(), "transform Total") {
+		t.Errorf("error %v, want the column of the expression failing to compile", err)
+	}
+	failing, err := compileTransform(map[string]string{"Total": "Price / 0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record = dataRecord{values: map[string]any{"Price": json.Number("0")}}
+	if err := failing.apply(record, lineTable(), Json); err == nil {
+		t.Error("no error for a result not a number")
+	}
+}
+
+func TestWhere(t *testing.T) {
+	data := "Email;Country;Qty;Active\na@example.com;DE;1;1\nb@example.com;FR;2;1\nc@example.com;DE;3;false\nd@example.com;DE;NULL;true\n"
+	tests := []struct {
+		where string
+		want  []string
+	}{
+		{where: `Country == "DE" && Active`, want: []string{"a@example.com", "d@example.com"}},
+		{where: `Qty != nil && Qty >= 2`, want: []string{"b@example.com", "c@example.com"}},
+		{where: `Qty == nil`, want: []string{"d@example.com"}},
+		{where: `Email endsWith "@other.com"`},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, record := range planRecords(t, lineTable(), "01_Lines.csv", data, "-where", tt.where) {
+			if record.err != nil {
+				t.Errorf("%s: %v", tt.where, record.err)
+			}
+			got = append(got, record.values["Email"].(string))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: rows %v, want %v", tt.where, got, tt.want)
+		}
+	}
+
+	// a row the filter fails on is kept to be rejected with the error
+	where, err := compileWhere(`Qty > 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := filterRecords([]dataRecord{{values: map[string]any{"Qty": "x"}}}, where, lineTable(), Csv)
+	if len(records) != 1 || records[0].err == nil {
+		t.Errorf("records %+v, want the row kept with its error", records)
+	}
+	if _, err := compileWhere(`"DE"`); err == nil {
+		t.Error("no error for a filter not a condition")
+	}
+}
@@ -0,0 +1,487 @@
+package loader
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-sql/civil"
+	"github.com/google/uuid"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// newIdToken is the data value that asks for a client side generated uniqueidentifier.
+const newIdToken = "NEWID()"
+
+// hierarchyPath matches the canonical string form of a hierarchyid, e.g. /1/3.2/
+var hierarchyPath = regexp.MustCompile(`^/(-?\d+(\.-?\d+)*/)*$`)
+
+// dateTimeLayouts are tried in order for date and time column values, values without offset are taken as UTC.
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var timeLayouts = []string{
+	"15:04:05.999999999",
+	"15:04",
+}
+
+// integerRanges holds the bounds of the integer column types.
+var integerRanges = map[string][2]int64{
+	"tinyint":  {0, math.MaxUint8},
+	"smallint": {math.MinInt16, math.MaxInt16},
+	"int":      {math.MinInt32, math.MaxInt32},
+	"bigint":   {math.MinInt64, math.MaxInt64},
+}
+
+// conversionOptions tunes how data values are converted for the column types.
+type conversionOptions struct {
+	SRID        int
+	ValidateXML bool
+	// DateFormats are tried before dateTimeLayouts
+	DateFormats []string
+	// MoneyLocale and MoneyGrouping are the separators of the money values of strings, they are
+	// normalized when either is set
+	MoneyLocale   string
+	MoneyGrouping string
+	// Timezone is where values without offset are, UTC if nil, and ColumnTimezones the ones
+	// of the columns of the table by lower cased name
+	Timezone        *time.Location
+	ColumnTimezones map[string]*time.Location
+	// ConvertTimezone converts values with an offset to the timezone of their column
+	ConvertTimezone bool
+	// Hints are the parse rules of the hints file by lower cased column name
+	Hints map[string]string
+	// Strict makes values changed on the way to the column invalid, see strict.go
+	Strict bool
+	// Absent are the policies of the columns missing or null in a row by lower cased column
+	// name, * for all columns
+	Absent map[string]string
+}
+
+// The policies of a column missing or null in a row: left out of the insert so its default
+// applies, or inserted as NULL. With none a missing column and a csv NULL are left out and a json
+// null inserted.
+const (
+	absentDefault = "default"
+	absentNull    = "null"
+)
+
+// absentPolicy returns the policy of the column missing or null in a row, empty if none.
+func (o conversionOptions) absentPolicy(column string) string {
+	if policy, ok := o.Absent[strings.ToLower(column)]; ok {
+		return policy
+	}
+	return o.Absent["*"]
+}
+
+// location returns the timezone of the values without offset of the column.
+func (o conversionOptions) location(column string) *time.Location {
+	if loc, ok := o.ColumnTimezones[strings.ToLower(column)]; ok {
+		return loc
+	}
+	if o.Timezone != nil {
+		return o.Timezone
+	}
+	return time.UTC
+}
+
+// supportedTypes lists the column types values can be inserted into.
+var supportedTypes = []string{
+	"bigint", "int", "smallint", "tinyint", "bit",
+	"decimal", "numeric", "money", "smallmoney", "float", "real",
+	"date", "datetime", "datetime2", "datetimeoffset", "smalldatetime", "time",
+	"char", "varchar", "text", "nchar", "nvarchar", "ntext",
+	"binary", "varbinary", "image",
+	"uniqueidentifier", "xml", "geography", "geometry", "hierarchyid", "sql_variant",
+}
+
+// skipReason tells why values for the column are never inserted, if so.
+func skipReason(col ColumnSchema) (string, bool) {
+	if col.DataType == "timestamp" {
+		return "rowversion values are generated by the server", true
+	}
+	if !slices.Contains(supportedTypes, col.DataType) {
+		return fmt.Sprintf("unsupported data type %s", col.DataType), true
+	}
+	return "", false
+}
+
+func isRequired(col ColumnSchema) bool {
+	return col.IsNullable != "YES" && !col.ColumnDefault.Valid
+}
+
+// convertValue checks val against the column type and returns the value to bind,
+// typed so that the parameter matches the column and needs no implicit conversion on the server.
+func convertValue(col ColumnSchema, val any, opts conversionOptions) (any, error) {
+	if val == nil {
+		return nil, nil
+	}
+	if rule, ok := opts.Hints[strings.ToLower(col.ColumnName)]; ok {
+		return convertHinted(col, val, rule, opts)
+	}
+	switch col.DataType {
+	case "bit":
+		return convertBit(val)
+	case "tinyint", "smallint", "int", "bigint":
+		return convertInteger(val, integerRanges[col.DataType])
+	case "decimal", "numeric", "money", "smallmoney":
+		if s, ok := val.(string); ok && (opts.MoneyLocale != "" || opts.MoneyGrouping != "") {
+			val = normalizeMoney(s, opts.MoneyLocale, opts.MoneyGrouping)
+		}
+		d, err := convertDecimal(val)
+		if err != nil || !opts.Strict {
+			return d, err
+		}
+		if err := checkScale(col, string(d.(mssql.VarChar))); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case "float", "real":
+		return convertFloat(val)
+	case "date", "datetime", "datetime2", "datetimeoffset", "smalldatetime", "time":
+		return convertDateTime(col, val, opts)
+	case "char", "varchar", "text":
+		if opts.Strict {
+			if err := checkText(col, val); err != nil {
+				return nil, err
+			}
+		}
+		return convertVarChar(val)
+	case "nchar", "nvarchar", "ntext":
+		if opts.Strict {
+			if err := checkText(col, val); err != nil {
+				return nil, err
+			}
+		}
+		return convertString(val)
+	case "uniqueidentifier":
+		return convertUniqueIdentifier(val)
+	case "geography", "geometry":
+		return convertSpatial(val)
+	case "hierarchyid":
+		return convertHierarchyId(val)
+	case "xml":
+		return convertXml(val, opts.ValidateXML)
+	case "binary", "varbinary", "image":
+		return convertBinary(val)
+	case "sql_variant":
+		if n, ok := val.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+			return n.Float64()
+		}
+	}
+	return val, nil
+}
+
+// placeholder returns the expression binding param to the column in the insert statement.
+func placeholder(col ColumnSchema, param string, opts conversionOptions) string {
+	switch col.DataType {
+	case "geography", "geometry":
+		return fmt.Sprintf("%s::STGeomFromText(%s, %d)", col.DataType, param, opts.SRID)
+	case "hierarchyid":
+		return fmt.Sprintf("hierarchyid::Parse(%s)", param)
+	}
+	return param
+}
+
+// generateValue returns a value for a required column missing from the data,
+// if the column type allows to make one up on the client.
+func generateValue(col ColumnSchema) (any, bool) {
+	switch col.DataType {
+	case "uniqueidentifier":
+		return mssql.UniqueIdentifier(uuid.New()), true
+	}
+	return nil, false
+}
+
+func convertUniqueIdentifier(val any) (any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected guid string, got %v", val)
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(s, newIdToken) {
+		return mssql.UniqueIdentifier(uuid.New()), nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid guid %q: %w", s, err)
+	}
+	return mssql.UniqueIdentifier(id), nil
+}
+
+func convertHierarchyId(val any) (any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected hierarchy path string, got %v", val)
+	}
+	s = strings.TrimSpace(s)
+	if !hierarchyPath.MatchString(s) {
+		return nil, fmt.Errorf("invalid hierarchy path %q", s)
+	}
+	return s, nil
+}
+
+func convertXml(val any, validate bool) (any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected xml string, got %v", val)
+	}
+	if validate {
+		d := xml.NewDecoder(strings.NewReader(s))
+		for {
+			_, err := d.Token()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("malformed xml: %w", err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// convertBinary decodes binary values given as 0x prefixed hex or base64 strings.
+func convertBinary(val any) (any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected hex or base64 string, got %v", val)
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex value: %w", err)
+		}
+		return b, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 value: %w", err)
+	}
+	return b, nil
+}
+
+func convertBit(val any) (any, error) {
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "1", "true":
+			return true, nil
+		case "0", "false":
+			return false, nil
+		}
+	case json.Number:
+		return convertBit(v.String())
+	case float64:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	case int:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	}
+	return nil, fmt.Errorf("expected bit value, got %v", val)
+}
+
+func convertInteger(val any, bounds [2]int64) (any, error) {
+	var i int64
+	switch v := val.(type) {
+	case int:
+		i = int64(v)
+	case int64:
+		i = v
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v > math.MaxInt64 {
+			return nil, fmt.Errorf("expected integer, got %v", v)
+		}
+		i = int64(v)
+	case json.Number:
+		return convertInteger(v.String(), bounds)
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected integer, got %q", v)
+		}
+		i = n
+	case bool:
+		i = 0
+		if v {
+			i = 1
+		}
+	default:
+		return nil, fmt.Errorf("expected integer, got %v", val)
+	}
+	if i < bounds[0] || i > bounds[1] {
+		return nil, fmt.Errorf("integer %d out of range", i)
+	}
+	return i, nil
+}
+
+// convertDecimal keeps exact numeric values as text, the server parses them without rounding.
+func convertDecimal(val any) (any, error) {
+	var s string
+	switch v := val.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case json.Number:
+		s = v.String()
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil, fmt.Errorf("expected number, got %v", val)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("expected number, got %q", s)
+	}
+	if !strings.ContainsAny(s, "eE") {
+		return mssql.VarChar(s), nil
+	}
+	// the server does not take exponents for decimal, write the value out
+	if r.IsInt() {
+		return mssql.VarChar(r.FloatString(0)), nil
+	}
+	return mssql.VarChar(strings.TrimRight(r.FloatString(38), "0")), nil
+}
+
+func convertFloat(val any) (any, error) {
+	switch v := val.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected number, got %q", v)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("expected number, got %v", val)
+}
+
+// parseTime parses s with the first layout matching, values without offset in loc, and tells
+// whether s had an offset.
+func parseTime(s string, layouts []string, loc *time.Location) (time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, strings.Contains(layout, "Z07") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST"), nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("unrecognized date/time %q", s)
+}
+
+func convertDateTime(col ColumnSchema, val any, opts conversionOptions) (any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected date/time string, got %v", val)
+	}
+	loc := opts.location(col.ColumnName)
+	if col.DataType == "time" {
+		t, _, err := parseTime(s, slices.Concat(opts.DateFormats, timeLayouts, dateTimeLayouts), loc)
+		if err != nil {
+			return nil, err
+		}
+		return civil.TimeOf(t), nil
+	}
+	t, offset, err := parseTime(s, slices.Concat(opts.DateFormats, dateTimeLayouts), loc)
+	if err != nil {
+		return nil, err
+	}
+	if offset && opts.ConvertTimezone {
+		t = t.In(loc)
+	}
+	if opts.Strict {
+		if err := checkTime(col, t, !offset || opts.ConvertTimezone); err != nil {
+			return nil, err
+		}
+	}
+	switch col.DataType {
+	case "date":
+		return civil.DateOf(t), nil
+	case "datetime", "smalldatetime":
+		return mssql.DateTime1(t), nil
+	case "datetimeoffset":
+		return t, nil
+	}
+	// datetime2 has no offset, keep the wall clock as written
+	return civil.DateTimeOf(t), nil
+}
+
+func stringOf(val any) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case map[string]any, []any:
+		// nested json is stored as json text
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+	return "", fmt.Errorf("expected string, got %v", val)
+}
+
+func convertString(val any) (any, error) {
+	return stringOf(val)
+}
+
+// convertVarChar binds ascii text as varchar, anything else stays nvarchar
+// for the server to convert to the column code page.
+func convertVarChar(val any) (any, error) {
+	s, err := stringOf(val)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return s, nil
+		}
+	}
+	if len(s) > 8000 {
+		return mssql.VarCharMax(s), nil
+	}
+	return mssql.VarChar(s), nil
+}
@@ -0,0 +1,139 @@
+package loader
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-sql/civil"
+	"github.com/google/uuid"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestParseTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		value      string
+		layouts    []string
+		loc        *time.Location
+		want       time.Time
+		wantOffset bool
+		wantErr    bool
+	}{
+		{value: "2024-03-01T10:30:00Z", loc: time.UTC, want: time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), wantOffset: true},
+		{value: "2024-03-01T10:30:00+02:00", loc: time.UTC, want: time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC), wantOffset: true},
+		{value: "2024-03-01 10:30:00 +02:00", loc: time.UTC, want: time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC), wantOffset: true},
+		{value: "2024-03-01T10:30:00", loc: time.UTC, want: time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{value: " 2024-03-01 10:30 ", loc: time.UTC, want: time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+		// values without offset are in the location, winter and summer time
+		{value: "2024-01-15 10:30:00", loc: berlin, want: time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC)},
+		{value: "2024-07-15 10:30:00", loc: berlin, want: time.Date(2024, 7, 15, 8, 30, 0, 0, time.UTC)},
+		{value: "2024-07-15T10:30:00Z", loc: berlin, want: time.Date(2024, 7, 15, 10, 30, 0, 0, time.UTC), wantOffset: true},
+		{value: "2024-03-01", loc: berlin, want: time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC)},
+		{value: "01.03.2024", layouts: []string{"02.01.2006"}, loc: time.UTC, want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "01.03.2024", loc: time.UTC, wantErr: true},
+		{value: "2024-13-01", loc: time.UTC, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, offset, err := parseTime(tt.value, slices.Concat(tt.layouts, dateTimeLayouts), tt.loc)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTime(%q): error %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (!got.Equal(tt.want) || offset != tt.wantOffset) {
+			t.Errorf("parseTime(%q, %s) = %s, %t, want %s, %t", tt.value, tt.loc, got, offset, tt.want, tt.wantOffset)
+		}
+	}
+}
+
+func TestConvertDateTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	column := func(dataType string) ColumnSchema {
+		return ColumnSchema{ColumnName: "At", DataType: dataType, DatetimePrecision: sql.NullInt64{Int64: 7, Valid: dataType == "datetime2"}}
+	}
+	tests := []struct {
+		name     string
+		dataType string
+		value    string
+		opts     conversionOptions
+		want     any
+		wantErr  bool
+	}{
+		{name: "date", dataType: "date", value: "2024-03-01", want: civil.Date{Year: 2024, Month: 3, Day: 1}},
+		{name: "wall clock kept", dataType: "datetime2", value: "2024-03-01T10:30:00+02:00",
+			want: civil.DateTime{Date: civil.Date{Year: 2024, Month: 3, Day: 1}, Time: civil.Time{Hour: 10, Minute: 30}}},
+		{name: "converted to the column timezone", dataType: "datetime2", value: "2024-07-01T10:30:00Z",
+			opts: conversionOptions{ColumnTimezones: map[string]*time.Location{"at": berlin}, ConvertTimezone: true},
+			want: civil.DateTime{Date: civil.Date{Year: 2024, Month: 7, Day: 1}, Time: civil.Time{Hour: 12, Minute: 30}}},
+		{name: "offset kept", dataType: "datetimeoffset", value: "2024-03-01 10:30:00", opts: conversionOptions{Timezone: berlin},
+			want: time.Date(2024, 3, 1, 10, 30, 0, 0, berlin)},
+		{name: "time", dataType: "time", value: "10:30:15", want: civil.Time{Hour: 10, Minute: 30, Second: 15}},
+		{name: "strict offset dropped", dataType: "datetime2", value: "2024-03-01T10:30:00+02:00", opts: conversionOptions{Strict: true}, wantErr: true},
+		{name: "strict offset converted", dataType: "datetime2", value: "2024-03-01T10:30:00+02:00", opts: conversionOptions{Strict: true, ConvertTimezone: true},
+			want: civil.DateTime{Date: civil.Date{Year: 2024, Month: 3, Day: 1}, Time: civil.Time{Hour: 8, Minute: 30}}},
+		{name: "not a string", dataType: "date", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any = tt.value
+			if tt.value == "" {
+				value = 20240301
+			}
+			got, err := convertDateTime(column(tt.dataType), value, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if want, ok := tt.want.(time.Time); ok {
+				if g, _ := got.(time.Time); !g.Equal(want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertUniqueIdentifier(t *testing.T) {
+	id := "6F9619FF-8B86-D011-B42D-00C04FC964FF"
+	tests := []struct {
+		name    string
+		val     any
+		want    any
+		wantNew bool
+		wantErr bool
+	}{
+		{name: "guid", val: id, want: mssql.UniqueIdentifier(uuid.MustParse(id))},
+		{name: "newid", val: "newid()", wantNew: true},
+		{name: "empty", val: "", want: nil},
+		{name: "blank", val: "  ", want: nil},
+		{name: "invalid", val: "not a guid", wantErr: true},
+		{name: "not a string", val: 1.0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertUniqueIdentifier(tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantNew {
+				if _, ok := got.(mssql.UniqueIdentifier); !ok {
+					t.Errorf("value = %#v, want a new guid", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("value = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// schemaSnapshotJson is the snapshot the offline validate tests check files against.
+const schemaSnapshotJson = `{"version": 1, "tables": [{"schema": "dbo", "name": "Orders", "columns": [
+	{"name": "Id", "data_type": "int", "nullable": false, "identity": {"seed": 1, "increment": 1}},
+	{"name": "Customer", "data_type": "nvarchar", "max_length": 10, "nullable": false},
+	{"name": "Total", "data_type": "decimal", "precision": 10, "scale": 2, "nullable": true},
+	{"name": "Created", "data_type": "datetime2", "scale": 7, "nullable": true}
+], "primary_key": ["Id"]}]}`
+
+// validateOffline runs validate with the arguments against the schema snapshot, with no database.
+func validateOffline(t *testing.T, args ...string) (result *RunResult, err error) {
+	t.Helper()
+	opts := &uploadOptions{validate: true, yes: true}
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	opts.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	opts.log.quiet = true
+	if err := opts.sourceOptions.check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.offline(); err != nil {
+		t.Fatal(err)
+	}
+	result = newRunResult("validate")
+	return result, uploadTo(context.Background(), nil, opts, result, io.Discard)
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		data     string
+		wantCode AppExitCode
+		wantRows int
+	}{
+		{name: "valid", file: "01_Orders.json",
+			data:     `[{"Id": 1, "Customer": "Ada", "Total": "12.50", "Created": "2024-03-01T10:30:00"}, {"Id": 2, "Customer": "Bob", "Total": null}]`,
+			wantRows: 2},
+		{name: "valid csv", file: "01_Orders.csv", data: "Id;Customer;Total\n1;Ada;12.50\n2;Bob;NULL\n", wantRows: 2},
+		{name: "invalid values", file: "01_Orders.json",
+			data:     `[{"Id": 1, "Customer": "Ada", "Total": "twelve"}, {"Id": 2, "Created": "2024-03-01"}, {"Id": 3, "Customer": "Cy"}]`,
+			wantCode: ValidationErrorCode, wantRows: 3},
+		{name: "table missing", file: "01_Customers.json", data: `[{"Name": "Ada"}]`, wantCode: TableInfoErrorCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			schema := filepath.Join(dir, "schema.json")
+			data := filepath.Join(dir, "data")
+			if err := os.WriteFile(schema, []byte(schemaSnapshotJson), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(data, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(data, tt.file), []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			result, err := validateOffline(t, "-d", data, "-schema", schema)
+			var runErr *RunError
+			switch {
+			case tt.wantCode == SuccessCode && err != nil:
+				t.Fatalf("error %v", err)
+			case tt.wantCode != SuccessCode && (!errors.As(err, &runErr) || runErr.Code != tt.wantCode):
+				t.Fatalf("error %v, want code %d", err, tt.wantCode)
+			}
+			if tt.wantRows == 0 {
+				return
+			}
+			if len(result.Files) != 1 || result.Files[0].Rows != tt.wantRows {
+				t.Fatalf("files %+v, want one of %d rows", result.Files, tt.wantRows)
+			}
+			if invalid := result.Files[0].InvalidRows; tt.wantCode == ValidationErrorCode && invalid != 2 {
+				t.Errorf("invalid rows %d, want 2", invalid)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    uploadOptions
+		wantErr string
+	}{
+		{name: "validate", opts: uploadOptions{validate: true}},
+		{name: "upload", opts: uploadOptions{}, wantErr: "-schema is for validate"},
+		{name: "check existing", opts: uploadOptions{validate: true, checkExisting: "skip"}, wantErr: "-check-existing needs the database"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.offline()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// syncTable is the table of the sync tests, keyed by Id.
+func syncTable() *tableInfo {
+	return (&tableDefinition{Schema: "dbo", Name: "Orders", PrimaryKey: []string{"Id"}, Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "Customer", DataType: "nvarchar", MaxLength: 50},
+	}}).tableInfo()
+}
+
+func TestSyncStatement(t *testing.T) {
+	table := syncTable()
+	u := &uploader{opts: &uploadOptions{}}
+	row := &rowValues{columns: []ColumnSchema{table.schema["Id"], table.schema["Customer"]}, values: []any{int64(1), "Ann"}}
+	stmt, err := u.buildStatement(table, row, SyncMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "MERGE INTO [dbo].[Orders] AS target USING (VALUES (@p1, @p2)) AS source ([Id], [Customer]) ON target.[Id] = source.[Id]" +
+		" WHEN MATCHED THEN UPDATE SET target.[Customer] = source.[Customer]" +
+		" WHEN NOT MATCHED THEN INSERT ([Id], [Customer]) VALUES (source.[Id], source.[Customer]);"
+	if stmt.query != want {
+		t.Errorf("query:\n%s\nwant:\n%s", stmt.query, want)
+	}
+	// the rows are matched by primary key
+	row = &rowValues{columns: []ColumnSchema{table.schema["Customer"]}, values: []any{"Ann"}}
+	if _, err := u.buildStatement(table, row, SyncMode); err == nil || !strings.Contains(err.Error(), "primary key column Id") {
+		t.Errorf("error %v, want the primary key missing", err)
+	}
+}
+
+func TestDeleteMissingRows(t *testing.T) {
+	table := syncTable()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"01_Orders.json": `[{"Id": 1, "Customer": "Ann"}]`,
+		"02_Orders.json": `[{"Id": 3, "Customer": "Cid"}]`,
+	})
+	opts, source := sourceFor(t, "upload", "-d", dir, "-mode", "sync")
+	files, err := source.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, _, err := source.planFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{results: map[string]*fakeResult{
+		"SELECT [Id] FROM [dbo].[Orders] ORDER BY [Id]": {columns: []string{"Id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}}},
+	}}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	sdb := sqlx.NewDb(db, "sqlserver")
+	u := &uploader{ctx: context.Background(), db: sdb, opts: opts, plans: plans, result: newRunResult("upload"), out: io.Discard,
+		batch: newBatch(context.Background(), sdb, 10, 0, logger()), log: logger()}
+	u.result.startFile(plans[0].name, plans[0].table)
+
+	// the rows of both files of the table are kept
+	if err := u.deleteMissingRows(table, plans[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.batch.commit(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DELETE FROM [dbo].[Orders] WHERE [Id] = @p1;", "DELETE FROM [dbo].[Orders] WHERE [Id] = @p1;"}
+	if !slices.Equal(server.committed, want) {
+		t.Errorf("statements %q, want %q", server.committed, want)
+	}
+	if deleted := u.result.current.DeletedRows; deleted != 2 {
+		t.Errorf("deleted rows %d, want 2", deleted)
+	}
+
+	table.primaryKey = nil
+	var runErr *RunError
+	if err := u.deleteMissingRows(table, plans[0]); !errors.As(err, &runErr) || runErr.Code != TableInfoErrorCode {
+		t.Errorf("error %v, want code %d without a primary key", err, TableInfoErrorCode)
+	}
+}
+
+func TestAbsentPolicy(t *testing.T) {
+	table := (&tableDefinition{Schema: "dbo", Name: "Orders", Columns: []columnDefinition{
+		{Name: "Id", DataType: "int"},
+		{Name: "CreatedAt", DataType: "datetime2", Scale: 0, Nullable: true, Default: "(getdate())"},
+		{Name: "Note", DataType: "nvarchar", MaxLength: 100, Nullable: true},
+		{Name: "Code", DataType: "varchar", MaxLength: 10, Default: "('x')"},
+	}}).tableInfo()
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "json null inserted", file: "01_Orders.json", data: `[{"Id": 1, "CreatedAt": null}]`, want: "Id=1 CreatedAt=<nil>"},
+		{name: "csv NULL left out", file: "01_Orders.csv", data: "Id;CreatedAt\n1;NULL\n", want: "Id=1"},
+		{name: "default", file: "01_Orders.json", data: `[{"Id": 1, "CreatedAt": null}]`, args: []string{"-absent", "createdat=default"}, want: "Id=1"},
+		{name: "default of the table", file: "01_Orders.json", data: `[{"Id": 1, "CreatedAt": null}]`, args: []string{"-absent", "Orders.CreatedAt=default"}, want: "Id=1"},
+		{name: "default of another table", file: "01_Orders.json", data: `[{"Id": 1, "CreatedAt": null}]`, args: []string{"-absent", "Lines.CreatedAt=default"}, want: "Id=1 CreatedAt=<nil>"},
+		{name: "null", file: "01_Orders.csv", data: "Id;CreatedAt\n1;NULL\n", args: []string{"-absent", "CreatedAt=null"}, want: "Id=1 CreatedAt=<nil>"},
+		{name: "null of missing columns", file: "01_Orders.json", data: `[{"Id": 1}]`, args: []string{"-absent", "*=null", "-absent", "Code=default"}, want: "Id=1 CreatedAt=<nil> Note=<nil>"},
+		{name: "null of a required column", file: "01_Orders.json", data: `[{"Id": 1}]`, args: []string{"-absent", "*=null"}, wantErr: "Code is NULL"},
+	}
+	u := &uploader{opts: &uploadOptions{}, summary: newRunSummary(), log: logger()}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{tt.file: tt.data})
+		_, source := sourceFor(t, "upload", append([]string{"-d", dir}, tt.args...)...)
+		files, err := source.files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plans, _, err := source.planFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := plans[0].records(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values map[string]any
+		for _, record := range records.all() {
+			values = record.values
+		}
+		records.close()
+		row, err := u.buildRow(table, values, plans[0].ext, plans[0].conv)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for i, col := range row.columns {
+			got = append(got, fmt.Sprintf("%s=%v", col.ColumnName, row.values[i]))
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: row %s, want %s", tt.name, strings.Join(got, " "), tt.want)
+		}
+	}
+	if err := sourceError("-absent", "Note=zero"); err == nil || !strings.Contains(err.Error(), `unknown policy "zero"`) {
+		t.Errorf("error %v, want the unknown policy", err)
+	}
+}
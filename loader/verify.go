@@ -0,0 +1,314 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/golang-sql/civil"
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// verifyOptions are the flags of the verify command.
+type verifyOptions struct {
+	sourceOptions
+	conn connOptions
+	log  logOptions
+}
+
+func (o *verifyOptions) addFlags(fs *flag.FlagSet) {
+	o.conn.addFlags(fs)
+	o.log.addFlags(fs)
+	o.sourceOptions.addFlags(fs)
+}
+
+func runVerify(cmd *command, args []string) {
+	var opts verifyOptions
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	parseFlags(fs, args)
+	err := opts.sourceOptions.check()
+	if err == nil {
+		err = opts.log.check()
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	handleError(opts.log.setup(), OpenFileErrorCode)
+
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	source, err := newFileSource(&opts.sourceOptions, logger())
+	handleError(err, ReadDirErrorCode)
+	files, err := source.files()
+	handleError(err, ReadDirErrorCode)
+	plans, _, err := source.planFiles(files)
+	handleError(err, ReadFileErrorCode)
+	handleError(verify(db, plans, os.Stdout, logger()), VerifyFailedCode)
+}
+
+// verify compares the row count and digest of every table to the ones of its data files,
+// the run fails if any differs.
+func verify(db *sqlx.DB, plans []*filePlan, out io.Writer, log *slog.Logger) error {
+	tables, tablePlans := groupByTable(plans)
+	failed := 0
+	for _, ref := range tables {
+		table, err := getTableInfo(db, ref)
+		if err != nil {
+			return runError(err, dbErrorCode(err, TableInfoErrorCode))
+		}
+		if len(table.columns) == 0 {
+			return runError(fmt.Errorf("table %s not found", ref), TableInfoErrorCode)
+		}
+		files, cols, err := fileDigest(table, tablePlans[ref])
+		if err != nil {
+			return err
+		}
+		tbl, err := queryTableDigest(db, table, cols, log)
+		if err != nil {
+			return runError(err, dbErrorCode(err, ExportErrorCode))
+		}
+
+		names := make([]string, len(cols))
+		for i, col := range cols {
+			names[i] = col.ColumnName
+		}
+		log.Info("table verified", "table", ref.String(), "file_rows", files.rows, "table_rows", tbl.rows,
+			"file_digest", files.String(), "table_digest", tbl.String(), "columns", strings.Join(names, ","))
+		color := colorFor(out)
+		switch {
+		case files.rows != tbl.rows:
+			failed++
+			fmt.Fprintln(out, paint(color, colorRed, fmt.Sprintf("%s: %d rows in the table, %d in the data files", ref, tbl.rows, files.rows)))
+		case files != tbl:
+			failed++
+			fmt.Fprintln(out, paint(color, colorRed, fmt.Sprintf("%s: %d rows, checksum of %s differs", ref, tbl.rows, strings.Join(names, ", "))))
+		default:
+			fmt.Fprintf(out, "%s: %d rows, ok\n", ref, tbl.rows)
+		}
+	}
+	if failed > 0 {
+		return runError(fmt.Errorf("%d of %d tables do not match their data files", failed, len(tables)), VerifyFailedCode)
+	}
+	log.Info("verify done, the tables match the data files")
+	return nil
+}
+
+// fileDigest returns the digest of the rows of the data files of the table and the columns hashed.
+func fileDigest(table *tableInfo, plans []*filePlan) (tableDigest, []ColumnSchema, error) {
+	var files tableDigest
+	records := make(map[*filePlan]*recordSet)
+	for _, plan := range plans {
+		set, err := plan.records(table)
+		defer set.close()
+		if err != nil {
+			return files, nil, err
+		}
+		records[plan] = set
+	}
+	cols := digestColumns(table, records)
+
+	for _, plan := range plans {
+		for i, record := range records[plan].all() {
+			if record.err != nil {
+				return files, nil, runError(fmt.Errorf("%s row %d (line %d): %w", plan.name, i+1, record.line, record.err), ConversionErrorCode)
+			}
+			values := make([]any, len(cols))
+			for j, col := range cols {
+				val := record.values[col.ColumnName]
+				if plan.ext == Csv && val == "NULL" {
+					val = nil
+				}
+				v, err := convertValue(col, val, plan.conv)
+				if err != nil {
+					return files, nil, runError(fmt.Errorf("%s row %d (line %d): column %s: %w", plan.name, i+1, record.line, col.ColumnName, err), ConversionErrorCode)
+				}
+				values[j] = v
+			}
+			if err := files.add(cols, values); err != nil {
+				return files, nil, runError(fmt.Errorf("%s row %d (line %d): %w", plan.name, i+1, record.line, err), ConversionErrorCode)
+			}
+		}
+		if err := records[plan].err(); err != nil {
+			return files, nil, err
+		}
+	}
+	return files, cols, nil
+}
+
+// digestColumns returns the columns hashed for the table, the ones every row of the files has
+// and whose values read back the same from the server.
+func digestColumns(table *tableInfo, records map[*filePlan]*recordSet) []ColumnSchema {
+	var cols []ColumnSchema
+	for _, name := range table.columns {
+		col := table.schema[name]
+		if _, ok := digestExprs[col.DataType]; !ok || slices.Contains(table.computeColumns, name) {
+			continue
+		}
+		inAll := true
+		for _, rows := range records {
+			for _, record := range rows.all() {
+				if _, ok := record.values[name]; !ok {
+					inAll = false
+					break
+				}
+			}
+		}
+		if inAll {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// trimZerosExpr trims the trailing zeros of the decimal text %[1]s, and the point if nothing is left after it.
+const trimZerosExpr = "CASE WHEN CHARINDEX('.', %[1]s) = 0 THEN %[1]s ELSE " +
+	"REPLACE(RTRIM(REPLACE(REPLACE(RTRIM(REPLACE(%[1]s, '0', ' ')), ' ', '0'), '.', ' ')), ' ', '.') END"
+
+// digestExprs write a column value of the type as the text digestText makes of the value converted for
+// the column. Types whose text does not read back the same, like float or xml, are not hashed.
+var digestExprs = map[string]string{
+	"bit":              "CONVERT(nvarchar(1), %s)",
+	"tinyint":          "CONVERT(nvarchar(20), %s)",
+	"smallint":         "CONVERT(nvarchar(20), %s)",
+	"int":              "CONVERT(nvarchar(20), %s)",
+	"bigint":           "CONVERT(nvarchar(20), %s)",
+	"decimal":          fmt.Sprintf(trimZerosExpr, "CONVERT(nvarchar(50), %[1]s)"),
+	"numeric":          fmt.Sprintf(trimZerosExpr, "CONVERT(nvarchar(50), %[1]s)"),
+	"money":            fmt.Sprintf(trimZerosExpr, "CONVERT(nvarchar(50), %[1]s, 2)"),
+	"smallmoney":       fmt.Sprintf(trimZerosExpr, "CONVERT(nvarchar(50), %[1]s, 2)"),
+	"date":             "CONVERT(nvarchar(10), %s, 23)",
+	"datetime":         "CONVERT(nvarchar(23), %s, 121)",
+	"smalldatetime":    "CONVERT(nvarchar(19), %s, 120)",
+	"datetime2":        "CONVERT(nvarchar(27), CONVERT(datetime2(7), %s), 121)",
+	"datetimeoffset":   "CONVERT(nvarchar(27), CONVERT(datetime2(7), SWITCHOFFSET(%s, '+00:00')), 121)",
+	"time":             "CONVERT(nvarchar(16), CONVERT(time(7), %s))",
+	"char":             "RTRIM(%s)",
+	"nchar":            "RTRIM(%s)",
+	"varchar":          "%s",
+	"nvarchar":         "%s",
+	"text":             "CONVERT(nvarchar(max), %s)",
+	"ntext":            "CONVERT(nvarchar(max), %s)",
+	"uniqueidentifier": "CONVERT(nvarchar(36), %s)",
+	"binary":           "CONVERT(nvarchar(max), CONVERT(varbinary(max), %s), 2)",
+	"varbinary":        "CONVERT(nvarchar(max), CONVERT(varbinary(max), %s), 2)",
+	"image":            "CONVERT(nvarchar(max), CONVERT(varbinary(max), %s), 2)",
+	"hierarchyid":      "%s.ToString()",
+}
+
+// digestText writes a value converted for the column as the server writes it with its digestExprs.
+func digestText(col ColumnSchema, v any) (string, error) {
+	switch val := v.(type) {
+	case bool:
+		if val {
+			return "1", nil
+		}
+		return "0", nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case string:
+		return digestString(col, val), nil
+	case mssql.VarChar:
+		return digestString(col, string(val)), nil
+	case mssql.VarCharMax:
+		return digestString(col, string(val)), nil
+	case civil.Date:
+		return val.String(), nil
+	case mssql.DateTime1:
+		t := roundDateTime(col.DataType, time.Time(val))
+		if col.DataType == "smalldatetime" {
+			return t.Format(time.DateTime), nil
+		}
+		return t.Round(time.Millisecond).Format("2006-01-02 15:04:05.000"), nil
+	case civil.DateTime:
+		return val.Date.String() + " " + timeLiteral(val.Time), nil
+	case civil.Time:
+		return timeLiteral(val), nil
+	case time.Time:
+		return val.UTC().Format("2006-01-02 15:04:05.0000000"), nil
+	case mssql.UniqueIdentifier:
+		return val.String(), nil
+	case []byte:
+		return strings.ToUpper(hex.EncodeToString(val)), nil
+	}
+	return "", fmt.Errorf("no digest text for %T", v)
+}
+
+// digestString writes decimal text without trailing zeros and fixed length text without padding.
+func digestString(col ColumnSchema, s string) string {
+	switch col.DataType {
+	case "decimal", "numeric", "money", "smallmoney":
+		return trimZeros(s)
+	case "char", "nchar":
+		return strings.TrimRight(s, " ")
+	}
+	return s
+}
+
+// tableDigest is the row count and an order independent digest of the rows: the sums of the first
+// and second 4 bytes of the SHA-256 of every row text, which unlike CHECKSUM_AGG does not cancel
+// out duplicate rows.
+type tableDigest struct {
+	rows       int64
+	sum1, sum2 int64
+}
+
+func (d tableDigest) String() string {
+	return fmt.Sprintf("%x-%x", d.sum1, d.sum2)
+}
+
+// add hashes a row of values for the columns: each value is written as = and its digestText,
+// nothing for NULL, followed by a unit separator, and hashed as UTF-16 as the server hashes nvarchar.
+func (d *tableDigest) add(cols []ColumnSchema, values []any) error {
+	var sb strings.Builder
+	for i, col := range cols {
+		if values[i] != nil {
+			s, err := digestText(col, values[i])
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.ColumnName, err)
+			}
+			sb.WriteString("=" + s)
+		}
+		sb.WriteRune('\x1f')
+	}
+	units := utf16.Encode([]rune(sb.String()))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	h := sha256.Sum256(b)
+	d.rows++
+	d.sum1 += int64(binary.BigEndian.Uint32(h[0:4]))
+	d.sum2 += int64(binary.BigEndian.Uint32(h[4:8]))
+	return nil
+}
+
+// queryTableDigest has the server count and hash the table rows as tableDigest.add does.
+func queryTableDigest(db *sqlx.DB, table *tableInfo, cols []ColumnSchema, log *slog.Logger) (tableDigest, error) {
+	parts := make([]string, 0, 2*len(cols)+2)
+	for _, col := range cols {
+		expr := fmt.Sprintf(digestExprs[col.DataType], quoteName(col.ColumnName))
+		parts = append(parts, fmt.Sprintf("ISNULL(N'=' + %s, N'')", expr), "NCHAR(31)")
+	}
+	// CONCAT takes two arguments at least
+	parts = append(parts, "N''", "N''")
+	query := fmt.Sprintf(`
+SELECT COUNT_BIG(*), ISNULL(SUM(CONVERT(bigint, SUBSTRING(h, 1, 4))), 0), ISNULL(SUM(CONVERT(bigint, SUBSTRING(h, 5, 4))), 0)
+FROM (SELECT HASHBYTES('SHA2_256', CONCAT(%s)) AS h FROM %s) AS hashed`, strings.Join(parts, ", "), table.ref.quoted())
+	log.Debug("query", "table", table.ref.String(), "sql", query)
+	var d tableDigest
+	err := db.QueryRowx(query).Scan(&d.rows, &d.sum1, &d.sum2)
+	return d, err
+}
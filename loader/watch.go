@@ -0,0 +1,272 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jmoiron/sqlx"
+)
+
+// doneMarkerSuffix is appended to a data file name for the marker written once it is loaded by watch.
+const doneMarkerSuffix = ".done"
+
+func isDoneMarker(fileName string) bool {
+	return strings.HasSuffix(fileName, doneMarkerSuffix)
+}
+
+// loadedBefore tells whether the file has a done marker not older than it.
+func loadedBefore(path string) bool {
+	marker, err := os.Stat(path + doneMarkerSuffix)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !marker.ModTime().Before(info.ModTime())
+}
+
+func runWatch(cmd *command, args []string) {
+	var opts uploadOptions
+	var debounce time.Duration
+	var once bool
+	var healthAddr string
+	fs := newFlagSet(cmd)
+	opts.addFlags(fs)
+	fs.DurationVar(&debounce, "debounce", 2*time.Second, "time a file must go unchanged before it is loaded")
+	fs.BoolVar(&once, "once", false, "load the files without a done marker newer than them and exit, without watching")
+	fs.StringVar(&healthAddr, "health-addr", "", "answer GET /healthz, /readyz and /metrics on this address, host:port, none if empty")
+	opts.parse(fs, args)
+	var err error
+	switch {
+	case opts.filePath != "":
+		err = errors.New("watch loads the files of the -d dirs, not -f")
+	case opts.emitSql != "" || opts.output != "" || opts.reportFile != "" || opts.checkpoint != "" || opts.rollbackSql != "" || opts.snapshotDir != "" || opts.notify.url != "" || opts.metricsPush != "" || opts.otel.endpoint != "":
+		err = errors.New("-emit-sql, -output, -report, -checkpoint, -rollback-sql, -snapshot-before, -notify-url, -metrics-push-url and -otlp-endpoint are for upload")
+	case len(opts.targets) > 1:
+		err = errors.New("more than one -target is for upload")
+	case !opts.yes && !opts.dryRun && !isLocalServer(opts.conn.dataSource) && (opts.file.Truncate || opts.file.Mode == RefreshMode || opts.file.Mode == SyncMode && !opts.noDelete):
+		// nobody is there to answer the question before rows are deleted
+		err = errors.New("-truncate and the refresh and sync modes need -yes with watch on a server other than localhost")
+	case debounce <= 0:
+		err = fmt.Errorf("invalid -debounce %s", debounce)
+	}
+	if err != nil {
+		usageError(fs, err)
+	}
+	watch(&opts, debounce, once, healthAddr)
+}
+
+// watch loads the data files of the -d dirs as they are added or changed, once they went
+// unchanged for the debounce time, until interrupted. A done marker is written next to
+// every file loaded, files with one newer than them are not loaded again.
+func watch(opts *uploadOptions, debounce time.Duration, once bool, healthAddr string) {
+	db, err := opts.conn.open()
+	handleError(err, ConnectErrorCode)
+	defer db.Close()
+	source, err := newFileSource(&opts.sourceOptions, logger())
+	handleError(err, ReadDirErrorCode)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if once {
+		failure, err := loadChanged(ctx, db, opts, source, nil, nil)
+		handleError(err, ReadDirErrorCode)
+		handleError(failure, InsertDataErrorCode)
+		return
+	}
+	var health *healthState
+	if healthAddr != "" {
+		health = newHealthState(db)
+		handleError(health.serve(healthAddr), InternalErrorCode)
+	}
+	// the files failing are left for their next change, the watch goes on
+	load := func(paths map[string]bool) {
+		_, err := loadChanged(ctx, db, opts, source, paths, health)
+		handleError(err, ReadDirErrorCode)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	handleError(err, ReadDirErrorCode)
+	defer watcher.Close()
+	for _, pattern := range opts.dirPaths {
+		paths := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			paths, err = filepath.Glob(pattern)
+			handleError(err, ReadDirErrorCode)
+		}
+		for _, path := range paths {
+			handleError(watchDir(watcher, path, opts.recursive), ReadDirErrorCode)
+		}
+	}
+
+	logger().Info("watching", "dirs", opts.dirPaths.String(), "debounce", debounce.String())
+	// files already there and not loaded go first
+	load(nil)
+
+	// pending holds the files changed with the time of their last change
+	pending := make(map[string]time.Time)
+	ticker := time.NewTicker(debounce / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger().Info("watch stopped")
+			return
+		case err := <-watcher.Errors:
+			handleError(err, ReadDirErrorCode)
+		case event := <-watcher.Events:
+			name := filepath.Base(event.Name)
+			switch {
+			case event.Has(fsnotify.Remove):
+				delete(pending, filepath.Clean(event.Name))
+			case notDataFile(name):
+			case event.Has(fsnotify.Create) || event.Has(fsnotify.Write) || event.Has(fsnotify.Rename):
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if opts.recursive {
+						handleError(watchDir(watcher, event.Name, true), ReadDirErrorCode)
+						// files copied with the dir may come before it is watched
+						load(nil)
+					}
+					continue
+				}
+				pending[filepath.Clean(event.Name)] = time.Now()
+				health.setQueued(len(pending))
+			}
+		case now := <-ticker.C:
+			settled := make(map[string]bool)
+			for path, changed := range pending {
+				if now.Sub(changed) >= debounce {
+					settled[path] = true
+					delete(pending, path)
+				}
+			}
+			if len(settled) > 0 {
+				load(settled)
+			}
+			health.setQueued(len(pending))
+		}
+	}
+}
+
+// watchDir watches the dir, and its subdirs when recursive.
+func watchDir(watcher *fsnotify.Watcher, dir string, recursive bool) error {
+	if !recursive {
+		return watcher.Add(dir)
+	}
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return watcher.Add(path)
+	})
+}
+
+// loadChanged loads the data files of the dirs without a done marker newer than them,
+// of the paths given only if any, in load order. A file failing to load is logged and left
+// for its next change, failure is the error of the last one. The load is recorded in health
+// if given. The error is of the setup of the load, which loads no file.
+func loadChanged(ctx context.Context, db *sqlx.DB, opts *uploadOptions, source *fileSource, paths map[string]bool, health *healthState) (failure, err error) {
+	files, err := source.files()
+	if err != nil {
+		return nil, runError(err, ReadDirErrorCode)
+	}
+	files = slices.DeleteFunc(files, func(file dataFile) bool {
+		return (paths != nil && !paths[filepath.Clean(file.path)]) || loadedBefore(file.path)
+	})
+	if len(files) == 0 {
+		return nil, nil
+	}
+	start := time.Now()
+	u := &uploader{ctx: ctx, db: db, opts: opts, source: source, summary: newRunSummary(), result: newRunResult("watch"), out: os.Stdout,
+		tables: newTableCache(db, logger()), log: logger()}
+	if opts.errorLog != "" {
+		u.errorLog, err = openErrorLog(opts.errorLog, opts.redact)
+		if err != nil {
+			return nil, runError(err, OpenFileErrorCode)
+		}
+		defer u.errorLog.close()
+	}
+	u.policy, err = readErrorPolicy(opts.errorPolicyFile)
+	if err != nil {
+		return nil, runError(err, ReadFileErrorCode)
+	}
+
+	code := SuccessCode
+	fail := func(path string, err error) {
+		failure = runError(err, InsertDataErrorCode)
+		code = failure.(*RunError).Code
+		logger().Error("file not loaded, left for its next change", "file", path, "code", code, "err", err)
+	}
+	for _, file := range files {
+		plans, _, err := source.planFiles([]dataFile{file})
+		if err != nil {
+			fail(file.path, err)
+			continue
+		}
+		u.plans = append(u.plans, plans...)
+	}
+	if u.writesToDb() && !opts.yes && !isLocalServer(opts.conn.dataSource) {
+		// nobody is there to answer the question, the files deleting rows need -yes
+		u.plans = slices.DeleteFunc(u.plans, func(plan *filePlan) bool {
+			tables := destructiveTables([]*filePlan{plan}, opts.noDelete)
+			if len(tables) > 0 {
+				fail(plan.path, runError(fmt.Errorf("deletes table rows (%s), run with -yes to load it", strings.Join(tables, ", ")), NotConfirmedCode))
+			}
+			return len(tables) > 0
+		})
+	}
+	// the database going away fails the files of the load, the next load tries again
+	failAll := func(err error) (error, error) {
+		for _, plan := range u.plans {
+			fail(plan.path, err)
+		}
+		health.runDone(start, code)
+		return failure, nil
+	}
+	if len(u.plans) > 0 && u.writesToDb() {
+		lock, err := acquireRunLock(ctx, db, opts.lockTimeout)
+		if err != nil {
+			return failAll(runError(err, dbErrorCode(err, LockedCode)))
+		}
+		defer lock.release()
+	}
+	if len(u.plans) > 0 && opts.track && !opts.validate {
+		u.runs, err = openRunLog(db, u.writesToDb())
+		if err != nil {
+			return failAll(runError(err, dbErrorCode(err, TableInfoErrorCode)))
+		}
+	}
+	for _, plan := range u.plans {
+		aborted := u.abortedFiles
+		err := u.uploadFiles([]*filePlan{plan})
+		if errors.Is(err, errInterrupted) {
+			health.runDone(start, InterruptedCode)
+			return runError(err, InterruptedCode), nil
+		}
+		if err != nil {
+			fail(plan.path, err)
+			continue
+		}
+		// a file aborted is loaded again with the next change
+		if u.writesToDb() && u.abortedFiles == aborted {
+			marker := fmt.Sprintf("loaded at %s into %s\n", time.Now().Format(time.RFC3339), plan.table)
+			if err := os.WriteFile(plan.path+doneMarkerSuffix, []byte(marker), 0o644); err != nil {
+				fail(plan.path, runError(err, OpenFileErrorCode))
+			}
+		}
+	}
+	u.summary.print(u.out)
+	if code == SuccessCode && u.rejectedRows+u.skippedRows+u.abortedFiles > 0 {
+		code = PartialSuccessCode
+	}
+	health.runDone(start, code)
+	return failure, nil
+}
@@ -0,0 +1,68 @@
+package loader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestLoadChangedGoesOn(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// a sidecar failing to parse fails the plan of its file
+		"01_Bad.json":           `[{"Id": 1}]`,
+		"01_Bad.json.meta.yaml": "mode: [",
+		// the table info queries fail on the fake server
+		"02_Customers.json": `[{"Id": 1}]`,
+		"03_Orders.json":    `[{"Id": 1}]`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := sql.OpenDB(&fakeServer{})
+	defer db.Close()
+	opts, source := sourceFor(t, "watch", "-d", dir, "-s", "db.example.com", "-yes")
+	health := newHealthState(nil)
+
+	failure, err := loadChanged(context.Background(), sqlx.NewDb(db, "sqlserver"), opts, source, nil, health)
+	if err != nil {
+		t.Fatalf("setup error %v, want the files failing on their own", err)
+	}
+	var runErr *RunError
+	if !errors.As(failure, &runErr) || runErr.Code != TableInfoErrorCode {
+		t.Errorf("failure %v, want the one of the last file, code %d", failure, TableInfoErrorCode)
+	}
+	if health.lastRun == nil || health.lastRun.ExitCode != TableInfoErrorCode {
+		t.Errorf("health %+v, want the run failed with code %d", health.lastRun, TableInfoErrorCode)
+	}
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name+doneMarkerSuffix)); err == nil {
+			t.Errorf("%s has a done marker, want it left for its next change", name)
+		}
+	}
+}
+
+func TestLoadChangedNeedsYes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "01_Orders.json"), []byte(`[{"Id": 1}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(&fakeServer{})
+	defer db.Close()
+	opts, source := sourceFor(t, "watch", "-d", dir, "-s", "db.example.com", "-mode", "sync")
+	failure, err := loadChanged(context.Background(), sqlx.NewDb(db, "sqlserver"), opts, source, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runErr *RunError
+	if !errors.As(failure, &runErr) || runErr.Code != NotConfirmedCode {
+		t.Errorf("failure %v, want code %d without -yes", failure, NotConfirmedCode)
+	}
+}